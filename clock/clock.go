@@ -0,0 +1,95 @@
+// Package clock is the time source for every layer that schedules work:
+// MRP retransmissions, session and exchange idle expiry, handshake
+// timeouts and, later, subscription intervals. Production code uses Real;
+// tests use a Fake and advance it by hand, so hours of protocol time pass
+// instantly and deterministically.
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time and makes timers. Implementations are safe for
+// concurrent use.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer that sends the time on its channel once d
+	// has elapsed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of *time.Timer the protocol layers use.
+type Timer interface {
+	// C delivers the time at which the timer fired. It is buffered, so a
+	// fire is never lost if nobody is receiving.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It reports whether it did so,
+	// false meaning the timer had already fired or been stopped.
+	Stop() bool
+	// Reset re-arms the timer to fire after d, as Stop then start.
+	Reset(d time.Duration) bool
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (r realTimer) C() <-chan time.Time        { return r.t.C }
+func (r realTimer) Stop() bool                 { return r.t.Stop() }
+func (r realTimer) Reset(d time.Duration) bool { return r.t.Reset(d) }
+
+// WithTimeout returns a copy of ctx that is cancelled once d has elapsed on
+// c, for deadlines a Fake must be able to trigger. context.WithTimeout
+// cannot be used for those: it always runs on the system clock. On expiry
+// Err and context.Cause report context.DeadlineExceeded, as for a context
+// from context.WithTimeout. The copy reports no Deadline of its own, since
+// its deadline is on c rather than the system clock.
+func WithTimeout(ctx context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	inner, cancel := context.WithCancelCause(ctx)
+	tc := &timeoutCtx{Context: inner, done: make(chan struct{})}
+	t := c.NewTimer(d)
+	go func() {
+		select {
+		case <-t.C():
+			cancel(context.DeadlineExceeded)
+		case <-inner.Done():
+			t.Stop()
+		}
+		close(tc.done)
+	}()
+	return tc, func() {
+		cancel(context.Canceled)
+		<-tc.done
+	}
+}
+
+// timeoutCtx is the context WithTimeout returns. It has a Done channel of
+// its own, closed once the inner context is cancelled, so that contexts
+// derived from it take their error from its Err rather than straight from
+// the inner context.
+type timeoutCtx struct {
+	context.Context // cancelled with cause context.DeadlineExceeded on expiry
+	done            chan struct{}
+}
+
+func (tc *timeoutCtx) Done() <-chan struct{} { return tc.done }
+
+func (tc *timeoutCtx) Err() error {
+	select {
+	case <-tc.done:
+	default:
+		return nil
+	}
+	if context.Cause(tc.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return tc.Context.Err()
+}
@@ -0,0 +1,124 @@
+package clock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1_700_000_000, 0)
+
+func fired(t Timer) (time.Time, bool) {
+	select {
+	case at := <-t.C():
+		return at, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFake_FiresInDeadlineOrder(t *testing.T) {
+	f := NewFake(epoch)
+	late := f.NewTimer(2 * time.Second)
+	early := f.NewTimer(time.Second)
+
+	f.Advance(999 * time.Millisecond)
+	if _, ok := fired(early); ok {
+		t.Fatal("fired before its deadline")
+	}
+	f.Advance(time.Hour)
+	if at, ok := fired(early); !ok || !at.Equal(epoch.Add(time.Second)) {
+		t.Errorf("early fired at %v, %v; want its deadline", at, ok)
+	}
+	if at, ok := fired(late); !ok || !at.Equal(epoch.Add(2*time.Second)) {
+		t.Errorf("late fired at %v, %v; want its deadline", at, ok)
+	}
+	if got := f.Now(); !got.Equal(epoch.Add(time.Hour + 999*time.Millisecond)) {
+		t.Errorf("Now = %v", got)
+	}
+	if f.Timers() != 0 {
+		t.Errorf("Timers = %d after firing", f.Timers())
+	}
+}
+
+func TestFake_StopAndReset(t *testing.T) {
+	f := NewFake(epoch)
+	tm := f.NewTimer(time.Second)
+	if !tm.Stop() || tm.Stop() {
+		t.Error("Stop should report true once, then false")
+	}
+	f.Advance(time.Second)
+	if _, ok := fired(tm); ok {
+		t.Error("stopped timer fired")
+	}
+	if tm.Reset(time.Minute) {
+		t.Error("Reset of a stopped timer reported it armed")
+	}
+	f.Advance(30 * time.Second)
+	if tm.Reset(time.Minute) != true {
+		t.Error("Reset of an armed timer reported it idle")
+	}
+	f.Advance(59 * time.Second)
+	if _, ok := fired(tm); ok {
+		t.Error("Reset did not push the deadline out")
+	}
+	f.Advance(time.Second)
+	if _, ok := fired(tm); !ok {
+		t.Error("reset timer never fired")
+	}
+}
+
+func TestFake_BlockUntil(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan time.Time)
+	go func() { done <- <-f.NewTimer(time.Minute).C() }()
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	if at := <-done; !at.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("fired at %v", at)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	f := NewFake(epoch)
+	ctx, cancel := WithTimeout(context.Background(), f, time.Minute)
+	defer cancel()
+	f.BlockUntil(1)
+	f.Advance(59 * time.Second)
+	if ctx.Err() != nil {
+		t.Fatal("cancelled early")
+	}
+	f.Advance(time.Second)
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("Err = %v, want DeadlineExceeded", ctx.Err())
+	}
+	if !errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		t.Errorf("Cause = %v, want DeadlineExceeded", context.Cause(ctx))
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), f, time.Minute)
+	f.BlockUntil(1)
+	cancel()
+	f.BlockUntil(0) // the timer is released with the context
+	if ctx.Err() != context.Canceled || !errors.Is(context.Cause(ctx), context.Canceled) {
+		t.Errorf("Err = %v, Cause = %v; want Canceled", ctx.Err(), context.Cause(ctx))
+	}
+}
+
+// TestWithTimeout_Derived — a context derived from the timeout context
+// sees the expiry as DeadlineExceeded too.
+func TestWithTimeout_Derived(t *testing.T) {
+	f := NewFake(epoch)
+	ctx, cancel := WithTimeout(context.Background(), f, time.Minute)
+	defer cancel()
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	<-child.Done()
+	if child.Err() != context.DeadlineExceeded {
+		t.Errorf("child Err = %v, want DeadlineExceeded", child.Err())
+	}
+}
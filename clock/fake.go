@@ -0,0 +1,136 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when told to. Advance and Set fire, in
+// deadline order, every timer that falls due. The zero value is not
+// usable; call NewFake.
+type Fake struct {
+	mu     sync.Mutex
+	cond   *sync.Cond // signalled when timers are added or removed
+	now    time.Time
+	timers []*fakeTimer // armed timers, unordered
+}
+
+// NewFake returns a Fake reading start.
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, firing due timers one deadline at a time so
+// each sees Now at its own deadline. Setting the clock backwards fires
+// nothing.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		next := f.earliest()
+		if next == nil || next.deadline.After(t) {
+			break
+		}
+		if next.deadline.After(f.now) {
+			f.now = next.deadline
+		}
+		f.disarm(next)
+		select {
+		case next.c <- f.now:
+		default:
+		}
+	}
+	f.now = t
+}
+
+// Timers returns how many timers are armed.
+func (f *Fake) Timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil waits until n timers are armed. Tests call it before Advance
+// so a goroutine under test has armed the timer the advance should fire.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) != n {
+		f.cond.Wait()
+	}
+}
+
+// earliest returns the armed timer with the soonest deadline. The caller
+// holds f.mu.
+func (f *Fake) earliest() *fakeTimer {
+	var e *fakeTimer
+	for _, t := range f.timers {
+		if e == nil || t.deadline.Before(e.deadline) {
+			e = t
+		}
+	}
+	return e
+}
+
+// disarm removes t and reports whether it was armed. The caller holds f.mu.
+func (f *Fake) disarm(t *fakeTimer) bool {
+	for i, armed := range f.timers {
+		if armed == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			f.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	f        *Fake
+	c        chan time.Time
+	deadline time.Time // guarded by f.mu
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.disarm(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	armed := f.disarm(t)
+	t.deadline = f.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		return armed
+	}
+	f.timers = append(f.timers, t)
+	f.cond.Broadcast()
+	return armed
+}
@@ -0,0 +1,328 @@
+package commissioning
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"go-matter/crypto"
+	"go-matter/message"
+	"go-matter/session"
+	"go-matter/tlv"
+)
+
+// CASE wire-format types (Matter §4.14.2). Sigma2Resume and the
+// resumption fields of Sigma1 are defined for completeness; the handshake
+// does not resume yet, so a responder answers every Sigma1 with a full
+// Sigma2. Each full handshake does agree a resumption ID, which both sides
+// record in their session.ResumptionStore, if they have one.
+
+// Sigma1 — Matter §4.14.2.3.
+type Sigma1 struct {
+	InitiatorRandom        []byte                     `tlv:"1"`
+	InitiatorSessionID     uint16                     `tlv:"2"`
+	DestinationID          []byte                     `tlv:"3"`
+	InitiatorEphPubKey     []byte                     `tlv:"4"`
+	InitiatorSessionParams *session.SessionParameters `tlv:"5,omitempty"`
+	ResumptionID           []byte                     `tlv:"6,omitempty"`
+	InitiatorResumeMIC     []byte                     `tlv:"7,omitempty"`
+}
+
+// Sigma2 — Matter §4.14.2.4. Encrypted2 is the responder's sigmaTBEData
+// under S2K.
+type Sigma2 struct {
+	ResponderRandom        []byte                     `tlv:"1"`
+	ResponderSessionID     uint16                     `tlv:"2"`
+	ResponderEphPubKey     []byte                     `tlv:"3"`
+	Encrypted2             []byte                     `tlv:"4"`
+	ResponderSessionParams *session.SessionParameters `tlv:"5,omitempty"`
+}
+
+// Sigma3 — Matter §4.14.2.5. Encrypted3 is the initiator's sigmaTBEData
+// under S3K.
+type Sigma3 struct {
+	Encrypted3 []byte `tlv:"1"`
+}
+
+// Sigma2Resume — Matter §4.14.2.7.
+type Sigma2Resume struct {
+	ResumptionID           []byte                     `tlv:"1"`
+	Sigma2ResumeMIC        []byte                     `tlv:"2"`
+	ResponderSessionID     uint16                     `tlv:"3"`
+	ResponderSessionParams *session.SessionParameters `tlv:"4,omitempty"`
+}
+
+// sigmaTBSData is what each side signs with its operational key: its own
+// certificates, in the Matter TLV encoding, and both ephemeral keys,
+// sender's first.
+type sigmaTBSData struct {
+	SenderNOC         []byte `tlv:"1"`
+	SenderICAC        []byte `tlv:"2,omitempty"`
+	SenderEphPubKey   []byte `tlv:"3"`
+	ReceiverEphPubKey []byte `tlv:"4"`
+}
+
+// sigmaTBEData is the encrypted part of Sigma2 and Sigma3. Only Sigma2
+// carries a ResumptionID.
+type sigmaTBEData struct {
+	SenderNOC    []byte `tlv:"1"`
+	SenderICAC   []byte `tlv:"2,omitempty"`
+	Signature    []byte `tlv:"3"`
+	ResumptionID []byte `tlv:"4,omitempty"`
+}
+
+// Key derivation labels and the fixed AES-CCM nonces that encrypt Sigma2
+// and Sigma3 (Matter §4.14.2).
+const (
+	caseSigma2Info = "Sigma2"
+	caseSigma3Info = "Sigma3"
+	caseRandomSize = 32
+)
+
+var (
+	caseSigma2Nonce = []byte("NCASE_Sigma2N")
+	caseSigma3Nonce = []byte("NCASE_Sigma3N")
+
+	// statusNoSharedTrustRoots answers a Sigma1 whose destination ID
+	// matches none of the responder's fabrics.
+	statusNoSharedTrustRoots = message.StatusReport{
+		GeneralCode:  message.GeneralCodeFailure,
+		ProtocolID:   message.ProtocolSecureChannel,
+		ProtocolCode: message.SecureChannelNoSharedTrustRoots,
+	}
+)
+
+var (
+	// ErrNoSharedTrustRoots is returned by CASEResponder.HandleExchange
+	// for a Sigma1 addressed to no fabric of the device, and by
+	// CASEInitiator.EstablishCASE when the device reports so.
+	ErrNoSharedTrustRoots = errors.New("commissioning: no shared trust roots")
+	// ErrPeerIdentity is returned when the peer's verified NOC names
+	// another node or fabric than the handshake is for.
+	ErrPeerIdentity = errors.New("commissioning: unexpected CASE peer identity")
+)
+
+// caseIdentity is what CASE needs of a fabric: the node's place in it,
+// the root key that anchors it, the operational IPK and the signing key.
+type caseIdentity struct {
+	fabric        *Fabric
+	nodeID        uint64
+	fabricID      uint64
+	rootPublicKey []byte
+	ipk           []byte // operational group key derived from the epoch IPK
+	key           crypto.KeyPair
+	noc, icac     []byte // the fabric's certificates in Matter TLV form
+}
+
+// newCASEIdentity parses f's certificates, in either encoding, and derives
+// its operational IPK. f's own chain is verified too, so a fabric built
+// from bad credentials fails here rather than on the peer.
+func newCASEIdentity(p crypto.CryptoProvider, f *Fabric) (*caseIdentity, error) {
+	if f == nil || f.OperationalKey == nil {
+		return nil, errors.New("commissioning: fabric has no operational key")
+	}
+	noc, err := crypto.VerifyOperationalChain(f.NOC, f.ICAC, f.RCAC)
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: fabric %d: %w", f.Index, err)
+	}
+	root, err := crypto.ParseOperationalCertificate(f.RCAC)
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: fabric %d: %w", f.Index, err)
+	}
+	cfid, err := crypto.CompressedFabricIDWith(p, root.PublicKey, noc.FabricID)
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: fabric %d: %w", f.Index, err)
+	}
+	ipk, err := crypto.DeriveOperationalGroupKeyWith(p, f.IPK, cfid)
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: fabric %d IPK: %w", f.Index, err)
+	}
+	priv, err := f.OperationalKey.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: fabric %d: %w", f.Index, err)
+	}
+	key, err := crypto.NewP256KeyPair(priv)
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: fabric %d: %w", f.Index, err)
+	}
+	nocTLV, err := crypto.MatterTLVCertificate(f.NOC)
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: fabric %d NOC: %w", f.Index, err)
+	}
+	var icacTLV []byte
+	if len(f.ICAC) > 0 {
+		if icacTLV, err = crypto.MatterTLVCertificate(f.ICAC); err != nil {
+			return nil, fmt.Errorf("commissioning: fabric %d ICAC: %w", f.Index, err)
+		}
+	}
+	return &caseIdentity{
+		fabric:        f,
+		nodeID:        noc.NodeID,
+		fabricID:      noc.FabricID,
+		rootPublicKey: root.PublicKey,
+		ipk:           ipk,
+		key:           key,
+		noc:           nocTLV,
+		icac:          icacTLV,
+	}, nil
+}
+
+// destinationID addresses a Sigma1 to nodeID on id's fabric without
+// naming either in the clear: HMAC-SHA-256 keyed with the IPK over the
+// initiator random, root public key, fabric ID and node ID (Matter
+// §4.14.2.3).
+func (id *caseIdentity) destinationID(p crypto.CryptoProvider, initiatorRandom []byte, nodeID uint64) []byte {
+	msg := make([]byte, 0, len(initiatorRandom)+len(id.rootPublicKey)+16)
+	msg = append(msg, initiatorRandom...)
+	msg = append(msg, id.rootPublicKey...)
+	msg = binary.LittleEndian.AppendUint64(msg, id.fabricID)
+	msg = binary.LittleEndian.AppendUint64(msg, nodeID)
+	return p.HMAC(id.ipk, msg)
+}
+
+// sign returns the sender's TBE data: its certificates and its signature
+// over them and both ephemeral keys.
+func (id *caseIdentity) sign(p crypto.CryptoProvider, senderEph, receiverEph []byte) (*sigmaTBEData, error) {
+	tbs, err := tlv.Marshal(&sigmaTBSData{
+		SenderNOC:         id.noc,
+		SenderICAC:        id.icac,
+		SenderEphPubKey:   senderEph,
+		ReceiverEphPubKey: receiverEph,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode TBSData: %w", err)
+	}
+	sig, err := p.Sign(id.key, tbs)
+	if err != nil {
+		return nil, fmt.Errorf("sign TBSData: %w", err)
+	}
+	return &sigmaTBEData{SenderNOC: id.noc, SenderICAC: id.icac, Signature: sig}, nil
+}
+
+// verify checks the peer's TBE data: its certificates must be in Matter
+// TLV form, its NOC chain to id's root, be on id's fabric, and have
+// signed both ephemeral keys. It returns the peer's NOC.
+func (id *caseIdentity) verify(p crypto.CryptoProvider, tbe *sigmaTBEData, senderEph, receiverEph []byte) (*crypto.OperationalCertificate, error) {
+	if !crypto.IsMatterTLVCertificate(tbe.SenderNOC) || len(tbe.SenderICAC) > 0 && !crypto.IsMatterTLVCertificate(tbe.SenderICAC) {
+		return nil, fmt.Errorf("%w: not in Matter TLV form", crypto.ErrInvalidCertificate)
+	}
+	noc, err := crypto.VerifyOperationalChain(tbe.SenderNOC, tbe.SenderICAC, id.fabric.RCAC)
+	if err != nil {
+		return nil, err
+	}
+	if noc.FabricID != id.fabricID {
+		return nil, fmt.Errorf("%w: fabric ID %#x, want %#x", ErrPeerIdentity, noc.FabricID, id.fabricID)
+	}
+	tbs, err := tlv.Marshal(&sigmaTBSData{
+		SenderNOC:         tbe.SenderNOC,
+		SenderICAC:        tbe.SenderICAC,
+		SenderEphPubKey:   senderEph,
+		ReceiverEphPubKey: receiverEph,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode TBSData: %w", err)
+	}
+	if err := p.Verify(noc.PublicKey, tbs, tbe.Signature); err != nil {
+		return nil, err
+	}
+	return noc, nil
+}
+
+// sigmaKey derives S2K or S3K: HKDF over the ECDH shared secret, salted
+// with the IPK followed by salt (Matter §4.14.2.4, §4.14.2.5).
+func (id *caseIdentity) sigmaKey(p crypto.CryptoProvider, sharedSecret []byte, info string, salt ...[]byte) ([]byte, error) {
+	s := append([]byte(nil), id.ipk...)
+	for _, part := range salt {
+		s = append(s, part...)
+	}
+	return p.HKDF(sharedSecret, s, []byte(info), 16)
+}
+
+// sessionKeys derives the CASE session keys from the shared secret,
+// salted with the IPK and the hash of all three Sigma messages (Matter
+// §4.14.2.6).
+func (id *caseIdentity) sessionKeys(p crypto.CryptoProvider, sharedSecret, sigma1, sigma2, sigma3 []byte) (crypto.SessionKeys, error) {
+	salt := append(append([]byte(nil), id.ipk...), transcriptHash(p, sigma1, sigma2, sigma3)...)
+	out, err := p.HKDF(sharedSecret, salt, []byte(crypto.SessionKeyInfo), 48)
+	if err != nil {
+		return crypto.SessionKeys{}, err
+	}
+	return crypto.SessionKeys{
+		I2RKey:               out[0:16],
+		R2IKey:               out[16:32],
+		AttestationChallenge: out[32:48],
+	}, nil
+}
+
+// matches reports whether destinationID addresses id's node.
+func (id *caseIdentity) matches(p crypto.CryptoProvider, initiatorRandom, destinationID []byte) bool {
+	return hmac.Equal(id.destinationID(p, initiatorRandom, id.nodeID), destinationID)
+}
+
+// transcriptHash hashes the concatenated Sigma messages sent so far.
+func transcriptHash(p crypto.CryptoProvider, msgs ...[]byte) []byte {
+	var all []byte
+	for _, m := range msgs {
+		all = append(all, m...)
+	}
+	return p.Hash(all)
+}
+
+// encryptTBE and decryptTBE seal and open a Sigma2 or Sigma3 TBE
+// payload under its sigma key and fixed nonce.
+func encryptTBE(p crypto.CryptoProvider, key, nonce []byte, tbe *sigmaTBEData) ([]byte, error) {
+	plain, err := tlv.Marshal(tbe)
+	if err != nil {
+		return nil, fmt.Errorf("encode TBEData: %w", err)
+	}
+	return p.Encrypt(key, nonce, plain, nil)
+}
+
+func decryptTBE(p crypto.CryptoProvider, key, nonce, ciphertext []byte) (*sigmaTBEData, error) {
+	plain, err := p.Decrypt(key, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt TBEData: %w", err)
+	}
+	var tbe sigmaTBEData
+	if err := tlv.Unmarshal(plain, &tbe); err != nil {
+		return nil, fmt.Errorf("decode TBEData: %w", err)
+	}
+	return &tbe, nil
+}
+
+// saveResumption records a completed handshake with peer in rs, if it is
+// not nil, under the resumption ID the responder chose.
+func saveResumption(rs *session.ResumptionStore, resumptionID, sharedSecret []byte, local *caseIdentity, peer *crypto.OperationalCertificate) error {
+	if rs == nil {
+		return nil
+	}
+	if err := rs.Save(session.ResumptionRecord{
+		ResumptionID: resumptionID,
+		FabricIndex:  local.fabric.Index,
+		PeerNodeID:   peer.NodeID,
+		SharedSecret: sharedSecret,
+		CATs:         peer.CATs,
+	}); err != nil {
+		return fmt.Errorf("save resumption record: %w", err)
+	}
+	return nil
+}
+
+// installCASESession registers the keys of a completed CASE handshake in
+// sm with both nodes' operational IDs, which the session's nonces are
+// built from, and the local fabric index.
+func installCASESession(sm *session.SessionManager, id, peerID uint16, keys crypto.SessionKeys, role session.Role, local *caseIdentity, peerNodeID uint64, peer session.SessionParameters) error {
+	_, err := sm.CreateSession(session.SessionParams{
+		ID:             id,
+		PeerSessionID:  peerID,
+		Type:           session.SessionTypeCASE,
+		LocalNodeID:    local.nodeID,
+		PeerNodeID:     peerNodeID,
+		FabricIndex:    local.fabric.Index,
+		Keys:           keys,
+		Role:           role,
+		PeerParameters: peer,
+	})
+	return err
+}
@@ -0,0 +1,265 @@
+package commissioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/crypto"
+	"go-matter/exchange"
+	"go-matter/message"
+	"go-matter/session"
+	"go-matter/tlv"
+)
+
+// CASEInitiator establishes CASE sessions (Matter §4.14.2) from one of the
+// node's fabrics, usually a controller's, to operational nodes on the same
+// fabric. It runs one handshake at a time; each starts afresh, so one
+// CASEInitiator can open sessions to several peers in turn.
+type CASEInitiator struct {
+	State     CommissioningState
+	SessionID uint16 // local ID of the session being established
+
+	PeerNodeID         uint64
+	ResponderSessionID uint16
+	PeerSessionParams  session.SessionParameters // zero if the responder sent none
+
+	// Timeout bounds the whole handshake, timed on the session manager's
+	// clock. Zero selects DefaultHandshakeTimeout.
+	Timeout time.Duration
+	// Resumption, if not nil, receives a record of each completed
+	// handshake, keyed by the peer's node ID on the initiator's fabric.
+	Resumption *session.ResumptionStore
+
+	sessionManager *session.SessionManager
+	identity       *caseIdentity
+	ex             *exchange.Exchange
+
+	ephemeral    crypto.KeyPair
+	sharedSecret []byte
+	peerNOC      *crypto.OperationalCertificate
+	resumptionID []byte
+	sigma1       []byte
+	sigma2       []byte
+	sigma3       []byte
+}
+
+// NewCASEInitiator returns an initiator that proves it holds fabric's NOC.
+// fabric needs its RCAC, NOC (and ICAC, if any), IPK epoch key and
+// operational key; the certificates are verified here.
+func NewCASEInitiator(sm *session.SessionManager, fabric *Fabric) (*CASEInitiator, error) {
+	if sm == nil {
+		return nil, errors.New("case initiator: session manager must not be nil")
+	}
+	id, err := newCASEIdentity(sm.CryptoProvider(), fabric)
+	if err != nil {
+		return nil, err
+	}
+	return &CASEInitiator{State: StateIdle, sessionManager: sm, identity: id}, nil
+}
+
+// EstablishCASE runs the handshake with peerNodeID on ex, an exchange
+// opened towards it on session.UnsecuredSessionID for
+// message.ProtocolSecureChannel, and returns once the peer has confirmed
+// it with a StatusReport and the CASE session, with both nodes'
+// operational IDs, is installed under SessionID. The peer must prove a NOC
+// for peerNodeID on the initiator's fabric.
+//
+// Failures are reported to the peer as for Commissioner.EstablishPASE,
+// and the CASEInitiator resets to StateIdle, releasing its reserved
+// session ID. A peer that does not know the fabric fails the handshake
+// with ErrNoSharedTrustRoots. ex is closed either way.
+func (c *CASEInitiator) EstablishCASE(ctx context.Context, ex *exchange.Exchange, peerNodeID uint64) error {
+	c.reset()
+	c.ex = ex
+	defer ex.Close()
+	ctx, cancel := clock.WithTimeout(ctx, c.sessionManager.Clock(), handshakeTimeout(c.Timeout))
+	defer cancel()
+	if err := c.start(peerNodeID); err != nil {
+		return c.fail(err, true)
+	}
+	for c.State != StateComplete {
+		frame, err := receive(ctx, ex)
+		if err != nil {
+			var status *exchange.StatusError
+			if errors.As(err, &status) && status.Report.ProtocolCode == message.SecureChannelNoSharedTrustRoots {
+				err = fmt.Errorf("%w: %w", ErrNoSharedTrustRoots, err)
+			}
+			return c.fail(fmt.Errorf("case initiator: %w", err), false)
+		}
+		if err := c.HandleMessage(frame); err != nil {
+			return c.fail(err, true)
+		}
+	}
+	return nil
+}
+
+// start sends Sigma1.
+func (c *CASEInitiator) start(peerNodeID uint64) error {
+	p := c.sessionManager.CryptoProvider()
+	c.PeerNodeID = peerNodeID
+
+	random := make([]byte, caseRandomSize)
+	if _, err := io.ReadFull(p.Rand(), random); err != nil {
+		return fmt.Errorf("case initiator: random: %w", err)
+	}
+	eph, err := p.GenerateKeyPair()
+	if err != nil {
+		return fmt.Errorf("case initiator: ephemeral key: %w", err)
+	}
+	c.ephemeral = eph
+	if c.SessionID, err = c.sessionManager.AllocateSessionID(); err != nil {
+		return fmt.Errorf("case initiator: %w", err)
+	}
+
+	params := c.sessionManager.LocalSessionParameters()
+	payload, err := tlv.Marshal(&Sigma1{
+		InitiatorRandom:        random,
+		InitiatorSessionID:     c.SessionID,
+		DestinationID:          c.identity.destinationID(p, random, peerNodeID),
+		InitiatorEphPubKey:     eph.Public(),
+		InitiatorSessionParams: &params,
+	})
+	if err != nil {
+		return fmt.Errorf("case initiator: encode Sigma1: %w", err)
+	}
+	c.sigma1 = payload
+	c.State = StateCASE_Sigma2
+	return c.send(message.OpcodeCASESigma1, payload)
+}
+
+// HandleMessage processes one message from the responder and sends the
+// next message of the handshake. EstablishCASE calls it for each reply.
+func (c *CASEInitiator) HandleMessage(frame *message.Frame) error {
+	switch op := frame.PayloadHeader.Opcode; {
+	case c.State == StateCASE_Sigma2 && op == message.OpcodeCASESigma2:
+		return c.handleSigma2(frame)
+	case c.State == StateCASE_StatusReport && message.IsStatusReport(frame):
+		return c.handleStatusReport(frame)
+	default:
+		return fmt.Errorf("case initiator: %w: opcode %#x in state %d",
+			ErrUnexpectedMessage, byte(op), c.State)
+	}
+}
+
+// handleSigma2 authenticates the responder and answers with Sigma3.
+func (c *CASEInitiator) handleSigma2(frame *message.Frame) error {
+	p := c.sessionManager.CryptoProvider()
+	var s2 Sigma2
+	if err := tlv.Unmarshal(frame.Payload, &s2); err != nil {
+		return fmt.Errorf("case initiator: decode Sigma2: %w", err)
+	}
+	shared, err := p.ECDH(c.ephemeral, s2.ResponderEphPubKey)
+	if err != nil {
+		return fmt.Errorf("case initiator: ECDH: %w", err)
+	}
+	s2k, err := c.identity.sigmaKey(p, shared, caseSigma2Info, s2.ResponderRandom, s2.ResponderEphPubKey, transcriptHash(p, c.sigma1))
+	if err != nil {
+		return fmt.Errorf("case initiator: derive S2K: %w", err)
+	}
+	tbe2, err := decryptTBE(p, s2k, caseSigma2Nonce, s2.Encrypted2)
+	if err != nil {
+		return fmt.Errorf("case initiator: Sigma2: %w", err)
+	}
+	noc, err := c.identity.verify(p, tbe2, s2.ResponderEphPubKey, c.ephemeral.Public())
+	if err != nil {
+		return fmt.Errorf("case initiator: Sigma2: %w", err)
+	}
+	if noc.NodeID != c.PeerNodeID {
+		return fmt.Errorf("case initiator: %w: node ID %#016x, want %#016x", ErrPeerIdentity, noc.NodeID, c.PeerNodeID)
+	}
+	if len(tbe2.ResumptionID) != session.ResumptionIDSize {
+		return fmt.Errorf("case initiator: Sigma2 resumption ID is %d bytes", len(tbe2.ResumptionID))
+	}
+
+	c.sharedSecret = shared
+	c.peerNOC = noc
+	c.resumptionID = tbe2.ResumptionID
+	c.ResponderSessionID = s2.ResponderSessionID
+	if s2.ResponderSessionParams != nil {
+		c.PeerSessionParams = *s2.ResponderSessionParams
+	}
+	c.sigma2 = frame.Payload
+
+	tbe3, err := c.identity.sign(p, c.ephemeral.Public(), s2.ResponderEphPubKey)
+	if err != nil {
+		return fmt.Errorf("case initiator: %w", err)
+	}
+	s3k, err := c.identity.sigmaKey(p, shared, caseSigma3Info, transcriptHash(p, c.sigma1, c.sigma2))
+	if err != nil {
+		return fmt.Errorf("case initiator: derive S3K: %w", err)
+	}
+	encrypted, err := encryptTBE(p, s3k, caseSigma3Nonce, tbe3)
+	if err != nil {
+		return fmt.Errorf("case initiator: Sigma3: %w", err)
+	}
+	payload, err := tlv.Marshal(&Sigma3{Encrypted3: encrypted})
+	if err != nil {
+		return fmt.Errorf("case initiator: encode Sigma3: %w", err)
+	}
+	c.sigma3 = payload
+	c.State = StateCASE_StatusReport
+	return c.send(message.OpcodeCASESigma3, payload)
+}
+
+// handleStatusReport completes the handshake once the responder has
+// accepted Sigma3. A failure report never gets here: Receive returns it as
+// an error.
+func (c *CASEInitiator) handleStatusReport(frame *message.Frame) error {
+	var r message.StatusReport
+	if err := r.Unmarshal(frame.Payload); err != nil {
+		return fmt.Errorf("case initiator: decode StatusReport: %w", err)
+	}
+	if r.ProtocolID != message.ProtocolSecureChannel || r.ProtocolCode != message.SecureChannelSessionEstablishmentSuccess {
+		return fmt.Errorf("case initiator: %w: status %v", ErrUnexpectedMessage, &r)
+	}
+	p := c.sessionManager.CryptoProvider()
+	keys, err := c.identity.sessionKeys(p, c.sharedSecret, c.sigma1, c.sigma2, c.sigma3)
+	if err != nil {
+		return fmt.Errorf("case initiator: derive session keys: %w", err)
+	}
+	if err := saveResumption(c.Resumption, c.resumptionID, c.sharedSecret, c.identity, c.peerNOC); err != nil {
+		return fmt.Errorf("case initiator: %w", err)
+	}
+	if err := installCASESession(c.sessionManager, c.SessionID, c.ResponderSessionID, keys, session.RoleInitiator, c.identity, c.PeerNodeID, c.PeerSessionParams); err != nil {
+		return fmt.Errorf("case initiator: %w", err)
+	}
+	c.State = StateComplete
+	return nil
+}
+
+// fail resets the CASEInitiator after err ended the handshake, first
+// telling the responder with an InvalidParameter StatusReport if notify is
+// set. It returns err.
+func (c *CASEInitiator) fail(err error, notify bool) error {
+	if notify && c.ex != nil {
+		c.ex.SendStatusReport(statusInvalidParameter)
+	}
+	c.sessionManager.ReleaseSessionID(c.SessionID)
+	c.reset()
+	return err
+}
+
+// reset clears the last handshake, keeping the configuration.
+func (c *CASEInitiator) reset() {
+	*c = CASEInitiator{
+		State:          StateIdle,
+		Timeout:        c.Timeout,
+		Resumption:     c.Resumption,
+		sessionManager: c.sessionManager,
+		identity:       c.identity,
+	}
+}
+
+func (c *CASEInitiator) send(opcode message.Opcode, payload any) error {
+	if c.ex == nil {
+		return errNoExchange
+	}
+	if err := c.ex.Send(opcode, payload); err != nil {
+		return fmt.Errorf("case initiator: send opcode=%#x: %w", byte(opcode), err)
+	}
+	return nil
+}
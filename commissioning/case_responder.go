@@ -0,0 +1,267 @@
+package commissioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/crypto"
+	"go-matter/exchange"
+	"go-matter/message"
+	"go-matter/session"
+	"go-matter/tlv"
+)
+
+// CASEResponder answers CASE handshakes (Matter §4.14.2) for the fabrics
+// in a FabricTable: a Sigma1 is served from the fabric its destination ID
+// names. Unlike Commissionee it keeps each handshake's state on the
+// handshake, so it is safe for concurrent use and serves any number of
+// initiators at once.
+type CASEResponder struct {
+	sessionManager *session.SessionManager
+	fabrics        *FabricTable
+
+	// Timeout bounds each handshake, timed on the session manager's
+	// clock. Zero selects DefaultHandshakeTimeout. Set it before serving.
+	Timeout time.Duration
+	// Resumption, if not nil, receives a record of each completed
+	// handshake, keyed by the initiator's node ID on the fabric served.
+	// Set it before serving.
+	Resumption *session.ResumptionStore
+}
+
+// NewCASEResponder returns a responder for the fabrics in fabrics, as they
+// are when each Sigma1 arrives.
+func NewCASEResponder(sm *session.SessionManager, fabrics *FabricTable) (*CASEResponder, error) {
+	if sm == nil {
+		return nil, errors.New("case responder: session manager must not be nil")
+	}
+	if fabrics == nil {
+		return nil, errors.New("case responder: fabric table must not be nil")
+	}
+	return &CASEResponder{sessionManager: sm, fabrics: fabrics}, nil
+}
+
+// caseResponse is one handshake a CASEResponder is serving.
+type caseResponse struct {
+	sessionManager *session.SessionManager
+	resumption     *session.ResumptionStore
+	ex             *exchange.Exchange
+	state          CommissioningState
+	sessionID      uint16
+
+	identity           *caseIdentity
+	initiatorSessionID uint16
+	initiatorEph       []byte
+	peerSessionParams  session.SessionParameters
+	ephemeral          crypto.KeyPair
+	sharedSecret       []byte
+	resumptionID       []byte
+	sigma1             []byte
+	sigma2             []byte
+}
+
+// HandleExchange serves the handshake an initiator opened with ex, whose
+// Sigma1 is waiting in ex.Inbox, and returns once the CASE session is
+// installed, on the fabric Sigma1 was addressed to and with the
+// initiator's verified node ID, and the initiator told so with a
+// StatusReport. It has the shape to be called from an
+// exchange.UnsolicitedHandler registered for message.ProtocolSecureChannel
+// (see SecureChannel for serving PASE alongside); ex is closed on return.
+//
+// A Sigma1 for no fabric in the table is answered with NoSharedTrustRoots
+// and fails with ErrNoSharedTrustRoots; any other bad message, with
+// InvalidParameter as for Commissionee.HandleExchange. A receive error or
+// timeout ends the handshake without a StatusReport. The reserved session
+// ID is released in every case.
+func (r *CASEResponder) HandleExchange(ctx context.Context, ex *exchange.Exchange) error {
+	return r.serve(ctx, ex, nil)
+}
+
+// serve runs HandleExchange; first, if not nil, is the exchange's first
+// message, already taken from its inbox.
+func (r *CASEResponder) serve(ctx context.Context, ex *exchange.Exchange, first *message.Frame) error {
+	defer ex.Close()
+	h := &caseResponse{sessionManager: r.sessionManager, resumption: r.Resumption, ex: ex, state: StateIdle}
+	ctx, cancel := clock.WithTimeout(ctx, r.sessionManager.Clock(), handshakeTimeout(r.Timeout))
+	defer cancel()
+	for h.state != StateComplete {
+		frame := first
+		first = nil
+		if frame == nil {
+			var err error
+			if frame, err = receive(ctx, ex); err != nil {
+				return h.fail(fmt.Errorf("case responder: %w", err), false)
+			}
+		}
+		if err := r.handleMessage(h, frame); err != nil {
+			return h.fail(err, true)
+		}
+	}
+	return nil
+}
+
+// handleMessage processes one message from the initiator and sends the
+// reply.
+func (r *CASEResponder) handleMessage(h *caseResponse, frame *message.Frame) error {
+	switch op := frame.PayloadHeader.Opcode; {
+	case h.state == StateIdle && op == message.OpcodeCASESigma1:
+		return r.handleSigma1(h, frame)
+	case h.state == StateCASE_Sigma3 && op == message.OpcodeCASESigma3:
+		return h.handleSigma3(frame)
+	default:
+		return fmt.Errorf("case responder: %w: opcode %#x in state %d",
+			ErrUnexpectedMessage, byte(op), h.state)
+	}
+}
+
+// identityFor returns the fabric whose destination ID for the initiator's
+// random is destinationID.
+func (r *CASEResponder) identityFor(initiatorRandom, destinationID []byte) (*caseIdentity, error) {
+	p := r.sessionManager.CryptoProvider()
+	for _, f := range r.fabrics.Fabrics() {
+		id, err := newCASEIdentity(p, f)
+		if err != nil {
+			// AddNOC verified the chain; a fabric that fails now cannot
+			// be the one addressed.
+			continue
+		}
+		if id.matches(p, initiatorRandom, destinationID) {
+			return id, nil
+		}
+	}
+	return nil, ErrNoSharedTrustRoots
+}
+
+// handleSigma1 picks the fabric Sigma1 is addressed to and answers with
+// Sigma2.
+func (r *CASEResponder) handleSigma1(h *caseResponse, frame *message.Frame) error {
+	p := h.sessionManager.CryptoProvider()
+	var s1 Sigma1
+	if err := tlv.Unmarshal(frame.Payload, &s1); err != nil {
+		return fmt.Errorf("case responder: decode Sigma1: %w", err)
+	}
+	if len(s1.InitiatorRandom) != caseRandomSize {
+		return fmt.Errorf("case responder: Sigma1 random is %d bytes", len(s1.InitiatorRandom))
+	}
+	id, err := r.identityFor(s1.InitiatorRandom, s1.DestinationID)
+	if err != nil {
+		return fmt.Errorf("case responder: %w", err)
+	}
+	h.identity = id
+	h.initiatorSessionID = s1.InitiatorSessionID
+	h.initiatorEph = s1.InitiatorEphPubKey
+	if s1.InitiatorSessionParams != nil {
+		h.peerSessionParams = *s1.InitiatorSessionParams
+	}
+	h.sigma1 = frame.Payload
+
+	if h.ephemeral, err = p.GenerateKeyPair(); err != nil {
+		return fmt.Errorf("case responder: ephemeral key: %w", err)
+	}
+	if h.sharedSecret, err = p.ECDH(h.ephemeral, s1.InitiatorEphPubKey); err != nil {
+		return fmt.Errorf("case responder: ECDH: %w", err)
+	}
+	random := make([]byte, caseRandomSize)
+	resumptionID := make([]byte, session.ResumptionIDSize)
+	for _, b := range [][]byte{random, resumptionID} {
+		if _, err := io.ReadFull(p.Rand(), b); err != nil {
+			return fmt.Errorf("case responder: random: %w", err)
+		}
+	}
+	if h.sessionID, err = h.sessionManager.AllocateSessionID(); err != nil {
+		return fmt.Errorf("case responder: %w", err)
+	}
+
+	tbe2, err := id.sign(p, h.ephemeral.Public(), s1.InitiatorEphPubKey)
+	if err != nil {
+		return fmt.Errorf("case responder: %w", err)
+	}
+	tbe2.ResumptionID = resumptionID
+	h.resumptionID = resumptionID
+	s2k, err := id.sigmaKey(p, h.sharedSecret, caseSigma2Info, random, h.ephemeral.Public(), transcriptHash(p, h.sigma1))
+	if err != nil {
+		return fmt.Errorf("case responder: derive S2K: %w", err)
+	}
+	encrypted, err := encryptTBE(p, s2k, caseSigma2Nonce, tbe2)
+	if err != nil {
+		return fmt.Errorf("case responder: Sigma2: %w", err)
+	}
+	params := h.sessionManager.LocalSessionParameters()
+	payload, err := tlv.Marshal(&Sigma2{
+		ResponderRandom:        random,
+		ResponderSessionID:     h.sessionID,
+		ResponderEphPubKey:     h.ephemeral.Public(),
+		Encrypted2:             encrypted,
+		ResponderSessionParams: &params,
+	})
+	if err != nil {
+		return fmt.Errorf("case responder: encode Sigma2: %w", err)
+	}
+	h.sigma2 = payload
+	h.state = StateCASE_Sigma3
+	return h.send(message.OpcodeCASESigma2, payload)
+}
+
+// handleSigma3 authenticates the initiator, installs the session and
+// confirms it.
+func (h *caseResponse) handleSigma3(frame *message.Frame) error {
+	p := h.sessionManager.CryptoProvider()
+	var s3 Sigma3
+	if err := tlv.Unmarshal(frame.Payload, &s3); err != nil {
+		return fmt.Errorf("case responder: decode Sigma3: %w", err)
+	}
+	s3k, err := h.identity.sigmaKey(p, h.sharedSecret, caseSigma3Info, transcriptHash(p, h.sigma1, h.sigma2))
+	if err != nil {
+		return fmt.Errorf("case responder: derive S3K: %w", err)
+	}
+	tbe3, err := decryptTBE(p, s3k, caseSigma3Nonce, s3.Encrypted3)
+	if err != nil {
+		return fmt.Errorf("case responder: Sigma3: %w", err)
+	}
+	noc, err := h.identity.verify(p, tbe3, h.initiatorEph, h.ephemeral.Public())
+	if err != nil {
+		return fmt.Errorf("case responder: Sigma3: %w", err)
+	}
+	keys, err := h.identity.sessionKeys(p, h.sharedSecret, h.sigma1, h.sigma2, frame.Payload)
+	if err != nil {
+		return fmt.Errorf("case responder: derive session keys: %w", err)
+	}
+	if err := saveResumption(h.resumption, h.resumptionID, h.sharedSecret, h.identity, noc); err != nil {
+		return fmt.Errorf("case responder: %w", err)
+	}
+	if err := installCASESession(h.sessionManager, h.sessionID, h.initiatorSessionID, keys, session.RoleResponder, h.identity, noc.NodeID, h.peerSessionParams); err != nil {
+		return fmt.Errorf("case responder: %w", err)
+	}
+
+	h.state = StateComplete
+	if err := h.ex.SendStatusReport(statusSessionEstablished); err != nil {
+		return fmt.Errorf("case responder: send StatusReport: %w", err)
+	}
+	return nil
+}
+
+// fail ends the handshake after err, first telling the initiator if
+// notify is set, and releases its reserved session ID. It returns err.
+func (h *caseResponse) fail(err error, notify bool) error {
+	if notify {
+		status := statusInvalidParameter
+		if errors.Is(err, ErrNoSharedTrustRoots) {
+			status = statusNoSharedTrustRoots
+		}
+		h.ex.SendStatusReport(status)
+	}
+	h.sessionManager.ReleaseSessionID(h.sessionID)
+	h.state = StateIdle
+	return err
+}
+
+func (h *caseResponse) send(opcode message.Opcode, payload any) error {
+	if err := h.ex.Send(opcode, payload); err != nil {
+		return fmt.Errorf("case responder: send opcode=%#x: %w", byte(opcode), err)
+	}
+	return nil
+}
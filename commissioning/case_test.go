@@ -0,0 +1,383 @@
+package commissioning
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"go-matter/crypto"
+	"go-matter/exchange"
+	"go-matter/message"
+	"go-matter/session"
+	"go-matter/storage"
+)
+
+const (
+	testFabricID   = 0x2906C908D115D362
+	controllerNode = 112233
+	deviceNode     = 0x00000000DEDEDEDE
+)
+
+// testCA is a fabric's root CA, issuing its NOCs directly.
+type testCA struct {
+	fabricID uint64
+	rcac     []byte
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	ipk      []byte // epoch key
+}
+
+func newTestCA(t *testing.T, fabricID uint64) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+			matterAttr(crypto.OIDMatterRCACID, 1), matterAttr(crypto.OIDMatterFabricID, fabricID),
+		}},
+		NotBefore:             time.Unix(1_700_000_000, 0),
+		NotAfter:              time.Unix(1_900_000_000, 0),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{fabricID: fabricID, rcac: der, cert: cert, key: key, ipk: bytes.Repeat([]byte{0x1B}, 16)}
+}
+
+func matterAttr(oid []int, v uint64) pkix.AttributeTypeAndValue {
+	// Matter IDs are UTF8Strings; Go would encode a plain string as a
+	// PrintableString, which has no Matter TLV form.
+	s := fmt.Sprintf("%016X", v)
+	return pkix.AttributeTypeAndValue{Type: oid, Value: asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(s)}}
+}
+
+// issueNOC issues a NOC for pub and nodeID on the CA's fabric.
+func (ca *testCA) issueNOC(t *testing.T, pub *ecdsa.PublicKey, nodeID uint64) []byte {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+			matterAttr(crypto.OIDMatterNodeID, nodeID), matterAttr(crypto.OIDMatterFabricID, ca.fabricID),
+		}},
+		NotBefore:             time.Unix(1_700_000_000, 0),
+		NotAfter:              time.Unix(1_900_000_000, 0),
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// fabric returns a Fabric for a node with a fresh operational key.
+func (ca *testCA) fabric(t *testing.T, index uint8, nodeID uint64) *Fabric {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Fabric{
+		Index:          index,
+		RCAC:           ca.rcac,
+		NOC:            ca.issueNOC(t, &key.PublicKey, nodeID),
+		IPK:            ca.ipk,
+		OperationalKey: key,
+	}
+}
+
+// runCASE runs a whole CASE handshake from initiator to peerNodeID against
+// a SecureChannel serving responder, and returns each side's error.
+func runCASE(t *testing.T, initiator *CASEInitiator, responder *CASEResponder, peerNodeID uint64) (initErr, respErr error) {
+	t.Helper()
+	toDevice, toController := &link{addr: controllerAddr}, &link{addr: deviceAddr}
+	ctrl := exchange.NewExchangeManager(initiator.sessionManager, toDevice)
+	dev := exchange.NewExchangeManager(responder.sessionManager, toController)
+	toDevice.peer, toController.peer = dev, ctrl
+	done := make(chan error, 1)
+	dev.RegisterUnsolicitedHandler(message.ProtocolSecureChannel, func(ex *exchange.Exchange) {
+		done <- SecureChannel{CASE: responder}.HandleExchange(context.Background(), ex)
+	})
+
+	ex, err := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initErr = initiator.EstablishCASE(context.Background(), ex, peerNodeID)
+	select {
+	case respErr = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("responder did not finish")
+	}
+	return initErr, respErr
+}
+
+// casePeers returns a controller's initiator and a device's responder on
+// one fabric.
+func casePeers(t *testing.T, ca *testCA) (*CASEInitiator, *CASEResponder, *FabricTable) {
+	t.Helper()
+	initiator, err := NewCASEInitiator(session.NewSessionManager(nil), ca.fabric(t, 1, controllerNode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fabrics := NewFabricTable(0)
+	fabrics.add(ca.fabric(t, 0, deviceNode))
+	responder, err := NewCASEResponder(session.NewSessionManager(nil), fabrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return initiator, responder, fabrics
+}
+
+// TestCASE_Loopback — both sides install a CASE session on the fabric
+// with each other's operational node IDs, and traffic sealed by one opens
+// on the other with nonces built from those IDs.
+func TestCASE_Loopback(t *testing.T) {
+	ca := newTestCA(t, testFabricID)
+	initiator, responder, fabrics := casePeers(t, ca)
+	if initErr, respErr := runCASE(t, initiator, responder, deviceNode); initErr != nil || respErr != nil {
+		t.Fatalf("CASE: initiator %v, responder %v", initErr, respErr)
+	}
+	if initiator.State != StateComplete {
+		t.Errorf("initiator state %d", initiator.State)
+	}
+
+	ctrlSess, ok := initiator.sessionManager.Session(initiator.SessionID)
+	if !ok {
+		t.Fatal("initiator installed no session")
+	}
+	devSess, ok := responder.sessionManager.Session(initiator.ResponderSessionID)
+	if !ok {
+		t.Fatal("responder installed no session")
+	}
+	deviceFabric := fabrics.Fabrics()[0].Index
+	switch {
+	case ctrlSess.Type != session.SessionTypeCASE || devSess.Type != session.SessionTypeCASE:
+		t.Errorf("session types %v / %v", ctrlSess.Type, devSess.Type)
+	case ctrlSess.LocalNodeID != controllerNode || ctrlSess.PeerNodeID != deviceNode:
+		t.Errorf("controller session nodes %#x -> %#x", ctrlSess.LocalNodeID, ctrlSess.PeerNodeID)
+	case devSess.LocalNodeID != deviceNode || devSess.PeerNodeID != controllerNode:
+		t.Errorf("device session nodes %#x -> %#x", devSess.LocalNodeID, devSess.PeerNodeID)
+	case ctrlSess.FabricIndex != 1 || devSess.FabricIndex != deviceFabric:
+		t.Errorf("fabric indexes %d / %d", ctrlSess.FabricIndex, devSess.FabricIndex)
+	case ctrlSess.PeerSessionID != devSess.ID || devSess.PeerSessionID != ctrlSess.ID:
+		t.Errorf("session IDs %d->%d, %d->%d", ctrlSess.ID, ctrlSess.PeerSessionID, devSess.ID, devSess.PeerSessionID)
+	case !bytes.Equal(ctrlSess.AttestationChallenge, devSess.AttestationChallenge):
+		t.Error("attestation challenges differ")
+	}
+
+	f := &message.Frame{
+		Header:        message.Header{SecurityFlags: message.SessionTypeUnicast},
+		PayloadHeader: message.PayloadHeader{ExchangeFlags: message.ExchangeFlagInitiator, Opcode: 0x02, ProtocolID: message.ProtocolInteractionModel},
+		Payload:       []byte("read request"),
+	}
+	wire, err := initiator.sessionManager.SealFrame(ctrlSess.ID, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := responder.sessionManager.OpenFrame(wire)
+	if err != nil {
+		t.Fatalf("OpenFrame: %v", err)
+	}
+	if !bytes.Equal(got.Payload, f.Payload) {
+		t.Errorf("payload %q", got.Payload)
+	}
+}
+
+// TestCASE_DeterministicReplay — with seeded providers on both sides,
+// signatures included, two runs over the same fabrics derive the same
+// session keys.
+func TestCASE_DeterministicReplay(t *testing.T) {
+	ca := newTestCA(t, testFabricID)
+	ctrlFabric := ca.fabric(t, 1, controllerNode)
+	fabrics := NewFabricTable(0)
+	fabrics.add(ca.fabric(t, 0, deviceNode))
+	run := func() []byte {
+		newSM := func(seed string) *session.SessionManager {
+			return session.NewSessionManagerWithConfig(nil, session.Config{
+				CryptoProvider: crypto.NewDeterministicCryptoProvider([]byte(seed)),
+			})
+		}
+		initiator, err := NewCASEInitiator(newSM("controller"), ctrlFabric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responder, err := NewCASEResponder(newSM("device"), fabrics)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if initErr, respErr := runCASE(t, initiator, responder, deviceNode); initErr != nil || respErr != nil {
+			t.Fatalf("CASE: initiator %v, responder %v", initErr, respErr)
+		}
+		sess, ok := initiator.sessionManager.Session(initiator.SessionID)
+		if !ok {
+			t.Fatal("initiator installed no session")
+		}
+		return sess.EncryptKey
+	}
+	if first, second := run(), run(); !bytes.Equal(first, second) {
+		t.Errorf("session keys differ between runs: %x, %x", first, second)
+	}
+}
+
+// TestCASE_NoSharedTrustRoots — a Sigma1 for a node or fabric the device
+// does not have is answered with NoSharedTrustRoots.
+// TestCASE_RecordsResumption — both sides store the resumption ID the
+// responder chose, with the same shared secret, under each other's node
+// ID on their own fabric.
+func TestCASE_RecordsResumption(t *testing.T) {
+	ca := newTestCA(t, testFabricID)
+	initiator, responder, fabrics := casePeers(t, ca)
+	newStore := func() *session.ResumptionStore {
+		rs, err := session.NewResumptionStore(storage.NewMemory(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	initiator.Resumption, responder.Resumption = newStore(), newStore()
+	if initErr, respErr := runCASE(t, initiator, responder, deviceNode); initErr != nil || respErr != nil {
+		t.Fatalf("CASE: initiator %v, responder %v", initErr, respErr)
+	}
+
+	ctrlRec, err := initiator.Resumption.FindByPeer(1, deviceNode)
+	if err != nil {
+		t.Fatalf("controller record: %v", err)
+	}
+	devRec, err := responder.Resumption.FindByID(ctrlRec.ResumptionID)
+	if err != nil {
+		t.Fatalf("device record for %x: %v", ctrlRec.ResumptionID, err)
+	}
+	if devRec.PeerNodeID != controllerNode || devRec.FabricIndex != fabrics.Fabrics()[0].Index {
+		t.Errorf("device record for node %#x on fabric %d", devRec.PeerNodeID, devRec.FabricIndex)
+	}
+	if len(ctrlRec.SharedSecret) == 0 || !bytes.Equal(ctrlRec.SharedSecret, devRec.SharedSecret) {
+		t.Error("shared secrets differ")
+	}
+}
+
+func TestCASE_NoSharedTrustRoots(t *testing.T) {
+	ca := newTestCA(t, testFabricID)
+	initiator, responder, _ := casePeers(t, ca)
+	initErr, respErr := runCASE(t, initiator, responder, deviceNode+1)
+	if !errors.Is(initErr, ErrNoSharedTrustRoots) || !errors.Is(respErr, ErrNoSharedTrustRoots) {
+		t.Errorf("wrong node: initiator %v, responder %v", initErr, respErr)
+	}
+	if initiator.State != StateIdle {
+		t.Errorf("initiator state %d after failure", initiator.State)
+	}
+
+	// Same fabric ID under another root is another fabric.
+	stranger, err := NewCASEInitiator(session.NewSessionManager(nil), newTestCA(t, testFabricID).fabric(t, 1, controllerNode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	initErr, respErr = runCASE(t, stranger, responder, deviceNode)
+	if !errors.Is(initErr, ErrNoSharedTrustRoots) || !errors.Is(respErr, ErrNoSharedTrustRoots) {
+		t.Errorf("foreign root: initiator %v, responder %v", initErr, respErr)
+	}
+}
+
+// TestCASE_UntrustedInitiator — an initiator that knows the fabric's IPK
+// but whose NOC does not chain to its root is rejected at Sigma3, and no
+// session is left behind.
+func TestCASE_UntrustedInitiator(t *testing.T) {
+	ca := newTestCA(t, testFabricID)
+	initiator, responder, _ := casePeers(t, ca)
+	forger := newTestCA(t, testFabricID)
+	initiator.identity.fabric.NOC = forger.issueNOC(t, &initiator.identity.fabric.OperationalKey.PublicKey, controllerNode)
+	noc, err := crypto.MatterTLVCertificate(initiator.identity.fabric.NOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiator.identity.noc = noc
+
+	initErr, respErr := runCASE(t, initiator, responder, deviceNode)
+	if !errors.Is(respErr, crypto.ErrInvalidCertificate) {
+		t.Errorf("responder: %v, want ErrInvalidCertificate", respErr)
+	}
+	var status *exchange.StatusError
+	if !errors.As(initErr, &status) || status.Report.ProtocolCode != message.SecureChannelInvalidParameter {
+		t.Errorf("initiator: %v, want InvalidParameter", initErr)
+	}
+	if n := responder.sessionManager.Metrics().ActiveSessions; n != 0 {
+		t.Errorf("responder holds %d sessions", n)
+	}
+}
+
+// TestCASE_RejectsDERCertificates — a peer presenting its NOC as X.509
+// DER rather than Matter TLV is rejected at Sigma3.
+func TestCASE_RejectsDERCertificates(t *testing.T) {
+	initiator, responder, _ := casePeers(t, newTestCA(t, testFabricID))
+	initiator.identity.noc = initiator.identity.fabric.NOC
+
+	_, respErr := runCASE(t, initiator, responder, deviceNode)
+	if !errors.Is(respErr, crypto.ErrInvalidCertificate) {
+		t.Errorf("responder: %v, want ErrInvalidCertificate", respErr)
+	}
+	if n := responder.sessionManager.Metrics().ActiveSessions; n != 0 {
+		t.Errorf("responder holds %d sessions", n)
+	}
+}
+
+// TestSecureChannel_Dispatch — one handler serves PASE and CASE and
+// drops exchanges opening with anything else.
+func TestSecureChannel_Dispatch(t *testing.T) {
+	dsm := session.NewSessionManager(nil)
+	commissionee := newCommissionee(t, 20202021, dsm)
+	sc := SecureChannel{Commissionee: commissionee}
+
+	csm := session.NewSessionManager(nil)
+	toDevice, toController := &link{addr: controllerAddr}, &link{addr: deviceAddr}
+	ctrl := exchange.NewExchangeManager(csm, toDevice)
+	dev := exchange.NewExchangeManager(dsm, toController)
+	toDevice.peer, toController.peer = dev, ctrl
+	done := make(chan error, 1)
+	dev.RegisterUnsolicitedHandler(message.ProtocolSecureChannel, func(ex *exchange.Exchange) {
+		done <- sc.HandleExchange(context.Background(), ex)
+	})
+
+	ex, err := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCommissioner(csm).EstablishPASE(context.Background(), ex, 20202021); err != nil {
+		t.Errorf("PASE through SecureChannel: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("commissionee: %v", err)
+	}
+
+	// No CASE responder: Sigma1 is not served.
+	ex, err = ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ex.Send(message.OpcodeCASESigma1, &Sigma1{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, ErrUnexpectedMessage) {
+		t.Errorf("Sigma1 without a responder: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package commissioning
+
+import "fmt"
+
+// Cluster commands exchanged after PASE. They are Interaction Model Invoke
+// commands, not Secure Channel messages; each struct's TLV layout is the
+// command's fields in the cluster specification, so the Interaction Model
+// can carry them as command data once Invoke exists.
+
+// Cluster IDs of the commissioning clusters.
+const (
+	ClusterGeneralCommissioning   uint32 = 0x0030
+	ClusterNetworkCommissioning   uint32 = 0x0031
+	ClusterOperationalCredentials uint32 = 0x003E
+)
+
+// CommissioningError is the General Commissioning cluster's
+// CommissioningErrorEnum. Every value but CommissioningOK is an error.
+type CommissioningError uint8
+
+const (
+	CommissioningOK                    CommissioningError = 0
+	CommissioningValueOutsideRange     CommissioningError = 1
+	CommissioningInvalidAuthentication CommissioningError = 2
+	CommissioningNoFailSafe            CommissioningError = 3
+	CommissioningBusyWithOtherAdmin    CommissioningError = 4
+)
+
+func (e CommissioningError) String() string {
+	switch e {
+	case CommissioningOK:
+		return "OK"
+	case CommissioningValueOutsideRange:
+		return "ValueOutsideRange"
+	case CommissioningInvalidAuthentication:
+		return "InvalidAuthentication"
+	case CommissioningNoFailSafe:
+		return "NoFailSafe"
+	case CommissioningBusyWithOtherAdmin:
+		return "BusyWithOtherAdmin"
+	}
+	return fmt.Sprintf("CommissioningError(%d)", uint8(e))
+}
+
+func (e CommissioningError) Error() string { return "commissioning: device reported " + e.String() }
+
+// NOCStatus is the Operational Credentials cluster's
+// NodeOperationalCertStatusEnum. Every value but NOCStatusOK is an error.
+type NOCStatus uint8
+
+const (
+	NOCStatusOK                  NOCStatus = 0
+	NOCStatusInvalidPublicKey    NOCStatus = 1
+	NOCStatusInvalidNodeOpID     NOCStatus = 2
+	NOCStatusInvalidNOC          NOCStatus = 3
+	NOCStatusMissingCSR          NOCStatus = 4
+	NOCStatusTableFull           NOCStatus = 5
+	NOCStatusInvalidAdminSubject NOCStatus = 6
+	NOCStatusFabricConflict      NOCStatus = 9
+	NOCStatusLabelConflict       NOCStatus = 10
+	NOCStatusInvalidFabricIndex  NOCStatus = 11
+)
+
+func (s NOCStatus) String() string {
+	switch s {
+	case NOCStatusOK:
+		return "OK"
+	case NOCStatusInvalidPublicKey:
+		return "InvalidPublicKey"
+	case NOCStatusInvalidNodeOpID:
+		return "InvalidNodeOpId"
+	case NOCStatusInvalidNOC:
+		return "InvalidNOC"
+	case NOCStatusMissingCSR:
+		return "MissingCsr"
+	case NOCStatusTableFull:
+		return "TableFull"
+	case NOCStatusInvalidAdminSubject:
+		return "InvalidAdminSubject"
+	case NOCStatusFabricConflict:
+		return "FabricConflict"
+	case NOCStatusLabelConflict:
+		return "LabelConflict"
+	case NOCStatusInvalidFabricIndex:
+		return "InvalidFabricIndex"
+	}
+	return fmt.Sprintf("NOCStatus(%d)", uint8(s))
+}
+
+func (s NOCStatus) Error() string { return "commissioning: device reported NOC status " + s.String() }
+
+// NetworkingStatus is the Network Commissioning cluster's
+// NetworkCommissioningStatusEnum. Every value but NetworkingSuccess is an
+// error.
+type NetworkingStatus uint8
+
+const (
+	NetworkingSuccess                NetworkingStatus = 0
+	NetworkingOutOfRange             NetworkingStatus = 1
+	NetworkingBoundsExceeded         NetworkingStatus = 2
+	NetworkingNetworkIDNotFound      NetworkingStatus = 3
+	NetworkingDuplicateNetworkID     NetworkingStatus = 4
+	NetworkingNetworkNotFound        NetworkingStatus = 5
+	NetworkingRegulatoryError        NetworkingStatus = 6
+	NetworkingAuthFailure            NetworkingStatus = 7
+	NetworkingUnsupportedSecurity    NetworkingStatus = 8
+	NetworkingOtherConnectionFailure NetworkingStatus = 9
+	NetworkingIPv6Failed             NetworkingStatus = 10
+	NetworkingIPBindFailed           NetworkingStatus = 11
+	NetworkingUnknownError           NetworkingStatus = 12
+)
+
+func (s NetworkingStatus) String() string {
+	switch s {
+	case NetworkingSuccess:
+		return "Success"
+	case NetworkingOutOfRange:
+		return "OutOfRange"
+	case NetworkingBoundsExceeded:
+		return "BoundsExceeded"
+	case NetworkingNetworkIDNotFound:
+		return "NetworkIDNotFound"
+	case NetworkingDuplicateNetworkID:
+		return "DuplicateNetworkID"
+	case NetworkingNetworkNotFound:
+		return "NetworkNotFound"
+	case NetworkingRegulatoryError:
+		return "RegulatoryError"
+	case NetworkingAuthFailure:
+		return "AuthFailure"
+	case NetworkingUnsupportedSecurity:
+		return "UnsupportedSecurity"
+	case NetworkingOtherConnectionFailure:
+		return "OtherConnectionFailure"
+	case NetworkingIPv6Failed:
+		return "IPV6Failed"
+	case NetworkingIPBindFailed:
+		return "IPBindFailed"
+	case NetworkingUnknownError:
+		return "UnknownError"
+	}
+	return fmt.Sprintf("NetworkingStatus(%d)", uint8(s))
+}
+
+func (s NetworkingStatus) Error() string {
+	return "commissioning: device reported networking status " + s.String()
+}
+
+// RegulatoryLocation is the General Commissioning cluster's
+// RegulatoryLocationTypeEnum.
+type RegulatoryLocation uint8
+
+const (
+	RegulatoryIndoor        RegulatoryLocation = 0
+	RegulatoryOutdoor       RegulatoryLocation = 1
+	RegulatoryIndoorOutdoor RegulatoryLocation = 2
+)
+
+// CertificateType selects the certificate CertificateChainRequest asks for.
+type CertificateType uint8
+
+const (
+	CertificateDAC CertificateType = 1
+	CertificatePAI CertificateType = 2
+)
+
+// General Commissioning cluster.
+
+type ArmFailSafeRequest struct {
+	ExpiryLengthSeconds uint16 `tlv:"0"`
+	Breadcrumb          uint64 `tlv:"1"`
+}
+
+type ArmFailSafeResponse struct {
+	ErrorCode CommissioningError `tlv:"0"`
+	DebugText string             `tlv:"1"`
+}
+
+type SetRegulatoryConfigRequest struct {
+	NewRegulatoryConfig RegulatoryLocation `tlv:"0"`
+	CountryCode         string             `tlv:"1"`
+	Breadcrumb          uint64             `tlv:"2"`
+}
+
+type SetRegulatoryConfigResponse struct {
+	ErrorCode CommissioningError `tlv:"0"`
+	DebugText string             `tlv:"1"`
+}
+
+type CommissioningCompleteResponse struct {
+	ErrorCode CommissioningError `tlv:"0"`
+	DebugText string             `tlv:"1"`
+}
+
+// Operational Credentials cluster.
+
+type AttestationRequest struct {
+	AttestationNonce []byte `tlv:"0"`
+}
+
+type AttestationResponse struct {
+	AttestationElements  []byte `tlv:"0"`
+	AttestationSignature []byte `tlv:"1"`
+}
+
+type CertificateChainRequest struct {
+	CertificateType CertificateType `tlv:"0"`
+}
+
+type CertificateChainResponse struct {
+	Certificate []byte `tlv:"0"`
+}
+
+type CSRRequest struct {
+	CSRNonce       []byte `tlv:"0"`
+	IsForUpdateNOC bool   `tlv:"1,omitempty"`
+}
+
+type CSRResponse struct {
+	NOCSRElements        []byte `tlv:"0"`
+	AttestationSignature []byte `tlv:"1"`
+}
+
+type AddTrustedRootCertificateRequest struct {
+	RootCACertificate []byte `tlv:"0"`
+}
+
+type AddNOCRequest struct {
+	NOCValue         []byte `tlv:"0"`
+	ICACValue        []byte `tlv:"1,omitempty"`
+	IPKValue         []byte `tlv:"2"`
+	CaseAdminSubject uint64 `tlv:"3"`
+	AdminVendorID    uint16 `tlv:"4"`
+}
+
+type NOCResponse struct {
+	StatusCode  NOCStatus `tlv:"0"`
+	FabricIndex uint8     `tlv:"1,omitempty"`
+	DebugText   string    `tlv:"2,omitempty"`
+}
+
+// Network Commissioning cluster.
+
+type AddOrUpdateWiFiNetworkRequest struct {
+	SSID        []byte `tlv:"0"`
+	Credentials []byte `tlv:"1"`
+	Breadcrumb  uint64 `tlv:"2"`
+}
+
+type AddOrUpdateThreadNetworkRequest struct {
+	OperationalDataset []byte `tlv:"0"`
+	Breadcrumb         uint64 `tlv:"1"`
+}
+
+type NetworkConfigResponse struct {
+	NetworkingStatus NetworkingStatus `tlv:"0"`
+	DebugText        string           `tlv:"1,omitempty"`
+	NetworkIndex     uint8            `tlv:"2,omitempty"`
+}
+
+type ConnectNetworkRequest struct {
+	NetworkID  []byte `tlv:"0"`
+	Breadcrumb uint64 `tlv:"1"`
+}
+
+type ConnectNetworkResponse struct {
+	NetworkingStatus NetworkingStatus `tlv:"0"`
+	DebugText        string           `tlv:"1,omitempty"`
+	ErrorValue       int32            `tlv:"2,omitempty"`
+}
@@ -1,18 +1,27 @@
 package commissioning
 
 import (
-	"crypto/rand"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
+	"go-matter/clock"
 	"go-matter/crypto"
+	"go-matter/exchange"
 	"go-matter/message"
 	"go-matter/session"
+	"go-matter/tlv"
 )
 
 // Commissionee drives the PASE handshake from the device (responder) side.
 // (W0, L) is the persisted SPAKE2+ verifier — the device never stores the
-// passcode itself.
+// passcode itself. An enhanced commissioning window replaces it with the
+// window's own verifier while open (see OpenEnhancedCommissioningWindow).
+// It serves one handshake at a time, each on the exchange the controller
+// opened (see HandleExchange).
 type Commissionee struct {
 	State      CommissioningState
 	Salt       []byte
@@ -21,97 +30,209 @@ type Commissionee struct {
 	L          []byte
 	Random     []byte
 
-	Messenger      CommissioningMessenger
 	sessionManager *session.SessionManager
+	ex             *exchange.Exchange
 	SessionID      uint16
-	MessageCounter uint32
 
 	InitiatorRandom    []byte
 	InitiatorSessionID uint16
-	ExchangeID         uint16
+	PeerSessionParams  session.SessionParameters // zero if the initiator sent none
 	RequestPayload     []byte
 	ResponsePayload    []byte
 
 	Ke []byte // 16-byte shared key, populated after Pake3 verification
 
+	// Timeout bounds each handshake, timed on the session manager's
+	// clock. Zero selects DefaultHandshakeTimeout.
+	Timeout time.Duration
+
 	verifier *crypto.SPAKE2PVerifier
+	creds    *pakeCredentials // what the current handshake was admitted with
+
+	// mu guards the commissioning window and the handshake slot; see
+	// window.go.
+	mu             sync.Mutex
+	active         bool // a handshake holds the fields above
+	window         WindowStatus
+	windowDeadline time.Time
+	failedAttempts int
+	paseSessionID  uint16 // session the last successful attempt installed
+	enhanced       *pakeCredentials
+	discriminator  uint16
 }
 
+// NewCommissionee derives the SPAKE2+ verifier from passcode and builds a
+// responder around it. Production devices should provision the verifier
+// at manufacture and use NewCommissioneeFromVerifier instead.
 func NewCommissionee(passcode uint32, salt []byte, iterations int, sm *session.SessionManager) (*Commissionee, error) {
-	if sm == nil {
-		return nil, errors.New("commissionee: session manager must not be nil")
-	}
 	w0, L, err := crypto.ComputeSPAKE2PVerifierData(passcode, salt, iterations)
 	if err != nil {
 		return nil, fmt.Errorf("commissionee: derive verifier: %w", err)
 	}
+	return NewCommissioneeFromVerifier(&crypto.SPAKE2PVerifierData{W0: w0, L: L}, salt, iterations, sm)
+}
+
+// NewCommissioneeFromVerifier builds a responder from stored verifier data
+// and the PBKDF parameters it was derived with, so the device never needs
+// the passcode at runtime. The verifier and parameters are validated.
+func NewCommissioneeFromVerifier(v *crypto.SPAKE2PVerifierData, salt []byte, iterations int, sm *session.SessionManager) (*Commissionee, error) {
+	if sm == nil {
+		return nil, errors.New("commissionee: session manager must not be nil")
+	}
+	if v == nil {
+		return nil, errors.New("commissionee: verifier must not be nil")
+	}
+	if err := v.Validate(); err != nil {
+		return nil, fmt.Errorf("commissionee: %w", err)
+	}
+	if err := crypto.ValidatePBKDFParams(salt, iterations); err != nil {
+		return nil, fmt.Errorf("commissionee: %w", err)
+	}
 	return &Commissionee{
 		State:          StateIdle,
 		sessionManager: sm,
 		Salt:           append([]byte(nil), salt...),
 		Iterations:     uint32(iterations),
-		W0:             w0,
-		L:              L,
+		W0:             append([]byte(nil), v.W0...),
+		L:              append([]byte(nil), v.L...),
 	}, nil
 }
 
+// HandleExchange serves the handshake a controller opened with ex, whose
+// PBKDFParamRequest is waiting in ex.Inbox, and returns once the secure
+// session is installed and the controller told so with a StatusReport. It
+// has the shape to be called from an exchange.UnsolicitedHandler
+// registered for message.ProtocolSecureChannel; ex is closed on return.
+//
+// Attempts are only served while a commissioning window is open; others
+// are ignored with ErrWindowNotOpen. An attempt that arrives while another
+// handshake is in progress, or while the PASE session of an earlier one
+// is still installed, is answered with Busy and fails with ErrBusy.
+//
+// Each message must be the one the current state expects; anything else,
+// like a malformed message or a wrong passcode, fails the handshake with
+// an InvalidParameter StatusReport to the controller. On any failure,
+// timeout included, the Commissionee resets to StateIdle and releases its
+// reserved session ID, ready for the next attempt; MaxFailedPASEAttempts
+// failures close the window.
+func (c *Commissionee) HandleExchange(ctx context.Context, ex *exchange.Exchange) error {
+	return c.serve(ctx, ex, nil)
+}
+
+// serve runs HandleExchange; first, if not nil, is the exchange's first
+// message, already taken from its inbox.
+func (c *Commissionee) serve(ctx context.Context, ex *exchange.Exchange, first *message.Frame) error {
+	defer ex.Close()
+	creds, err := c.admit()
+	if err != nil {
+		if errors.Is(err, ErrBusy) {
+			ex.SendStatusReport(statusBusy)
+		}
+		return err
+	}
+	c.reset()
+	c.creds = creds
+	err = c.handshake(ctx, ex, first)
+	c.release(err, c.SessionID)
+	return err
+}
+
+// handshake runs one admitted attempt on ex, starting with first if it
+// is not nil.
+func (c *Commissionee) handshake(ctx context.Context, ex *exchange.Exchange, first *message.Frame) error {
+	c.ex = ex
+	c.State = StateIdle
+	ctx, cancel := clock.WithTimeout(ctx, c.sessionManager.Clock(), handshakeTimeout(c.Timeout))
+	defer cancel()
+	for c.State != StateComplete {
+		frame := first
+		first = nil
+		if frame == nil {
+			var err error
+			if frame, err = receive(ctx, ex); err != nil {
+				return c.fail(fmt.Errorf("commissionee: %w", err), false)
+			}
+		}
+		if err := c.HandleMessage(frame); err != nil {
+			return c.fail(err, true)
+		}
+	}
+	return nil
+}
+
+// HandleMessage processes one message from the commissioner and sends the
+// reply, if the handshake step has one. HandleExchange calls it for each
+// message on the exchange.
 func (c *Commissionee) HandleMessage(frame *message.Frame) error {
-	switch frame.PayloadHeader.Opcode {
-	case message.OpcodePBKDFParamRequest:
+	switch op := frame.PayloadHeader.Opcode; {
+	case c.State == StateIdle && op == message.OpcodePBKDFParamRequest:
 		return c.handlePBKDFParamRequest(frame)
-	case message.OpcodePASEPake1:
+	case c.State == StatePASE_Pake1 && op == message.OpcodePASEPake1:
 		return c.handlePake1(frame)
-	case message.OpcodePASEPake3:
+	case c.State == StatePASE_Pake3 && op == message.OpcodePASEPake3:
 		return c.handlePake3(frame)
 	default:
-		return fmt.Errorf("commissionee: unexpected opcode %#x in state %d",
-			byte(frame.PayloadHeader.Opcode), c.State)
+		return fmt.Errorf("commissionee: %w: opcode %#x in state %d",
+			ErrUnexpectedMessage, byte(op), c.State)
 	}
 }
 
 func (c *Commissionee) handlePBKDFParamRequest(frame *message.Frame) error {
 	var req PBKDFParamRequest
-	if err := decodePayload(frame.Payload, &req); err != nil {
+	if err := tlv.Unmarshal(frame.Payload, &req); err != nil {
 		return fmt.Errorf("commissionee: decode PBKDFParamRequest: %w", err)
 	}
 
+	if c.creds == nil {
+		return errors.New("commissionee: no commissioning window admitted the attempt")
+	}
+	if req.PasscodeID != c.creds.passcodeID {
+		return fmt.Errorf("commissionee: %w: %d", ErrUnknownPasscodeID, req.PasscodeID)
+	}
+
 	c.InitiatorRandom = req.InitiatorRandom
 	c.InitiatorSessionID = req.InitiatorSessionID
-	c.ExchangeID = frame.PayloadHeader.ExchangeID
+	if req.InitiatorSessionParams != nil {
+		c.PeerSessionParams = *req.InitiatorSessionParams
+	}
 	c.RequestPayload = frame.Payload
 
 	c.Random = make([]byte, 32)
-	if _, err := rand.Read(c.Random); err != nil {
+	if _, err := io.ReadFull(c.sessionManager.CryptoProvider().Rand(), c.Random); err != nil {
 		return fmt.Errorf("commissionee: random: %w", err)
 	}
-	if c.SessionID == 0 {
-		c.SessionID = 23456 // TODO: draw from SessionManager.
+	id, err := c.sessionManager.AllocateSessionID()
+	if err != nil {
+		return fmt.Errorf("commissionee: %w", err)
 	}
+	c.SessionID = id
 
+	params := c.sessionManager.LocalSessionParameters()
 	resp := PBKDFParamResponse{
-		InitiatorRandom:    c.InitiatorRandom,
-		ResponderRandom:    c.Random,
-		ResponderSessionID: c.SessionID,
+		InitiatorRandom:        c.InitiatorRandom,
+		ResponderRandom:        c.Random,
+		ResponderSessionID:     c.SessionID,
+		ResponderSessionParams: &params,
 	}
 	if !req.HasPBKDFParameters {
-		resp.Params = &PBKDFParamSet{Iterations: c.Iterations, Salt: c.Salt}
+		resp.Params = &PBKDFParamSet{Iterations: c.creds.iterations, Salt: c.creds.salt}
 	}
 
-	out, err := c.buildFrame(message.OpcodePBKDFParamResponse, frame.Header.MessageCounter, &resp)
+	payload, err := tlv.Marshal(&resp)
 	if err != nil {
-		return err
+		return fmt.Errorf("commissionee: encode PBKDFParamResponse: %w", err)
 	}
-	c.ResponsePayload = out.Payload
+	c.ResponsePayload = payload
 	c.State = StatePASE_Pake1
-	return c.send(out)
+	return c.send(message.OpcodePBKDFParamResponse, payload)
 }
 
 func (c *Commissionee) handlePake1(frame *message.Frame) error {
 	var p1 Pake1
-	if err := decodePayload(frame.Payload, &p1); err != nil {
+	if err := tlv.Unmarshal(frame.Payload, &p1); err != nil {
 		return fmt.Errorf("commissionee: decode Pake1: %w", err)
 	}
-	verifier, err := crypto.NewSPAKE2PVerifier(c.W0, c.L, paseContext(c.RequestPayload, c.ResponsePayload))
+	verifier, err := c.sessionManager.CryptoProvider().NewSPAKE2PVerifier(c.creds.w0, c.creds.l, paseContext(c.RequestPayload, c.ResponsePayload))
 	if err != nil {
 		return fmt.Errorf("commissionee: new verifier: %w", err)
 	}
@@ -128,12 +249,8 @@ func (c *Commissionee) handlePake1(frame *message.Frame) error {
 	}
 	c.verifier = verifier
 
-	out, err := c.buildFrame(message.OpcodePASEPake2, frame.Header.MessageCounter, &Pake2{PB: pB, CB: cB})
-	if err != nil {
-		return err
-	}
 	c.State = StatePASE_Pake3
-	return c.send(out)
+	return c.send(message.OpcodePASEPake2, &Pake2{PB: pB, CB: cB})
 }
 
 func (c *Commissionee) handlePake3(frame *message.Frame) error {
@@ -141,7 +258,7 @@ func (c *Commissionee) handlePake3(frame *message.Frame) error {
 		return errors.New("commissionee: Pake3 received before Pake1")
 	}
 	var p3 Pake3
-	if err := decodePayload(frame.Payload, &p3); err != nil {
+	if err := tlv.Unmarshal(frame.Payload, &p3); err != nil {
 		return fmt.Errorf("commissionee: decode Pake3: %w", err)
 	}
 	if err := c.verifier.VerifyConfirmationA(p3.CA); err != nil {
@@ -153,42 +270,53 @@ func (c *Commissionee) handlePake3(frame *message.Frame) error {
 	}
 	c.Ke = ke
 
-	if err := installPASESession(c.sessionManager, c.SessionID, c.Ke, session.RoleResponder); err != nil {
+	if err := installPASESession(c.sessionManager, c.SessionID, c.InitiatorSessionID, c.Ke, session.RoleResponder, c.PeerSessionParams); err != nil {
 		return fmt.Errorf("commissionee: %w", err)
 	}
 
 	c.State = StateComplete
+	if err := c.ex.SendStatusReport(statusSessionEstablished); err != nil {
+		return fmt.Errorf("commissionee: send StatusReport: %w", err)
+	}
 	return nil
 }
 
-// buildFrame assembles an outgoing responder-side PASE frame: bumps the
-// message counter, sets the standard unsecured/SecureChannel/R flags, and
-// piggybacks an Ack when ackMC != 0.
-func (c *Commissionee) buildFrame(opcode message.Opcode, ackMC uint32, payload any) (*message.Frame, error) {
-	if err := bumpCounter(&c.MessageCounter); err != nil {
-		return nil, err
-	}
-	b := message.NewBuilder().
-		Unsecured().
-		Protocol(message.ProtocolSecureChannel).
-		Opcode(opcode).
-		ExchangeID(c.ExchangeID).
-		MessageCounter(c.MessageCounter).
-		RequestAck().
-		Payload(payload)
-	if ackMC != 0 {
-		b = b.AckCounter(ackMC)
-	}
-	frame, err := b.Build()
-	if err != nil {
-		return nil, fmt.Errorf("commissionee: build opcode=%#x: %w", byte(opcode), err)
+// fail resets the Commissionee after err ended the handshake, first
+// telling the controller with an InvalidParameter StatusReport if notify
+// is set. It returns err. The caller holds the handshake (c.active).
+func (c *Commissionee) fail(err error, notify bool) error {
+	if notify && c.ex != nil {
+		c.ex.SendStatusReport(statusInvalidParameter)
 	}
-	return frame, nil
+	c.sessionManager.ReleaseSessionID(c.SessionID)
+	c.reset()
+	return err
+}
+
+// reset clears the last handshake, successful or not, so the next one
+// starts from nothing: a fresh session ID, no stale Ke or verifier. The
+// caller holds the handshake (c.active).
+func (c *Commissionee) reset() {
+	c.State = StateIdle
+	c.ex = nil
+	c.SessionID = 0
+	c.Random = nil
+	c.InitiatorRandom = nil
+	c.InitiatorSessionID = 0
+	c.PeerSessionParams = session.SessionParameters{}
+	c.RequestPayload = nil
+	c.ResponsePayload = nil
+	c.Ke = nil
+	c.verifier = nil
+	c.creds = nil
 }
 
-func (c *Commissionee) send(frame *message.Frame) error {
-	if c.Messenger == nil {
-		return nil
+func (c *Commissionee) send(opcode message.Opcode, payload any) error {
+	if c.ex == nil {
+		return errNoExchange
 	}
-	return c.Messenger.SendMessage(frame)
+	if err := c.ex.Send(opcode, payload); err != nil {
+		return fmt.Errorf("commissionee: send opcode=%#x: %w", byte(opcode), err)
+	}
+	return nil
 }
@@ -2,28 +2,33 @@ package commissioning
 
 import (
 	"bytes"
-	"crypto/rand"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
+	"go-matter/clock"
 	"go-matter/crypto"
+	"go-matter/exchange"
 	"go-matter/message"
 	"go-matter/session"
+	"go-matter/setup"
+	"go-matter/tlv"
 )
 
 // Commissioner drives the PASE handshake from the controller (initiator) side.
 //
 // SessionID is the initiator's chosen *future* secure session ID; the PASE
-// frames themselves use unsecured session 0.
+// frames themselves use unsecured session 0, on an exchange whose IDs,
+// counters and acks the exchange layer owns.
 type Commissioner struct {
 	State          CommissioningState
-	Messenger      CommissioningMessenger
 	sessionManager *session.SessionManager
+	ex             *exchange.Exchange
 	Passcode       uint32
 	Random         []byte
 	SessionID      uint16
-	ExchangeID     uint16
-	MessageCounter uint32
 
 	RequestPayload  []byte
 	ResponsePayload []byte
@@ -32,87 +37,160 @@ type Commissioner struct {
 	ResponderSessionID uint16
 	Salt               []byte
 	Iterations         uint32
+	PeerSessionParams  session.SessionParameters // zero if the responder sent none
 
 	Ke []byte // 16-byte shared key, populated after Pake2 verification
 
+	// Timeout bounds the whole handshake, timed on the session manager's
+	// clock. Zero selects DefaultHandshakeTimeout.
+	Timeout time.Duration
+
+	// PasscodeID selects the device's verifier: DefaultPasscodeID for the
+	// onboarding passcode, or the one an administrator gave an enhanced
+	// commissioning window.
+	PasscodeID uint16
+	// PBKDFParams, if set, are the salt and iterations the device's
+	// verifier was derived with, known beforehand (e.g. from the
+	// administrator who opened an enhanced window). The request then sets
+	// HasPBKDFParameters and the device leaves them out of its response.
+	PBKDFParams *PBKDFParamSet
+
 	prover *crypto.SPAKE2PProver
 }
 
 // NewCommissioner constructs a Commissioner. sm must not be nil — PASE
-// produces a secure session which is installed in sm after Pake2; passing
-// nil panics immediately rather than nil-derefing mid-handshake.
-func NewCommissioner(messenger CommissioningMessenger, sm *session.SessionManager) *Commissioner {
+// produces a secure session which is installed in sm once the device
+// confirms Pake3; passing nil panics immediately rather than nil-derefing
+// mid-handshake.
+func NewCommissioner(sm *session.SessionManager) *Commissioner {
 	if sm == nil {
 		panic("commissioning: NewCommissioner requires a non-nil SessionManager")
 	}
-	return &Commissioner{State: StateIdle, Messenger: messenger, sessionManager: sm}
+	return &Commissioner{State: StateIdle, sessionManager: sm}
 }
 
-func (c *Commissioner) StartPASE(passcode uint32) error {
+// EstablishPASE runs the handshake on ex, an exchange opened towards the
+// device on session.UnsecuredSessionID for message.ProtocolSecureChannel,
+// and returns once the device has confirmed it with a StatusReport and the
+// secure session is installed. On failure the device is sent an
+// InvalidParameter StatusReport, unless it reported the failure itself
+// (an *exchange.StatusError, e.g. Busy) or the handshake timed out, and
+// the Commissioner resets to StateIdle, releasing its reserved session ID,
+// so it can be used for another attempt. ex is closed either way.
+func (c *Commissioner) EstablishPASE(ctx context.Context, ex *exchange.Exchange, passcode uint32) error {
+	c.reset()
+	c.ex = ex
+	defer ex.Close()
+	ctx, cancel := clock.WithTimeout(ctx, c.sessionManager.Clock(), handshakeTimeout(c.Timeout))
+	defer cancel()
+	if err := c.start(passcode); err != nil {
+		return c.fail(err, true)
+	}
+	for c.State != StateComplete {
+		frame, err := receive(ctx, ex)
+		if err != nil {
+			return c.fail(fmt.Errorf("commissioner: %w", err), false)
+		}
+		if err := c.HandleMessage(frame); err != nil {
+			return c.fail(err, true)
+		}
+	}
+	return nil
+}
+
+// EstablishPASEWithPayload runs EstablishPASE with the passcode from a
+// scanned QR code or typed manual pairing code (see setup.Parse). The
+// payload is validated first so a mistyped code fails here rather than at
+// Pake2.
+func (c *Commissioner) EstablishPASEWithPayload(ctx context.Context, ex *exchange.Exchange, p *setup.Payload) error {
+	if err := p.Validate(); err != nil {
+		ex.Close()
+		return fmt.Errorf("commissioner: %w", err)
+	}
+	return c.EstablishPASE(ctx, ex, p.Passcode)
+}
+
+// start sends PBKDFParamRequest.
+func (c *Commissioner) start(passcode uint32) error {
 	c.Passcode = passcode
 
 	c.Random = make([]byte, 32)
-	if _, err := rand.Read(c.Random); err != nil {
+	if _, err := io.ReadFull(c.sessionManager.CryptoProvider().Rand(), c.Random); err != nil {
 		return err
 	}
-	if c.SessionID == 0 {
-		c.SessionID = 12345 // TODO: draw from SessionManager.
-	}
-	if c.ExchangeID == 0 {
-		c.ExchangeID = 1
+	id, err := c.sessionManager.AllocateSessionID()
+	if err != nil {
+		return fmt.Errorf("commissioner: %w", err)
 	}
+	c.SessionID = id
 
-	frame, err := c.buildFrame(message.OpcodePBKDFParamRequest, 0, &PBKDFParamRequest{
-		InitiatorRandom:    c.Random,
-		InitiatorSessionID: c.SessionID,
+	params := c.sessionManager.LocalSessionParameters()
+	payload, err := tlv.Marshal(&PBKDFParamRequest{
+		InitiatorRandom:        c.Random,
+		InitiatorSessionID:     c.SessionID,
+		PasscodeID:             c.PasscodeID,
+		HasPBKDFParameters:     c.PBKDFParams != nil,
+		InitiatorSessionParams: &params,
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("commissioner: encode PBKDFParamRequest: %w", err)
 	}
-	c.RequestPayload = frame.Payload
+	c.RequestPayload = payload
 	c.State = StatePASE_PBKDFParamResponse
-	return c.send(frame)
+	return c.send(message.OpcodePBKDFParamRequest, payload)
 }
 
+// HandleMessage processes one message from the commissionee and sends the
+// next message of the handshake. EstablishPASE calls it for each reply.
 func (c *Commissioner) HandleMessage(frame *message.Frame) error {
-	switch frame.PayloadHeader.Opcode {
-	case message.OpcodePBKDFParamResponse:
+	switch op := frame.PayloadHeader.Opcode; {
+	case c.State == StatePASE_PBKDFParamResponse && op == message.OpcodePBKDFParamResponse:
 		return c.handlePBKDFParamResponse(frame)
-	case message.OpcodePASEPake2:
+	case c.State == StatePASE_Pake2 && op == message.OpcodePASEPake2:
 		return c.handlePake2(frame)
+	case c.State == StatePASE_StatusReport && message.IsStatusReport(frame):
+		return c.handleStatusReport(frame)
 	default:
-		return fmt.Errorf("commissioner: unexpected opcode %#x in state %d",
-			byte(frame.PayloadHeader.Opcode), c.State)
+		return fmt.Errorf("commissioner: %w: opcode %#x in state %d",
+			ErrUnexpectedMessage, byte(op), c.State)
 	}
 }
 
 func (c *Commissioner) handlePBKDFParamResponse(frame *message.Frame) error {
 	var resp PBKDFParamResponse
-	if err := decodePayload(frame.Payload, &resp); err != nil {
+	if err := tlv.Unmarshal(frame.Payload, &resp); err != nil {
 		return fmt.Errorf("commissioner: decode PBKDFParamResponse: %w", err)
 	}
 	if !bytes.Equal(resp.InitiatorRandom, c.Random) {
 		return errors.New("commissioner: PBKDFParamResponse echoed wrong InitiatorRandom")
 	}
-	if resp.Params == nil {
-		return errors.New("commissioner: PBKDFParamResponse missing PBKDF parameters")
+	params := resp.Params
+	if params == nil {
+		params = c.PBKDFParams
+	}
+	if params == nil {
+		return fmt.Errorf("commissioner: %w: PBKDFParamResponse omitted them", ErrMissingPBKDFParams)
 	}
 
 	c.ResponderRandom = resp.ResponderRandom
 	c.ResponderSessionID = resp.ResponderSessionID
-	c.Salt = resp.Params.Salt
-	c.Iterations = resp.Params.Iterations
+	c.Salt = params.Salt
+	c.Iterations = params.Iterations
+	if resp.ResponderSessionParams != nil {
+		c.PeerSessionParams = *resp.ResponderSessionParams
+	}
 	c.ResponsePayload = frame.Payload
 
-	return c.sendPake1(frame.Header.MessageCounter)
+	return c.sendPake1()
 }
 
-func (c *Commissioner) sendPake1(ackMC uint32) error {
-	w0, w1, err := crypto.Spake2pW0W1FromPasscode(c.Passcode, c.Salt, int(c.Iterations))
+func (c *Commissioner) sendPake1() error {
+	provider := c.sessionManager.CryptoProvider()
+	w0, w1, err := crypto.Spake2pW0W1FromPasscodeWith(provider, c.Passcode, c.Salt, int(c.Iterations))
 	if err != nil {
 		return fmt.Errorf("commissioner: derive w0/w1: %w", err)
 	}
-	c.prover, err = crypto.NewSPAKE2PProver(w0, w1, paseContext(c.RequestPayload, c.ResponsePayload))
+	c.prover, err = provider.NewSPAKE2PProver(w0, w1, paseContext(c.RequestPayload, c.ResponsePayload))
 	if err != nil {
 		return fmt.Errorf("commissioner: new prover: %w", err)
 	}
@@ -121,12 +199,8 @@ func (c *Commissioner) sendPake1(ackMC uint32) error {
 		return fmt.Errorf("commissioner: ComputePA: %w", err)
 	}
 
-	frame, err := c.buildFrame(message.OpcodePASEPake1, ackMC, &Pake1{PA: pA})
-	if err != nil {
-		return err
-	}
 	c.State = StatePASE_Pake2
-	return c.send(frame)
+	return c.send(message.OpcodePASEPake1, &Pake1{PA: pA})
 }
 
 func (c *Commissioner) handlePake2(frame *message.Frame) error {
@@ -134,7 +208,7 @@ func (c *Commissioner) handlePake2(frame *message.Frame) error {
 		return errors.New("commissioner: Pake2 received before Pake1 sent")
 	}
 	var p2 Pake2
-	if err := decodePayload(frame.Payload, &p2); err != nil {
+	if err := tlv.Unmarshal(frame.Payload, &p2); err != nil {
 		return fmt.Errorf("commissioner: decode Pake2: %w", err)
 	}
 	if err := c.prover.Finalize(p2.PB); err != nil {
@@ -150,54 +224,56 @@ func (c *Commissioner) handlePake2(frame *message.Frame) error {
 	if c.Ke, err = c.prover.SharedKey(); err != nil {
 		return err
 	}
+	c.State = StatePASE_StatusReport
+	return c.send(message.OpcodePASEPake3, &Pake3{CA: cA})
+}
 
-	if err := installPASESession(c.sessionManager, c.SessionID, c.Ke, session.RoleInitiator); err != nil {
-		return fmt.Errorf("commissioner: %w", err)
+// handleStatusReport completes the handshake once the device has accepted
+// Pake3. A failure report never gets here: Receive returns it as an error.
+func (c *Commissioner) handleStatusReport(frame *message.Frame) error {
+	var r message.StatusReport
+	if err := r.Unmarshal(frame.Payload); err != nil {
+		return fmt.Errorf("commissioner: decode StatusReport: %w", err)
 	}
-
-	out, err := c.buildFrame(message.OpcodePASEPake3, frame.Header.MessageCounter, &Pake3{CA: cA})
-	if err != nil {
-		return err
+	if r.ProtocolID != message.ProtocolSecureChannel || r.ProtocolCode != message.SecureChannelSessionEstablishmentSuccess {
+		return fmt.Errorf("commissioner: %w: status %v", ErrUnexpectedMessage, &r)
+	}
+	if err := installPASESession(c.sessionManager, c.SessionID, c.ResponderSessionID, c.Ke, session.RoleInitiator, c.PeerSessionParams); err != nil {
+		return fmt.Errorf("commissioner: %w", err)
 	}
 	c.State = StateComplete
-	return c.send(out)
+	return nil
 }
 
-// StartCASE is a stub. TODO: implement CASE Sigma1.
-func (c *Commissioner) StartCASE(nodeID uint64) error {
-	c.State = StateCASE
-	return nil
+// fail resets the Commissioner after err ended the handshake, first
+// telling the device with an InvalidParameter StatusReport if notify is
+// set. It returns err.
+func (c *Commissioner) fail(err error, notify bool) error {
+	if notify && c.ex != nil {
+		c.ex.SendStatusReport(statusInvalidParameter)
+	}
+	c.sessionManager.ReleaseSessionID(c.SessionID)
+	c.reset()
+	return err
 }
 
-// buildFrame assembles an outgoing initiator-side PASE frame: bumps the
-// message counter, sets the standard unsecured/SecureChannel/Initiator/R
-// flags, and piggybacks an Ack when ackMC != 0.
-func (c *Commissioner) buildFrame(opcode message.Opcode, ackMC uint32, payload any) (*message.Frame, error) {
-	if err := bumpCounter(&c.MessageCounter); err != nil {
-		return nil, err
-	}
-	b := message.NewBuilder().
-		Unsecured().
-		Protocol(message.ProtocolSecureChannel).
-		Opcode(opcode).
-		ExchangeID(c.ExchangeID).
-		MessageCounter(c.MessageCounter).
-		Initiator().
-		RequestAck().
-		Payload(payload)
-	if ackMC != 0 {
-		b = b.AckCounter(ackMC)
-	}
-	frame, err := b.Build()
-	if err != nil {
-		return nil, fmt.Errorf("commissioner: build opcode=%#x: %w", byte(opcode), err)
+// reset clears the last handshake, keeping the configuration.
+func (c *Commissioner) reset() {
+	*c = Commissioner{
+		State:          StateIdle,
+		sessionManager: c.sessionManager,
+		Timeout:        c.Timeout,
+		PasscodeID:     c.PasscodeID,
+		PBKDFParams:    c.PBKDFParams,
 	}
-	return frame, nil
 }
 
-func (c *Commissioner) send(frame *message.Frame) error {
-	if c.Messenger == nil {
-		return nil
+func (c *Commissioner) send(opcode message.Opcode, payload any) error {
+	if c.ex == nil {
+		return errNoExchange
 	}
-	return c.Messenger.SendMessage(frame)
+	if err := c.ex.Send(opcode, payload); err != nil {
+		return fmt.Errorf("commissioner: send opcode=%#x: %w", byte(opcode), err)
+	}
+	return nil
 }
@@ -1,20 +1,35 @@
 // Package commissioning implements the Matter PASE handshake (Matter §4.13).
 // Commissioner is the controller (initiator) side; Commissionee is the device
-// (responder) side. Wire-format types live in messages.go.
+// (responder) side. Each runs inside an *exchange.Exchange on the unsecured
+// session, so message counters, exchange IDs, acks and retransmissions come
+// from the exchange layer. Wire-format types live in messages.go.
+//
+// CASEInitiator and CASEResponder run the CASE handshake (Matter §4.14.2)
+// the same way, between nodes holding operational certificates on a shared
+// fabric; its wire types live in case.go. SecureChannel serves PASE and
+// CASE on one unsolicited handler.
+//
+// Orchestrator drives the rest of commissioning from the controller, over
+// the PASE session and then CASE, through the commissioning clusters'
+// commands in commands.go; Server handles them on the device, under a
+// fail-safe that rolls back what an unfinished commissioning staged.
 package commissioning
 
 import (
-	"bytes"
-	"crypto/rand"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"time"
 
 	"go-matter/crypto"
+	"go-matter/exchange"
 	"go-matter/message"
 	"go-matter/session"
-	"go-matter/tlv"
 )
 
+// CommissioningState names the message a handshake is waiting for; each
+// side accepts only that message in each state.
 type CommissioningState int
 
 const (
@@ -23,14 +38,44 @@ const (
 	StatePASE_Pake1
 	StatePASE_Pake2
 	StatePASE_Pake3
-	StateCASE
+	StatePASE_StatusReport
+	StateCASE_Sigma2
+	StateCASE_Sigma3
+	StateCASE_StatusReport
 	StateComplete
 	StateError
 )
 
-type CommissioningMessenger interface {
-	SendMessage(frame *message.Frame) error
-}
+// DefaultHandshakeTimeout bounds a whole PASE handshake when the
+// Commissioner's or Commissionee's Timeout is zero.
+const DefaultHandshakeTimeout = 30 * time.Second
+
+// busyRetryDelay is the minimum wait a Busy StatusReport asks the
+// initiator to observe before retrying.
+const busyRetryDelay = 500 * time.Millisecond
+
+var (
+	// ErrBusy is returned by Commissionee.HandleExchange for an attempt
+	// that arrives while another handshake is in progress or an earlier
+	// one's PASE session is still open. The initiator is told with a Busy
+	// StatusReport.
+	ErrBusy = errors.New("commissioning: handshake already in progress")
+	// ErrUnexpectedMessage is returned for a message the handshake's
+	// current state does not accept, such as Pake3 before Pake1.
+	ErrUnexpectedMessage = errors.New("commissioning: unexpected message")
+	// ErrHandshakeTimeout is returned when a handshake does not finish
+	// within its Timeout.
+	ErrHandshakeTimeout = errors.New("commissioning: handshake timed out")
+	// ErrUnknownPasscodeID is returned by the Commissionee for a
+	// PBKDFParamRequest whose PasscodeID names no verifier of the open
+	// window.
+	ErrUnknownPasscodeID = errors.New("commissioning: unknown passcode ID")
+	// ErrMissingPBKDFParams is returned by the Commissioner when the
+	// responder omits the PBKDF parameters and none are known beforehand.
+	ErrMissingPBKDFParams = errors.New("commissioning: PBKDF parameters not known")
+
+	errNoExchange = errors.New("commissioning: no exchange")
+)
 
 const paseContextPrefix = "CHIP PAKE V1 Commissioning"
 
@@ -45,48 +90,93 @@ func paseContext(req, resp []byte) []byte {
 	return out
 }
 
-// decodePayload reads one top-level TLV element, recursively populating
-// SubElements for containers, then reflects it into out.
-func decodePayload(payload []byte, out any) error {
-	r := tlv.NewReader(bytes.NewReader(payload))
-	elem, err := r.ReadElement()
+// installPASESession derives the AES-CCM session keys from Ke
+// (Matter §4.13.2.1) and registers them in sm under the local id with the
+// peer's chosen session ID, role and advertised session parameters. Called
+// by both PASE handlers once SharedKey() has succeeded.
+func installPASESession(sm *session.SessionManager, id, peerID uint16, ke []byte, role session.Role, peer session.SessionParameters) error {
+	keys, err := crypto.DeriveSessionKeysFromKeWith(sm.CryptoProvider(), ke)
 	if err != nil {
-		return err
-	}
-	switch elem.Type {
-	case tlv.TypeStructure, tlv.TypeArray, tlv.TypeList:
-		children, err := r.ReadContainerChildren()
-		if err != nil {
-			return err
-		}
-		elem.SubElements = children
+		return fmt.Errorf("derive session keys: %w", err)
 	}
-	return tlv.Decode(elem, out)
+	_, err = sm.CreateSession(session.SessionParams{
+		ID:             id,
+		PeerSessionID:  peerID,
+		Type:           session.SessionTypePASE,
+		LocalNodeID:    session.UnspecifiedNodeID,
+		PeerNodeID:     session.UnspecifiedNodeID,
+		Keys:           keys,
+		Role:           role,
+		PeerParameters: peer,
+	})
+	return err
 }
 
-// bumpCounter seeds *ctr from 32 random bits on first use (Matter §4.5.1.1)
-// and increments thereafter.
-func bumpCounter(ctr *uint32) error {
-	if *ctr == 0 {
-		var b [4]byte
-		if _, err := rand.Read(b[:]); err != nil {
-			return err
-		}
-		*ctr = binary.LittleEndian.Uint32(b[:])
-		return nil
+// Secure Channel StatusReports ending a PASE handshake (Matter §4.11.1.3).
+var (
+	statusSessionEstablished = message.StatusReport{
+		GeneralCode:  message.GeneralCodeSuccess,
+		ProtocolID:   message.ProtocolSecureChannel,
+		ProtocolCode: message.SecureChannelSessionEstablishmentSuccess,
+	}
+	statusInvalidParameter = message.StatusReport{
+		GeneralCode:  message.GeneralCodeFailure,
+		ProtocolID:   message.ProtocolSecureChannel,
+		ProtocolCode: message.SecureChannelInvalidParameter,
 	}
-	*ctr++
-	return nil
+	statusBusy = message.StatusReport{
+		GeneralCode:  message.GeneralCodeBusy,
+		ProtocolID:   message.ProtocolSecureChannel,
+		ProtocolCode: message.SecureChannelBusy,
+		ProtocolData: binary.LittleEndian.AppendUint16(nil, uint16(busyRetryDelay.Milliseconds())),
+	}
+)
+
+// handshakeTimeout returns d, or DefaultHandshakeTimeout if d is zero.
+func handshakeTimeout(d time.Duration) time.Duration {
+	if d == 0 {
+		return DefaultHandshakeTimeout
+	}
+	return d
 }
 
-// installPASESession derives the AES-CCM session keys from Ke
-// (Matter §4.13.2.1) and registers them in sm under id with role.
-// Called by both PASE handlers once SharedKey() has succeeded.
-func installPASESession(sm *session.SessionManager, id uint16, ke []byte, role session.Role) error {
-	keys, err := crypto.DeriveSessionKeysFromKe(ke)
+// SecureChannel serves the handshakes peers open on
+// message.ProtocolSecureChannel, telling them apart by their first
+// message: PASE (PBKDFParamRequest) goes to Commissionee and CASE (Sigma1)
+// to CASE. Its HandleExchange has the shape to be called from the
+// protocol's exchange.UnsolicitedHandler.
+type SecureChannel struct {
+	Commissionee *Commissionee  // nil on a device not accepting PASE
+	CASE         *CASEResponder // nil on a node not accepting CASE
+}
+
+// HandleExchange serves the handshake ex opens as
+// Commissionee.HandleExchange or CASEResponder.HandleExchange does. An
+// exchange starting with any other message, or with a handshake nobody
+// serves, is closed unanswered with ErrUnexpectedMessage.
+func (s SecureChannel) HandleExchange(ctx context.Context, ex *exchange.Exchange) error {
+	first, err := ex.Receive(ctx)
 	if err != nil {
-		return fmt.Errorf("derive session keys: %w", err)
+		ex.Close()
+		return fmt.Errorf("commissioning: %w", err)
+	}
+	switch op := first.PayloadHeader.Opcode; {
+	case op == message.OpcodePBKDFParamRequest && s.Commissionee != nil:
+		return s.Commissionee.serve(ctx, ex, first)
+	case op == message.OpcodeCASESigma1 && s.CASE != nil:
+		return s.CASE.serve(ctx, ex, first)
+	default:
+		ex.Close()
+		return fmt.Errorf("commissioning: %w: opcode %#x opens no handshake served here", ErrUnexpectedMessage, byte(op))
+	}
+}
+
+// receive waits for the peer's next handshake message, turning the expiry
+// of a clock.WithTimeout context into ErrHandshakeTimeout.
+func receive(ctx context.Context, ex *exchange.Exchange) (*message.Frame, error) {
+	f, err := ex.Receive(ctx)
+	if err != nil && errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		return nil, ErrHandshakeTimeout
 	}
-	sm.InstallSecureSession(id, session.UnspecifiedNodeID, session.UnspecifiedNodeID, keys, role)
-	return nil
+	return f, err
 }
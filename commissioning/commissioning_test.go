@@ -2,18 +2,95 @@ package commissioning
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"net"
 	"testing"
+	"time"
 
+	"go-matter/clock"
+	"go-matter/crypto"
+	"go-matter/exchange"
 	"go-matter/message"
 	"go-matter/session"
 	"go-matter/tlv"
 )
 
-type loopMessenger struct {
-	deliver func(*message.Frame) error
+// testAddr is a transport address that needs no sockets.
+type testAddr string
+
+func (a testAddr) Network() string { return "test" }
+func (a testAddr) String() string  { return string(a) }
+
+const (
+	controllerAddr testAddr = "controller"
+	deviceAddr     testAddr = "device"
+)
+
+// link delivers every packet synchronously to the peer's Receive, as if
+// sent from addr.
+type link struct {
+	peer *exchange.ExchangeManager
+	addr net.Addr
+}
+
+func (l *link) SendPacket(_ net.Addr, b []byte) error {
+	l.peer.Receive(b, l.addr)
+	return nil
+}
+
+// connect returns exchange managers for sm and the device's dsm, linked
+// to each other in memory. The device side runs commissionee for every
+// exchange the controller opens and reports each outcome on done.
+func connect(sm, dsm *session.SessionManager, commissionee *Commissionee) (ctrl *exchange.ExchangeManager, done <-chan error, cancel func()) {
+	toDevice, toController := &link{addr: controllerAddr}, &link{addr: deviceAddr}
+	ctrl = exchange.NewExchangeManager(sm, toDevice)
+	dev := exchange.NewExchangeManager(dsm, toController)
+	toDevice.peer, toController.peer = dev, ctrl
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan error, 4)
+	dev.RegisterUnsolicitedHandler(message.ProtocolSecureChannel, func(ex *exchange.Exchange) {
+		results <- commissionee.HandleExchange(ctx, ex)
+	})
+	return ctrl, results, cancel
 }
 
-func (l *loopMessenger) SendMessage(f *message.Frame) error { return l.deliver(f) }
+// runPASE runs a whole handshake between commissioner and commissionee
+// and returns the first error either side hit.
+func runPASE(t *testing.T, commissioner *Commissioner, commissionee *Commissionee, passcode uint32) error {
+	t.Helper()
+	ctrl, done, cancel := connect(commissioner.sessionManager, commissionee.sessionManager, commissionee)
+	defer cancel()
+	ex, err := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commissioner.EstablishPASE(context.Background(), ex, passcode)
+	select {
+	case devErr := <-done:
+		if err == nil {
+			err = devErr
+		}
+	case <-time.After(time.Second):
+		t.Fatal("commissionee did not finish")
+	}
+	return err
+}
+
+// newCommissionee returns a Commissionee for passcode on sm with its
+// commissioning window open.
+func newCommissionee(t *testing.T, passcode uint32, sm *session.SessionManager) *Commissionee {
+	t.Helper()
+	commissionee, err := NewCommissionee(passcode, []byte("SPAKE2P Key Salt"), 1000, sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := commissionee.OpenBasicCommissioningWindow(MaxCommissioningTimeout); err != nil {
+		t.Fatal(err)
+	}
+	return commissionee
+}
 
 type pasePeers struct {
 	Commissioner   *Commissioner
@@ -24,25 +101,20 @@ type pasePeers struct {
 
 func setupPASEPeers(t *testing.T, devicePasscode, controllerPasscode uint32) (*pasePeers, error) {
 	t.Helper()
-	salt := []byte("SPAKE2P Key Salt")
-	const iterations = 1000
-	commissionerSM := session.NewSessionManager(nil)
-	commissioneeSM := session.NewSessionManager(nil)
-	commissionee, err := NewCommissionee(devicePasscode, salt, iterations, commissioneeSM)
-	if err != nil {
-		t.Fatal(err)
-	}
-	commissioner := NewCommissioner(nil, commissionerSM)
-	deviceMsg, controllerMsg := &loopMessenger{}, &loopMessenger{}
-	commissionee.Messenger, commissioner.Messenger = deviceMsg, controllerMsg
-	deviceMsg.deliver = commissioner.HandleMessage
-	controllerMsg.deliver = commissionee.HandleMessage
+	return setupPASEPeersWith(t, devicePasscode, controllerPasscode,
+		session.NewSessionManager(nil), session.NewSessionManager(nil))
+}
+
+func setupPASEPeersWith(t *testing.T, devicePasscode, controllerPasscode uint32, commissionerSM, commissioneeSM *session.SessionManager) (*pasePeers, error) {
+	t.Helper()
+	commissionee := newCommissionee(t, devicePasscode, commissioneeSM)
+	commissioner := NewCommissioner(commissionerSM)
 	return &pasePeers{
 		Commissioner:   commissioner,
 		Commissionee:   commissionee,
 		CommissionerSM: commissionerSM,
 		CommissioneeSM: commissioneeSM,
-	}, commissioner.StartPASE(controllerPasscode)
+	}, runPASE(t, commissioner, commissionee, controllerPasscode)
 }
 
 func TestPBKDFParamResponse_TLVRoundTrip(t *testing.T) {
@@ -57,7 +129,7 @@ func TestPBKDFParamResponse_TLVRoundTrip(t *testing.T) {
 		t.Fatal(err)
 	}
 	var got PBKDFParamResponse
-	if err := decodePayload(encoded, &got); err != nil {
+	if err := tlv.Unmarshal(encoded, &got); err != nil {
 		t.Fatal(err)
 	}
 	if !bytes.Equal(got.InitiatorRandom, want.InitiatorRandom) ||
@@ -73,7 +145,7 @@ func TestPBKDFParamResponse_TLVRoundTrip(t *testing.T) {
 	noParams := PBKDFParamResponse{InitiatorRandom: []byte{1}, ResponderRandom: []byte{2}, ResponderSessionID: 3}
 	enc2, _ := tlv.Marshal(&noParams)
 	var dec2 PBKDFParamResponse
-	if err := decodePayload(enc2, &dec2); err != nil {
+	if err := tlv.Unmarshal(enc2, &dec2); err != nil {
 		t.Fatal(err)
 	}
 	if dec2.Params != nil {
@@ -163,6 +235,42 @@ func TestPASE_InstallsSecureSession_Commissionee(t *testing.T) {
 	}
 }
 
+// TestPASE_ExchangesSessionParameters checks each side ends up holding the
+// other's advertised session-parameter-struct on its installed session.
+func TestPASE_ExchangesSessionParameters(t *testing.T) {
+	controllerParams := session.DefaultSessionParameters()
+	controllerParams.SessionIdleIntervalMs = 5000
+	deviceParams := session.DefaultSessionParameters()
+	deviceParams.SessionActiveIntervalMs = 1000
+	deviceParams.MaxPathsPerInvoke = 4
+
+	const passcode = uint32(12345678)
+	peers, err := setupPASEPeersWith(t, passcode, passcode,
+		session.NewSessionManagerWithConfig(nil, session.Config{LocalParameters: controllerParams}),
+		session.NewSessionManagerWithConfig(nil, session.Config{LocalParameters: deviceParams}))
+	if err != nil {
+		t.Fatalf("PASE handshake: %v", err)
+	}
+
+	s, ok := peers.CommissionerSM.Session(peers.Commissioner.SessionID)
+	if !ok {
+		t.Fatal("commissioner session not installed")
+	}
+	if s.PeerParameters != deviceParams {
+		t.Errorf("commissioner sees %+v, want %+v", s.PeerParameters, deviceParams)
+	}
+	s, ok = peers.CommissioneeSM.Session(peers.Commissionee.SessionID)
+	if !ok {
+		t.Fatal("commissionee session not installed")
+	}
+	if s.PeerParameters != controllerParams {
+		t.Errorf("commissionee sees %+v, want %+v", s.PeerParameters, controllerParams)
+	}
+	if got := s.PeerParameters.IdleInterval(); got != 5*time.Second {
+		t.Errorf("IdleInterval = %v, want 5s", got)
+	}
+}
+
 func TestPASE_WrongPasscode(t *testing.T) {
 	peers, err := setupPASEPeers(t, 12345678, 99999999)
 	if err == nil {
@@ -268,7 +376,8 @@ func TestCommissioner_HandleMessage_Errors(t *testing.T) {
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			c := NewCommissioner(nil, session.NewSessionManager(nil))
+			c := NewCommissioner(session.NewSessionManager(nil))
+			c.State = StatePASE_PBKDFParamResponse
 			c.Random = bytes.Repeat([]byte{0x11}, 32)
 			payload, _ := tlv.Marshal(&tc.resp)
 			frame := &message.Frame{
@@ -281,3 +390,240 @@ func TestCommissioner_HandleMessage_Errors(t *testing.T) {
 		})
 	}
 }
+
+func TestNewCommissioneeFromVerifier(t *testing.T) {
+	const passcode = uint32(20202021)
+	salt := []byte("SPAKE2P Key Salt")
+	v, err := crypto.NewSPAKE2PVerifierData(passcode, salt, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := v.MarshalText()
+	var stored crypto.SPAKE2PVerifierData
+	if err := stored.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+
+	commissionerSM := session.NewSessionManager(nil)
+	commissionee, err := NewCommissioneeFromVerifier(&stored, salt, 1000, session.NewSessionManager(nil))
+	if err != nil {
+		t.Fatalf("NewCommissioneeFromVerifier: %v", err)
+	}
+	if err := commissionee.OpenBasicCommissioningWindow(MaxCommissioningTimeout); err != nil {
+		t.Fatal(err)
+	}
+	commissioner := NewCommissioner(commissionerSM)
+	if err := runPASE(t, commissioner, commissionee, passcode); err != nil {
+		t.Fatalf("PASE handshake: %v", err)
+	}
+	if !bytes.Equal(commissioner.Ke, commissionee.Ke) {
+		t.Error("Ke mismatch")
+	}
+
+	if _, err := NewCommissioneeFromVerifier(&stored, []byte("salt"), 1000, session.NewSessionManager(nil)); err == nil {
+		t.Error("short salt: expected error")
+	}
+	if _, err := NewCommissioneeFromVerifier(&crypto.SPAKE2PVerifierData{W0: stored.W0, L: stored.L[:10]},
+		salt, 1000, session.NewSessionManager(nil)); err == nil {
+		t.Error("truncated L: expected error")
+	}
+}
+
+// TestPASE_DeterministicReplay — with both sides on seeded providers the
+// whole handshake, including randoms and counters, replays byte for byte.
+func TestPASE_DeterministicReplay(t *testing.T) {
+	run := func() (req, resp, ke []byte) {
+		newSM := func(seed string) *session.SessionManager {
+			return session.NewSessionManagerWithConfig(nil, session.Config{
+				CryptoProvider: crypto.NewDeterministicCryptoProvider([]byte(seed)),
+			})
+		}
+		commissionee := newCommissionee(t, 20202021, newSM("device"))
+		commissioner := NewCommissioner(newSM("controller"))
+		if err := runPASE(t, commissioner, commissionee, 20202021); err != nil {
+			t.Fatalf("PASE handshake: %v", err)
+		}
+		return commissioner.RequestPayload, commissioner.ResponsePayload, commissioner.Ke
+	}
+	req1, resp1, ke1 := run()
+	req2, resp2, ke2 := run()
+	if !bytes.Equal(req1, req2) || !bytes.Equal(resp1, resp2) || !bytes.Equal(ke1, ke2) {
+		t.Error("seeded PASE handshake did not replay identically")
+	}
+}
+
+// TestPASE_SecondControllerBusy — a second controller's attempt, on its own
+// exchange, is refused while the first handshake is in progress, and does
+// not disturb it.
+func TestPASE_SecondControllerBusy(t *testing.T) {
+	const passcode = uint32(20202021)
+	devSM := session.NewSessionManager(nil)
+	commissionee := newCommissionee(t, passcode, devSM)
+	ctrl, done, cancel := connect(session.NewSessionManager(nil), devSM, commissionee)
+	defer cancel()
+
+	// The first controller sends PBKDFParamRequest and stalls.
+	first, _ := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	stalled := NewCommissioner(session.NewSessionManager(nil))
+	stalled.ex = first
+	if err := stalled.start(passcode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Receive(context.Background()); err != nil {
+		t.Fatalf("first attempt got no PBKDFParamResponse: %v", err)
+	}
+
+	second, _ := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	err := NewCommissioner(session.NewSessionManager(nil)).EstablishPASE(context.Background(), second, passcode)
+	var se *exchange.StatusError
+	if !errors.As(err, &se) || se.Report.GeneralCode != message.GeneralCodeBusy {
+		t.Fatalf("second attempt: err = %v, want Busy StatusReport", err)
+	}
+	if err := <-done; !errors.Is(err, ErrBusy) {
+		t.Errorf("device refused second attempt with %v, want ErrBusy", err)
+	}
+	if commissionee.State != StatePASE_Pake1 || !bytes.Equal(commissionee.InitiatorRandom, stalled.Random) {
+		t.Errorf("first handshake disturbed: state %d", commissionee.State)
+	}
+}
+
+// TestCommissionee_RejectsOutOfOrder — a message the state does not expect
+// fails the attempt with InvalidParameter, and the device is ready for a
+// fresh handshake straight after.
+func TestCommissionee_RejectsOutOfOrder(t *testing.T) {
+	const passcode = uint32(20202021)
+	cases := []struct {
+		name string
+		// send drives the controller side of the bad attempt on ex.
+		send func(t *testing.T, c *Commissioner, ex *exchange.Exchange)
+	}{
+		{"Pake3 first", func(t *testing.T, _ *Commissioner, ex *exchange.Exchange) {
+			ex.Send(message.OpcodePASEPake3, &Pake3{CA: make([]byte, 32)})
+		}},
+		{"Pake1 first", func(t *testing.T, _ *Commissioner, ex *exchange.Exchange) {
+			ex.Send(message.OpcodePASEPake1, &Pake1{PA: make([]byte, 65)})
+		}},
+		{"second PBKDFParamRequest", func(t *testing.T, c *Commissioner, ex *exchange.Exchange) {
+			if err := c.start(passcode); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ex.Receive(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			ex.Send(message.OpcodePBKDFParamRequest, c.RequestPayload)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			devSM := session.NewSessionManager(nil)
+			commissionee := newCommissionee(t, passcode, devSM)
+			ctrl, done, cancel := connect(session.NewSessionManager(nil), devSM, commissionee)
+			defer cancel()
+
+			ex, _ := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+			c := NewCommissioner(session.NewSessionManager(nil))
+			c.ex = ex
+			tc.send(t, c, ex)
+			if err := <-done; !errors.Is(err, ErrUnexpectedMessage) {
+				t.Errorf("device: err = %v, want ErrUnexpectedMessage", err)
+			}
+			var se *exchange.StatusError
+			if _, err := ex.Receive(context.Background()); !errors.As(err, &se) ||
+				se.Report.ProtocolCode != message.SecureChannelInvalidParameter {
+				t.Errorf("controller: err = %v, want InvalidParameter StatusReport", err)
+			}
+			if commissionee.State != StateIdle || commissionee.SessionID != 0 || commissionee.verifier != nil {
+				t.Errorf("device not reset: state %d, session %d", commissionee.State, commissionee.SessionID)
+			}
+
+			if err := runPASE(t, NewCommissioner(session.NewSessionManager(nil)), commissionee, passcode); err != nil {
+				t.Errorf("handshake after rejected attempt: %v", err)
+			}
+		})
+	}
+}
+
+// TestCommissionee_HandshakeTimeout — a controller that goes quiet is
+// abandoned after the timeout, on the device's clock.
+func TestCommissionee_HandshakeTimeout(t *testing.T) {
+	const passcode = uint32(20202021)
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	devSM := session.NewSessionManagerWithConfig(nil, session.Config{Clock: clk})
+	commissionee := newCommissionee(t, passcode, devSM)
+	ctrl, done, cancel := connect(session.NewSessionManager(nil), devSM, commissionee)
+	defer cancel()
+
+	ex, _ := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	c := NewCommissioner(session.NewSessionManager(nil))
+	c.ex = ex
+	if err := c.start(passcode); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	clk.Advance(DefaultHandshakeTimeout - time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("gave up early: %v", err)
+	default:
+	}
+	clk.Advance(time.Second)
+	if err := <-done; !errors.Is(err, ErrHandshakeTimeout) {
+		t.Errorf("err = %v, want ErrHandshakeTimeout", err)
+	}
+	if commissionee.State != StateIdle || commissionee.SessionID != 0 {
+		t.Errorf("device not reset: state %d, session %d", commissionee.State, commissionee.SessionID)
+	}
+}
+
+// TestCommissioner_ResetsAfterFailure — a failed attempt leaves nothing
+// behind, so the same Commissioner can try again.
+func TestCommissioner_ResetsAfterFailure(t *testing.T) {
+	const passcode = uint32(20202021)
+	commissionee := newCommissionee(t, passcode, session.NewSessionManager(nil))
+	commissioner := NewCommissioner(session.NewSessionManager(nil))
+	if err := runPASE(t, commissioner, commissionee, passcode+1); err == nil {
+		t.Fatal("wrong passcode accepted")
+	}
+	if commissioner.State != StateIdle || commissioner.SessionID != 0 || commissioner.Ke != nil {
+		t.Errorf("not reset: state %d, session %d", commissioner.State, commissioner.SessionID)
+	}
+	if err := runPASE(t, commissioner, commissionee, passcode); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if commissioner.State != StateComplete {
+		t.Errorf("state = %d after retry", commissioner.State)
+	}
+}
+
+// TestPASE_SuccessiveAttemptsStartAfresh — once a successful attempt's
+// PASE session is gone, the next attempt on the same Commissioner and
+// Commissionee allocates new session IDs rather than reusing the
+// quarantined ones, and derives a new Ke.
+func TestPASE_SuccessiveAttemptsStartAfresh(t *testing.T) {
+	const passcode = uint32(20202021)
+	devSM, ctrlSM := session.NewSessionManager(nil), session.NewSessionManager(nil)
+	commissionee := newCommissionee(t, passcode, devSM)
+	commissioner := NewCommissioner(ctrlSM)
+	if err := runPASE(t, commissioner, commissionee, passcode); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+	devID, ctrlID, ke := commissionee.SessionID, commissioner.SessionID, commissionee.Ke
+	devSM.RemoveSession(devID)
+	ctrlSM.RemoveSession(ctrlID)
+
+	if err := runPASE(t, commissioner, commissionee, passcode); err != nil {
+		t.Fatalf("second attempt: %v", err)
+	}
+	if commissionee.SessionID == devID {
+		t.Errorf("commissionee reused session ID %d", devID)
+	}
+	if commissioner.SessionID == ctrlID {
+		t.Errorf("commissioner reused session ID %d", ctrlID)
+	}
+	if bytes.Equal(commissionee.Ke, ke) {
+		t.Error("second attempt kept the first attempt's Ke")
+	}
+	if _, ok := devSM.Session(commissionee.SessionID); !ok {
+		t.Errorf("commissionee session %d not installed", commissionee.SessionID)
+	}
+}
@@ -0,0 +1,113 @@
+package commissioning
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"slices"
+	"sync"
+
+	"go-matter/crypto"
+)
+
+// DefaultMaxFabrics is how many fabrics a FabricTable from
+// NewFabricTable(0) holds, the minimum a device must support.
+const DefaultMaxFabrics = 5
+
+// Fabric is one administrative domain the device has joined: the
+// credentials AddTrustedRootCertificate and AddNOC installed, and the
+// operational key the device generated for its CSR. The certificates may
+// be X.509 DER or Matter TLV; CASE always sends them as TLV.
+type Fabric struct {
+	Index            uint8 // FabricIndex, 1..254, assigned by the device
+	RCAC             []byte
+	ICAC             []byte
+	NOC              []byte
+	IPK              []byte // epoch key of the fabric's IPK
+	CaseAdminSubject uint64
+	AdminVendorID    uint16
+	OperationalKey   *ecdsa.PrivateKey
+}
+
+// FabricTable holds the device's fabrics, including one a commissioning
+// in progress has added but not yet committed. It is safe for concurrent
+// use.
+type FabricTable struct {
+	mu      sync.Mutex
+	max     int
+	fabrics []*Fabric
+	last    uint8 // index most recently assigned
+}
+
+// NewFabricTable returns an empty table holding up to max fabrics. Zero
+// selects DefaultMaxFabrics.
+func NewFabricTable(max int) *FabricTable {
+	if max <= 0 {
+		max = DefaultMaxFabrics
+	}
+	return &FabricTable{max: max}
+}
+
+// Fabric returns the fabric with the given index.
+func (t *FabricTable) Fabric(index uint8) (*Fabric, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range t.fabrics {
+		if f.Index == index {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Fabrics returns the fabrics in the order they were added.
+func (t *FabricTable) Fabrics() []*Fabric {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.fabrics)
+}
+
+// holds reports whether a fabric with rcac's root public key and
+// fabricID is in the table; each (root, fabric ID) pair is one fabric.
+func (t *FabricTable) holds(rcac []byte, fabricID uint64) bool {
+	root, err := crypto.ParseOperationalCertificate(rcac)
+	if err != nil {
+		return false
+	}
+	for _, f := range t.Fabrics() {
+		fr, err := crypto.ParseOperationalCertificate(f.RCAC)
+		if err != nil || !bytes.Equal(fr.PublicKey, root.PublicKey) {
+			continue
+		}
+		if noc, err := crypto.ParseOperationalCertificate(f.NOC); err == nil && noc.FabricID == fabricID {
+			return true
+		}
+	}
+	return false
+}
+
+// add assigns f the next free index and stores it. It reports false if
+// the table is full.
+func (t *FabricTable) add(f *Fabric) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.fabrics) >= t.max {
+		return false
+	}
+	for {
+		// Indexes run 1..254 and are not reused until they wrap.
+		t.last = t.last%254 + 1
+		if !slices.ContainsFunc(t.fabrics, func(g *Fabric) bool { return g.Index == t.last }) {
+			break
+		}
+	}
+	f.Index = t.last
+	t.fabrics = append(t.fabrics, f)
+	return true
+}
+
+// remove deletes the fabric with the given index.
+func (t *FabricTable) remove(index uint8) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fabrics = slices.DeleteFunc(t.fabrics, func(f *Fabric) bool { return f.Index == index })
+}
@@ -1,15 +1,17 @@
 package commissioning
 
+import "go-matter/session"
+
 // PASE wire-format types. Each struct's TLV layout matches the corresponding
 // section of Matter §4.13.1.
 
 // PBKDFParamRequest — Matter §4.13.1.1.
 type PBKDFParamRequest struct {
-	InitiatorRandom    []byte `tlv:"1"`
-	InitiatorSessionID uint16 `tlv:"2"`
-	PasscodeID         uint16 `tlv:"3"`
-	HasPBKDFParameters bool   `tlv:"4"`
-	InitiatorNodeID    uint64 `tlv:"5,omitempty"`
+	InitiatorRandom        []byte                     `tlv:"1"`
+	InitiatorSessionID     uint16                     `tlv:"2"`
+	PasscodeID             uint16                     `tlv:"3"`
+	HasPBKDFParameters     bool                       `tlv:"4"`
+	InitiatorSessionParams *session.SessionParameters `tlv:"5,omitempty"`
 }
 
 // PBKDFParamSet is sent inside PBKDFParamResponse only when the initiator
@@ -22,10 +24,11 @@ type PBKDFParamSet struct {
 // PBKDFParamResponse — Matter §4.13.1.2. The responder echoes
 // InitiatorRandom to bind the transcript.
 type PBKDFParamResponse struct {
-	InitiatorRandom    []byte         `tlv:"1"`
-	ResponderRandom    []byte         `tlv:"2"`
-	ResponderSessionID uint16         `tlv:"3"`
-	Params             *PBKDFParamSet `tlv:"4,omitempty"`
+	InitiatorRandom        []byte                     `tlv:"1"`
+	ResponderRandom        []byte                     `tlv:"2"`
+	ResponderSessionID     uint16                     `tlv:"3"`
+	Params                 *PBKDFParamSet             `tlv:"4,omitempty"`
+	ResponderSessionParams *session.SessionParameters `tlv:"5,omitempty"`
 }
 
 // Pake1 — Matter §4.13.1.3.
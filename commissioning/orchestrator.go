@@ -0,0 +1,463 @@
+package commissioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go-matter/crypto"
+)
+
+// Step is one stage of the commissioning flow that follows PASE, in the
+// order the Orchestrator runs them.
+type Step int
+
+const (
+	StepArmFailSafe Step = iota
+	StepSetRegulatoryConfig
+	StepDeviceAttestation
+	StepCSRRequest
+	StepAddTrustedRootCertificate
+	StepAddNOC
+	StepNetworkConfig
+	StepCASE
+	StepCommissioningComplete
+	StepDone
+)
+
+func (s Step) String() string {
+	switch s {
+	case StepArmFailSafe:
+		return "ArmFailSafe"
+	case StepSetRegulatoryConfig:
+		return "SetRegulatoryConfig"
+	case StepDeviceAttestation:
+		return "DeviceAttestation"
+	case StepCSRRequest:
+		return "CSRRequest"
+	case StepAddTrustedRootCertificate:
+		return "AddTrustedRootCertificate"
+	case StepAddNOC:
+		return "AddNOC"
+	case StepNetworkConfig:
+		return "NetworkConfig"
+	case StepCASE:
+		return "CASE"
+	case StepCommissioningComplete:
+		return "CommissioningComplete"
+	case StepDone:
+		return "Done"
+	}
+	return fmt.Sprintf("Step(%d)", int(s))
+}
+
+// DefaultFailSafeExpiry is how long the Orchestrator arms the device's
+// fail-safe for when OrchestratorConfig.FailSafeExpiry is zero.
+const DefaultFailSafeExpiry = 60 * time.Second
+
+// attestationNonceSize is the length of the AttestationRequest and
+// CSRRequest nonces.
+const attestationNonceSize = 32
+
+// StepError reports the step a commissioning run failed at. Err is what
+// went wrong: a transport error, a status the device reported (a
+// CommissioningError, NOCStatus or NetworkingStatus), or the
+// AttestationVerifier's, CredentialIssuer's or CASEConnector's error.
+type StepError struct {
+	Step Step
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("commissioning: step %v: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+// Device sends the commissioning clusters' commands to a device and
+// returns its responses. A transport or Interaction Model failure is
+// returned as an error; a status the command's response carries is left
+// for the Orchestrator to check. The Orchestrator is given one reaching
+// the device over the PASE session, and a CASEConnector's over CASE.
+type Device interface {
+	ArmFailSafe(ctx context.Context, req ArmFailSafeRequest) (ArmFailSafeResponse, error)
+	SetRegulatoryConfig(ctx context.Context, req SetRegulatoryConfigRequest) (SetRegulatoryConfigResponse, error)
+	RequestCertificateChain(ctx context.Context, req CertificateChainRequest) (CertificateChainResponse, error)
+	RequestAttestation(ctx context.Context, req AttestationRequest) (AttestationResponse, error)
+	RequestCSR(ctx context.Context, req CSRRequest) (CSRResponse, error)
+	AddTrustedRootCertificate(ctx context.Context, req AddTrustedRootCertificateRequest) error
+	AddNOC(ctx context.Context, req AddNOCRequest) (NOCResponse, error)
+	AddOrUpdateWiFiNetwork(ctx context.Context, req AddOrUpdateWiFiNetworkRequest) (NetworkConfigResponse, error)
+	AddOrUpdateThreadNetwork(ctx context.Context, req AddOrUpdateThreadNetworkRequest) (NetworkConfigResponse, error)
+	ConnectNetwork(ctx context.Context, req ConnectNetworkRequest) (ConnectNetworkResponse, error)
+	CommissioningComplete(ctx context.Context) (CommissioningCompleteResponse, error)
+}
+
+// Attestation is what the device presented to prove it is a certified
+// product: its Device Attestation Certificate and Product Attestation
+// Intermediate, and its signed answer to Nonce.
+type Attestation struct {
+	DAC       []byte
+	PAI       []byte
+	Nonce     []byte
+	Elements  []byte
+	Signature []byte
+}
+
+// AttestationVerifier decides whether to trust a device: it checks the
+// certificate chain up to a trusted PAA, the signature over Elements with
+// the DAC's key and the PASE attestation challenge, and the nonce echoed
+// in Elements. A non-nil error stops commissioning.
+type AttestationVerifier interface {
+	VerifyAttestation(ctx context.Context, a *Attestation) error
+}
+
+// CSR is the device's certificate signing request for its new
+// operational key, signed with its attestation key. DAC is the
+// attestation step's, for checking that signature.
+type CSR struct {
+	Nonce     []byte
+	Elements  []byte
+	Signature []byte
+	DAC       []byte
+}
+
+// OperationalCredentials are what the controller's fabric issues the
+// device: the root and optional intermediate CA certificates, the
+// device's NOC, the fabric's IPK epoch key, and the node's identity on
+// the fabric.
+type OperationalCredentials struct {
+	RCAC             []byte
+	ICAC             []byte
+	NOC              []byte
+	IPK              []byte
+	CaseAdminSubject uint64
+	AdminVendorID    uint16
+	FabricID         uint64
+	NodeID           uint64
+}
+
+// CredentialIssuer is the fabric's certificate authority. It verifies the
+// CSR and issues the device its operational credentials.
+type CredentialIssuer interface {
+	IssueCredentials(ctx context.Context, csr *CSR) (*OperationalCredentials, error)
+}
+
+// CASEConnector finds the device on its operational network once it holds
+// creds and establishes CASE with it, returning a Device that reaches it
+// over the CASE session.
+type CASEConnector interface {
+	ConnectCASE(ctx context.Context, creds *OperationalCredentials) (Device, error)
+}
+
+// NetworkConfig is the operational network the device is told to join:
+// Wi-Fi if SSID is set, Thread if ThreadDataset is. A device already on
+// its operational network, e.g. over Ethernet, needs none.
+type NetworkConfig struct {
+	SSID          []byte
+	Credentials   []byte
+	ThreadDataset []byte // Thread operational dataset, in its TLV form
+}
+
+// OrchestratorConfig carries what an Orchestrator needs beyond the PASE
+// Device. Attestation, Issuer and CASE are required.
+type OrchestratorConfig struct {
+	Attestation AttestationVerifier
+	Issuer      CredentialIssuer
+	CASE        CASEConnector
+
+	// FailSafeExpiry is how long each ArmFailSafe arms the fail-safe for.
+	// Zero selects DefaultFailSafeExpiry.
+	FailSafeExpiry time.Duration
+	// Location and CountryCode are sent with SetRegulatoryConfig. An
+	// empty CountryCode selects "XX", unknown.
+	Location    RegulatoryLocation
+	CountryCode string
+	// Network, if set, is configured before CASE; if nil the
+	// NetworkConfig step is skipped.
+	Network *NetworkConfig
+	// CryptoProvider supplies the attestation and CSR nonces. Nil
+	// selects crypto.DefaultCryptoProvider.
+	CryptoProvider crypto.CryptoProvider
+}
+
+// Orchestrator runs the controller side of commissioning after PASE:
+// ArmFailSafe, SetRegulatoryConfig, device attestation, CSRRequest,
+// AddTrustedRootCertificate, AddNOC, network configuration, CASE and
+// CommissioningComplete. Each command carries the step as its
+// breadcrumb.
+//
+// The Orchestrator records the next step and the results of the steps
+// done so far, so Run after a failure resumes at the step that failed.
+// Steps already done are not repeated: if the device's fail-safe may have
+// expired in between, rolling them back, call Reset first. An
+// Orchestrator is not safe for concurrent use.
+type Orchestrator struct {
+	dev  Device
+	cfg  OrchestratorConfig
+	next Step
+
+	// Results of the steps done so far.
+	Attestation *Attestation
+	CSR         *CSR
+	Credentials *OperationalCredentials
+	FabricIndex uint8
+	Operational Device // the device over CASE, once StepCASE is done
+}
+
+// NewOrchestrator returns an Orchestrator that commissions dev, a Device
+// reaching it over an established PASE session.
+func NewOrchestrator(dev Device, cfg OrchestratorConfig) (*Orchestrator, error) {
+	if dev == nil {
+		return nil, errors.New("commissioning: orchestrator requires a Device")
+	}
+	if cfg.Attestation == nil || cfg.Issuer == nil || cfg.CASE == nil {
+		return nil, errors.New("commissioning: orchestrator requires an AttestationVerifier, CredentialIssuer and CASEConnector")
+	}
+	if cfg.FailSafeExpiry == 0 {
+		cfg.FailSafeExpiry = DefaultFailSafeExpiry
+	}
+	if cfg.FailSafeExpiry < time.Second || cfg.FailSafeExpiry > 0xFFFF*time.Second {
+		return nil, fmt.Errorf("commissioning: fail-safe expiry %v out of range", cfg.FailSafeExpiry)
+	}
+	if cfg.CountryCode == "" {
+		cfg.CountryCode = "XX"
+	}
+	if len(cfg.CountryCode) != 2 {
+		return nil, fmt.Errorf("commissioning: country code %q is not two characters", cfg.CountryCode)
+	}
+	if cfg.CryptoProvider == nil {
+		cfg.CryptoProvider = &crypto.DefaultCryptoProvider{}
+	}
+	return &Orchestrator{dev: dev, cfg: cfg}, nil
+}
+
+// Next returns the step Run will start at: StepArmFailSafe for a new
+// Orchestrator, the failed step after a failure, StepDone once
+// commissioning is complete.
+func (o *Orchestrator) Next() Step { return o.next }
+
+// Reset forgets all progress, so the next Run starts over.
+func (o *Orchestrator) Reset() {
+	*o = Orchestrator{dev: o.dev, cfg: o.cfg}
+}
+
+// Run runs the remaining steps and returns nil once the device has
+// accepted CommissioningComplete. A failure is returned as a *StepError,
+// with Next left at the failed step.
+func (o *Orchestrator) Run(ctx context.Context) error {
+	for o.next != StepDone {
+		if err := ctx.Err(); err != nil {
+			return &StepError{Step: o.next, Err: err}
+		}
+		if err := o.run(ctx, o.next); err != nil {
+			return &StepError{Step: o.next, Err: err}
+		}
+		o.next++
+	}
+	return nil
+}
+
+func (o *Orchestrator) run(ctx context.Context, step Step) error {
+	breadcrumb := uint64(step)
+	switch step {
+	case StepArmFailSafe:
+		resp, err := o.dev.ArmFailSafe(ctx, ArmFailSafeRequest{
+			ExpiryLengthSeconds: uint16(o.cfg.FailSafeExpiry / time.Second),
+			Breadcrumb:          breadcrumb,
+		})
+		return commissioningErr(resp.ErrorCode, resp.DebugText, err)
+	case StepSetRegulatoryConfig:
+		resp, err := o.dev.SetRegulatoryConfig(ctx, SetRegulatoryConfigRequest{
+			NewRegulatoryConfig: o.cfg.Location,
+			CountryCode:         o.cfg.CountryCode,
+			Breadcrumb:          breadcrumb,
+		})
+		return commissioningErr(resp.ErrorCode, resp.DebugText, err)
+	case StepDeviceAttestation:
+		return o.attest(ctx)
+	case StepCSRRequest:
+		return o.requestCredentials(ctx)
+	case StepAddTrustedRootCertificate:
+		return o.dev.AddTrustedRootCertificate(ctx, AddTrustedRootCertificateRequest{RootCACertificate: o.Credentials.RCAC})
+	case StepAddNOC:
+		resp, err := o.dev.AddNOC(ctx, AddNOCRequest{
+			NOCValue:         o.Credentials.NOC,
+			ICACValue:        o.Credentials.ICAC,
+			IPKValue:         o.Credentials.IPK,
+			CaseAdminSubject: o.Credentials.CaseAdminSubject,
+			AdminVendorID:    o.Credentials.AdminVendorID,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != NOCStatusOK {
+			return withDebugText(resp.StatusCode, resp.DebugText)
+		}
+		o.FabricIndex = resp.FabricIndex
+		return nil
+	case StepNetworkConfig:
+		if o.cfg.Network == nil {
+			return nil
+		}
+		return o.configureNetwork(ctx, o.cfg.Network, breadcrumb)
+	case StepCASE:
+		dev, err := o.cfg.CASE.ConnectCASE(ctx, o.Credentials)
+		if err != nil {
+			return err
+		}
+		o.Operational = dev
+		return nil
+	case StepCommissioningComplete:
+		resp, err := o.Operational.CommissioningComplete(ctx)
+		return commissioningErr(resp.ErrorCode, resp.DebugText, err)
+	}
+	return fmt.Errorf("unknown step %v", step)
+}
+
+// attest fetches the device's certificates and attestation and has the
+// AttestationVerifier judge them.
+func (o *Orchestrator) attest(ctx context.Context) error {
+	dac, err := o.dev.RequestCertificateChain(ctx, CertificateChainRequest{CertificateType: CertificateDAC})
+	if err != nil {
+		return fmt.Errorf("DAC: %w", err)
+	}
+	pai, err := o.dev.RequestCertificateChain(ctx, CertificateChainRequest{CertificateType: CertificatePAI})
+	if err != nil {
+		return fmt.Errorf("PAI: %w", err)
+	}
+	nonce, err := o.nonce()
+	if err != nil {
+		return err
+	}
+	resp, err := o.dev.RequestAttestation(ctx, AttestationRequest{AttestationNonce: nonce})
+	if err != nil {
+		return err
+	}
+	a := &Attestation{
+		DAC:       dac.Certificate,
+		PAI:       pai.Certificate,
+		Nonce:     nonce,
+		Elements:  resp.AttestationElements,
+		Signature: resp.AttestationSignature,
+	}
+	if err := o.cfg.Attestation.VerifyAttestation(ctx, a); err != nil {
+		return fmt.Errorf("attestation rejected: %w", err)
+	}
+	o.Attestation = a
+	return nil
+}
+
+// requestCredentials fetches the device's CSR and has the
+// CredentialIssuer issue its operational credentials.
+func (o *Orchestrator) requestCredentials(ctx context.Context) error {
+	nonce, err := o.nonce()
+	if err != nil {
+		return err
+	}
+	resp, err := o.dev.RequestCSR(ctx, CSRRequest{CSRNonce: nonce})
+	if err != nil {
+		return err
+	}
+	csr := &CSR{
+		Nonce:     nonce,
+		Elements:  resp.NOCSRElements,
+		Signature: resp.AttestationSignature,
+		DAC:       o.Attestation.DAC,
+	}
+	creds, err := o.cfg.Issuer.IssueCredentials(ctx, csr)
+	if err != nil {
+		return fmt.Errorf("issue credentials: %w", err)
+	}
+	o.CSR, o.Credentials = csr, creds
+	return nil
+}
+
+// configureNetwork adds n to the device's networks and has it connect.
+func (o *Orchestrator) configureNetwork(ctx context.Context, n *NetworkConfig, breadcrumb uint64) error {
+	var (
+		resp      NetworkConfigResponse
+		networkID []byte
+		err       error
+	)
+	switch {
+	case len(n.SSID) > 0:
+		networkID = n.SSID
+		resp, err = o.dev.AddOrUpdateWiFiNetwork(ctx, AddOrUpdateWiFiNetworkRequest{
+			SSID: n.SSID, Credentials: n.Credentials, Breadcrumb: breadcrumb,
+		})
+	case len(n.ThreadDataset) > 0:
+		if networkID, err = threadExtendedPANID(n.ThreadDataset); err != nil {
+			return err
+		}
+		resp, err = o.dev.AddOrUpdateThreadNetwork(ctx, AddOrUpdateThreadNetworkRequest{
+			OperationalDataset: n.ThreadDataset, Breadcrumb: breadcrumb,
+		})
+	default:
+		return errors.New("network config has neither SSID nor Thread dataset")
+	}
+	if err != nil {
+		return err
+	}
+	if resp.NetworkingStatus != NetworkingSuccess {
+		return withDebugText(resp.NetworkingStatus, resp.DebugText)
+	}
+	connect, err := o.dev.ConnectNetwork(ctx, ConnectNetworkRequest{NetworkID: networkID, Breadcrumb: breadcrumb})
+	if err != nil {
+		return err
+	}
+	if connect.NetworkingStatus != NetworkingSuccess {
+		return withDebugText(connect.NetworkingStatus, connect.DebugText)
+	}
+	return nil
+}
+
+func (o *Orchestrator) nonce() ([]byte, error) {
+	nonce := make([]byte, attestationNonceSize)
+	if _, err := io.ReadFull(o.cfg.CryptoProvider.Rand(), nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// commissioningErr folds a General Commissioning response into one error:
+// err if the command failed, else the CommissioningError it reported.
+func commissioningErr(code CommissioningError, debugText string, err error) error {
+	if err != nil {
+		return err
+	}
+	if code != CommissioningOK {
+		return withDebugText(code, debugText)
+	}
+	return nil
+}
+
+// withDebugText returns status, annotated with the device's DebugText if
+// it sent one.
+func withDebugText(status error, debugText string) error {
+	if debugText == "" {
+		return status
+	}
+	return fmt.Errorf("%w (%s)", status, debugText)
+}
+
+// threadExtendedPANID returns the Extended PAN ID from a Thread
+// operational dataset, the Network Commissioning cluster's NetworkID for
+// a Thread network. The dataset is a sequence of one-byte type, one-byte
+// length TLVs.
+func threadExtendedPANID(dataset []byte) ([]byte, error) {
+	const typeExtendedPANID, extendedPANIDLen = 2, 8
+	for b := dataset; len(b) >= 2; {
+		typ, n := b[0], int(b[1])
+		if len(b) < 2+n {
+			break
+		}
+		if typ == typeExtendedPANID && n == extendedPANIDLen {
+			return b[2 : 2+n], nil
+		}
+		b = b[2+n:]
+	}
+	return nil, errors.New("thread dataset has no extended PAN ID")
+}
@@ -0,0 +1,352 @@
+package commissioning
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go-matter/tlv"
+)
+
+// fakeDevice records the commands it receives, by name, and answers with
+// the responses the test set.
+type fakeDevice struct {
+	calls       []string
+	breadcrumbs []uint64
+
+	armFailSafe ArmFailSafeResponse
+	addNOC      []NOCResponse // consumed one per AddNOC; OK once empty
+	networkResp NetworkConfigResponse
+	network     []byte // dataset or SSID last added
+	connectedID []byte
+	complete    CommissioningCompleteResponse
+	rootErr     error
+	rootCert    []byte
+	noc         AddNOCRequest
+}
+
+func (d *fakeDevice) record(name string, breadcrumb uint64) {
+	d.calls = append(d.calls, name)
+	d.breadcrumbs = append(d.breadcrumbs, breadcrumb)
+}
+
+func (d *fakeDevice) ArmFailSafe(_ context.Context, req ArmFailSafeRequest) (ArmFailSafeResponse, error) {
+	d.record("ArmFailSafe", req.Breadcrumb)
+	return d.armFailSafe, nil
+}
+
+func (d *fakeDevice) SetRegulatoryConfig(_ context.Context, req SetRegulatoryConfigRequest) (SetRegulatoryConfigResponse, error) {
+	d.record("SetRegulatoryConfig", req.Breadcrumb)
+	return SetRegulatoryConfigResponse{}, nil
+}
+
+func (d *fakeDevice) RequestCertificateChain(_ context.Context, req CertificateChainRequest) (CertificateChainResponse, error) {
+	d.record("CertificateChainRequest", 0)
+	if req.CertificateType == CertificateDAC {
+		return CertificateChainResponse{Certificate: []byte("DAC")}, nil
+	}
+	return CertificateChainResponse{Certificate: []byte("PAI")}, nil
+}
+
+func (d *fakeDevice) RequestAttestation(_ context.Context, req AttestationRequest) (AttestationResponse, error) {
+	d.record("AttestationRequest", 0)
+	return AttestationResponse{AttestationElements: req.AttestationNonce, AttestationSignature: []byte("sig")}, nil
+}
+
+func (d *fakeDevice) RequestCSR(_ context.Context, req CSRRequest) (CSRResponse, error) {
+	d.record("CSRRequest", 0)
+	return CSRResponse{NOCSRElements: req.CSRNonce, AttestationSignature: []byte("sig")}, nil
+}
+
+func (d *fakeDevice) AddTrustedRootCertificate(_ context.Context, req AddTrustedRootCertificateRequest) error {
+	d.record("AddTrustedRootCertificate", 0)
+	d.rootCert = req.RootCACertificate
+	return d.rootErr
+}
+
+func (d *fakeDevice) AddNOC(_ context.Context, req AddNOCRequest) (NOCResponse, error) {
+	d.record("AddNOC", 0)
+	d.noc = req
+	if len(d.addNOC) > 0 {
+		resp := d.addNOC[0]
+		d.addNOC = d.addNOC[1:]
+		return resp, nil
+	}
+	return NOCResponse{FabricIndex: 1}, nil
+}
+
+func (d *fakeDevice) AddOrUpdateWiFiNetwork(_ context.Context, req AddOrUpdateWiFiNetworkRequest) (NetworkConfigResponse, error) {
+	d.record("AddOrUpdateWiFiNetwork", req.Breadcrumb)
+	d.network = req.SSID
+	return d.networkResp, nil
+}
+
+func (d *fakeDevice) AddOrUpdateThreadNetwork(_ context.Context, req AddOrUpdateThreadNetworkRequest) (NetworkConfigResponse, error) {
+	d.record("AddOrUpdateThreadNetwork", req.Breadcrumb)
+	d.network = req.OperationalDataset
+	return d.networkResp, nil
+}
+
+func (d *fakeDevice) ConnectNetwork(_ context.Context, req ConnectNetworkRequest) (ConnectNetworkResponse, error) {
+	d.record("ConnectNetwork", req.Breadcrumb)
+	d.connectedID = req.NetworkID
+	return ConnectNetworkResponse{}, nil
+}
+
+func (d *fakeDevice) CommissioningComplete(context.Context) (CommissioningCompleteResponse, error) {
+	d.record("CommissioningComplete", 0)
+	return d.complete, nil
+}
+
+type fakeFabric struct {
+	rejectAttestation error
+	attested          *Attestation
+	csr               *CSR
+	operational       *fakeDevice
+	connectedWith     *OperationalCredentials
+}
+
+func (f *fakeFabric) VerifyAttestation(_ context.Context, a *Attestation) error {
+	f.attested = a
+	return f.rejectAttestation
+}
+
+func (f *fakeFabric) IssueCredentials(_ context.Context, csr *CSR) (*OperationalCredentials, error) {
+	f.csr = csr
+	return &OperationalCredentials{
+		RCAC: []byte("RCAC"), NOC: []byte("NOC"), IPK: bytes.Repeat([]byte{1}, 16),
+		CaseAdminSubject: 112233, AdminVendorID: 0xFFF1, FabricID: 1, NodeID: 0x1234,
+	}, nil
+}
+
+func (f *fakeFabric) ConnectCASE(_ context.Context, creds *OperationalCredentials) (Device, error) {
+	f.connectedWith = creds
+	return f.operational, nil
+}
+
+func newTestOrchestrator(t *testing.T, cfg OrchestratorConfig) (*Orchestrator, *fakeDevice, *fakeFabric) {
+	t.Helper()
+	dev := &fakeDevice{}
+	fabric := &fakeFabric{operational: &fakeDevice{}}
+	cfg.Attestation, cfg.Issuer, cfg.CASE = fabric, fabric, fabric
+	o, err := NewOrchestrator(dev, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, dev, fabric
+}
+
+func TestOrchestrator_Run(t *testing.T) {
+	o, dev, fabric := newTestOrchestrator(t, OrchestratorConfig{})
+	if err := o.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := []string{
+		"ArmFailSafe", "SetRegulatoryConfig",
+		"CertificateChainRequest", "CertificateChainRequest", "AttestationRequest",
+		"CSRRequest", "AddTrustedRootCertificate", "AddNOC",
+	}
+	if !slices.Equal(dev.calls, want) {
+		t.Errorf("PASE commands = %v, want %v", dev.calls, want)
+	}
+	if dev.breadcrumbs[0] != uint64(StepArmFailSafe) || dev.breadcrumbs[1] != uint64(StepSetRegulatoryConfig) {
+		t.Errorf("breadcrumbs = %v", dev.breadcrumbs[:2])
+	}
+	if got := fabric.operational.calls; !slices.Equal(got, []string{"CommissioningComplete"}) {
+		t.Errorf("CASE commands = %v, want CommissioningComplete", got)
+	}
+	if fabric.attested == nil || !bytes.Equal(fabric.attested.DAC, []byte("DAC")) ||
+		!bytes.Equal(fabric.attested.Elements, fabric.attested.Nonce) || len(fabric.attested.Nonce) != 32 {
+		t.Errorf("attestation = %+v", fabric.attested)
+	}
+	if fabric.csr == nil || !bytes.Equal(fabric.csr.DAC, []byte("DAC")) || bytes.Equal(fabric.csr.Nonce, fabric.attested.Nonce) {
+		t.Errorf("CSR = %+v", fabric.csr)
+	}
+	if !bytes.Equal(dev.rootCert, []byte("RCAC")) || !bytes.Equal(dev.noc.NOCValue, []byte("NOC")) || dev.noc.CaseAdminSubject != 112233 {
+		t.Errorf("credentials sent: root %q, AddNOC %+v", dev.rootCert, dev.noc)
+	}
+	if fabric.connectedWith != o.Credentials || o.FabricIndex != 1 {
+		t.Errorf("CASE with %+v, fabric index %d", fabric.connectedWith, o.FabricIndex)
+	}
+	if o.Next() != StepDone {
+		t.Errorf("Next = %v after success", o.Next())
+	}
+}
+
+// TestOrchestrator_Resume — a failed step is reported with the device's
+// status, and Run picks up there without repeating the steps before it.
+func TestOrchestrator_Resume(t *testing.T) {
+	o, dev, fabric := newTestOrchestrator(t, OrchestratorConfig{})
+	dev.addNOC = []NOCResponse{{StatusCode: NOCStatusInvalidNOC, DebugText: "bad subject"}}
+
+	err := o.Run(context.Background())
+	var se *StepError
+	if !errors.As(err, &se) || se.Step != StepAddNOC {
+		t.Fatalf("err = %v, want StepError at AddNOC", err)
+	}
+	if !errors.Is(err, NOCStatusInvalidNOC) || !strings.Contains(err.Error(), "bad subject") {
+		t.Errorf("err = %v, want InvalidNOC with debug text", err)
+	}
+	if o.Next() != StepAddNOC {
+		t.Errorf("Next = %v, want AddNOC", o.Next())
+	}
+
+	dev.calls = nil
+	if err := o.Run(context.Background()); err != nil {
+		t.Fatalf("resumed Run: %v", err)
+	}
+	if !slices.Equal(dev.calls, []string{"AddNOC"}) {
+		t.Errorf("resumed run sent %v, want only AddNOC", dev.calls)
+	}
+	if len(fabric.operational.calls) != 1 {
+		t.Errorf("CommissioningComplete sent %d times", len(fabric.operational.calls))
+	}
+
+	o.Reset()
+	if o.Next() != StepArmFailSafe || o.Credentials != nil {
+		t.Errorf("Reset left Next %v, credentials %v", o.Next(), o.Credentials)
+	}
+}
+
+func TestOrchestrator_StepErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(dev *fakeDevice, fabric *fakeFabric)
+		step  Step
+		want  error
+	}{
+		{"fail-safe busy", func(dev *fakeDevice, _ *fakeFabric) {
+			dev.armFailSafe = ArmFailSafeResponse{ErrorCode: CommissioningBusyWithOtherAdmin}
+		}, StepArmFailSafe, CommissioningBusyWithOtherAdmin},
+		{"attestation rejected", func(_ *fakeDevice, fabric *fakeFabric) {
+			fabric.rejectAttestation = errors.New("untrusted PAA")
+		}, StepDeviceAttestation, nil},
+		{"root certificate refused", func(dev *fakeDevice, _ *fakeFabric) {
+			dev.rootErr = errors.New("constraint error")
+		}, StepAddTrustedRootCertificate, nil},
+		{"CommissioningComplete refused", func(_ *fakeDevice, fabric *fakeFabric) {
+			fabric.operational.complete = CommissioningCompleteResponse{ErrorCode: CommissioningInvalidAuthentication}
+		}, StepCommissioningComplete, CommissioningInvalidAuthentication},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o, dev, fabric := newTestOrchestrator(t, OrchestratorConfig{})
+			tc.setup(dev, fabric)
+			err := o.Run(context.Background())
+			var se *StepError
+			if !errors.As(err, &se) || se.Step != tc.step {
+				t.Fatalf("err = %v, want StepError at %v", err, tc.step)
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+			if o.Next() != tc.step {
+				t.Errorf("Next = %v, want %v", o.Next(), tc.step)
+			}
+		})
+	}
+	t.Run("attestation rejected stops before CSR", func(t *testing.T) {
+		o, dev, fabric := newTestOrchestrator(t, OrchestratorConfig{})
+		fabric.rejectAttestation = errors.New("untrusted PAA")
+		o.Run(context.Background())
+		if slices.Contains(dev.calls, "CSRRequest") {
+			t.Error("CSR requested from an unattested device")
+		}
+	})
+}
+
+func TestOrchestrator_Network(t *testing.T) {
+	dataset := []byte{
+		0x00, 0x03, 0x00, 0x00, 0x0f, // channel 15
+		0x02, 0x08, 0xde, 0xad, 0x00, 0xbe, 0xef, 0x00, 0xca, 0xfe, // extended PAN ID
+	}
+	tests := []struct {
+		name    string
+		network *NetworkConfig
+		add     string
+		id      []byte
+	}{
+		{"Wi-Fi", &NetworkConfig{SSID: []byte("home"), Credentials: []byte("secret")}, "AddOrUpdateWiFiNetwork", []byte("home")},
+		{"Thread", &NetworkConfig{ThreadDataset: dataset}, "AddOrUpdateThreadNetwork", dataset[7:]},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o, dev, _ := newTestOrchestrator(t, OrchestratorConfig{Network: tc.network})
+			if err := o.Run(context.Background()); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			i := slices.Index(dev.calls, tc.add)
+			if i < 0 || i+1 >= len(dev.calls) || dev.calls[i+1] != "ConnectNetwork" {
+				t.Fatalf("commands = %v, want %s then ConnectNetwork", dev.calls, tc.add)
+			}
+			if dev.breadcrumbs[i] != uint64(StepNetworkConfig) || !bytes.Equal(dev.connectedID, tc.id) {
+				t.Errorf("breadcrumb %d, connected to %x", dev.breadcrumbs[i], dev.connectedID)
+			}
+		})
+	}
+
+	o, dev, _ := newTestOrchestrator(t, OrchestratorConfig{Network: &NetworkConfig{SSID: []byte("home")}})
+	dev.networkResp = NetworkConfigResponse{NetworkingStatus: NetworkingAuthFailure}
+	if err := o.Run(context.Background()); !errors.Is(err, NetworkingAuthFailure) {
+		t.Errorf("err = %v, want AuthFailure", err)
+	}
+}
+
+func TestNewOrchestrator_Errors(t *testing.T) {
+	fabric := &fakeFabric{}
+	full := OrchestratorConfig{Attestation: fabric, Issuer: fabric, CASE: fabric}
+	tests := []struct {
+		name string
+		dev  Device
+		cfg  func(c *OrchestratorConfig)
+	}{
+		{"no device", nil, func(*OrchestratorConfig) {}},
+		{"no verifier", &fakeDevice{}, func(c *OrchestratorConfig) { c.Attestation = nil }},
+		{"no CASE", &fakeDevice{}, func(c *OrchestratorConfig) { c.CASE = nil }},
+		{"fail-safe too long", &fakeDevice{}, func(c *OrchestratorConfig) { c.FailSafeExpiry = 0x10000 * time.Second }},
+		{"country code", &fakeDevice{}, func(c *OrchestratorConfig) { c.CountryCode = "USA" }},
+	}
+	for _, tc := range tests {
+		cfg := full
+		tc.cfg(&cfg)
+		if _, err := NewOrchestrator(tc.dev, cfg); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestCommands_TLVRoundTrip(t *testing.T) {
+	want := AddNOCRequest{
+		NOCValue: []byte("noc"), IPKValue: bytes.Repeat([]byte{7}, 16),
+		CaseAdminSubject: 112233, AdminVendorID: 0xFFF1,
+	}
+	encoded, err := tlv.Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got AddNOCRequest
+	if err := tlv.Unmarshal(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.NOCValue, want.NOCValue) || got.ICACValue != nil ||
+		got.CaseAdminSubject != want.CaseAdminSubject || got.AdminVendorID != want.AdminVendorID {
+		t.Errorf("round-trip mismatch: got %+v want %+v", got, want)
+	}
+
+	resp := NOCResponse{StatusCode: NOCStatusFabricConflict, FabricIndex: 3}
+	encoded, err = tlv.Marshal(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotResp NOCResponse
+	if err := tlv.Unmarshal(encoded, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+	if gotResp != resp {
+		t.Errorf("NOCResponse round-trip: got %+v want %+v", gotResp, resp)
+	}
+}
@@ -0,0 +1,615 @@
+package commissioning
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/crypto"
+	"go-matter/session"
+	"go-matter/tlv"
+)
+
+// MaxCumulativeFailSafe bounds how long ArmFailSafe can keep extending the
+// fail-safe past its first arming, the General Commissioning cluster's
+// BasicCommissioningInfo.MaxCumulativeFailsafeSeconds.
+const MaxCumulativeFailSafe = 900 * time.Second
+
+// Errors the Server's handlers return where the Interaction Model answers
+// with a status instead of the command's response.
+var (
+	// ErrFailSafeRequired is returned for a command that needs the
+	// fail-safe armed by the invoking administrator (FAILSAFE_REQUIRED).
+	ErrFailSafeRequired = errors.New("commissioning: fail-safe required")
+	// ErrConstraint is returned for a malformed or out-of-order command
+	// (CONSTRAINT_ERROR).
+	ErrConstraint = errors.New("commissioning: constraint error")
+	// ErrUnsupportedCommand is returned for network commands on a device
+	// without a NetworkDriver (UNSUPPORTED_COMMAND).
+	ErrUnsupportedCommand = errors.New("commissioning: unsupported command")
+)
+
+// DeviceAttestation is the device's attestation identity: its DAC and PAI,
+// the Certification Declaration, and the DAC's private key, which never
+// leaves it.
+type DeviceAttestation interface {
+	Certificate(t CertificateType) ([]byte, error)
+	CertificationDeclaration() []byte
+	// SignWithDAC signs message (SHA-256, ECDSA P-256, raw r||s) with the
+	// DAC's private key.
+	SignWithDAC(message []byte) ([]byte, error)
+}
+
+// Network is one entry of the device's network list.
+type Network struct {
+	ID            []byte // SSID, or a Thread dataset's extended PAN ID
+	SSID          []byte
+	Credentials   []byte
+	ThreadDataset []byte
+}
+
+// NetworkDriver joins the device to an operational network.
+type NetworkDriver interface {
+	ConnectNetwork(n Network) error
+}
+
+// ServerConfig carries what a Server needs beyond its session manager.
+// Attestation is required.
+type ServerConfig struct {
+	Attestation DeviceAttestation
+	// Fabrics receives the fabric a commissioning adds. Nil selects
+	// NewFabricTable(0).
+	Fabrics *FabricTable
+	// Commissionee, if set, has its commissioning window closed when
+	// commissioning completes.
+	Commissionee *Commissionee
+	// Network, if set, serves the Network Commissioning commands;
+	// without it they fail with ErrUnsupportedCommand.
+	Network NetworkDriver
+}
+
+// attestationElements is the TLV the AttestationRequest response signs.
+type attestationElements struct {
+	CertificationDeclaration []byte `tlv:"1"`
+	AttestationNonce         []byte `tlv:"2"`
+	Timestamp                uint32 `tlv:"3"`
+}
+
+// nocsrElements is the TLV the CSRRequest response signs.
+type nocsrElements struct {
+	CSR      []byte `tlv:"1"`
+	CSRNonce []byte `tlv:"2"`
+}
+
+// Server is the device side of commissioning after PASE: the General
+// Commissioning, Operational Credentials and Network Commissioning
+// commands a controller's Orchestrator sends. The Interaction Model calls
+// its Handle methods with the session each command arrived on.
+//
+// ArmFailSafe opens a fail-safe context for the invoking administrator.
+// The root certificate, fabric and network changes made under it are
+// staged and rolled back if the fail-safe expires or is disarmed before
+// CommissioningComplete commits them; CommissioningComplete also closes
+// the PASE session and the commissioning window. The fail-safe is timed
+// on the session manager's clock. A Server is safe for concurrent use.
+type Server struct {
+	sessionManager *session.SessionManager
+	clock          clock.Clock
+	attestation    DeviceAttestation
+	fabrics        *FabricTable
+	commissionee   *Commissionee
+	network        NetworkDriver
+
+	mu         sync.Mutex
+	breadcrumb uint64
+	location   RegulatoryLocation
+	country    string
+	networks   []Network
+	failSafe   *failSafe // nil while disarmed
+}
+
+// failSafe is one armed fail-safe context and what it has staged.
+type failSafe struct {
+	fabricIndex   uint8  // accessing fabric that armed it; 0 over PASE
+	paseSessionID uint16 // PASE session that armed it, if any
+	started       time.Time
+	deadline      time.Time
+	stop          chan struct{} // closed to retire the current timer
+
+	rootCert     []byte
+	opKey        *ecdsa.PrivateKey
+	addedFabric  uint8 // index AddNOC assigned; 0 if none yet
+	networks     []Network
+	networksSeen bool // networks holds the list as it was before arming
+}
+
+// heldBy reports whether the administrator on fabric holds fs: the one
+// that armed it or, once AddNOC has run, the fabric it added.
+func (fs *failSafe) heldBy(fabric uint8) bool {
+	return fabric == fs.fabricIndex || (fs.addedFabric != 0 && fabric == fs.addedFabric)
+}
+
+// NewServer returns a Server installing fabrics for sessions in sm.
+func NewServer(sm *session.SessionManager, cfg ServerConfig) (*Server, error) {
+	if sm == nil {
+		return nil, errors.New("commissioning: server requires a session manager")
+	}
+	if cfg.Attestation == nil {
+		return nil, errors.New("commissioning: server requires a DeviceAttestation")
+	}
+	if cfg.Fabrics == nil {
+		cfg.Fabrics = NewFabricTable(0)
+	}
+	return &Server{
+		sessionManager: sm,
+		clock:          sm.Clock(),
+		attestation:    cfg.Attestation,
+		fabrics:        cfg.Fabrics,
+		commissionee:   cfg.Commissionee,
+		network:        cfg.Network,
+		country:        "XX",
+	}, nil
+}
+
+// Fabrics returns the table the Server adds fabrics to.
+func (s *Server) Fabrics() *FabricTable { return s.fabrics }
+
+// Breadcrumb returns the General Commissioning cluster's Breadcrumb
+// attribute: the value the controller last stored with a commissioning
+// command, reset to 0 when commissioning completes or fails.
+func (s *Server) Breadcrumb() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.breadcrumb
+}
+
+// RegulatoryConfig returns the location and country code
+// SetRegulatoryConfig last set; "XX" is an unknown country.
+func (s *Server) RegulatoryConfig() (RegulatoryLocation, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.location, s.country
+}
+
+// FailSafeArmed reports whether a fail-safe context is open.
+func (s *Server) FailSafeArmed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failSafe != nil
+}
+
+// Networks returns the device's network list, staged changes included.
+func (s *Server) Networks() []Network {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.networks)
+}
+
+// accessingFabric returns the invoking session and its fabric index, 0
+// for PASE.
+func (s *Server) accessingFabric(sessionID uint16) (*session.Session, uint8, error) {
+	sess, ok := s.sessionManager.Session(sessionID)
+	if !ok {
+		return nil, 0, fmt.Errorf("commissioning: %w: %d", session.ErrUnknownSession, sessionID)
+	}
+	if sess.Type == session.SessionTypePASE {
+		return sess, 0, nil
+	}
+	return sess, sess.FabricIndex, nil
+}
+
+// armedBy returns the fail-safe if the invoking administrator armed it.
+// The caller holds s.mu.
+func (s *Server) armedBy(sessionID uint16) (*failSafe, error) {
+	_, fabric, err := s.accessingFabric(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if s.failSafe == nil || !s.failSafe.heldBy(fabric) {
+		return nil, ErrFailSafeRequired
+	}
+	return s.failSafe, nil
+}
+
+// HandleArmFailSafe arms the fail-safe for the invoking administrator, or
+// extends it, up to MaxCumulativeFailSafe after it was first armed. An
+// expiry of zero disarms it, rolling back what it staged. Another
+// administrator's fail-safe is reported as BusyWithOtherAdmin.
+func (s *Server) HandleArmFailSafe(sessionID uint16, req ArmFailSafeRequest) (ArmFailSafeResponse, error) {
+	sess, fabric, err := s.accessingFabric(sessionID)
+	if err != nil {
+		return ArmFailSafeResponse{}, err
+	}
+	s.mu.Lock()
+	fs := s.failSafe
+	if fs != nil && !fs.heldBy(fabric) {
+		s.mu.Unlock()
+		return ArmFailSafeResponse{ErrorCode: CommissioningBusyWithOtherAdmin}, nil
+	}
+	if req.ExpiryLengthSeconds == 0 {
+		if fs == nil {
+			s.breadcrumb = req.Breadcrumb
+			s.mu.Unlock()
+			return ArmFailSafeResponse{}, nil
+		}
+		s.rollbackLocked(fs)
+		s.mu.Unlock()
+		s.closeRolledBack(fs)
+		return ArmFailSafeResponse{}, nil
+	}
+
+	now := s.clock.Now()
+	if fs == nil {
+		fs = &failSafe{fabricIndex: fabric, started: now}
+		if sess.Type == session.SessionTypePASE {
+			fs.paseSessionID = sess.ID
+		}
+		s.failSafe = fs
+	} else {
+		close(fs.stop)
+	}
+	fs.deadline = now.Add(time.Duration(req.ExpiryLengthSeconds) * time.Second)
+	if limit := fs.started.Add(MaxCumulativeFailSafe); fs.deadline.After(limit) {
+		fs.deadline = limit
+	}
+	fs.stop = make(chan struct{})
+	go s.expireAfter(fs, s.clock.NewTimer(fs.deadline.Sub(now)), fs.stop)
+	s.breadcrumb = req.Breadcrumb
+	s.mu.Unlock()
+	return ArmFailSafeResponse{}, nil
+}
+
+// expireAfter rolls fs back once t fires, unless stop is closed first by
+// a re-arm, disarm or commit.
+func (s *Server) expireAfter(fs *failSafe, t clock.Timer, stop chan struct{}) {
+	select {
+	case <-t.C():
+	case <-stop:
+		t.Stop()
+		return
+	}
+	s.mu.Lock()
+	select {
+	case <-stop:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	s.rollbackLocked(fs)
+	s.mu.Unlock()
+	s.closeRolledBack(fs)
+}
+
+// rollbackLocked disarms fs and undoes what it staged: the fabric AddNOC
+// added, the root certificate and network list changes. The caller holds
+// s.mu, and calls closeRolledBack once it has released it.
+func (s *Server) rollbackLocked(fs *failSafe) {
+	close(fs.stop)
+	if fs.addedFabric != 0 {
+		s.fabrics.remove(fs.addedFabric)
+	}
+	if fs.networksSeen {
+		s.networks = fs.networks
+	}
+	s.breadcrumb = 0
+	s.failSafe = nil
+}
+
+// closeRolledBack closes the sessions a rolled-back fs leaves behind: the
+// PASE session that armed it and any CASE session on the fabric AddNOC
+// added, which no longer exists.
+func (s *Server) closeRolledBack(fs *failSafe) {
+	s.closeSession(fs.paseSessionID)
+	s.sessionManager.RemoveFabricSessions(fs.addedFabric)
+}
+
+func (s *Server) closeSession(id uint16) {
+	if id != 0 {
+		s.sessionManager.RemoveSession(id)
+	}
+}
+
+// HandleSetRegulatoryConfig records the device's regulatory location and
+// country code.
+func (s *Server) HandleSetRegulatoryConfig(sessionID uint16, req SetRegulatoryConfigRequest) (SetRegulatoryConfigResponse, error) {
+	if len(req.CountryCode) != 2 {
+		return SetRegulatoryConfigResponse{}, fmt.Errorf("%w: country code %q", ErrConstraint, req.CountryCode)
+	}
+	if req.NewRegulatoryConfig > RegulatoryIndoorOutdoor {
+		return SetRegulatoryConfigResponse{ErrorCode: CommissioningValueOutsideRange}, nil
+	}
+	if _, _, err := s.accessingFabric(sessionID); err != nil {
+		return SetRegulatoryConfigResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.location = req.NewRegulatoryConfig
+	s.country = req.CountryCode
+	s.breadcrumb = req.Breadcrumb
+	return SetRegulatoryConfigResponse{}, nil
+}
+
+// HandleCertificateChainRequest returns the DAC or PAI.
+func (s *Server) HandleCertificateChainRequest(sessionID uint16, req CertificateChainRequest) (CertificateChainResponse, error) {
+	if req.CertificateType != CertificateDAC && req.CertificateType != CertificatePAI {
+		return CertificateChainResponse{}, fmt.Errorf("%w: certificate type %d", ErrConstraint, req.CertificateType)
+	}
+	cert, err := s.attestation.Certificate(req.CertificateType)
+	if err != nil {
+		return CertificateChainResponse{}, fmt.Errorf("commissioning: certificate: %w", err)
+	}
+	return CertificateChainResponse{Certificate: cert}, nil
+}
+
+// HandleAttestationRequest returns the attestation elements for the
+// controller's nonce, signed with the DAC together with the invoking
+// session's attestation challenge.
+func (s *Server) HandleAttestationRequest(sessionID uint16, req AttestationRequest) (AttestationResponse, error) {
+	if len(req.AttestationNonce) != attestationNonceSize {
+		return AttestationResponse{}, fmt.Errorf("%w: nonce length %d", ErrConstraint, len(req.AttestationNonce))
+	}
+	sess, _, err := s.accessingFabric(sessionID)
+	if err != nil {
+		return AttestationResponse{}, err
+	}
+	elements, err := tlv.Marshal(&attestationElements{
+		CertificationDeclaration: s.attestation.CertificationDeclaration(),
+		AttestationNonce:         req.AttestationNonce,
+	})
+	if err != nil {
+		return AttestationResponse{}, fmt.Errorf("commissioning: encode attestation elements: %w", err)
+	}
+	sig, err := s.sign(elements, sess)
+	if err != nil {
+		return AttestationResponse{}, err
+	}
+	return AttestationResponse{AttestationElements: elements, AttestationSignature: sig}, nil
+}
+
+// HandleCSRRequest generates a new operational key pair and returns a
+// CSR for it, signed with the DAC together with the invoking session's
+// attestation challenge. It needs the fail-safe, and fails once AddNOC
+// has used the key.
+func (s *Server) HandleCSRRequest(sessionID uint16, req CSRRequest) (CSRResponse, error) {
+	if len(req.CSRNonce) != attestationNonceSize {
+		return CSRResponse{}, fmt.Errorf("%w: nonce length %d", ErrConstraint, len(req.CSRNonce))
+	}
+	if req.IsForUpdateNOC {
+		return CSRResponse{}, fmt.Errorf("%w: UpdateNOC is not supported", ErrConstraint)
+	}
+	sess, _, err := s.accessingFabric(sessionID)
+	if err != nil {
+		return CSRResponse{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs, err := s.armedBy(sessionID)
+	if err != nil {
+		return CSRResponse{}, err
+	}
+	if fs.addedFabric != 0 {
+		return CSRResponse{}, fmt.Errorf("%w: AddNOC already done under this fail-safe", ErrConstraint)
+	}
+
+	// The key comes from the provider, not crypto/ecdsa.GenerateKey, which
+	// ignores its reader: a DeterministicCryptoProvider reproduces it.
+	p := s.sessionManager.CryptoProvider()
+	kp, err := p.GenerateKeyPair()
+	if err != nil {
+		return CSRResponse{}, fmt.Errorf("commissioning: operational key: %w", err)
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), kp.Private())
+	if err != nil {
+		return CSRResponse{}, fmt.Errorf("commissioning: operational key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(p.Rand(), &x509.CertificateRequest{}, key)
+	if err != nil {
+		return CSRResponse{}, fmt.Errorf("commissioning: CSR: %w", err)
+	}
+	elements, err := tlv.Marshal(&nocsrElements{CSR: csr, CSRNonce: req.CSRNonce})
+	if err != nil {
+		return CSRResponse{}, fmt.Errorf("commissioning: encode NOCSR elements: %w", err)
+	}
+	sig, err := s.sign(elements, sess)
+	if err != nil {
+		return CSRResponse{}, err
+	}
+	fs.opKey = key
+	return CSRResponse{NOCSRElements: elements, AttestationSignature: sig}, nil
+}
+
+// sign signs elements || the session's attestation challenge with the DAC.
+func (s *Server) sign(elements []byte, sess *session.Session) ([]byte, error) {
+	msg := append(bytes.Clone(elements), sess.AttestationChallenge...)
+	sig, err := s.attestation.SignWithDAC(msg)
+	if err != nil {
+		return nil, fmt.Errorf("commissioning: sign with DAC: %w", err)
+	}
+	return sig, nil
+}
+
+// HandleAddTrustedRootCertificate stages the root certificate AddNOC's
+// chain will be anchored at. It needs the fail-safe, and takes one root
+// per fail-safe.
+func (s *Server) HandleAddTrustedRootCertificate(sessionID uint16, req AddTrustedRootCertificateRequest) error {
+	if len(req.RootCACertificate) == 0 {
+		return fmt.Errorf("%w: empty root certificate", ErrConstraint)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs, err := s.armedBy(sessionID)
+	if err != nil {
+		return err
+	}
+	if fs.rootCert != nil || fs.addedFabric != 0 {
+		return fmt.Errorf("%w: root certificate already added under this fail-safe", ErrConstraint)
+	}
+	fs.rootCert = bytes.Clone(req.RootCACertificate)
+	return nil
+}
+
+// HandleAddNOC adds a fabric from the NOC chain, anchored at the staged
+// root certificate and bound to the key of the last CSRRequest: a chain
+// that does not verify is InvalidNOC, a NOC for another key
+// InvalidPublicKey, and a root and fabric ID the table already holds
+// FabricConflict. The fabric is usable for CASE at once, but removed again
+// unless CommissioningComplete commits it.
+func (s *Server) HandleAddNOC(sessionID uint16, req AddNOCRequest) (NOCResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs, err := s.armedBy(sessionID)
+	if err != nil {
+		return NOCResponse{}, err
+	}
+	if fs.addedFabric != 0 {
+		return NOCResponse{}, fmt.Errorf("%w: AddNOC already done under this fail-safe", ErrConstraint)
+	}
+	switch {
+	case fs.opKey == nil:
+		return NOCResponse{StatusCode: NOCStatusMissingCSR}, nil
+	case fs.rootCert == nil || len(req.NOCValue) == 0:
+		return NOCResponse{StatusCode: NOCStatusInvalidNOC}, nil
+	case len(req.IPKValue) != 16:
+		return NOCResponse{}, fmt.Errorf("%w: IPK length %d", ErrConstraint, len(req.IPKValue))
+	case req.CaseAdminSubject == 0:
+		return NOCResponse{StatusCode: NOCStatusInvalidAdminSubject}, nil
+	}
+	noc, err := crypto.VerifyOperationalChain(req.NOCValue, req.ICACValue, fs.rootCert)
+	if err != nil {
+		return NOCResponse{StatusCode: NOCStatusInvalidNOC, DebugText: err.Error()}, nil
+	}
+	if pub, err := fs.opKey.PublicKey.Bytes(); err != nil || !bytes.Equal(pub, noc.PublicKey) {
+		return NOCResponse{StatusCode: NOCStatusInvalidPublicKey}, nil
+	}
+	if s.fabrics.holds(fs.rootCert, noc.FabricID) {
+		return NOCResponse{StatusCode: NOCStatusFabricConflict}, nil
+	}
+	f := &Fabric{
+		RCAC:             fs.rootCert,
+		ICAC:             bytes.Clone(req.ICACValue),
+		NOC:              bytes.Clone(req.NOCValue),
+		IPK:              bytes.Clone(req.IPKValue),
+		CaseAdminSubject: req.CaseAdminSubject,
+		AdminVendorID:    req.AdminVendorID,
+		OperationalKey:   fs.opKey,
+	}
+	if !s.fabrics.add(f) {
+		return NOCResponse{StatusCode: NOCStatusTableFull}, nil
+	}
+	fs.addedFabric = f.Index
+	return NOCResponse{FabricIndex: f.Index}, nil
+}
+
+// HandleAddOrUpdateWiFiNetwork stages a Wi-Fi network, keyed by SSID.
+func (s *Server) HandleAddOrUpdateWiFiNetwork(sessionID uint16, req AddOrUpdateWiFiNetworkRequest) (NetworkConfigResponse, error) {
+	if len(req.SSID) == 0 || len(req.SSID) > 32 {
+		return NetworkConfigResponse{NetworkingStatus: NetworkingOutOfRange}, nil
+	}
+	return s.stageNetwork(sessionID, req.Breadcrumb, Network{
+		ID:          bytes.Clone(req.SSID),
+		SSID:        bytes.Clone(req.SSID),
+		Credentials: bytes.Clone(req.Credentials),
+	})
+}
+
+// HandleAddOrUpdateThreadNetwork stages a Thread network, keyed by its
+// dataset's extended PAN ID.
+func (s *Server) HandleAddOrUpdateThreadNetwork(sessionID uint16, req AddOrUpdateThreadNetworkRequest) (NetworkConfigResponse, error) {
+	id, err := threadExtendedPANID(req.OperationalDataset)
+	if err != nil {
+		return NetworkConfigResponse{NetworkingStatus: NetworkingOutOfRange, DebugText: err.Error()}, nil
+	}
+	return s.stageNetwork(sessionID, req.Breadcrumb, Network{
+		ID:            bytes.Clone(id),
+		ThreadDataset: bytes.Clone(req.OperationalDataset),
+	})
+}
+
+func (s *Server) stageNetwork(sessionID uint16, breadcrumb uint64, n Network) (NetworkConfigResponse, error) {
+	if s.network == nil {
+		return NetworkConfigResponse{}, ErrUnsupportedCommand
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs, err := s.armedBy(sessionID)
+	if err != nil {
+		return NetworkConfigResponse{}, err
+	}
+	if !fs.networksSeen {
+		fs.networks, fs.networksSeen = slices.Clone(s.networks), true
+	}
+	i := slices.IndexFunc(s.networks, func(m Network) bool { return bytes.Equal(m.ID, n.ID) })
+	if i < 0 {
+		s.networks = append(s.networks, n)
+		i = len(s.networks) - 1
+	} else {
+		s.networks = slices.Clone(s.networks)
+		s.networks[i] = n
+	}
+	s.breadcrumb = breadcrumb
+	return NetworkConfigResponse{NetworkIndex: uint8(i)}, nil
+}
+
+// HandleConnectNetwork has the NetworkDriver join a network from the
+// list.
+func (s *Server) HandleConnectNetwork(sessionID uint16, req ConnectNetworkRequest) (ConnectNetworkResponse, error) {
+	if s.network == nil {
+		return ConnectNetworkResponse{}, ErrUnsupportedCommand
+	}
+	s.mu.Lock()
+	if _, err := s.armedBy(sessionID); err != nil {
+		s.mu.Unlock()
+		return ConnectNetworkResponse{}, err
+	}
+	i := slices.IndexFunc(s.networks, func(m Network) bool { return bytes.Equal(m.ID, req.NetworkID) })
+	if i < 0 {
+		s.mu.Unlock()
+		return ConnectNetworkResponse{NetworkingStatus: NetworkingNetworkIDNotFound}, nil
+	}
+	n := s.networks[i]
+	s.breadcrumb = req.Breadcrumb
+	s.mu.Unlock()
+
+	if err := s.network.ConnectNetwork(n); err != nil {
+		return ConnectNetworkResponse{NetworkingStatus: NetworkingOtherConnectionFailure, DebugText: err.Error()}, nil
+	}
+	return ConnectNetworkResponse{}, nil
+}
+
+// HandleCommissioningComplete commits what the fail-safe staged, disarms
+// it, and closes the PASE session and commissioning window. It must come
+// over CASE on the fabric AddNOC added.
+func (s *Server) HandleCommissioningComplete(sessionID uint16) (CommissioningCompleteResponse, error) {
+	sess, fabric, err := s.accessingFabric(sessionID)
+	if err != nil {
+		return CommissioningCompleteResponse{}, err
+	}
+	s.mu.Lock()
+	fs := s.failSafe
+	switch {
+	case fs == nil:
+		s.mu.Unlock()
+		return CommissioningCompleteResponse{ErrorCode: CommissioningNoFailSafe}, nil
+	case sess.Type != session.SessionTypeCASE || fs.addedFabric == 0 || fabric != fs.addedFabric:
+		s.mu.Unlock()
+		return CommissioningCompleteResponse{ErrorCode: CommissioningInvalidAuthentication}, nil
+	}
+	close(fs.stop)
+	s.failSafe = nil
+	s.breadcrumb = 0
+	s.mu.Unlock()
+
+	s.closeSession(fs.paseSessionID)
+	if s.commissionee != nil {
+		// The window may have timed out already; that is fine.
+		s.commissionee.RevokeCommissioning()
+	}
+	return CommissioningCompleteResponse{}, nil
+}
@@ -0,0 +1,564 @@
+// Test with crypto/ecdsa ignoring custom readers, the default from Go 1.26
+// whatever go.mod says, so TestServer_CSRKeyFromProvider catches keys
+// generated outside the crypto provider.
+//
+//go:debug cryptocustomrand=0
+
+package commissioning
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/crypto"
+	"go-matter/session"
+	"go-matter/tlv"
+)
+
+// testAttestation is a DeviceAttestation with a self-signed DAC.
+type testAttestation struct {
+	key *ecdsa.PrivateKey
+	dac []byte
+}
+
+func newTestAttestation(t *testing.T) *testAttestation {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test DAC"},
+		NotBefore:    time.Unix(1_700_000_000, 0),
+		NotAfter:     time.Unix(1_900_000_000, 0),
+	}
+	dac, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAttestation{key: key, dac: dac}
+}
+
+func (a *testAttestation) Certificate(t CertificateType) ([]byte, error) {
+	if t == CertificateDAC {
+		return a.dac, nil
+	}
+	return []byte("PAI"), nil
+}
+
+func (a *testAttestation) CertificationDeclaration() []byte { return []byte("CD") }
+
+func (a *testAttestation) SignWithDAC(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig, nil
+}
+
+// verifyDACSignature checks a SignWithDAC signature over elements and the
+// attestation challenge against the DAC.
+func verifyDACSignature(dac, elements, challenge, sig []byte) error {
+	cert, err := x509.ParseCertificate(dac)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(append(bytes.Clone(elements), challenge...))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), digest[:], r, s) {
+		return errors.New("bad DAC signature")
+	}
+	return nil
+}
+
+type testNetworkDriver struct{ joined []Network }
+
+func (d *testNetworkDriver) ConnectNetwork(n Network) error {
+	d.joined = append(d.joined, n)
+	return nil
+}
+
+// serverHarness is a Server on a fake clock with a PASE session open, and
+// the CA of the fabric it is commissioned into.
+type serverHarness struct {
+	server *Server
+	sm     *session.SessionManager
+	clk    *clock.Fake
+	pase   uint16
+	driver *testNetworkDriver
+	ca     *testCA
+}
+
+func newServerHarness(t *testing.T, commissionee *Commissionee) *serverHarness {
+	t.Helper()
+	return newServerHarnessWith(t, commissionee, nil)
+}
+
+// newServerHarnessWith is newServerHarness with the session manager's
+// crypto provider set to p.
+func newServerHarnessWith(t *testing.T, commissionee *Commissionee, p crypto.CryptoProvider) *serverHarness {
+	t.Helper()
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	sm := session.NewSessionManagerWithConfig(nil, session.Config{Clock: clk, CryptoProvider: p})
+	driver := &testNetworkDriver{}
+	server, err := NewServer(sm, ServerConfig{
+		Attestation:  newTestAttestation(t),
+		Commissionee: commissionee,
+		Network:      driver,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &serverHarness{server: server, sm: sm, clk: clk, driver: driver, pase: 1, ca: newTestCA(t, testFabricID)}
+	h.addSession(t, h.pase, session.SessionTypePASE, 0)
+	return h
+}
+
+func (h *serverHarness) addSession(t *testing.T, id uint16, typ session.SessionType, fabric uint8) {
+	t.Helper()
+	keys, err := crypto.DeriveSessionKeysFromKe(bytes.Repeat([]byte{byte(id)}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.sm.CreateSession(session.SessionParams{ID: id, Type: typ, FabricIndex: fabric, Keys: keys}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stageFabric arms the fail-safe over PASE and runs the commands up to
+// AddNOC, returning the index of the fabric added.
+func (h *serverHarness) stageFabric(t *testing.T, expiry uint16) uint8 {
+	t.Helper()
+	s := h.server
+	if resp, err := s.HandleArmFailSafe(h.pase, ArmFailSafeRequest{ExpiryLengthSeconds: expiry, Breadcrumb: 1}); err != nil || resp.ErrorCode != CommissioningOK {
+		t.Fatalf("ArmFailSafe: %v %v", resp.ErrorCode, err)
+	}
+	resp, err := h.addNOC(t, deviceNode)
+	if err != nil || resp.StatusCode != NOCStatusOK {
+		t.Fatalf("AddNOC: %v %v", resp.StatusCode, err)
+	}
+	return resp.FabricIndex
+}
+
+// addNOC runs CSRRequest, AddTrustedRootCertificate and AddNOC under the
+// armed fail-safe, with a NOC for nodeID issued to the CSR's key.
+func (h *serverHarness) addNOC(t *testing.T, nodeID uint64) (NOCResponse, error) {
+	t.Helper()
+	s := h.server
+	csr, err := s.HandleCSRRequest(h.pase, CSRRequest{CSRNonce: make([]byte, 32)})
+	if err != nil {
+		t.Fatalf("CSRRequest: %v", err)
+	}
+	if err := s.HandleAddTrustedRootCertificate(h.pase, AddTrustedRootCertificateRequest{RootCACertificate: h.ca.rcac}); err != nil {
+		t.Fatalf("AddTrustedRootCertificate: %v", err)
+	}
+	return s.HandleAddNOC(h.pase, AddNOCRequest{
+		NOCValue:         h.ca.issueNOC(t, csrPublicKey(t, csr.NOCSRElements), nodeID),
+		IPKValue:         h.ca.ipk,
+		CaseAdminSubject: controllerNode,
+	})
+}
+
+// csrPublicKey returns the key of the CSR in NOCSR elements.
+func csrPublicKey(t *testing.T, elements []byte) *ecdsa.PublicKey {
+	t.Helper()
+	var e nocsrElements
+	if err := tlv.Unmarshal(elements, &e); err != nil {
+		t.Fatal(err)
+	}
+	req, err := x509.ParseCertificateRequest(e.CSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.CheckSignature(); err != nil {
+		t.Fatal(err)
+	}
+	return req.PublicKey.(*ecdsa.PublicKey)
+}
+
+// waitFor polls cond, for effects of the fail-safe timer's goroutine.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// serverDevice is a Device whose commands the Server handles as if they
+// arrived on session id.
+type serverDevice struct {
+	s  *Server
+	id uint16
+}
+
+func (d serverDevice) ArmFailSafe(_ context.Context, req ArmFailSafeRequest) (ArmFailSafeResponse, error) {
+	return d.s.HandleArmFailSafe(d.id, req)
+}
+
+func (d serverDevice) SetRegulatoryConfig(_ context.Context, req SetRegulatoryConfigRequest) (SetRegulatoryConfigResponse, error) {
+	return d.s.HandleSetRegulatoryConfig(d.id, req)
+}
+
+func (d serverDevice) RequestCertificateChain(_ context.Context, req CertificateChainRequest) (CertificateChainResponse, error) {
+	return d.s.HandleCertificateChainRequest(d.id, req)
+}
+
+func (d serverDevice) RequestAttestation(_ context.Context, req AttestationRequest) (AttestationResponse, error) {
+	return d.s.HandleAttestationRequest(d.id, req)
+}
+
+func (d serverDevice) RequestCSR(_ context.Context, req CSRRequest) (CSRResponse, error) {
+	return d.s.HandleCSRRequest(d.id, req)
+}
+
+func (d serverDevice) AddTrustedRootCertificate(_ context.Context, req AddTrustedRootCertificateRequest) error {
+	return d.s.HandleAddTrustedRootCertificate(d.id, req)
+}
+
+func (d serverDevice) AddNOC(_ context.Context, req AddNOCRequest) (NOCResponse, error) {
+	return d.s.HandleAddNOC(d.id, req)
+}
+
+func (d serverDevice) AddOrUpdateWiFiNetwork(_ context.Context, req AddOrUpdateWiFiNetworkRequest) (NetworkConfigResponse, error) {
+	return d.s.HandleAddOrUpdateWiFiNetwork(d.id, req)
+}
+
+func (d serverDevice) AddOrUpdateThreadNetwork(_ context.Context, req AddOrUpdateThreadNetworkRequest) (NetworkConfigResponse, error) {
+	return d.s.HandleAddOrUpdateThreadNetwork(d.id, req)
+}
+
+func (d serverDevice) ConnectNetwork(_ context.Context, req ConnectNetworkRequest) (ConnectNetworkResponse, error) {
+	return d.s.HandleConnectNetwork(d.id, req)
+}
+
+func (d serverDevice) CommissioningComplete(context.Context) (CommissioningCompleteResponse, error) {
+	return d.s.HandleCommissioningComplete(d.id)
+}
+
+// controllerFabric plays the controller's side for an Orchestrator driving
+// a serverHarness: it checks attestation and CSR signatures against the
+// PASE session's challenge, issues the device a NOC from the harness's CA,
+// and runs CASE with the device on the new fabric.
+type controllerFabric struct {
+	t *testing.T
+	h *serverHarness
+}
+
+func (f controllerFabric) challenge() []byte {
+	s, _ := f.h.sm.Session(f.h.pase)
+	return s.AttestationChallenge
+}
+
+func (f controllerFabric) VerifyAttestation(_ context.Context, a *Attestation) error {
+	var elements attestationElements
+	if err := tlv.Unmarshal(a.Elements, &elements); err != nil {
+		return err
+	}
+	if !bytes.Equal(elements.AttestationNonce, a.Nonce) {
+		return errors.New("nonce not echoed")
+	}
+	return verifyDACSignature(a.DAC, a.Elements, f.challenge(), a.Signature)
+}
+
+func (f controllerFabric) IssueCredentials(_ context.Context, csr *CSR) (*OperationalCredentials, error) {
+	if err := verifyDACSignature(csr.DAC, csr.Elements, f.challenge(), csr.Signature); err != nil {
+		return nil, err
+	}
+	ca := f.h.ca
+	return &OperationalCredentials{
+		RCAC: ca.rcac, NOC: ca.issueNOC(f.t, csrPublicKey(f.t, csr.Elements), deviceNode), IPK: ca.ipk,
+		CaseAdminSubject: controllerNode, AdminVendorID: 0xFFF1,
+		FabricID: ca.fabricID, NodeID: deviceNode,
+	}, nil
+}
+
+func (f controllerFabric) ConnectCASE(_ context.Context, creds *OperationalCredentials) (Device, error) {
+	initiator, err := NewCASEInitiator(session.NewSessionManager(nil), f.h.ca.fabric(f.t, 1, creds.CaseAdminSubject))
+	if err != nil {
+		return nil, err
+	}
+	responder, err := NewCASEResponder(f.h.sm, f.h.server.Fabrics())
+	if err != nil {
+		return nil, err
+	}
+	initErr, respErr := runCASE(f.t, initiator, responder, creds.NodeID)
+	if err := errors.Join(initErr, respErr); err != nil {
+		return nil, err
+	}
+	return serverDevice{f.h.server, initiator.ResponderSessionID}, nil
+}
+
+// TestServer_Orchestrator — an Orchestrator commissions a Server end to
+// end; completion commits the fabric and network and closes the PASE
+// session and commissioning window.
+func TestServer_Orchestrator(t *testing.T) {
+	commissionee := newCommissionee(t, 20202021, session.NewSessionManager(nil))
+	h := newServerHarness(t, commissionee)
+	fabric := controllerFabric{t, h}
+	o, err := NewOrchestrator(serverDevice{h.server, h.pase}, OrchestratorConfig{
+		Attestation: fabric, Issuer: fabric, CASE: fabric,
+		CountryCode: "NZ", Location: RegulatoryIndoorOutdoor,
+		Network: &NetworkConfig{SSID: []byte("home"), Credentials: []byte("secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if h.server.FailSafeArmed() || h.server.Breadcrumb() != 0 {
+		t.Errorf("after completion: armed %v, breadcrumb %d", h.server.FailSafeArmed(), h.server.Breadcrumb())
+	}
+	if _, ok := h.sm.Session(h.pase); ok {
+		t.Error("PASE session still open")
+	}
+	if s := commissionee.WindowStatus(); s != WindowNotOpen {
+		t.Errorf("commissioning window %v", s)
+	}
+	if loc, country := h.server.RegulatoryConfig(); loc != RegulatoryIndoorOutdoor || country != "NZ" {
+		t.Errorf("regulatory config %v %q", loc, country)
+	}
+	if len(h.driver.joined) != 1 || !bytes.Equal(h.driver.joined[0].SSID, []byte("home")) {
+		t.Errorf("joined %+v", h.driver.joined)
+	}
+
+	// Nothing is rolled back once committed.
+	h.clk.Advance(MaxCumulativeFailSafe)
+	f, ok := h.server.Fabrics().Fabric(o.FabricIndex)
+	if !ok || f.OperationalKey == nil || !bytes.Equal(f.NOC, o.Credentials.NOC) {
+		t.Fatalf("fabric %d not committed: %+v", o.FabricIndex, f)
+	}
+	if len(h.server.Networks()) != 1 {
+		t.Errorf("networks %+v", h.server.Networks())
+	}
+}
+
+// TestServer_FailSafeExpiry — on expiry the staged fabric, root and
+// network changes are rolled back and the PASE session closed.
+func TestServer_FailSafeExpiry(t *testing.T) {
+	h := newServerHarness(t, nil)
+	index := h.stageFabric(t, 60)
+	if _, err := h.server.HandleAddOrUpdateWiFiNetwork(h.pase, AddOrUpdateWiFiNetworkRequest{SSID: []byte("home"), Breadcrumb: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if h.server.Breadcrumb() != 5 {
+		t.Errorf("breadcrumb %d, want 5", h.server.Breadcrumb())
+	}
+
+	h.clk.Advance(59 * time.Second)
+	if !h.server.FailSafeArmed() {
+		t.Fatal("fail-safe expired early")
+	}
+	h.clk.Advance(time.Second)
+	waitFor(t, "rollback", func() bool { return !h.server.FailSafeArmed() })
+	if _, ok := h.server.Fabrics().Fabric(index); ok {
+		t.Error("staged fabric survived expiry")
+	}
+	if n := h.server.Networks(); len(n) != 0 {
+		t.Errorf("staged network survived expiry: %+v", n)
+	}
+	if h.server.Breadcrumb() != 0 {
+		t.Errorf("breadcrumb %d after expiry", h.server.Breadcrumb())
+	}
+	if _, ok := h.sm.Session(h.pase); ok {
+		t.Error("PASE session still open after expiry")
+	}
+}
+
+// TestServer_FailSafeExpiryClosesCASE — a CASE session set up on the
+// staged fabric does not outlive the fabric when the fail-safe expires.
+func TestServer_FailSafeExpiryClosesCASE(t *testing.T) {
+	h := newServerHarness(t, nil)
+	index := h.stageFabric(t, 60)
+	device, err := controllerFabric{t, h}.ConnectCASE(context.Background(), &OperationalCredentials{CaseAdminSubject: controllerNode, NodeID: deviceNode})
+	if err != nil {
+		t.Fatalf("CASE: %v", err)
+	}
+	id := device.(serverDevice).id
+	if s, ok := h.sm.Session(id); !ok || s.FabricIndex != index {
+		t.Fatalf("CASE session %d not on fabric %d", id, index)
+	}
+
+	h.clk.Advance(60 * time.Second)
+	waitFor(t, "rollback", func() bool { return !h.server.FailSafeArmed() })
+	if _, ok := h.sm.Session(id); ok {
+		t.Error("CASE session on the rolled-back fabric still open")
+	}
+}
+
+func TestServer_ArmFailSafe(t *testing.T) {
+	h := newServerHarness(t, nil)
+	s := h.server
+	arm := func(id uint16, expiry uint16) CommissioningError {
+		t.Helper()
+		resp, err := s.HandleArmFailSafe(id, ArmFailSafeRequest{ExpiryLengthSeconds: expiry})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.ErrorCode
+	}
+
+	// Re-arming extends the deadline, up to MaxCumulativeFailSafe.
+	arm(h.pase, 60)
+	h.clk.Advance(50 * time.Second)
+	arm(h.pase, 60)
+	h.clk.Advance(50 * time.Second)
+	if !s.FailSafeArmed() {
+		t.Fatal("re-arm did not extend the fail-safe")
+	}
+	arm(h.pase, 0xFFFF)
+	h.clk.Advance(MaxCumulativeFailSafe - 100*time.Second)
+	waitFor(t, "cumulative limit", func() bool { return !s.FailSafeArmed() })
+
+	// Another administrator cannot take over an armed fail-safe. Expiry
+	// closed the PASE session; the controller opens a new one.
+	h.addSession(t, h.pase, session.SessionTypePASE, 0)
+	h.addSession(t, 9, session.SessionTypeCASE, 3)
+	arm(h.pase, 60)
+	if code := arm(9, 60); code != CommissioningBusyWithOtherAdmin {
+		t.Errorf("second administrator: %v, want BusyWithOtherAdmin", code)
+	}
+
+	// Disarming rolls back at once.
+	index := h.stageFabric(t, 60)
+	if code := arm(h.pase, 0); code != CommissioningOK || s.FailSafeArmed() {
+		t.Fatalf("disarm: %v, armed %v", code, s.FailSafeArmed())
+	}
+	if _, ok := s.Fabrics().Fabric(index); ok {
+		t.Error("disarm kept the staged fabric")
+	}
+}
+
+func TestServer_CommandErrors(t *testing.T) {
+	h := newServerHarness(t, nil)
+	s := h.server
+
+	if _, err := s.HandleCSRRequest(h.pase, CSRRequest{CSRNonce: make([]byte, 32)}); !errors.Is(err, ErrFailSafeRequired) {
+		t.Errorf("CSRRequest unarmed: %v", err)
+	}
+	if err := s.HandleAddTrustedRootCertificate(h.pase, AddTrustedRootCertificateRequest{RootCACertificate: []byte("R")}); !errors.Is(err, ErrFailSafeRequired) {
+		t.Errorf("AddTrustedRootCertificate unarmed: %v", err)
+	}
+	if resp, _ := s.HandleCommissioningComplete(h.pase); resp.ErrorCode != CommissioningNoFailSafe {
+		t.Errorf("CommissioningComplete unarmed: %v", resp.ErrorCode)
+	}
+	if _, err := s.HandleAttestationRequest(h.pase, AttestationRequest{AttestationNonce: []byte("short")}); !errors.Is(err, ErrConstraint) {
+		t.Errorf("short attestation nonce: %v", err)
+	}
+
+	s.HandleArmFailSafe(h.pase, ArmFailSafeRequest{ExpiryLengthSeconds: 60})
+	if resp, _ := s.HandleAddNOC(h.pase, AddNOCRequest{NOCValue: []byte("NOC"), IPKValue: make([]byte, 16), CaseAdminSubject: 1}); resp.StatusCode != NOCStatusMissingCSR {
+		t.Errorf("AddNOC before CSR: %v", resp.StatusCode)
+	}
+	if resp, _ := s.HandleCommissioningComplete(h.pase); resp.ErrorCode != CommissioningInvalidAuthentication {
+		t.Errorf("CommissioningComplete over PASE: %v", resp.ErrorCode)
+	}
+	s.HandleArmFailSafe(h.pase, ArmFailSafeRequest{}) // disarming closes the PASE session
+	h.addSession(t, h.pase, session.SessionTypePASE, 0)
+
+	index := h.stageFabric(t, 60)
+	h.addSession(t, 9, session.SessionTypeCASE, index+1)
+	if resp, _ := s.HandleCommissioningComplete(9); resp.ErrorCode != CommissioningInvalidAuthentication {
+		t.Errorf("CommissioningComplete from another fabric: %v", resp.ErrorCode)
+	}
+	if err := s.HandleAddTrustedRootCertificate(h.pase, AddTrustedRootCertificateRequest{RootCACertificate: []byte("R")}); !errors.Is(err, ErrConstraint) {
+		t.Errorf("second root certificate: %v", err)
+	}
+	if resp, _ := s.HandleConnectNetwork(h.pase, ConnectNetworkRequest{NetworkID: []byte("nowhere")}); resp.NetworkingStatus != NetworkingNetworkIDNotFound {
+		t.Errorf("ConnectNetwork to unknown network: %v", resp.NetworkingStatus)
+	}
+}
+
+// TestServer_AddNOCValidation — AddNOC only takes a NOC that chains to the
+// staged root, carries the CSR's key and names a fabric the device is not
+// already on.
+func TestServer_AddNOCValidation(t *testing.T) {
+	h := newServerHarness(t, nil)
+	s := h.server
+	s.HandleArmFailSafe(h.pase, ArmFailSafeRequest{ExpiryLengthSeconds: 60})
+	if _, err := s.HandleCSRRequest(h.pase, CSRRequest{CSRNonce: make([]byte, 32)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleAddTrustedRootCertificate(h.pase, AddTrustedRootCertificateRequest{RootCACertificate: h.ca.rcac}); err != nil {
+		t.Fatal(err)
+	}
+	addNOC := func(noc []byte) NOCStatus {
+		t.Helper()
+		resp, err := s.HandleAddNOC(h.pase, AddNOCRequest{NOCValue: noc, IPKValue: h.ca.ipk, CaseAdminSubject: controllerNode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := addNOC([]byte("NOC")); code != NOCStatusInvalidNOC {
+		t.Errorf("malformed NOC: %v", code)
+	}
+	if code := addNOC(newTestCA(t, testFabricID).issueNOC(t, &other.PublicKey, deviceNode)); code != NOCStatusInvalidNOC {
+		t.Errorf("NOC from another root: %v", code)
+	}
+	if code := addNOC(h.ca.issueNOC(t, &other.PublicKey, deviceNode)); code != NOCStatusInvalidPublicKey {
+		t.Errorf("NOC for another key: %v", code)
+	}
+	s.HandleArmFailSafe(h.pase, ArmFailSafeRequest{}) // disarming closes the PASE session
+
+	// Once the device is on the fabric, it cannot join it again.
+	h.addSession(t, h.pase, session.SessionTypePASE, 0)
+	index := h.stageFabric(t, 60)
+	h.addSession(t, 9, session.SessionTypeCASE, index)
+	if resp, _ := s.HandleCommissioningComplete(9); resp.ErrorCode != CommissioningOK {
+		t.Fatalf("CommissioningComplete: %v", resp.ErrorCode)
+	}
+	h.addSession(t, h.pase, session.SessionTypePASE, 0)
+	s.HandleArmFailSafe(h.pase, ArmFailSafeRequest{ExpiryLengthSeconds: 60})
+	if resp, _ := h.addNOC(t, deviceNode+1); resp.StatusCode != NOCStatusFabricConflict {
+		t.Errorf("second NOC on the fabric: %v", resp.StatusCode)
+	}
+}
+
+// TestServer_CSRKeyFromProvider — the operational key is drawn from the
+// session manager's crypto provider, so a deterministic provider yields
+// the same key on every run.
+func TestServer_CSRKeyFromProvider(t *testing.T) {
+	csrKey := func() []byte {
+		h := newServerHarnessWith(t, nil, crypto.NewDeterministicCryptoProvider([]byte("csr")))
+		if _, err := h.server.HandleArmFailSafe(h.pase, ArmFailSafeRequest{ExpiryLengthSeconds: 60}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := h.server.HandleCSRRequest(h.pase, CSRRequest{CSRNonce: make([]byte, 32)})
+		if err != nil {
+			t.Fatalf("CSRRequest: %v", err)
+		}
+		pub, err := csrPublicKey(t, resp.NOCSRElements).Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub
+	}
+	if a, b := csrKey(), csrKey(); !bytes.Equal(a, b) {
+		t.Errorf("same seed, different operational keys:\n%x\n%x", a, b)
+	}
+}
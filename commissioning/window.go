@@ -0,0 +1,249 @@
+package commissioning
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go-matter/crypto"
+	"go-matter/setup"
+)
+
+// WindowStatus is the state of the device's commissioning window, with the
+// values of the AdministratorCommissioning cluster's WindowStatus
+// attribute.
+type WindowStatus uint8
+
+const (
+	WindowNotOpen      WindowStatus = 0
+	EnhancedWindowOpen WindowStatus = 1
+	BasicWindowOpen    WindowStatus = 2
+)
+
+func (s WindowStatus) String() string {
+	switch s {
+	case WindowNotOpen:
+		return "WindowNotOpen"
+	case EnhancedWindowOpen:
+		return "EnhancedWindowOpen"
+	case BasicWindowOpen:
+		return "BasicWindowOpen"
+	}
+	return fmt.Sprintf("WindowStatus(%d)", uint8(s))
+}
+
+// Commissioning window limits, from the core spec's commissioning flows and
+// the AdministratorCommissioning cluster.
+const (
+	// MinCommissioningTimeout is the shortest window an administrator may
+	// open.
+	MinCommissioningTimeout = 3 * time.Minute
+	// MaxCommissioningTimeout is the longest window an administrator may
+	// open.
+	MaxCommissioningTimeout = 15 * time.Minute
+	// MaxFailedPASEAttempts is how many failed PASE attempts a window
+	// tolerates before it closes, bounding passcode brute-forcing.
+	MaxFailedPASEAttempts = 20
+)
+
+// DefaultPasscodeID is the PasscodeID a PBKDFParamRequest carries for the
+// device's onboarding passcode, the one a basic window serves.
+const DefaultPasscodeID uint16 = 0
+
+// EnhancedWindow is what an administrator installs with the
+// AdministratorCommissioning cluster's OpenCommissioningWindow command: a
+// fresh SPAKE2+ verifier, the PBKDF parameters it was derived with, and
+// the discriminator the device advertises while the window is open.
+// Controllers select the verifier by sending PasscodeID in their
+// PBKDFParamRequest.
+type EnhancedWindow struct {
+	Verifier      crypto.SPAKE2PVerifierData
+	Salt          []byte
+	Iterations    int
+	PasscodeID    uint16
+	Discriminator uint16
+}
+
+// pakeCredentials are the verifier and PBKDF parameters one handshake
+// runs with, fixed when it is admitted.
+type pakeCredentials struct {
+	w0, l      []byte
+	salt       []byte
+	iterations uint32
+	passcodeID uint16
+}
+
+var (
+	// ErrWindowNotOpen is returned by HandleExchange while no
+	// commissioning window is open, and by RevokeCommissioning when there
+	// is none to close. The cluster reports it as WindowNotOpen.
+	ErrWindowNotOpen = errors.New("commissioning: commissioning window not open")
+	// ErrWindowBusy is returned when opening a window while one is open.
+	// The cluster reports it as Busy.
+	ErrWindowBusy = errors.New("commissioning: commissioning window already open")
+	// ErrInvalidWindowTimeout is returned for a window timeout outside
+	// [MinCommissioningTimeout, MaxCommissioningTimeout].
+	ErrInvalidWindowTimeout = errors.New("commissioning: invalid commissioning window timeout")
+)
+
+// OpenBasicCommissioningWindow opens a window in which controllers may
+// establish PASE with the device's onboarding passcode, as the
+// AdministratorCommissioning cluster's OpenBasicCommissioningWindow
+// command does. The window closes after timeout, timed on the session
+// manager's clock, after MaxFailedPASEAttempts failed attempts, or on
+// RevokeCommissioning.
+func (c *Commissionee) OpenBasicCommissioningWindow(timeout time.Duration) error {
+	return c.openWindow(BasicWindowOpen, timeout, nil, 0)
+}
+
+// OpenEnhancedCommissioningWindow opens a window in which controllers
+// establish PASE against w.Verifier instead of the onboarding passcode, as
+// the AdministratorCommissioning cluster's OpenCommissioningWindow command
+// does. The verifier, PBKDF parameters and discriminator are validated and
+// copied; they are discarded when the window closes, which happens as for
+// OpenBasicCommissioningWindow.
+func (c *Commissionee) OpenEnhancedCommissioningWindow(timeout time.Duration, w EnhancedWindow) error {
+	if err := w.Verifier.Validate(); err != nil {
+		return fmt.Errorf("commissionee: %w", err)
+	}
+	if err := crypto.ValidatePBKDFParams(w.Salt, w.Iterations); err != nil {
+		return fmt.Errorf("commissionee: %w", err)
+	}
+	if w.Discriminator > setup.MaxDiscriminator {
+		return fmt.Errorf("commissionee: discriminator %#x exceeds 12 bits", w.Discriminator)
+	}
+	creds := &pakeCredentials{
+		w0:         append([]byte(nil), w.Verifier.W0...),
+		l:          append([]byte(nil), w.Verifier.L...),
+		salt:       append([]byte(nil), w.Salt...),
+		iterations: uint32(w.Iterations),
+		passcodeID: w.PasscodeID,
+	}
+	return c.openWindow(EnhancedWindowOpen, timeout, creds, w.Discriminator)
+}
+
+func (c *Commissionee) openWindow(status WindowStatus, timeout time.Duration, creds *pakeCredentials, discriminator uint16) error {
+	if timeout < MinCommissioningTimeout || timeout > MaxCommissioningTimeout {
+		return fmt.Errorf("%w: %v", ErrInvalidWindowTimeout, timeout)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.windowStatusLocked() != WindowNotOpen {
+		return ErrWindowBusy
+	}
+	c.window = status
+	c.windowDeadline = c.sessionManager.Clock().Now().Add(timeout)
+	c.failedAttempts = 0
+	c.enhanced = creds
+	c.discriminator = discriminator
+	return nil
+}
+
+// RevokeCommissioning closes the open commissioning window, as the
+// AdministratorCommissioning cluster's RevokeCommissioning command does.
+// A handshake already in progress runs to completion.
+func (c *Commissionee) RevokeCommissioning() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.windowStatusLocked() == WindowNotOpen {
+		return ErrWindowNotOpen
+	}
+	c.closeWindowLocked()
+	return nil
+}
+
+// WindowStatus reports whether a commissioning window is open, and which
+// kind.
+func (c *Commissionee) WindowStatus() WindowStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.windowStatusLocked()
+}
+
+// WindowDiscriminator returns the discriminator an open enhanced window
+// was given, which the device advertises instead of its own while the
+// window is open. ok is false when no enhanced window is open.
+func (c *Commissionee) WindowDiscriminator() (discriminator uint16, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.windowStatusLocked() != EnhancedWindowOpen {
+		return 0, false
+	}
+	return c.discriminator, true
+}
+
+// FailedAttempts returns how many PASE attempts have failed in the current
+// (or last) window.
+func (c *Commissionee) FailedAttempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failedAttempts
+}
+
+// windowStatusLocked closes the window if its timeout has passed and
+// returns its status. The caller holds c.mu.
+func (c *Commissionee) windowStatusLocked() WindowStatus {
+	if c.window != WindowNotOpen && !c.sessionManager.Clock().Now().Before(c.windowDeadline) {
+		c.closeWindowLocked()
+	}
+	return c.window
+}
+
+// closeWindowLocked closes the window and discards an enhanced window's
+// verifier. The caller holds c.mu.
+func (c *Commissionee) closeWindowLocked() {
+	c.window = WindowNotOpen
+	c.enhanced = nil
+	c.discriminator = 0
+}
+
+// admit decides whether a new PASE attempt may start: the window must be
+// open, no other handshake running, and no PASE session from an earlier
+// attempt still installed (a commissionee holds one PASE session at a
+// time). On success the attempt holds the handshake until release, and
+// runs with the credentials returned: the enhanced window's verifier, or
+// the onboarding passcode's.
+func (c *Commissionee) admit() (*pakeCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.windowStatusLocked() == WindowNotOpen {
+		return nil, ErrWindowNotOpen
+	}
+	if c.active {
+		return nil, ErrBusy
+	}
+	if c.paseSessionID != 0 {
+		if _, ok := c.sessionManager.Session(c.paseSessionID); ok {
+			return nil, ErrBusy
+		}
+		c.paseSessionID = 0
+	}
+	c.active = true
+	if c.enhanced != nil {
+		return c.enhanced, nil
+	}
+	return &pakeCredentials{
+		w0:         c.W0,
+		l:          c.L,
+		salt:       c.Salt,
+		iterations: c.Iterations,
+		passcodeID: DefaultPasscodeID,
+	}, nil
+}
+
+// release ends the attempt admit started. A failure counts towards
+// MaxFailedPASEAttempts; a success records the PASE session so no other
+// attempt is admitted while it lives.
+func (c *Commissionee) release(err error, sessionID uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = false
+	if err == nil {
+		c.paseSessionID = sessionID
+		return
+	}
+	c.failedAttempts++
+	if c.failedAttempts >= MaxFailedPASEAttempts {
+		c.closeWindowLocked()
+	}
+}
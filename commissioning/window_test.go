@@ -0,0 +1,349 @@
+package commissioning
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/crypto"
+	"go-matter/exchange"
+	"go-matter/message"
+	"go-matter/session"
+	"go-matter/tlv"
+)
+
+// startPASE sends a PBKDFParamRequest to commissionee and returns how the
+// device handled it, for attempts the device is expected to turn away
+// without an answer.
+func startPASE(t *testing.T, commissionee *Commissionee, passcode uint32) error {
+	t.Helper()
+	ctrl, done, cancel := connect(session.NewSessionManager(nil), commissionee.sessionManager, commissionee)
+	defer cancel()
+	ex, err := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCommissioner(session.NewSessionManager(nil))
+	c.ex = ex
+	if err := c.start(passcode); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("commissionee did not finish")
+	}
+	return nil
+}
+
+func TestWindow_OpenAndRevoke(t *testing.T) {
+	commissionee, err := NewCommissionee(20202021, []byte("SPAKE2P Key Salt"), 1000, session.NewSessionManager(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := commissionee.WindowStatus(); s != WindowNotOpen {
+		t.Fatalf("new commissionee: window %v", s)
+	}
+	for _, d := range []time.Duration{0, MinCommissioningTimeout - time.Second, MaxCommissioningTimeout + time.Second} {
+		if err := commissionee.OpenBasicCommissioningWindow(d); !errors.Is(err, ErrInvalidWindowTimeout) {
+			t.Errorf("timeout %v: err = %v, want ErrInvalidWindowTimeout", d, err)
+		}
+	}
+	if err := commissionee.RevokeCommissioning(); !errors.Is(err, ErrWindowNotOpen) {
+		t.Errorf("revoke with no window: err = %v, want ErrWindowNotOpen", err)
+	}
+	if err := commissionee.OpenBasicCommissioningWindow(MinCommissioningTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if s := commissionee.WindowStatus(); s != BasicWindowOpen {
+		t.Errorf("window %v, want BasicWindowOpen", s)
+	}
+	if err := commissionee.OpenBasicCommissioningWindow(MinCommissioningTimeout); !errors.Is(err, ErrWindowBusy) {
+		t.Errorf("second open: err = %v, want ErrWindowBusy", err)
+	}
+	if err := commissionee.RevokeCommissioning(); err != nil {
+		t.Fatal(err)
+	}
+	if s := commissionee.WindowStatus(); s != WindowNotOpen {
+		t.Errorf("after revoke: window %v", s)
+	}
+}
+
+// TestWindow_ClosedIgnoresAttempts — with no window open the device does
+// not take part in PASE at all.
+func TestWindow_ClosedIgnoresAttempts(t *testing.T) {
+	const passcode = uint32(20202021)
+	devSM := session.NewSessionManager(nil)
+	commissionee, err := NewCommissionee(passcode, []byte("SPAKE2P Key Salt"), 1000, devSM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := startPASE(t, commissionee, passcode); !errors.Is(err, ErrWindowNotOpen) {
+		t.Errorf("err = %v, want ErrWindowNotOpen", err)
+	}
+	if commissionee.State != StateIdle || commissionee.SessionID != 0 {
+		t.Errorf("device took part: state %d, session %d", commissionee.State, commissionee.SessionID)
+	}
+}
+
+// TestWindow_Expires — the window closes once its timeout passes on the
+// device's clock.
+func TestWindow_Expires(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	devSM := session.NewSessionManagerWithConfig(nil, session.Config{Clock: clk})
+	commissionee := newCommissionee(t, 20202021, devSM)
+
+	clk.Advance(MaxCommissioningTimeout - time.Second)
+	if s := commissionee.WindowStatus(); s != BasicWindowOpen {
+		t.Fatalf("closed early: window %v", s)
+	}
+	clk.Advance(time.Second)
+	if s := commissionee.WindowStatus(); s != WindowNotOpen {
+		t.Errorf("window %v after timeout", s)
+	}
+	if err := startPASE(t, commissionee, 20202021); !errors.Is(err, ErrWindowNotOpen) {
+		t.Errorf("attempt after timeout: err = %v, want ErrWindowNotOpen", err)
+	}
+}
+
+// TestWindow_ClosesAfterFailedAttempts — MaxFailedPASEAttempts wrong
+// passcodes close the window, so the right one no longer works.
+func TestWindow_ClosesAfterFailedAttempts(t *testing.T) {
+	const passcode = uint32(20202021)
+	commissionee := newCommissionee(t, passcode, session.NewSessionManager(nil))
+	for i := range MaxFailedPASEAttempts {
+		if s := commissionee.WindowStatus(); s != BasicWindowOpen {
+			t.Fatalf("window %v after %d failures", s, i)
+		}
+		if err := runPASE(t, NewCommissioner(session.NewSessionManager(nil)), commissionee, passcode+1); err == nil {
+			t.Fatal("wrong passcode accepted")
+		}
+	}
+	if n := commissionee.FailedAttempts(); n != MaxFailedPASEAttempts {
+		t.Errorf("FailedAttempts = %d, want %d", n, MaxFailedPASEAttempts)
+	}
+	if s := commissionee.WindowStatus(); s != WindowNotOpen {
+		t.Fatalf("window %v after %d failures", s, MaxFailedPASEAttempts)
+	}
+	if err := startPASE(t, commissionee, passcode); !errors.Is(err, ErrWindowNotOpen) {
+		t.Errorf("right passcode after lockout: err = %v, want ErrWindowNotOpen", err)
+	}
+
+	if err := commissionee.OpenBasicCommissioningWindow(MinCommissioningTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if n := commissionee.FailedAttempts(); n != 0 {
+		t.Errorf("FailedAttempts = %d in a new window", n)
+	}
+}
+
+// TestWindow_OnePASESession — while the PASE session of a successful
+// attempt lives, further attempts are answered with Busy.
+func TestWindow_OnePASESession(t *testing.T) {
+	const passcode = uint32(20202021)
+	devSM := session.NewSessionManager(nil)
+	commissionee := newCommissionee(t, passcode, devSM)
+	if err := runPASE(t, NewCommissioner(session.NewSessionManager(nil)), commissionee, passcode); err != nil {
+		t.Fatalf("PASE handshake: %v", err)
+	}
+
+	err := runPASE(t, NewCommissioner(session.NewSessionManager(nil)), commissionee, passcode)
+	var se *exchange.StatusError
+	if !errors.As(err, &se) || se.Report.GeneralCode != message.GeneralCodeBusy {
+		t.Fatalf("attempt during PASE session: err = %v, want Busy StatusReport", err)
+	}
+	if n := commissionee.FailedAttempts(); n != 0 {
+		t.Errorf("Busy counted as a failed attempt: %d", n)
+	}
+
+	devSM.RemoveSession(commissionee.SessionID)
+	if err := runPASE(t, NewCommissioner(session.NewSessionManager(nil)), commissionee, passcode); err != nil {
+		t.Errorf("attempt after PASE session closed: %v", err)
+	}
+}
+
+// TestWindow_RevokeKeepsHandshake — revoking the window stops new
+// attempts but lets the one in progress finish.
+func TestWindow_RevokeKeepsHandshake(t *testing.T) {
+	const passcode = uint32(20202021)
+	devSM := session.NewSessionManager(nil)
+	commissionee := newCommissionee(t, passcode, devSM)
+	ctrl, done, cancel := connect(session.NewSessionManager(nil), devSM, commissionee)
+	defer cancel()
+
+	ex, _ := ctrl.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	c := NewCommissioner(session.NewSessionManager(nil))
+	c.ex = ex
+	if err := c.start(passcode); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ex.Receive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := commissionee.RevokeCommissioning(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.HandleMessage(frame); err != nil {
+		t.Fatal(err)
+	}
+	for c.State != StateComplete {
+		frame, err := ex.Receive(context.Background())
+		if err != nil {
+			t.Fatalf("handshake after revoke: %v", err)
+		}
+		if err := c.HandleMessage(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("device: %v", err)
+	}
+}
+
+// enhancedWindow returns the parameters of an enhanced window for
+// passcode, derived with their own salt and iteration count.
+func enhancedWindow(t *testing.T, passcode uint32) EnhancedWindow {
+	t.Helper()
+	salt := []byte("Enhanced Window Salt")
+	v, err := crypto.NewSPAKE2PVerifierData(passcode, salt, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return EnhancedWindow{Verifier: *v, Salt: salt, Iterations: 2000, PasscodeID: 1, Discriminator: 0xABC}
+}
+
+// TestWindow_Enhanced — an enhanced window serves its own verifier, and
+// only that, until it closes.
+func TestWindow_Enhanced(t *testing.T) {
+	const onboarding, windowPasscode = uint32(20202021), uint32(34567890)
+	devSM := session.NewSessionManager(nil)
+	commissionee, err := NewCommissionee(onboarding, []byte("SPAKE2P Key Salt"), 1000, devSM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := enhancedWindow(t, windowPasscode)
+	if err := commissionee.OpenEnhancedCommissioningWindow(MinCommissioningTimeout, w); err != nil {
+		t.Fatal(err)
+	}
+	if s := commissionee.WindowStatus(); s != EnhancedWindowOpen {
+		t.Fatalf("window %v, want EnhancedWindowOpen", s)
+	}
+	if d, ok := commissionee.WindowDiscriminator(); !ok || d != w.Discriminator {
+		t.Errorf("WindowDiscriminator = %#x, %v", d, ok)
+	}
+
+	onboardingAttempt := NewCommissioner(session.NewSessionManager(nil))
+	onboardingAttempt.PasscodeID = w.PasscodeID
+	if err := runPASE(t, onboardingAttempt, commissionee, onboarding); err == nil {
+		t.Fatal("onboarding passcode accepted in an enhanced window")
+	}
+
+	commissioner := NewCommissioner(session.NewSessionManager(nil))
+	commissioner.PasscodeID = w.PasscodeID
+	if err := runPASE(t, commissioner, commissionee, windowPasscode); err != nil {
+		t.Fatalf("PASE handshake: %v", err)
+	}
+	if !bytes.Equal(commissioner.Salt, w.Salt) || commissioner.Iterations != uint32(w.Iterations) {
+		t.Errorf("commissioner got salt %q iterations %d", commissioner.Salt, commissioner.Iterations)
+	}
+	devSM.RemoveSession(commissionee.SessionID)
+
+	// Closing the window discards its verifier; a basic window is back on
+	// the onboarding passcode.
+	if err := commissionee.RevokeCommissioning(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := commissionee.WindowDiscriminator(); ok {
+		t.Error("discriminator outlived the window")
+	}
+	if err := commissionee.OpenBasicCommissioningWindow(MinCommissioningTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPASE(t, NewCommissioner(session.NewSessionManager(nil)), commissionee, onboarding); err != nil {
+		t.Errorf("onboarding passcode after enhanced window: %v", err)
+	}
+}
+
+// TestWindow_KnownPBKDFParams — a controller that already knows the salt
+// and iterations asks the device to leave them out, and still succeeds.
+func TestWindow_KnownPBKDFParams(t *testing.T) {
+	const passcode = uint32(34567890)
+	commissionee, err := NewCommissionee(20202021, []byte("SPAKE2P Key Salt"), 1000, session.NewSessionManager(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := enhancedWindow(t, passcode)
+	if err := commissionee.OpenEnhancedCommissioningWindow(MinCommissioningTimeout, w); err != nil {
+		t.Fatal(err)
+	}
+
+	commissioner := NewCommissioner(session.NewSessionManager(nil))
+	commissioner.PasscodeID = w.PasscodeID
+	commissioner.PBKDFParams = &PBKDFParamSet{Iterations: uint32(w.Iterations), Salt: w.Salt}
+	if err := runPASE(t, commissioner, commissionee, passcode); err != nil {
+		t.Fatalf("PASE handshake: %v", err)
+	}
+	var req PBKDFParamRequest
+	if err := tlv.Unmarshal(commissioner.RequestPayload, &req); err != nil {
+		t.Fatal(err)
+	}
+	var resp PBKDFParamResponse
+	if err := tlv.Unmarshal(commissioner.ResponsePayload, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !req.HasPBKDFParameters || req.PasscodeID != w.PasscodeID {
+		t.Errorf("request: HasPBKDFParameters %v, PasscodeID %d", req.HasPBKDFParameters, req.PasscodeID)
+	}
+	if resp.Params != nil {
+		t.Errorf("device sent PBKDF parameters the controller already had: %+v", resp.Params)
+	}
+}
+
+// TestWindow_UnknownPasscodeID — a request for a verifier the window does
+// not hold fails with InvalidParameter.
+func TestWindow_UnknownPasscodeID(t *testing.T) {
+	const passcode = uint32(20202021)
+	commissionee := newCommissionee(t, passcode, session.NewSessionManager(nil))
+	commissioner := NewCommissioner(session.NewSessionManager(nil))
+	commissioner.PasscodeID = 7
+	err := runPASE(t, commissioner, commissionee, passcode)
+	var se *exchange.StatusError
+	if !errors.As(err, &se) || se.Report.ProtocolCode != message.SecureChannelInvalidParameter {
+		t.Errorf("err = %v, want InvalidParameter StatusReport", err)
+	}
+	if n := commissionee.FailedAttempts(); n != 1 {
+		t.Errorf("FailedAttempts = %d, want 1", n)
+	}
+}
+
+func TestWindow_EnhancedRejectsBadParameters(t *testing.T) {
+	commissionee, err := NewCommissionee(20202021, []byte("SPAKE2P Key Salt"), 1000, session.NewSessionManager(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := enhancedWindow(t, 34567890)
+	tests := []struct {
+		name   string
+		mutate func(w *EnhancedWindow)
+	}{
+		{"truncated L", func(w *EnhancedWindow) { w.Verifier.L = w.Verifier.L[:10] }},
+		{"short salt", func(w *EnhancedWindow) { w.Salt = []byte("salt") }},
+		{"too few iterations", func(w *EnhancedWindow) { w.Iterations = 999 }},
+		{"discriminator", func(w *EnhancedWindow) { w.Discriminator = 0x1000 }},
+	}
+	for _, tc := range tests {
+		w := good
+		tc.mutate(&w)
+		if err := commissionee.OpenEnhancedCommissioningWindow(MinCommissioningTimeout, w); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+	if s := commissionee.WindowStatus(); s != WindowNotOpen {
+		t.Errorf("window %v after rejected opens", s)
+	}
+}
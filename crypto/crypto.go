@@ -1,3 +1,15 @@
+// Package crypto provides the primitives Matter builds on (Matter §3),
+// behind CryptoProvider, with the SPAKE2+ exchange PASE runs, the key
+// derivations of sessions, groups and message privacy, and operational
+// certificates.
+//
+// Operational certificates come in two encodings: the compact Matter TLV
+// form (Matter §6.5.2), which is what travels between nodes, in CASE and
+// in the operational credentials commands, and X.509 DER, the form the
+// issuer's signature covers. Functions that take a certificate accept
+// either; MatterTLVToX509 and X509ToMatterTLV convert between them, and
+// a DER certificate can only be sent if it converts to TLV and back
+// unchanged.
 package crypto
 
 import (
@@ -29,7 +41,10 @@ type KeyPair interface {
 	Private() []byte
 }
 
-// CryptoProvider defines the interface for cryptographic operations.
+// CryptoProvider defines the interface for cryptographic operations. It
+// covers every primitive Matter §3 names, so a FIPS-validated or hardware
+// backed implementation can replace DefaultCryptoProvider wholesale; the
+// session and commissioning layers only reach crypto through it.
 type CryptoProvider interface {
 	// Encrypt seals plaintext with AES-128-CCM (Matter §5.3); the
 	// authentication tag is appended to the returned ciphertext. Returns
@@ -44,10 +59,54 @@ type CryptoProvider interface {
 
 	// DeriveKeys derives session keys using HKDF.
 	DeriveKeys(secret []byte, salt []byte, info []byte) ([]byte, error)
+
+	// Hash returns SHA-256(data) (Crypto_Hash, §3.3).
+	Hash(data []byte) []byte
+
+	// HMAC returns HMAC-SHA-256(key, data) (Crypto_HMAC, §3.4).
+	HMAC(key, data []byte) []byte
+
+	// PBKDF2 runs PBKDF2-HMAC-SHA-256 (Crypto_PBKDF, §3.9).
+	PBKDF2(password, salt []byte, iterations, keyLen int) []byte
+
+	// HKDF runs HKDF-SHA-256 producing length bytes (Crypto_KDF, §3.8).
+	HKDF(secret, salt, info []byte, length int) ([]byte, error)
+
+	// GenerateKeyPair returns a fresh P-256 key pair; Public is the 65-byte
+	// uncompressed point and Private the 32-byte scalar (§3.5.1).
+	GenerateKeyPair() (KeyPair, error)
+
+	// Sign returns the 64-byte raw r || s ECDSA-SHA-256 signature over msg
+	// (Crypto_Sign, §3.5.3).
+	Sign(key KeyPair, msg []byte) ([]byte, error)
+
+	// Verify checks a raw r || s signature against an uncompressed P-256
+	// public key, returning ErrInvalidSignature on mismatch (§3.5.3).
+	Verify(publicKey, msg, signature []byte) error
+
+	// ECDH returns the 32-byte X coordinate of the shared point
+	// (Crypto_ECDH, §3.5.2).
+	ECDH(key KeyPair, peerPublicKey []byte) ([]byte, error)
+
+	// Rand is the DRBG (Crypto_DRBG, §3.1) for nonces, randoms and
+	// ephemeral keys.
+	Rand() io.Reader
+
+	// NewSPAKE2PProver and NewSPAKE2PVerifier construct the PASE SPAKE2+
+	// roles (Matter §3.10) on the provider's chosen P-256 implementation,
+	// hashing the transcript and deriving the confirmation keys with the
+	// provider's Hash, HMAC and HKDF.
+	NewSPAKE2PProver(w0, w1, context []byte) (*SPAKE2PProver, error)
+	NewSPAKE2PVerifier(w0, l, context []byte) (*SPAKE2PVerifier, error)
 }
 
-// DefaultCryptoProvider implements CryptoProvider.
-type DefaultCryptoProvider struct{}
+// DefaultCryptoProvider implements CryptoProvider. The zero value uses the
+// constant-time SPAKE2+ backend.
+type DefaultCryptoProvider struct {
+	// SPAKE2P selects the point arithmetic for NewSPAKE2PProver and
+	// NewSPAKE2PVerifier.
+	SPAKE2P SPAKE2PBackend
+}
 
 func newMatterCCM(key, nonce []byte) (cipher.AEAD, error) {
 	if len(nonce) != MatterNonceSize {
@@ -77,7 +136,23 @@ func (p *DefaultCryptoProvider) Decrypt(key []byte, nonce []byte, ciphertext []b
 }
 
 func (p *DefaultCryptoProvider) DeriveKeys(secret []byte, salt []byte, info []byte) ([]byte, error) {
-	return HKDF(secret, salt, info, 16)
+	return p.HKDF(secret, salt, info, 16)
+}
+
+func (p *DefaultCryptoProvider) NewSPAKE2PProver(w0, w1, context []byte) (*SPAKE2PProver, error) {
+	group, err := spakeGroupFor(p.SPAKE2P)
+	if err != nil {
+		return nil, err
+	}
+	return newSPAKE2PProver(group, p, nil, w0, w1, context)
+}
+
+func (p *DefaultCryptoProvider) NewSPAKE2PVerifier(w0, l, context []byte) (*SPAKE2PVerifier, error) {
+	group, err := spakeGroupFor(p.SPAKE2P)
+	if err != nil {
+		return nil, err
+	}
+	return newSPAKE2PVerifier(group, p, nil, w0, l, context)
 }
 
 // HKDF runs the full RFC 5869 Extract-then-Expand pipeline over SHA-256 and
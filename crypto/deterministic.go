@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"io"
+	"math/rand/v2"
+	"sync"
+)
+
+// DeterministicCryptoProvider is a CryptoProvider whose randomness comes
+// from a ChaCha8 stream keyed by SHA-256(seed). Two providers built from
+// the same seed hand out the same randoms, ephemeral SPAKE2+ scalars and
+// generated key pairs, and sign deterministically per RFC 6979, so a
+// recorded handshake can be replayed byte for byte.
+//
+// It is for tests and simulations only: anyone who knows the seed knows
+// every secret the provider produces.
+type DeterministicCryptoProvider struct {
+	DefaultCryptoProvider
+	rng *lockedReader
+}
+
+// NewDeterministicCryptoProvider returns a provider seeded with seed.
+func NewDeterministicCryptoProvider(seed []byte) *DeterministicCryptoProvider {
+	return &DeterministicCryptoProvider{
+		rng: &lockedReader{r: rand.NewChaCha8(sha256.Sum256(seed))},
+	}
+}
+
+func (p *DeterministicCryptoProvider) Rand() io.Reader {
+	return p.rng
+}
+
+func (p *DeterministicCryptoProvider) GenerateKeyPair() (KeyPair, error) {
+	return generateP256KeyPair(p.rng)
+}
+
+// Sign is DefaultCryptoProvider.Sign with the nonce derived from the key
+// and digest (RFC 6979) instead of drawn from system entropy.
+func (p *DeterministicCryptoProvider) Sign(key KeyPair, msg []byte) ([]byte, error) {
+	return signP256(nil, key, msg)
+}
+
+func (p *DeterministicCryptoProvider) NewSPAKE2PProver(w0, w1, context []byte) (*SPAKE2PProver, error) {
+	group, err := spakeGroupFor(p.SPAKE2P)
+	if err != nil {
+		return nil, err
+	}
+	return newSPAKE2PProver(group, p, p.rng, w0, w1, context)
+}
+
+func (p *DeterministicCryptoProvider) NewSPAKE2PVerifier(w0, l, context []byte) (*SPAKE2PVerifier, error) {
+	group, err := spakeGroupFor(p.SPAKE2P)
+	if err != nil {
+		return nil, err
+	}
+	return newSPAKE2PVerifier(group, p, p.rng, w0, l, context)
+}
+
+// lockedReader serialises reads from a ChaCha8 stream, which is not safe
+// for concurrent use on its own.
+type lockedReader struct {
+	mu sync.Mutex
+	r  *rand.ChaCha8
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+var (
+	_ CryptoProvider = (*DefaultCryptoProvider)(nil)
+	_ CryptoProvider = (*DeterministicCryptoProvider)(nil)
+)
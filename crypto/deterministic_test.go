@@ -0,0 +1,135 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestDeterministicProvider_Reproducible(t *testing.T) {
+	draw := func(seed string) ([]byte, KeyPair) {
+		p := NewDeterministicCryptoProvider([]byte(seed))
+		buf := make([]byte, 48)
+		if _, err := io.ReadFull(p.Rand(), buf); err != nil {
+			t.Fatal(err)
+		}
+		kp, err := p.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf, kp
+	}
+	r1, k1 := draw("seed")
+	r2, k2 := draw("seed")
+	r3, _ := draw("other")
+	if !bytes.Equal(r1, r2) || !bytes.Equal(k1.Private(), k2.Private()) {
+		t.Error("same seed produced different output")
+	}
+	if bytes.Equal(r1, r3) {
+		t.Error("different seeds produced the same output")
+	}
+}
+
+// TestDeterministicProvider_SPAKE2P — the ephemeral scalars come from the
+// seeded stream, so the whole SPAKE2+ transcript replays exactly.
+func TestDeterministicProvider_SPAKE2P(t *testing.T) {
+	run := func() (pA, pB []byte) {
+		p := NewDeterministicCryptoProvider([]byte("pase"))
+		prover, verifier := freshProverVerifierWith(t, p, p,
+			20202021, []byte("SPAKE2P Key Salt"), 1000, []byte(testContextString))
+		pA, err := prover.ComputePA()
+		if err != nil {
+			t.Fatalf("ComputePA: %v", err)
+		}
+		pB, err = verifier.ComputePB(pA)
+		if err != nil {
+			t.Fatalf("ComputePB: %v", err)
+		}
+		return pA, pB
+	}
+	pA1, pB1 := run()
+	pA2, pB2 := run()
+	if !bytes.Equal(pA1, pA2) || !bytes.Equal(pB1, pB2) {
+		t.Error("SPAKE2+ transcript differs between runs with the same seed")
+	}
+}
+
+// TestDeterministicProvider_Sign — signatures follow RFC 6979, checked
+// against its A.2.5 P-256 / SHA-256 "sample" vector.
+func TestDeterministicProvider_Sign(t *testing.T) {
+	p := NewDeterministicCryptoProvider(nil)
+	priv, _ := hex.DecodeString("c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721")
+	key, err := NewP256KeyPair(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := p.Sign(key, []byte("sample"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "efd48b2aacb6a8fd1140dd9cd45e81d69d2c877b56aaf991c34d0ea84eaf3716" +
+		"f7cb1c942d657c41d436c7a1b6e29f65f3e900dbb9aff4064dc4ab2f843acda8"
+	if got := hex.EncodeToString(sig); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if err := p.Verify(key.Public(), []byte("sample"), sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+// countingProvider counts the Hash, HMAC and HKDF calls made on it.
+type countingProvider struct {
+	DefaultCryptoProvider
+	hashes, hmacs, hkdfs int
+}
+
+func (p *countingProvider) Hash(data []byte) []byte {
+	p.hashes++
+	return p.DefaultCryptoProvider.Hash(data)
+}
+
+func (p *countingProvider) HMAC(key, data []byte) []byte {
+	p.hmacs++
+	return p.DefaultCryptoProvider.HMAC(key, data)
+}
+
+func (p *countingProvider) HKDF(secret, salt, info []byte, length int) ([]byte, error) {
+	p.hkdfs++
+	return p.DefaultCryptoProvider.HKDF(secret, salt, info, length)
+}
+
+func (p *countingProvider) NewSPAKE2PProver(w0, w1, context []byte) (*SPAKE2PProver, error) {
+	return newSPAKE2PProver(nistecGroup{}, p, nil, w0, w1, context)
+}
+
+func (p *countingProvider) NewSPAKE2PVerifier(w0, l, context []byte) (*SPAKE2PVerifier, error) {
+	return newSPAKE2PVerifier(nistecGroup{}, p, nil, w0, l, context)
+}
+
+// TestProvider_KeyDerivationsRouted — SPAKE2+ key confirmation runs on the
+// provider's primitives.
+func TestProvider_KeyDerivationsRouted(t *testing.T) {
+	pp, vp := &countingProvider{}, &countingProvider{}
+	prover, verifier := freshProverVerifierWith(t, pp, vp,
+		20202021, []byte("SPAKE2P Key Salt"), 1000, []byte(testContextString))
+	pA, err := prover.ComputePA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pB, err := verifier.ComputePB(pA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prover.Finalize(pB); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	for name, p := range map[string]*countingProvider{"prover": pp, "verifier": vp} {
+		if p.hashes != 2 || p.hmacs != 2 || p.hkdfs != 1 {
+			t.Errorf("%s: %d hashes, %d HMACs, %d HKDFs; want 2, 2, 1", name, p.hashes, p.hmacs, p.hkdfs)
+		}
+	}
+}
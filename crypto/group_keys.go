@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// HKDF info strings for fabric and group key derivation (Matter §4.3.2.2,
+// §4.17.2).
+const (
+	CompressedFabricInfo = "CompressedFabric"
+	GroupKeyInfo         = "GroupKey v1.0"
+	GroupKeyHashInfo     = "GroupKeyHash"
+)
+
+// Group key sizes. Epoch keys are distributed by the Group Key Management
+// cluster; the compressed fabric ID salts every operational group key so
+// the same epoch key yields unrelated keys on different fabrics.
+const (
+	EpochKeySize            = 16
+	OperationalGroupKeySize = 16
+	CompressedFabricIDSize  = 8
+)
+
+// CompressedFabricID derives the 64-bit compressed fabric identifier from
+// the fabric's root public key (uncompressed, 65 bytes) and fabric ID:
+// HKDF-SHA-256 over the key without its 0x04 prefix, salted with the
+// big-endian fabric ID, info = "CompressedFabric" (Matter §4.3.2.2).
+func CompressedFabricID(rootPublicKey []byte, fabricID uint64) ([]byte, error) {
+	return CompressedFabricIDWith(&DefaultCryptoProvider{}, rootPublicKey, fabricID)
+}
+
+// CompressedFabricIDWith is CompressedFabricID with the HKDF step run on p.
+func CompressedFabricIDWith(p CryptoProvider, rootPublicKey []byte, fabricID uint64) ([]byte, error) {
+	if len(rootPublicKey) != P256PublicKeySize || rootPublicKey[0] != 0x04 {
+		return nil, fmt.Errorf("crypto: root public key must be %d-byte uncompressed P-256", P256PublicKeySize)
+	}
+	var salt [8]byte
+	binary.BigEndian.PutUint64(salt[:], fabricID)
+	return p.HKDF(rootPublicKey[1:], salt[:], []byte(CompressedFabricInfo), CompressedFabricIDSize)
+}
+
+// DeriveOperationalGroupKey expands an epoch key into the operational
+// group key that encrypts group messages on one fabric: HKDF-SHA-256 with
+// salt = compressed fabric ID, info = "GroupKey v1.0" (Matter §4.17.2).
+func DeriveOperationalGroupKey(epochKey, compressedFabricID []byte) ([]byte, error) {
+	return DeriveOperationalGroupKeyWith(&DefaultCryptoProvider{}, epochKey, compressedFabricID)
+}
+
+// DeriveOperationalGroupKeyWith is DeriveOperationalGroupKey with the HKDF
+// step run on p.
+func DeriveOperationalGroupKeyWith(p CryptoProvider, epochKey, compressedFabricID []byte) ([]byte, error) {
+	if len(epochKey) != EpochKeySize {
+		return nil, fmt.Errorf("crypto: epoch key must be %d bytes", EpochKeySize)
+	}
+	if len(compressedFabricID) != CompressedFabricIDSize {
+		return nil, fmt.Errorf("crypto: compressed fabric id must be %d bytes", CompressedFabricIDSize)
+	}
+	return p.HKDF(epochKey, compressedFabricID, []byte(GroupKeyInfo), OperationalGroupKeySize)
+}
+
+// DeriveGroupSessionID hashes an operational group key into the 16-bit
+// session ID carried by group messages: the big-endian value of a 2-byte
+// HKDF-SHA-256 expansion with empty salt and info = "GroupKeyHash"
+// (Matter §4.17.3.6). Distinct keys may collide, so receivers must try
+// every key with a matching ID.
+func DeriveGroupSessionID(operationalGroupKey []byte) (uint16, error) {
+	return DeriveGroupSessionIDWith(&DefaultCryptoProvider{}, operationalGroupKey)
+}
+
+// DeriveGroupSessionIDWith is DeriveGroupSessionID with the HKDF step run
+// on p.
+func DeriveGroupSessionIDWith(p CryptoProvider, operationalGroupKey []byte) (uint16, error) {
+	if len(operationalGroupKey) != OperationalGroupKeySize {
+		return 0, errors.New("crypto: operational group key must be 16 bytes")
+	}
+	out, err := p.HKDF(operationalGroupKey, nil, []byte(GroupKeyHashInfo), 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(out), nil
+}
@@ -0,0 +1,63 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Example values from Matter §4.3.2.2 (compressed fabric ID) and §4.17.2
+// (operational group key and group session ID).
+const (
+	specRootPublicKey      = "044a9f42b1ca4840d37292bbc7f6a7e11e22200c976fc900dbc98a7a383a641cb8254a2e56d4e295a847943b4e3897c4a773e930277b4d9fbede8a052686bfacfa"
+	specFabricID           = 0x2906C908D115D362
+	specCompressedFabricID = "87e1b004e235a130"
+	specEpochKey           = "235bf7e62823d358dca4ba50b1535f4b"
+	specOperationalKey     = "a6f5306baf6d050af23ba4bd6b9dd960"
+	specGroupSessionID     = 0xB9F7
+)
+
+func TestCompressedFabricID_SpecVector(t *testing.T) {
+	pub, _ := hex.DecodeString(specRootPublicKey)
+	got, err := CompressedFabricID(pub, specFabricID)
+	if err != nil {
+		t.Fatalf("CompressedFabricID: %v", err)
+	}
+	if hex.EncodeToString(got) != specCompressedFabricID {
+		t.Errorf("compressed fabric id = %x, want %s", got, specCompressedFabricID)
+	}
+	if _, err := CompressedFabricID(pub[1:], specFabricID); err == nil {
+		t.Error("expected error for key without 0x04 prefix")
+	}
+}
+
+func TestGroupKeyDerivation_SpecVector(t *testing.T) {
+	epoch, _ := hex.DecodeString(specEpochKey)
+	cfid, _ := hex.DecodeString(specCompressedFabricID)
+	key, err := DeriveOperationalGroupKey(epoch, cfid)
+	if err != nil {
+		t.Fatalf("DeriveOperationalGroupKey: %v", err)
+	}
+	if hex.EncodeToString(key) != specOperationalKey {
+		t.Errorf("operational key = %x, want %s", key, specOperationalKey)
+	}
+	id, err := DeriveGroupSessionID(key)
+	if err != nil {
+		t.Fatalf("DeriveGroupSessionID: %v", err)
+	}
+	if id != specGroupSessionID {
+		t.Errorf("group session id = 0x%04X, want 0x%04X", id, specGroupSessionID)
+	}
+}
+
+func TestGroupKeyDerivation_RejectsBadSizes(t *testing.T) {
+	cfid, _ := hex.DecodeString(specCompressedFabricID)
+	if _, err := DeriveOperationalGroupKey(make([]byte, 15), cfid); err == nil {
+		t.Error("expected error for short epoch key")
+	}
+	if _, err := DeriveOperationalGroupKey(make([]byte, 16), cfid[:7]); err == nil {
+		t.Error("expected error for short compressed fabric id")
+	}
+	if _, err := DeriveGroupSessionID(make([]byte, 32)); err == nil {
+		t.Error("expected error for 32-byte group key")
+	}
+}
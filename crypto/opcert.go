@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Matter DN attribute types carried in operational certificate subjects
+// and issuers (Matter §6.5.6). Their values are UTF8Strings of upper-case
+// hex digits: 16 for IDs, 8 for CASE Authenticated Tags.
+var (
+	OIDMatterNodeID            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 1, 1}
+	OIDMatterFirmwareSigningID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 1, 2}
+	OIDMatterICACID            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 1, 3}
+	OIDMatterRCACID            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 1, 4}
+	OIDMatterFabricID          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 1, 5}
+	OIDMatterNOCCAT            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 1, 6}
+)
+
+// Operational node IDs, the range a NOC's matter-node-id must fall in
+// (Matter §2.5.5).
+const (
+	MinOperationalNodeID uint64 = 0x0000_0000_0000_0001
+	MaxOperationalNodeID uint64 = 0xFFFF_FFEF_FFFF_FFFF
+)
+
+// ErrInvalidCertificate is returned for an operational certificate that
+// does not parse, lacks a Matter attribute its role requires, or does
+// not chain to its issuer.
+var ErrInvalidCertificate = errors.New("crypto: invalid operational certificate")
+
+// OperationalCertificate is a parsed NOC, ICAC or RCAC (Matter §6.5):
+// an X.509 v3 certificate on P-256 with the Matter attributes of its
+// subject decoded. Certificate holds the X.509 form even when the
+// certificate was given in the Matter TLV encoding.
+type OperationalCertificate struct {
+	Certificate *x509.Certificate
+	PublicKey   []byte // uncompressed P-256 point, as Verify takes it
+	NodeID      uint64 // matter-node-id; 0 for CA certificates
+	FabricID    uint64 // matter-fabric-id; 0 if the subject carries none
+	CATs        []uint32
+}
+
+// ParseOperationalCertificate parses a certificate, in the Matter TLV
+// encoding or as X.509 DER, and its subject's Matter attributes. It
+// checks the encoding only; VerifyOperationalChain checks roles and
+// signatures.
+func ParseOperationalCertificate(cert []byte) (*OperationalCertificate, error) {
+	der, err := x509Certificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	pub, ok := c.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: public key is not P-256", ErrInvalidCertificate)
+	}
+	if c.SignatureAlgorithm != x509.ECDSAWithSHA256 {
+		return nil, fmt.Errorf("%w: signature algorithm %v", ErrInvalidCertificate, c.SignatureAlgorithm)
+	}
+	oc := &OperationalCertificate{Certificate: c}
+	if oc.PublicKey, err = pub.Bytes(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	var seenNode, seenFabric bool
+	for _, attr := range c.Subject.Names {
+		switch {
+		case attr.Type.Equal(OIDMatterNodeID):
+			if seenNode {
+				return nil, fmt.Errorf("%w: duplicate matter-node-id", ErrInvalidCertificate)
+			}
+			seenNode = true
+			oc.NodeID, err = matterHexAttribute(attr.Value, 16)
+		case attr.Type.Equal(OIDMatterFabricID):
+			if seenFabric {
+				return nil, fmt.Errorf("%w: duplicate matter-fabric-id", ErrInvalidCertificate)
+			}
+			seenFabric = true
+			oc.FabricID, err = matterHexAttribute(attr.Value, 16)
+		case attr.Type.Equal(OIDMatterNOCCAT):
+			var cat uint64
+			cat, err = matterHexAttribute(attr.Value, 8)
+			oc.CATs = append(oc.CATs, uint32(cat))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v: %v", ErrInvalidCertificate, attr.Type, err)
+		}
+	}
+	return oc, nil
+}
+
+// matterHexAttribute decodes a DN attribute value of exactly digits hex
+// characters.
+func matterHexAttribute(v any, digits int) (uint64, error) {
+	s, ok := v.(string)
+	if !ok || len(s) != digits {
+		return 0, fmt.Errorf("want %d hex digits, got %v", digits, v)
+	}
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// hasAttribute reports whether the certificate's subject carries oid.
+func (oc *OperationalCertificate) hasAttribute(oid asn1.ObjectIdentifier) bool {
+	for _, attr := range oc.Certificate.Subject.Names {
+		if attr.Type.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyOperationalChain checks that noc chains to the trusted root rcac,
+// through icac if it is not empty, and returns the parsed NOC. Each
+// certificate may be in either encoding, and must play its role: the
+// RCAC a self-signed CA with a matter-rcac-id, the ICAC a CA with a
+// matter-icac-id, and the NOC an end entity with an operational node ID
+// and a fabric ID that the CAs, where they name one, agree with.
+//
+// Validity periods are not checked: a device may not know the time, and
+// callers that do can check the returned certificate themselves.
+func VerifyOperationalChain(noc, icac, rcac []byte) (*OperationalCertificate, error) {
+	root, err := ParseOperationalCertificate(rcac)
+	if err != nil {
+		return nil, fmt.Errorf("RCAC: %w", err)
+	}
+	if err := checkCA(root, OIDMatterRCACID, root); err != nil {
+		return nil, fmt.Errorf("RCAC: %w", err)
+	}
+	issuer := root
+	if len(icac) > 0 {
+		inter, err := ParseOperationalCertificate(icac)
+		if err != nil {
+			return nil, fmt.Errorf("ICAC: %w", err)
+		}
+		if err := checkCA(inter, OIDMatterICACID, root); err != nil {
+			return nil, fmt.Errorf("ICAC: %w", err)
+		}
+		issuer = inter
+	}
+
+	leaf, err := ParseOperationalCertificate(noc)
+	if err != nil {
+		return nil, fmt.Errorf("NOC: %w", err)
+	}
+	switch {
+	case leaf.Certificate.IsCA:
+		return nil, fmt.Errorf("NOC: %w: is a CA", ErrInvalidCertificate)
+	case leaf.NodeID < MinOperationalNodeID || leaf.NodeID > MaxOperationalNodeID:
+		return nil, fmt.Errorf("NOC: %w: node ID %#016x is not operational", ErrInvalidCertificate, leaf.NodeID)
+	case leaf.FabricID == 0:
+		return nil, fmt.Errorf("NOC: %w: no fabric ID", ErrInvalidCertificate)
+	}
+	if err := leaf.Certificate.CheckSignatureFrom(issuer.Certificate); err != nil {
+		return nil, fmt.Errorf("NOC: %w: %v", ErrInvalidCertificate, err)
+	}
+	for _, ca := range []*OperationalCertificate{root, issuer} {
+		if ca.FabricID != 0 && ca.FabricID != leaf.FabricID {
+			return nil, fmt.Errorf("%w: NOC fabric ID %#x, issuer's %#x", ErrInvalidCertificate, leaf.FabricID, ca.FabricID)
+		}
+	}
+	return leaf, nil
+}
+
+// checkCA checks that ca is a CA certificate carrying the role attribute
+// and signed by parent.
+func checkCA(ca *OperationalCertificate, role asn1.ObjectIdentifier, parent *OperationalCertificate) error {
+	if !ca.Certificate.BasicConstraintsValid || !ca.Certificate.IsCA {
+		return fmt.Errorf("%w: not a CA", ErrInvalidCertificate)
+	}
+	if !ca.hasAttribute(role) {
+		return fmt.Errorf("%w: no %v attribute", ErrInvalidCertificate, role)
+	}
+	if err := ca.Certificate.CheckSignatureFrom(parent.Certificate); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert is an issued certificate and its private key.
+type testCert struct {
+	der  []byte
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueTestCert issues a certificate for a fresh key with the given
+// Matter subject attributes, signed by parent (self-signed if nil).
+func issueTestCert(t *testing.T, parent *testCert, ca bool, attrs ...pkix.AttributeTypeAndValue) *testCert {
+	t.Helper()
+	return issueTestCertWith(t, parent, func(c *x509.Certificate) {
+		c.Subject = pkix.Name{ExtraNames: attrs}
+		c.IsCA = ca
+		if ca {
+			c.KeyUsage = x509.KeyUsageCertSign
+		}
+	})
+}
+
+// issueTestCertWith issues a certificate from a template edit has
+// adjusted, signed by parent (self-signed if nil).
+func issueTestCertWith(t *testing.T, parent *testCert, edit func(*x509.Certificate)) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Unix(1_700_000_000, 0),
+		NotAfter:              time.Unix(1_900_000_000, 0),
+		BasicConstraintsValid: true,
+	}
+	edit(tmpl)
+	signer, issuer := key, tmpl
+	if parent != nil {
+		signer, issuer = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{der: der, cert: cert, key: key}
+}
+
+// matterAttr is a Matter DN attribute, encoded as Matter encodes it: a
+// UTF8String of 16 hex digits.
+func matterAttr(oid []int, v uint64) pkix.AttributeTypeAndValue {
+	return utf8Attr(oid, fmt.Sprintf("%016X", v))
+}
+
+func utf8Attr(oid []int, s string) pkix.AttributeTypeAndValue {
+	return pkix.AttributeTypeAndValue{Type: oid, Value: asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(s)}}
+}
+
+func TestVerifyOperationalChain(t *testing.T) {
+	const fabricID, nodeID = 0xFAB000000000001D, 0x0000000000ABCDEF
+	root := issueTestCert(t, nil, true, matterAttr(OIDMatterRCACID, 1), matterAttr(OIDMatterFabricID, fabricID))
+	icac := issueTestCert(t, root, true, matterAttr(OIDMatterICACID, 2))
+	noc := issueTestCert(t, icac, false,
+		matterAttr(OIDMatterNodeID, nodeID), matterAttr(OIDMatterFabricID, fabricID),
+		utf8Attr(OIDMatterNOCCAT, "ABCD0001"))
+
+	got, err := VerifyOperationalChain(noc.der, icac.der, root.der)
+	if err != nil {
+		t.Fatalf("VerifyOperationalChain: %v", err)
+	}
+	if got.NodeID != nodeID || got.FabricID != fabricID || len(got.CATs) != 1 || got.CATs[0] != 0xABCD0001 {
+		t.Errorf("parsed NOC: node %#x fabric %#x CATs %x", got.NodeID, got.FabricID, got.CATs)
+	}
+	if len(got.PublicKey) != P256PublicKeySize || got.PublicKey[0] != 0x04 {
+		t.Errorf("public key %x", got.PublicKey)
+	}
+
+	// Without an ICAC the root signs the NOC directly.
+	direct := issueTestCert(t, root, false, matterAttr(OIDMatterNodeID, nodeID), matterAttr(OIDMatterFabricID, fabricID))
+	if _, err := VerifyOperationalChain(direct.der, nil, root.der); err != nil {
+		t.Errorf("NOC signed by root: %v", err)
+	}
+}
+
+func TestVerifyOperationalChain_Rejects(t *testing.T) {
+	const fabricID, nodeID = 0x1D, 0xABCDEF
+	root := issueTestCert(t, nil, true, matterAttr(OIDMatterRCACID, 1), matterAttr(OIDMatterFabricID, fabricID))
+	otherRoot := issueTestCert(t, nil, true, matterAttr(OIDMatterRCACID, 1))
+	icac := issueTestCert(t, root, true, matterAttr(OIDMatterICACID, 2))
+	noc := func(parent *testCert, node, fabric uint64) []byte {
+		return issueTestCert(t, parent, false, matterAttr(OIDMatterNodeID, node), matterAttr(OIDMatterFabricID, fabric)).der
+	}
+
+	cases := []struct {
+		name            string
+		noc, icac, rcac []byte
+	}{
+		{"foreign root", noc(root, nodeID, fabricID), nil, otherRoot.der},
+		{"ICAC missing", noc(icac, nodeID, fabricID), nil, root.der},
+		{"ICAC of another root", noc(icac, nodeID, fabricID), icac.der, otherRoot.der},
+		{"group node ID", noc(root, 0xFFFFFFFFFFFF0001, fabricID), nil, root.der},
+		{"fabric mismatch", noc(root, nodeID, fabricID+1), nil, root.der},
+		{"no fabric ID", issueTestCert(t, root, false, matterAttr(OIDMatterNodeID, nodeID)).der, nil, root.der},
+		{"root without rcac-id", noc(root, nodeID, fabricID), nil, icac.der},
+		{"NOC as root", noc(root, nodeID, fabricID), nil, noc(root, nodeID, fabricID)},
+		{"garbage", []byte("NOC"), nil, root.der},
+	}
+	for _, tc := range cases {
+		if _, err := VerifyOperationalChain(tc.noc, tc.icac, tc.rcac); !errors.Is(err, ErrInvalidCertificate) {
+			t.Errorf("%s: %v, want ErrInvalidCertificate", tc.name, err)
+		}
+	}
+
+	bad := issueTestCert(t, root, false, pkix.AttributeTypeAndValue{Type: OIDMatterNodeID, Value: "ABC"})
+	if _, err := ParseOperationalCertificate(bad.der); !errors.Is(err, ErrInvalidCertificate) {
+		t.Errorf("short node ID: %v", err)
+	}
+}
+
+// TestMatterTLVCertificate — a chain converts to the Matter TLV encoding
+// and back byte for byte, verifies in TLV form, and keeps its attributes.
+func TestMatterTLVCertificate(t *testing.T) {
+	const fabricID, nodeID = 0xFAB000000000001D, 0x0000000000ABCDEF
+	root := issueTestCert(t, nil, true, matterAttr(OIDMatterRCACID, 1), matterAttr(OIDMatterFabricID, fabricID))
+	icac := issueTestCert(t, root, true, matterAttr(OIDMatterICACID, 2))
+	noc := issueTestCert(t, icac, false,
+		matterAttr(OIDMatterNodeID, nodeID), matterAttr(OIDMatterFabricID, fabricID),
+		utf8Attr(OIDMatterNOCCAT, "ABCD0001"), pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "Test NOC"})
+
+	var tlvs [3][]byte
+	for i, c := range []*testCert{noc, icac, root} {
+		enc, err := X509ToMatterTLV(c.der)
+		if err != nil {
+			t.Fatalf("X509ToMatterTLV: %v", err)
+		}
+		if !IsMatterTLVCertificate(enc) || IsMatterTLVCertificate(c.der) {
+			t.Error("IsMatterTLVCertificate misreads the encoding")
+		}
+		if len(enc) >= len(c.der) {
+			t.Errorf("TLV form is %d bytes, DER %d", len(enc), len(c.der))
+		}
+		der, err := MatterTLVToX509(enc)
+		if err != nil {
+			t.Fatalf("MatterTLVToX509: %v", err)
+		}
+		if !bytes.Equal(der, c.der) {
+			t.Errorf("round trip changed the certificate:\n%x\n%x", der, c.der)
+		}
+		if same, err := MatterTLVCertificate(enc); err != nil || !bytes.Equal(same, enc) {
+			t.Errorf("MatterTLVCertificate of TLV: %v", err)
+		}
+		tlvs[i] = enc
+	}
+
+	got, err := VerifyOperationalChain(tlvs[0], tlvs[1], tlvs[2])
+	if err != nil {
+		t.Fatalf("VerifyOperationalChain over TLV: %v", err)
+	}
+	if got.NodeID != nodeID || got.FabricID != fabricID || len(got.CATs) != 1 || got.CATs[0] != 0xABCD0001 {
+		t.Errorf("parsed NOC: node %#x fabric %#x CATs %x", got.NodeID, got.FabricID, got.CATs)
+	}
+	if got.Certificate.Subject.CommonName != "Test NOC" {
+		t.Errorf("common name %q", got.Certificate.Subject.CommonName)
+	}
+	// The encodings mix.
+	if _, err := VerifyOperationalChain(tlvs[0], icac.der, tlvs[2]); err != nil {
+		t.Errorf("mixed encodings: %v", err)
+	}
+
+	// A flipped signature bit in the TLV form breaks the rebuilt chain.
+	bad := bytes.Clone(tlvs[0])
+	bad[len(bad)-2] ^= 1
+	if _, err := VerifyOperationalChain(bad, tlvs[1], tlvs[2]); !errors.Is(err, ErrInvalidCertificate) {
+		t.Errorf("tampered TLV NOC: %v", err)
+	}
+}
+
+// TestMatterTLVCertificate_NoExpiry — a notAfter of 99991231235959Z is
+// not-after 0 in TLV.
+func TestMatterTLVCertificate_NoExpiry(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(0x7F),
+		Subject:               pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{matterAttr(OIDMatterRCACID, 1)}},
+		NotBefore:             time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := X509ToMatterTLV(der)
+	if err != nil {
+		t.Fatalf("X509ToMatterTLV: %v", err)
+	}
+	mc, err := decodeMatterTLV(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc.notBefore != 0 || mc.notAfter != 0 {
+		t.Errorf("validity %d..%d, want 0..0", mc.notBefore, mc.notAfter)
+	}
+	if back, err := MatterTLVToX509(enc); err != nil || !bytes.Equal(back, der) {
+		t.Errorf("round trip: %v", err)
+	}
+}
+
+// TestX509ToMatterTLV_Rejects — certificates the TLV form cannot carry
+// exactly are refused rather than converted with a broken signature.
+func TestX509ToMatterTLV_Rejects(t *testing.T) {
+	root := issueTestCert(t, nil, true, matterAttr(OIDMatterRCACID, 1))
+	printable := issueTestCert(t, root, false, pkix.AttributeTypeAndValue{Type: OIDMatterNodeID, Value: "0000000000000001"})
+	lower := issueTestCert(t, root, false, utf8Attr(OIDMatterNodeID, "00000000000000ab"))
+	eku := issueTestCertWith(t, root, func(c *x509.Certificate) { c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth} })
+	for name, der := range map[string][]byte{
+		"node ID as PrintableString": printable.der,
+		"lower-case node ID":         lower.der,
+		"non-critical EKU":           eku.der,
+		"garbage":                    []byte("NOC"),
+	} {
+		if _, err := X509ToMatterTLV(der); !errors.Is(err, ErrInvalidCertificate) {
+			t.Errorf("%s: %v, want ErrInvalidCertificate", name, err)
+		}
+	}
+	if _, err := MatterTLVToX509([]byte{0x15, 0x18}); !errors.Is(err, ErrInvalidCertificate) {
+		t.Errorf("empty structure: %v", err)
+	}
+}
@@ -0,0 +1,921 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+
+	"go-matter/tlv"
+)
+
+// Context tags of the Matter TLV certificate structure (Matter §6.5.2),
+// which carries its fields in this order.
+const (
+	certTagSerialNumber = 1
+	certTagSigAlgo      = 2
+	certTagIssuer       = 3
+	certTagNotBefore    = 4
+	certTagNotAfter     = 5
+	certTagSubject      = 6
+	certTagPubKeyAlgo   = 7
+	certTagECCurveID    = 8
+	certTagECPubKey     = 9
+	certTagExtensions   = 10
+	certTagSignature    = 11
+)
+
+// Context tags of the certificate extensions list.
+const (
+	extTagBasicConstraints = 1
+	extTagKeyUsage         = 2
+	extTagExtKeyUsage      = 3
+	extTagSubjectKeyID     = 4
+	extTagAuthorityKeyID   = 5
+	extTagFutureExtension  = 6
+)
+
+// The one value each algorithm field may take: ecdsa-with-SHA256,
+// id-ecPublicKey and prime256v1.
+const (
+	certSigAlgoECDSAWithSHA256 = 1
+	certPubKeyAlgoEC           = 1
+	certCurvePrime256v1        = 1
+)
+
+// dnPrintableString marks a standard DN attribute whose X.509 value is a
+// PrintableString rather than a UTF8String.
+const dnPrintableString = 0x80
+
+var (
+	oidECDSAWithSHA256     = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECPublicKey         = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidPrime256v1          = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidExtBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtExtKeyUsage      = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtSubjectKeyID     = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidExtAuthorityKeyID   = asn1.ObjectIdentifier{2, 5, 29, 35}
+
+	// dnStandardOIDs are the attribute types of DN tags 1 to 16.
+	dnStandardOIDs = [...]asn1.ObjectIdentifier{
+		1:  {2, 5, 4, 3},                       // common-name
+		2:  {2, 5, 4, 4},                       // surname
+		3:  {2, 5, 4, 5},                       // serial-num
+		4:  {2, 5, 4, 6},                       // country-name
+		5:  {2, 5, 4, 7},                       // locality-name
+		6:  {2, 5, 4, 8},                       // state-or-province-name
+		7:  {2, 5, 4, 10},                      // org-name
+		8:  {2, 5, 4, 11},                      // org-unit-name
+		9:  {2, 5, 4, 12},                      // title
+		10: {2, 5, 4, 41},                      // name
+		11: {2, 5, 4, 42},                      // given-name
+		12: {2, 5, 4, 43},                      // initials
+		13: {2, 5, 4, 44},                      // gen-qualifier
+		14: {2, 5, 4, 46},                      // dn-qualifier
+		15: {2, 5, 4, 65},                      // pseudonym
+		16: {0, 9, 2342, 19200300, 100, 1, 25}, // domain-component
+	}
+
+	// dnMatterAttributes are DN tags 17 to 22, the Matter attributes,
+	// carried as integers in TLV and as hex UTF8Strings in X.509.
+	dnMatterAttributes = []struct {
+		tag    uint64
+		oid    asn1.ObjectIdentifier
+		digits int
+	}{
+		{17, OIDMatterNodeID, 16},
+		{18, OIDMatterFirmwareSigningID, 16},
+		{19, OIDMatterICACID, 16},
+		{20, OIDMatterRCACID, 16},
+		{21, OIDMatterFabricID, 16},
+		{22, OIDMatterNOCCAT, 8},
+	}
+
+	// extKeyPurposes are the key purposes of the extended key usage
+	// extension, by their TLV value.
+	extKeyPurposes = [...]asn1.ObjectIdentifier{
+		1: {1, 3, 6, 1, 5, 5, 7, 3, 1}, // serverAuth
+		2: {1, 3, 6, 1, 5, 5, 7, 3, 2}, // clientAuth
+		3: {1, 3, 6, 1, 5, 5, 7, 3, 3}, // codeSigning
+		4: {1, 3, 6, 1, 5, 5, 7, 3, 4}, // emailProtection
+		5: {1, 3, 6, 1, 5, 5, 7, 3, 8}, // timeStamping
+		6: {1, 3, 6, 1, 5, 5, 7, 3, 9}, // OCSPSigning
+	}
+
+	// matterEpoch is time zero of TLV certificate validity fields.
+	matterEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	// noWellDefinedExpiry is the X.509 notAfter of a TLV not-after of 0.
+	noWellDefinedExpiry = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+)
+
+// matterCertificate is an operational certificate's content, which both
+// encodings carry in full.
+type matterCertificate struct {
+	serial              []byte
+	issuer, subject     []dnAttribute
+	notBefore, notAfter uint32
+	publicKey           []byte
+	extensions          []certExtension
+	signature           []byte // raw r || s
+}
+
+// dnAttribute is one attribute of an issuer or subject: its TLV tag and
+// value, text for standard attributes and a number for Matter ones.
+type dnAttribute struct {
+	tag  uint64
+	text string
+	id   uint64
+}
+
+// certExtension is one extension; tag selects which fields apply.
+type certExtension struct {
+	tag      uint64
+	isCA     bool
+	pathLen  int     // basic constraints; -1 if absent
+	usage    uint16  // key usage bits, digitalSignature first
+	purposes []uint8 // extended key usage
+	keyID    []byte  // subject or authority key ID
+	raw      []byte  // future extension: the whole DER Extension
+}
+
+// IsMatterTLVCertificate reports whether cert is in the Matter TLV
+// encoding (an anonymous structure) rather than X.509 DER (a SEQUENCE).
+// It looks at the first byte only.
+func IsMatterTLVCertificate(cert []byte) bool {
+	return len(cert) > 0 && cert[0] == byte(tlv.TagControlAnonymous)|byte(tlv.TypeStructure)
+}
+
+// MatterTLVToX509 rebuilds the X.509 DER certificate that a Matter TLV
+// certificate encodes, whose signature the TLV form carries.
+func MatterTLVToX509(cert []byte) ([]byte, error) {
+	mc, err := decodeMatterTLV(cert)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	der, err := mc.encodeX509()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	return der, nil
+}
+
+// X509ToMatterTLV returns the Matter TLV encoding of a DER certificate.
+// Only certificates that MatterTLVToX509 rebuilds byte for byte can be
+// converted, so the signature stays valid: one with, say, an extension
+// marked critical where Matter does not mark it, fails.
+func X509ToMatterTLV(der []byte) ([]byte, error) {
+	mc, err := decodeX509(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	back, err := mc.encodeX509()
+	if err != nil || !bytes.Equal(back, der) {
+		return nil, fmt.Errorf("%w: not representable in Matter TLV", ErrInvalidCertificate)
+	}
+	return mc.encodeTLV()
+}
+
+// MatterTLVCertificate returns cert, in either encoding, in the Matter
+// TLV encoding certificates travel in.
+func MatterTLVCertificate(cert []byte) ([]byte, error) {
+	if !IsMatterTLVCertificate(cert) {
+		return X509ToMatterTLV(cert)
+	}
+	if _, err := MatterTLVToX509(cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// x509Certificate returns cert, in either encoding, as X.509 DER.
+func x509Certificate(cert []byte) ([]byte, error) {
+	if IsMatterTLVCertificate(cert) {
+		return MatterTLVToX509(cert)
+	}
+	return cert, nil
+}
+
+// decodeMatterTLV reads a TLV certificate, whose fields must all be
+// present and in order.
+func decodeMatterTLV(b []byte) (*matterCertificate, error) {
+	r := tlv.NewReader(bytes.NewReader(b))
+	top, err := r.ReadElement()
+	if err != nil {
+		return nil, err
+	}
+	if top.Type != tlv.TypeStructure || top.Tag.Class != tlv.TagControlAnonymous {
+		return nil, errors.New("not an anonymous TLV structure")
+	}
+	fields, err := r.ReadContainerChildren()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadElement(); err != io.EOF {
+		return nil, errors.New("trailing data after certificate")
+	}
+	if len(fields) != certTagSignature {
+		return nil, fmt.Errorf("%d fields, want %d", len(fields), certTagSignature)
+	}
+	for i, f := range fields {
+		if !isContextTag(f, uint64(i+1)) {
+			return nil, fmt.Errorf("field %d has tag %d", i+1, f.Tag.ID)
+		}
+	}
+	field := func(tag int) tlv.Element { return fields[tag-1] }
+
+	mc := &matterCertificate{}
+	var ok bool
+	var v uint64
+	if mc.serial, ok = tlvBytes(field(certTagSerialNumber)); !ok || len(mc.serial) == 0 || len(mc.serial) > 20 {
+		return nil, errors.New("bad serial number")
+	}
+	if v, ok = tlvUint(field(certTagSigAlgo)); !ok || v != certSigAlgoECDSAWithSHA256 {
+		return nil, errors.New("unsupported signature algorithm")
+	}
+	if mc.issuer, err = decodeTLVName(field(certTagIssuer)); err != nil {
+		return nil, fmt.Errorf("issuer: %w", err)
+	}
+	notBefore, okBefore := tlvUint(field(certTagNotBefore))
+	notAfter, okAfter := tlvUint(field(certTagNotAfter))
+	if !okBefore || !okAfter || notBefore > math.MaxUint32 || notAfter > math.MaxUint32 {
+		return nil, errors.New("bad validity")
+	}
+	mc.notBefore, mc.notAfter = uint32(notBefore), uint32(notAfter)
+	if mc.subject, err = decodeTLVName(field(certTagSubject)); err != nil {
+		return nil, fmt.Errorf("subject: %w", err)
+	}
+	if v, ok = tlvUint(field(certTagPubKeyAlgo)); !ok || v != certPubKeyAlgoEC {
+		return nil, errors.New("unsupported public key algorithm")
+	}
+	if v, ok = tlvUint(field(certTagECCurveID)); !ok || v != certCurvePrime256v1 {
+		return nil, errors.New("unsupported curve")
+	}
+	if mc.publicKey, ok = tlvBytes(field(certTagECPubKey)); !ok || len(mc.publicKey) != P256PublicKeySize {
+		return nil, errors.New("bad public key")
+	}
+	if mc.extensions, err = decodeTLVExtensions(field(certTagExtensions)); err != nil {
+		return nil, fmt.Errorf("extensions: %w", err)
+	}
+	if mc.signature, ok = tlvBytes(field(certTagSignature)); !ok || len(mc.signature) != P256SignatureSize {
+		return nil, errors.New("bad signature")
+	}
+	return mc, nil
+}
+
+func decodeTLVName(list tlv.Element) ([]dnAttribute, error) {
+	if list.Type != tlv.TypeList {
+		return nil, errors.New("not a list")
+	}
+	var name []dnAttribute
+	for _, e := range list.SubElements {
+		if e.Tag.Class != tlv.TagControlContextSpecific {
+			return nil, errors.New("attribute without a context tag")
+		}
+		attr := dnAttribute{tag: e.Tag.ID}
+		var ok bool
+		if dnStandardOID(e.Tag.ID) != nil {
+			attr.text, ok = tlvString(e)
+		} else if m := dnMatterAttribute(e.Tag.ID); m >= 0 {
+			attr.id, ok = tlvUint(e)
+			ok = ok && (dnMatterAttributes[m].digits == 16 || attr.id <= math.MaxUint32)
+		}
+		if !ok {
+			return nil, fmt.Errorf("bad attribute %d", e.Tag.ID)
+		}
+		name = append(name, attr)
+	}
+	return name, nil
+}
+
+func decodeTLVExtensions(list tlv.Element) ([]certExtension, error) {
+	if list.Type != tlv.TypeList {
+		return nil, errors.New("not a list")
+	}
+	var exts []certExtension
+	for _, e := range list.SubElements {
+		if e.Tag.Class != tlv.TagControlContextSpecific {
+			return nil, errors.New("extension without a context tag")
+		}
+		ext := certExtension{tag: e.Tag.ID, pathLen: -1}
+		ok := true
+		switch e.Tag.ID {
+		case extTagBasicConstraints:
+			ok = e.Type == tlv.TypeStructure && len(e.SubElements) >= 1 && len(e.SubElements) <= 2 &&
+				isContextTag(e.SubElements[0], 1)
+			if ok {
+				ext.isCA, ok = tlvBool(e.SubElements[0])
+			}
+			if ok && len(e.SubElements) == 2 {
+				var n uint64
+				n, ok = tlvUint(e.SubElements[1])
+				ok = ok && isContextTag(e.SubElements[1], 2) && n <= math.MaxInt8
+				ext.pathLen = int(n)
+			}
+		case extTagKeyUsage:
+			var n uint64
+			n, ok = tlvUint(e)
+			ok = ok && n != 0 && n <= 0x1FF
+			ext.usage = uint16(n)
+		case extTagExtKeyUsage:
+			ok = e.Type == tlv.TypeArray && len(e.SubElements) > 0
+			for _, p := range e.SubElements {
+				n, isUint := tlvUint(p)
+				if !isUint || n == 0 || n >= uint64(len(extKeyPurposes)) {
+					ok = false
+					break
+				}
+				ext.purposes = append(ext.purposes, uint8(n))
+			}
+		case extTagSubjectKeyID, extTagAuthorityKeyID:
+			ext.keyID, ok = tlvBytes(e)
+			ok = ok && len(ext.keyID) > 0
+		case extTagFutureExtension:
+			ext.raw, ok = tlvBytes(e)
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("bad extension %d", e.Tag.ID)
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// encodeTLV writes mc in the Matter TLV encoding.
+func (mc *matterCertificate) encodeTLV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := &certWriter{w: tlv.NewWriter(&buf)}
+	w.start(tlv.Tag{Class: tlv.TagControlAnonymous}, tlv.TypeStructure)
+	w.bytes(certTagSerialNumber, mc.serial)
+	w.uint(certTagSigAlgo, certSigAlgoECDSAWithSHA256)
+	w.name(certTagIssuer, mc.issuer)
+	w.uint(certTagNotBefore, uint64(mc.notBefore))
+	w.uint(certTagNotAfter, uint64(mc.notAfter))
+	w.name(certTagSubject, mc.subject)
+	w.uint(certTagPubKeyAlgo, certPubKeyAlgoEC)
+	w.uint(certTagECCurveID, certCurvePrime256v1)
+	w.bytes(certTagECPubKey, mc.publicKey)
+	w.start(contextTag(certTagExtensions), tlv.TypeList)
+	for _, ext := range mc.extensions {
+		switch ext.tag {
+		case extTagBasicConstraints:
+			w.start(contextTag(ext.tag), tlv.TypeStructure)
+			w.boolean(1, ext.isCA)
+			if ext.pathLen >= 0 {
+				w.uint(2, uint64(ext.pathLen))
+			}
+			w.end()
+		case extTagKeyUsage:
+			w.uint(ext.tag, uint64(ext.usage))
+		case extTagExtKeyUsage:
+			w.start(contextTag(ext.tag), tlv.TypeArray)
+			for _, p := range ext.purposes {
+				w.put(w.w.PutUnsignedInt(tlv.Tag{Class: tlv.TagControlAnonymous}, uint64(p)))
+			}
+			w.end()
+		case extTagSubjectKeyID, extTagAuthorityKeyID:
+			w.bytes(ext.tag, ext.keyID)
+		case extTagFutureExtension:
+			w.bytes(ext.tag, ext.raw)
+		}
+	}
+	w.end()
+	w.bytes(certTagSignature, mc.signature)
+	w.end()
+	if w.err != nil {
+		return nil, fmt.Errorf("%w: encode TLV: %v", ErrInvalidCertificate, w.err)
+	}
+	return buf.Bytes(), nil
+}
+
+// certWriter writes TLV certificate fields, keeping the first error.
+type certWriter struct {
+	w   *tlv.Writer
+	err error
+}
+
+func (w *certWriter) put(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *certWriter) start(tag tlv.Tag, typ tlv.ElementType) { w.put(w.w.StartContainer(tag, typ)) }
+func (w *certWriter) end()                                   { w.put(w.w.EndContainer()) }
+func (w *certWriter) uint(tag, v uint64)                     { w.put(w.w.PutUnsignedInt(contextTag(tag), v)) }
+func (w *certWriter) bytes(tag uint64, b []byte)             { w.put(w.w.PutBytes(contextTag(tag), b)) }
+func (w *certWriter) boolean(tag uint64, v bool)             { w.put(w.w.PutBoolean(contextTag(tag), v)) }
+
+func (w *certWriter) name(tag uint64, name []dnAttribute) {
+	w.start(contextTag(tag), tlv.TypeList)
+	for _, a := range name {
+		if dnStandardOID(a.tag) != nil {
+			w.put(w.w.PutString(contextTag(a.tag), a.text))
+		} else {
+			w.uint(a.tag, a.id)
+		}
+	}
+	w.end()
+}
+
+// encodeX509 builds the DER certificate mc describes.
+func (mc *matterCertificate) encodeX509() ([]byte, error) {
+	if len(mc.signature) != P256SignatureSize {
+		return nil, errors.New("bad signature")
+	}
+	var b cryptobyte.Builder
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		mc.addTBSCertificate(b)
+		addAlgorithm(b, oidECDSAWithSHA256)
+		b.AddASN1(casn1.BIT_STRING, func(b *cryptobyte.Builder) {
+			b.AddUint8(0)
+			b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1BigInt(new(big.Int).SetBytes(mc.signature[:32]))
+				b.AddASN1BigInt(new(big.Int).SetBytes(mc.signature[32:]))
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+func (mc *matterCertificate) addTBSCertificate(b *cryptobyte.Builder) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(casn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1Int64(2) // v3
+		})
+		b.AddASN1(casn1.INTEGER, func(b *cryptobyte.Builder) { b.AddBytes(mc.serial) })
+		addAlgorithm(b, oidECDSAWithSHA256)
+		addName(b, mc.issuer)
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addTime(b, matterEpoch.Add(time.Duration(mc.notBefore)*time.Second))
+			if mc.notAfter == 0 {
+				b.AddASN1GeneralizedTime(noWellDefinedExpiry)
+			} else {
+				addTime(b, matterEpoch.Add(time.Duration(mc.notAfter)*time.Second))
+			}
+		})
+		addName(b, mc.subject)
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1ObjectIdentifier(oidECPublicKey)
+				b.AddASN1ObjectIdentifier(oidPrime256v1)
+			})
+			b.AddASN1BitString(mc.publicKey)
+		})
+		if len(mc.extensions) == 0 {
+			return
+		}
+		b.AddASN1(casn1.Tag(3).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				for _, ext := range mc.extensions {
+					addExtension(b, ext)
+				}
+			})
+		})
+	})
+}
+
+func addAlgorithm(b *cryptobyte.Builder, oid asn1.ObjectIdentifier) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) { b.AddASN1ObjectIdentifier(oid) })
+}
+
+// addTime adds t as a UTCTime up to 2049 and a GeneralizedTime after, as
+// RFC 5280 requires.
+func addTime(b *cryptobyte.Builder, t time.Time) {
+	if t.Year() < 2050 {
+		b.AddASN1UTCTime(t)
+	} else {
+		b.AddASN1GeneralizedTime(t)
+	}
+}
+
+// addName adds a Name with one attribute per RDN, in order.
+func addName(b *cryptobyte.Builder, name []dnAttribute) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for _, a := range name {
+			b.AddASN1(casn1.SET, func(b *cryptobyte.Builder) {
+				b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					if oid := dnStandardOID(a.tag); oid != nil {
+						b.AddASN1ObjectIdentifier(oid)
+						addString(b, dnStringTag(a.tag), a.text)
+						return
+					}
+					m := dnMatterAttributes[dnMatterAttribute(a.tag)]
+					b.AddASN1ObjectIdentifier(m.oid)
+					addString(b, casn1.UTF8String, fmt.Sprintf("%0*X", m.digits, a.id))
+				})
+			})
+		}
+	})
+}
+
+func addString(b *cryptobyte.Builder, tag casn1.Tag, s string) {
+	b.AddASN1(tag, func(b *cryptobyte.Builder) { b.AddBytes([]byte(s)) })
+}
+
+// addExtension adds ext with the criticality Matter gives it: basic
+// constraints, key usage and extended key usage are critical.
+func addExtension(b *cryptobyte.Builder, ext certExtension) {
+	if ext.tag == extTagFutureExtension {
+		b.AddBytes(ext.raw)
+		return
+	}
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		switch ext.tag {
+		case extTagBasicConstraints:
+			b.AddASN1ObjectIdentifier(oidExtBasicConstraints)
+			b.AddASN1Boolean(true)
+			b.AddASN1(casn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+				b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					if ext.isCA {
+						b.AddASN1Boolean(true)
+					}
+					if ext.pathLen >= 0 {
+						b.AddASN1Int64(int64(ext.pathLen))
+					}
+				})
+			})
+		case extTagKeyUsage:
+			b.AddASN1ObjectIdentifier(oidExtKeyUsage)
+			b.AddASN1Boolean(true)
+			b.AddASN1(casn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+				// Bit 0, digitalSignature, is the first byte's top bit.
+				usage := []byte{bits.Reverse8(byte(ext.usage)), bits.Reverse8(byte(ext.usage >> 8))}
+				if usage[1] == 0 {
+					usage = usage[:1]
+				}
+				b.AddASN1(casn1.BIT_STRING, func(b *cryptobyte.Builder) {
+					b.AddUint8(uint8(bits.TrailingZeros8(usage[len(usage)-1])))
+					b.AddBytes(usage)
+				})
+			})
+		case extTagExtKeyUsage:
+			b.AddASN1ObjectIdentifier(oidExtExtKeyUsage)
+			b.AddASN1Boolean(true)
+			b.AddASN1(casn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+				b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for _, p := range ext.purposes {
+						b.AddASN1ObjectIdentifier(extKeyPurposes[p])
+					}
+				})
+			})
+		case extTagSubjectKeyID:
+			b.AddASN1ObjectIdentifier(oidExtSubjectKeyID)
+			b.AddASN1(casn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+				b.AddASN1OctetString(ext.keyID)
+			})
+		case extTagAuthorityKeyID:
+			b.AddASN1ObjectIdentifier(oidExtAuthorityKeyID)
+			b.AddASN1(casn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+				b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1(casn1.Tag(0).ContextSpecific(), func(b *cryptobyte.Builder) { b.AddBytes(ext.keyID) })
+				})
+			})
+		}
+	})
+}
+
+// decodeX509 reads the content of a DER certificate. It accepts what
+// encodeX509 produces, and a little more; X509ToMatterTLV re-encodes the
+// result to catch the difference.
+func decodeX509(der []byte) (*matterCertificate, error) {
+	input := cryptobyte.String(der)
+	var cert, tbs, sigValue cryptobyte.String
+	if !input.ReadASN1(&cert, casn1.SEQUENCE) || !input.Empty() ||
+		!cert.ReadASN1(&tbs, casn1.SEQUENCE) ||
+		!readAlgorithm(&cert, oidECDSAWithSHA256) ||
+		!cert.ReadASN1(&sigValue, casn1.BIT_STRING) || !cert.Empty() {
+		return nil, errors.New("malformed certificate")
+	}
+
+	mc := &matterCertificate{}
+	var version cryptobyte.String
+	var v int64
+	var serial cryptobyte.String
+	if !tbs.ReadASN1(&version, casn1.Tag(0).Constructed().ContextSpecific()) ||
+		!version.ReadASN1Integer(&v) || v != 2 {
+		return nil, errors.New("not an X.509 v3 certificate")
+	}
+	if !tbs.ReadASN1(&serial, casn1.INTEGER) || !readAlgorithm(&tbs, oidECDSAWithSHA256) {
+		return nil, errors.New("malformed serial number or signature algorithm")
+	}
+	mc.serial = bytes.Clone(serial)
+	var err error
+	if mc.issuer, err = readName(&tbs); err != nil {
+		return nil, fmt.Errorf("issuer: %w", err)
+	}
+	var validity cryptobyte.String
+	if !tbs.ReadASN1(&validity, casn1.SEQUENCE) {
+		return nil, errors.New("malformed validity")
+	}
+	if mc.notBefore, err = readTime(&validity, false); err != nil {
+		return nil, fmt.Errorf("notBefore: %w", err)
+	}
+	if mc.notAfter, err = readTime(&validity, true); err != nil {
+		return nil, fmt.Errorf("notAfter: %w", err)
+	}
+	if mc.subject, err = readName(&tbs); err != nil {
+		return nil, fmt.Errorf("subject: %w", err)
+	}
+	var spki, alg cryptobyte.String
+	var algOID, curve asn1.ObjectIdentifier
+	var pub asn1.BitString
+	if !tbs.ReadASN1(&spki, casn1.SEQUENCE) || !spki.ReadASN1(&alg, casn1.SEQUENCE) ||
+		!alg.ReadASN1ObjectIdentifier(&algOID) || !algOID.Equal(oidECPublicKey) ||
+		!alg.ReadASN1ObjectIdentifier(&curve) || !curve.Equal(oidPrime256v1) ||
+		!spki.ReadASN1BitString(&pub) || pub.BitLength != 8*P256PublicKeySize {
+		return nil, errors.New("public key is not P-256")
+	}
+	mc.publicKey = bytes.Clone(pub.Bytes)
+	if tbs.PeekASN1Tag(casn1.Tag(3).Constructed().ContextSpecific()) {
+		var wrapper, exts cryptobyte.String
+		if !tbs.ReadASN1(&wrapper, casn1.Tag(3).Constructed().ContextSpecific()) ||
+			!wrapper.ReadASN1(&exts, casn1.SEQUENCE) || !wrapper.Empty() {
+			return nil, errors.New("malformed extensions")
+		}
+		for !exts.Empty() {
+			ext, err := readExtension(&exts)
+			if err != nil {
+				return nil, err
+			}
+			mc.extensions = append(mc.extensions, ext)
+		}
+	}
+	if !tbs.Empty() {
+		return nil, errors.New("unsupported fields in certificate")
+	}
+
+	var sig cryptobyte.String
+	var padding uint8
+	r, s := new(big.Int), new(big.Int)
+	if !sigValue.ReadUint8(&padding) || padding != 0 ||
+		!sigValue.ReadASN1(&sig, casn1.SEQUENCE) || !sigValue.Empty() ||
+		!sig.ReadASN1Integer(r) || !sig.ReadASN1Integer(s) || !sig.Empty() ||
+		r.Sign() <= 0 || s.Sign() <= 0 || r.BitLen() > 256 || s.BitLen() > 256 {
+		return nil, errors.New("malformed signature")
+	}
+	mc.signature = make([]byte, P256SignatureSize)
+	r.FillBytes(mc.signature[:32])
+	s.FillBytes(mc.signature[32:])
+	return mc, nil
+}
+
+func readAlgorithm(s *cryptobyte.String, want asn1.ObjectIdentifier) bool {
+	var alg cryptobyte.String
+	var oid asn1.ObjectIdentifier
+	return s.ReadASN1(&alg, casn1.SEQUENCE) && alg.ReadASN1ObjectIdentifier(&oid) && alg.Empty() && oid.Equal(want)
+}
+
+func readName(s *cryptobyte.String) ([]dnAttribute, error) {
+	var rdns cryptobyte.String
+	if !s.ReadASN1(&rdns, casn1.SEQUENCE) {
+		return nil, errors.New("malformed name")
+	}
+	var name []dnAttribute
+	for !rdns.Empty() {
+		var set, atv cryptobyte.String
+		var oid asn1.ObjectIdentifier
+		var value cryptobyte.String
+		var tag casn1.Tag
+		if !rdns.ReadASN1(&set, casn1.SET) || !set.ReadASN1(&atv, casn1.SEQUENCE) || !set.Empty() ||
+			!atv.ReadASN1ObjectIdentifier(&oid) || !atv.ReadAnyASN1(&value, &tag) || !atv.Empty() {
+			return nil, errors.New("malformed or multi-valued RDN")
+		}
+		attr, err := dnAttributeFor(oid, tag, string(value))
+		if err != nil {
+			return nil, err
+		}
+		name = append(name, attr)
+	}
+	return name, nil
+}
+
+// dnAttributeFor maps an X.509 attribute to its TLV form.
+func dnAttributeFor(oid asn1.ObjectIdentifier, tag casn1.Tag, value string) (dnAttribute, error) {
+	for _, m := range dnMatterAttributes {
+		if oid.Equal(m.oid) {
+			if tag != casn1.UTF8String || len(value) != m.digits {
+				return dnAttribute{}, fmt.Errorf("%v: want %d hex digits in a UTF8String", oid, m.digits)
+			}
+			id, err := parseHexID(value)
+			if err != nil {
+				return dnAttribute{}, fmt.Errorf("%v: %w", oid, err)
+			}
+			return dnAttribute{tag: m.tag, id: id}, nil
+		}
+	}
+	for t, std := range dnStandardOIDs {
+		if std == nil || !oid.Equal(std) {
+			continue
+		}
+		switch tag {
+		case casn1.PrintableString:
+			return dnAttribute{tag: uint64(t) | dnPrintableString, text: value}, nil
+		case dnStringTag(uint64(t)):
+			return dnAttribute{tag: uint64(t), text: value}, nil
+		}
+		return dnAttribute{}, fmt.Errorf("%v: unsupported string type %v", oid, tag)
+	}
+	return dnAttribute{}, fmt.Errorf("unsupported attribute %v", oid)
+}
+
+func parseHexID(s string) (uint64, error) {
+	var id uint64
+	for _, c := range []byte(s) {
+		var d byte
+		switch {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c >= 'A' && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, fmt.Errorf("%q is not upper-case hex", s)
+		}
+		id = id<<4 | uint64(d)
+	}
+	return id, nil
+}
+
+// readTime reads a validity time as seconds since the Matter epoch. The
+// notAfter of a certificate that never expires reads as 0.
+func readTime(s *cryptobyte.String, notAfter bool) (uint32, error) {
+	var t time.Time
+	switch {
+	case s.PeekASN1Tag(casn1.UTCTime):
+		if !s.ReadASN1UTCTime(&t) {
+			return 0, errors.New("malformed UTCTime")
+		}
+	case s.ReadASN1GeneralizedTime(&t):
+		if notAfter && t.Equal(noWellDefinedExpiry) {
+			return 0, nil
+		}
+	default:
+		return 0, errors.New("malformed time")
+	}
+	secs := t.Sub(matterEpoch) / time.Second
+	if t.Before(matterEpoch) || secs > math.MaxUint32 || t.Nanosecond() != 0 {
+		return 0, fmt.Errorf("%v is outside the Matter epoch", t)
+	}
+	return uint32(secs), nil
+}
+
+func readExtension(s *cryptobyte.String) (certExtension, error) {
+	var raw, ext, value cryptobyte.String
+	var oid asn1.ObjectIdentifier
+	if !s.ReadASN1Element(&raw, casn1.SEQUENCE) {
+		return certExtension{}, errors.New("malformed extension")
+	}
+	ext = raw
+	var body cryptobyte.String
+	if !ext.ReadASN1(&body, casn1.SEQUENCE) || !body.ReadASN1ObjectIdentifier(&oid) {
+		return certExtension{}, errors.New("malformed extension")
+	}
+	var critical bool
+	if body.PeekASN1Tag(casn1.BOOLEAN) && !body.ReadASN1Boolean(&critical) {
+		return certExtension{}, errors.New("malformed extension")
+	}
+	if !body.ReadASN1(&value, casn1.OCTET_STRING) || !body.Empty() {
+		return certExtension{}, errors.New("malformed extension")
+	}
+
+	out := certExtension{pathLen: -1}
+	ok := true
+	switch {
+	case oid.Equal(oidExtBasicConstraints):
+		out.tag = extTagBasicConstraints
+		var seq cryptobyte.String
+		ok = value.ReadASN1(&seq, casn1.SEQUENCE) && value.Empty()
+		if ok && seq.PeekASN1Tag(casn1.BOOLEAN) {
+			ok = seq.ReadASN1Boolean(&out.isCA)
+		}
+		if ok && !seq.Empty() {
+			var n int64
+			ok = seq.ReadASN1Integer(&n) && n >= 0 && n <= math.MaxInt8 && seq.Empty()
+			out.pathLen = int(n)
+		}
+	case oid.Equal(oidExtKeyUsage):
+		out.tag = extTagKeyUsage
+		var bs asn1.BitString
+		ok = value.ReadASN1BitString(&bs) && value.Empty() && bs.BitLength <= 9
+		for i := range bs.BitLength {
+			out.usage |= uint16(bs.At(i)) << i
+		}
+	case oid.Equal(oidExtExtKeyUsage):
+		out.tag = extTagExtKeyUsage
+		var seq cryptobyte.String
+		ok = value.ReadASN1(&seq, casn1.SEQUENCE) && value.Empty()
+		for ok && !seq.Empty() {
+			var p asn1.ObjectIdentifier
+			ok = seq.ReadASN1ObjectIdentifier(&p)
+			purpose := 0
+			for i, known := range extKeyPurposes {
+				if known != nil && p.Equal(known) {
+					purpose = i
+				}
+			}
+			ok = ok && purpose != 0
+			out.purposes = append(out.purposes, uint8(purpose))
+		}
+	case oid.Equal(oidExtSubjectKeyID):
+		out.tag = extTagSubjectKeyID
+		var id cryptobyte.String
+		ok = value.ReadASN1(&id, casn1.OCTET_STRING) && value.Empty()
+		out.keyID = bytes.Clone(id)
+	case oid.Equal(oidExtAuthorityKeyID):
+		out.tag = extTagAuthorityKeyID
+		var seq, id cryptobyte.String
+		ok = value.ReadASN1(&seq, casn1.SEQUENCE) && value.Empty() &&
+			seq.ReadASN1(&id, casn1.Tag(0).ContextSpecific()) && seq.Empty()
+		out.keyID = bytes.Clone(id)
+	default:
+		out.tag = extTagFutureExtension
+		out.raw = bytes.Clone(raw)
+	}
+	if !ok {
+		return certExtension{}, fmt.Errorf("malformed extension %v", oid)
+	}
+	return out, nil
+}
+
+func dnStandardOID(tag uint64) asn1.ObjectIdentifier {
+	tag &^= dnPrintableString
+	if tag == 0 || tag >= uint64(len(dnStandardOIDs)) {
+		return nil
+	}
+	return dnStandardOIDs[tag]
+}
+
+// dnStringTag is the X.509 string type of a standard attribute.
+func dnStringTag(tag uint64) casn1.Tag {
+	switch {
+	case tag&dnPrintableString != 0:
+		return casn1.PrintableString
+	case tag == 16:
+		return casn1.IA5String
+	default:
+		return casn1.UTF8String
+	}
+}
+
+// dnMatterAttribute returns the index of tag in dnMatterAttributes, or -1.
+func dnMatterAttribute(tag uint64) int {
+	for i, m := range dnMatterAttributes {
+		if m.tag == tag {
+			return i
+		}
+	}
+	return -1
+}
+
+func contextTag(id uint64) tlv.Tag {
+	return tlv.Tag{Class: tlv.TagControlContextSpecific, ID: id}
+}
+
+func isContextTag(e tlv.Element, id uint64) bool {
+	return e.Tag.Class == tlv.TagControlContextSpecific && e.Tag.ID == id
+}
+
+func tlvUint(e tlv.Element) (uint64, bool) {
+	if e.Type&0xFC != tlv.TypeUnsignedInt {
+		return 0, false
+	}
+	var v uint64
+	for i, b := range e.Value {
+		v |= uint64(b) << (8 * i)
+	}
+	return v, true
+}
+
+func tlvBytes(e tlv.Element) ([]byte, bool) {
+	if e.Type&0xFC != tlv.TypeByteString {
+		return nil, false
+	}
+	return bytes.Clone(e.Value), true
+}
+
+func tlvString(e tlv.Element) (string, bool) {
+	if e.Type&0xFC != tlv.TypeUTF8String {
+		return "", false
+	}
+	return string(e.Value), true
+}
+
+func tlvBool(e tlv.Element) (bool, bool) {
+	switch e.Type {
+	case tlv.TypeBoolean:
+		return false, true
+	case tlv.TypeBoolean + 1:
+		return true, true
+	}
+	return false, false
+}
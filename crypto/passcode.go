@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxPasscode is the largest setup passcode Matter §5.1.7.1 allows. The
+// passcode field is 27 bits wide on the onboarding payload, but only
+// 00000001..99999998 are legal values.
+const MaxPasscode uint32 = 99999998
+
+// ErrInvalidPasscode is returned when a setup passcode is outside the
+// 00000001..99999998 range or is one of the trivially guessable values
+// Matter §5.1.7.1 forbids.
+var ErrInvalidPasscode = errors.New("crypto: invalid setup passcode")
+
+// disallowedPasscodes are the trivial values Matter §5.1.7.1 forbids
+// regardless of range.
+var disallowedPasscodes = map[uint32]struct{}{
+	0:        {},
+	11111111: {},
+	22222222: {},
+	33333333: {},
+	44444444: {},
+	55555555: {},
+	66666666: {},
+	77777777: {},
+	88888888: {},
+	99999999: {},
+	12345678: {},
+	87654321: {},
+}
+
+// ValidatePasscode reports whether passcode may be used as a Matter setup
+// passcode. The returned error wraps ErrInvalidPasscode.
+func ValidatePasscode(passcode uint32) error {
+	if passcode == 0 || passcode > MaxPasscode {
+		return fmt.Errorf("%w: %d out of range 1..%d", ErrInvalidPasscode, passcode, MaxPasscode)
+	}
+	if _, bad := disallowedPasscodes[passcode]; bad {
+		return fmt.Errorf("%w: %08d is disallowed", ErrInvalidPasscode, passcode)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidatePasscode(t *testing.T) {
+	for _, ok := range []uint32{1, 2048, 20202021, 12345679, MaxPasscode} {
+		if err := ValidatePasscode(ok); err != nil {
+			t.Errorf("ValidatePasscode(%d) = %v, want nil", ok, err)
+		}
+	}
+	for _, bad := range []uint32{
+		0, 11111111, 22222222, 33333333, 44444444, 55555555,
+		66666666, 77777777, 88888888, 99999999, 12345678, 87654321,
+		100000000, 1 << 27,
+	} {
+		if err := ValidatePasscode(bad); !errors.Is(err, ErrInvalidPasscode) {
+			t.Errorf("ValidatePasscode(%d) = %v, want ErrInvalidPasscode", bad, err)
+		}
+	}
+}
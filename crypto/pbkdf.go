@@ -33,6 +33,12 @@ func PBKDF2HmacSha256(password, salt []byte, iterations, dkLen int) []byte {
 // kSpake2p_WS_Length and switch to FillBytes(make([]byte, 32), …) if the
 // spec mandates it.
 func Spake2pW0W1FromPasscode(passcode uint32, salt []byte, iterations int) (w0, w1 []byte, err error) {
+	return Spake2pW0W1FromPasscodeWith(&DefaultCryptoProvider{}, passcode, salt, iterations)
+}
+
+// Spake2pW0W1FromPasscodeWith is Spake2pW0W1FromPasscode with the PBKDF2
+// step run on p.
+func Spake2pW0W1FromPasscodeWith(p CryptoProvider, passcode uint32, salt []byte, iterations int) (w0, w1 []byte, err error) {
 	if iterations <= 0 {
 		return nil, nil, errors.New("crypto/spake2plus: iterations must be > 0")
 	}
@@ -41,7 +47,7 @@ func Spake2pW0W1FromPasscode(passcode uint32, salt []byte, iterations int) (w0,
 	}
 	pwd := make([]byte, 4)
 	binary.LittleEndian.PutUint32(pwd, passcode)
-	ws := PBKDF2HmacSha256(pwd, salt, iterations, 80)
+	ws := p.PBKDF2(pwd, salt, iterations, 80)
 
 	n := elliptic.P256().Params().N
 	w0Big := new(big.Int).SetBytes(ws[:40])
@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// P-256 key and signature sizes used across Matter §3.5.
+const (
+	P256PrivateKeySize = 32
+	P256PublicKeySize  = 65 // uncompressed SEC1: 0x04 || X || Y
+	P256SignatureSize  = 64 // raw r || s
+)
+
+// ErrInvalidSignature is returned by Verify when a signature does not
+// validate against the public key and message.
+var ErrInvalidSignature = errors.New("crypto: invalid ECDSA signature")
+
+// p256KeyPair is the KeyPair returned by GenerateKeyPair and
+// NewP256KeyPair.
+type p256KeyPair struct {
+	pub, priv []byte
+}
+
+func (k *p256KeyPair) Public() []byte  { return append([]byte(nil), k.pub...) }
+func (k *p256KeyPair) Private() []byte { return append([]byte(nil), k.priv...) }
+
+// NewP256KeyPair wraps a stored 32-byte P-256 private scalar, recomputing
+// its public key. The scalar must be in [1, n-1].
+func NewP256KeyPair(priv []byte) (KeyPair, error) {
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), priv)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: P-256 private key: %w", err)
+	}
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("crypto: P-256 public key: %w", err)
+	}
+	return &p256KeyPair{pub: pub, priv: append([]byte(nil), priv...)}, nil
+}
+
+// generateP256KeyPair draws private scalars from r until one is valid.
+// crypto/ecdsa.GenerateKey ignores its reader since Go 1.26, so the
+// scalar is read here to keep DeterministicCryptoProvider reproducible.
+func generateP256KeyPair(r io.Reader) (KeyPair, error) {
+	buf := make([]byte, P256PrivateKeySize)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("crypto: generate key pair: %w", err)
+		}
+		if kp, err := NewP256KeyPair(buf); err == nil {
+			return kp, nil
+		}
+	}
+}
+
+func (p *DefaultCryptoProvider) Hash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func (p *DefaultCryptoProvider) HMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (p *DefaultCryptoProvider) PBKDF2(password, salt []byte, iterations, keyLen int) []byte {
+	return PBKDF2HmacSha256(password, salt, iterations, keyLen)
+}
+
+func (p *DefaultCryptoProvider) HKDF(secret, salt, info []byte, length int) ([]byte, error) {
+	return HKDF(secret, salt, info, length)
+}
+
+func (p *DefaultCryptoProvider) GenerateKeyPair() (KeyPair, error) {
+	return generateP256KeyPair(p.Rand())
+}
+
+// Sign hashes msg with SHA-256 and returns the raw r || s signature Matter
+// carries on the wire (§3.5.3). Signatures are randomised: crypto/ecdsa
+// always mixes in system entropy, whatever Rand returns.
+func (p *DefaultCryptoProvider) Sign(key KeyPair, msg []byte) ([]byte, error) {
+	return signP256(rand.Reader, key, msg)
+}
+
+// signP256 signs SHA-256(msg) with key. A nil random makes the signature
+// deterministic per RFC 6979.
+func signP256(random io.Reader, key KeyPair, msg []byte) ([]byte, error) {
+	priv, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), key.Private())
+	if err != nil {
+		return nil, fmt.Errorf("crypto: sign: %w", err)
+	}
+	digest := sha256.Sum256(msg)
+	der, err := priv.Sign(random, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: sign: %w", err)
+	}
+	var r, s []byte
+	in, seq := cryptobyte.String(der), cryptobyte.String(nil)
+	if !in.ReadASN1(&seq, asn1.SEQUENCE) || !seq.ReadASN1Integer(&r) || !seq.ReadASN1Integer(&s) {
+		return nil, errors.New("crypto: sign: malformed ECDSA signature")
+	}
+	sig := make([]byte, P256SignatureSize)
+	new(big.Int).SetBytes(r).FillBytes(sig[:32])
+	new(big.Int).SetBytes(s).FillBytes(sig[32:])
+	return sig, nil
+}
+
+func (p *DefaultCryptoProvider) Verify(publicKey, msg, signature []byte) error {
+	if len(signature) != P256SignatureSize {
+		return ErrInvalidSignature
+	}
+	pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), publicKey)
+	if err != nil {
+		return fmt.Errorf("crypto: verify: %w", err)
+	}
+	digest := sha256.Sum256(msg)
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (p *DefaultCryptoProvider) ECDH(key KeyPair, peerPublicKey []byte) ([]byte, error) {
+	priv, err := ecdh.P256().NewPrivateKey(key.Private())
+	if err != nil {
+		return nil, fmt.Errorf("crypto: ECDH private key: %w", err)
+	}
+	pub, err := ecdh.P256().NewPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: ECDH peer key: %w", err)
+	}
+	return priv.ECDH(pub)
+}
+
+func (p *DefaultCryptoProvider) Rand() io.Reader {
+	return rand.Reader
+}
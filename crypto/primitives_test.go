@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDefaultProvider_HashHMAC(t *testing.T) {
+	p := &DefaultCryptoProvider{}
+	// FIPS 180-2 "abc" vector.
+	if got := hex.EncodeToString(p.Hash([]byte("abc"))); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("Hash(abc) = %s", got)
+	}
+	// RFC 4231 test case 2.
+	mac := p.HMAC([]byte("Jefe"), []byte("what do ya want for nothing?"))
+	if got := hex.EncodeToString(mac); got != "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Errorf("HMAC = %s", got)
+	}
+}
+
+func TestDefaultProvider_SignVerify(t *testing.T) {
+	p := &DefaultCryptoProvider{}
+	kp, err := p.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	if len(kp.Public()) != P256PublicKeySize || len(kp.Private()) != P256PrivateKeySize {
+		t.Fatalf("key sizes: pub=%d priv=%d", len(kp.Public()), len(kp.Private()))
+	}
+	msg := []byte("Sigma2 TBS data")
+	sig, err := p.Sign(kp, msg)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if len(sig) != P256SignatureSize {
+		t.Fatalf("signature is %d bytes, want %d", len(sig), P256SignatureSize)
+	}
+	if err := p.Verify(kp.Public(), msg, sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := p.Verify(kp.Public(), []byte("other"), sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify(wrong msg) = %v, want ErrInvalidSignature", err)
+	}
+	if err := p.Verify(kp.Public(), msg, sig[:63]); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify(short sig) = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestDefaultProvider_ECDH(t *testing.T) {
+	p := &DefaultCryptoProvider{}
+	a, _ := p.GenerateKeyPair()
+	b, _ := p.GenerateKeyPair()
+	ab, err := p.ECDH(a, b.Public())
+	if err != nil {
+		t.Fatalf("ECDH(a, B): %v", err)
+	}
+	ba, err := p.ECDH(b, a.Public())
+	if err != nil {
+		t.Fatalf("ECDH(b, A): %v", err)
+	}
+	if len(ab) != 32 || !bytes.Equal(ab, ba) {
+		t.Errorf("shared secrets differ: %x vs %x", ab, ba)
+	}
+	if _, err := p.ECDH(a, []byte{0x04, 0x01}); err == nil {
+		t.Error("ECDH accepted a malformed peer key")
+	}
+}
+
+func TestNewP256KeyPair(t *testing.T) {
+	p := &DefaultCryptoProvider{}
+	kp, _ := p.GenerateKeyPair()
+	again, err := NewP256KeyPair(kp.Private())
+	if err != nil {
+		t.Fatalf("NewP256KeyPair: %v", err)
+	}
+	if !bytes.Equal(again.Public(), kp.Public()) {
+		t.Error("public key not reproduced from private scalar")
+	}
+	if _, err := NewP256KeyPair(make([]byte, 32)); err == nil {
+		t.Error("NewP256KeyPair accepted the zero scalar")
+	}
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// PrivacyKeyInfo is the HKDF info string that derives a session's privacy
+// key from its encryption key (Matter §4.9.3).
+const PrivacyKeyInfo = "PrivacyKey"
+
+// privacyNonceMICOffset is where the 11 MIC bytes copied into the privacy
+// nonce start: PrivacyNonce = SessionID (BE16) || MIC[5:16].
+const privacyNonceMICOffset = MatterTagSize - (MatterNonceSize - 2)
+
+// ErrInvalidMICSize is returned when a privacy nonce is requested from a
+// MIC that is not MatterTagSize bytes.
+var ErrInvalidMICSize = errors.New("crypto: MIC must be 16 bytes per Matter §4.9.3")
+
+// DerivePrivacyKey expands a session encryption key into the 16-byte key
+// used to obfuscate message headers: HKDF-SHA-256 with empty salt and
+// info = "PrivacyKey" (Matter §4.9.3).
+func DerivePrivacyKey(encryptionKey []byte) ([]byte, error) {
+	return DerivePrivacyKeyWith(&DefaultCryptoProvider{}, encryptionKey)
+}
+
+// DerivePrivacyKeyWith is DerivePrivacyKey with the HKDF step run on p.
+func DerivePrivacyKeyWith(p CryptoProvider, encryptionKey []byte) ([]byte, error) {
+	if len(encryptionKey) == 0 {
+		return nil, errors.New("crypto: encryption key must be non-empty")
+	}
+	return p.HKDF(encryptionKey, nil, []byte(PrivacyKeyInfo), 16)
+}
+
+// PrivacyNonce builds the 13-byte privacy nonce from the session ID and the
+// MIC of the already-encrypted frame (Matter §4.9.3). Because the MIC is
+// effectively random per message, no counter needs to be tracked.
+func PrivacyNonce(sessionID uint16, mic []byte) ([]byte, error) {
+	if len(mic) != MatterTagSize {
+		return nil, ErrInvalidMICSize
+	}
+	nonce := make([]byte, MatterNonceSize)
+	binary.BigEndian.PutUint16(nonce[:2], sessionID)
+	copy(nonce[2:], mic[privacyNonceMICOffset:])
+	return nonce, nil
+}
+
+// PrivacyEncrypt obfuscates plaintext with AES-128 in the CTR mode AES-CCM
+// uses for its payload (Crypto_Privacy_Encrypt, Matter §3.7): counter
+// blocks are Flags(L-1) || nonce || counter, starting at 1. The output is
+// therefore the CCM ciphertext of plaintext under the same key and nonce,
+// minus the tag. Returns ErrInvalidNonceSize unless len(nonce) is
+// MatterNonceSize.
+func PrivacyEncrypt(key, nonce, plaintext []byte) ([]byte, error) {
+	stream, err := newPrivacyCTR(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(plaintext))
+	stream.XORKeyStream(out, plaintext)
+	return out, nil
+}
+
+// PrivacyDecrypt reverses PrivacyEncrypt. CTR mode is its own inverse, so
+// this exists for readability at call sites.
+func PrivacyDecrypt(key, nonce, ciphertext []byte) ([]byte, error) {
+	return PrivacyEncrypt(key, nonce, ciphertext)
+}
+
+func newPrivacyCTR(key, nonce []byte) (cipher.Stream, error) {
+	if len(nonce) != MatterNonceSize {
+		return nil, ErrInvalidNonceSize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: aes key: %w", err)
+	}
+	// A 13-byte nonce leaves L = 2 bytes of counter; the flags byte
+	// encodes L-1.
+	iv := make([]byte, aes.BlockSize)
+	iv[0] = byte(aes.BlockSize - 1 - MatterNonceSize - 1)
+	copy(iv[1:], nonce)
+	iv[aes.BlockSize-1] = 1
+	return cipher.NewCTR(block, iv), nil
+}
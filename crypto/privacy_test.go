@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestPrivacyNonce_Layout(t *testing.T) {
+	mic := make([]byte, 16)
+	for i := range mic {
+		mic[i] = byte(i)
+	}
+	nonce, err := PrivacyNonce(0xABCD, mic)
+	if err != nil {
+		t.Fatalf("PrivacyNonce: %v", err)
+	}
+	want := "abcd05060708090a0b0c0d0e0f"
+	if got := hex.EncodeToString(nonce); got != want {
+		t.Errorf("nonce = %s, want %s", got, want)
+	}
+	if _, err := PrivacyNonce(1, mic[:15]); !errors.Is(err, ErrInvalidMICSize) {
+		t.Errorf("short MIC: err = %v, want ErrInvalidMICSize", err)
+	}
+}
+
+// TestPrivacyNonce_Vector — connectedhomeip's CryptoContext privacy nonce
+// test vector.
+func TestPrivacyNonce_Vector(t *testing.T) {
+	mic, _ := hex.DecodeString("c5a0063ad5d2518191400dd68c5c163b")
+	nonce, err := PrivacyNonce(0x002a, mic)
+	if err != nil {
+		t.Fatalf("PrivacyNonce: %v", err)
+	}
+	if got, want := hex.EncodeToString(nonce), "002ad2518191400dd68c5c163b"; got != want {
+		t.Errorf("nonce = %s, want %s", got, want)
+	}
+}
+
+// TestPrivacyEncrypt_MatchesCCMKeystream — privacy encryption is the CTR
+// half of AES-CCM, so its output must equal the CCM ciphertext body under
+// the same key and nonce.
+func TestPrivacyEncrypt_MatchesCCMKeystream(t *testing.T) {
+	key := bytes.Repeat([]byte{0x11}, 16)
+	nonce := bytes.Repeat([]byte{0x22}, MatterNonceSize)
+	for _, n := range []int{0, 1, 8, 16, 17, 40} {
+		plaintext := bytes.Repeat([]byte{0x5A}, n)
+		sealed, err := (&DefaultCryptoProvider{}).Encrypt(key, nonce, plaintext, nil)
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		got, err := PrivacyEncrypt(key, nonce, plaintext)
+		if err != nil {
+			t.Fatalf("PrivacyEncrypt: %v", err)
+		}
+		if !bytes.Equal(got, sealed[:n]) {
+			t.Errorf("len %d: privacy ciphertext %x, CCM body %x", n, got, sealed[:n])
+		}
+	}
+}
+
+func TestPrivacyEncrypt_RoundTripAndVector(t *testing.T) {
+	encKey, _ := hex.DecodeString("5eded244e5532b3cdc23409dbad052d2")
+	privacyKey, err := DerivePrivacyKey(encKey)
+	if err != nil {
+		t.Fatalf("DerivePrivacyKey: %v", err)
+	}
+	mic, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	nonce, err := PrivacyNonce(0x0001, mic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Message counter + source node ID, the fields §4.9.3 obfuscates.
+	header, _ := hex.DecodeString("7856341200000000efcdab89")
+	obf, err := PrivacyEncrypt(privacyKey, nonce, header)
+	if err != nil {
+		t.Fatalf("PrivacyEncrypt: %v", err)
+	}
+	back, err := PrivacyDecrypt(privacyKey, nonce, obf)
+	if err != nil {
+		t.Fatalf("PrivacyDecrypt: %v", err)
+	}
+	if !bytes.Equal(back, header) {
+		t.Fatalf("round trip = %x, want %x", back, header)
+	}
+
+	// Key derivation and CTR framing, computed outside this package: the
+	// key with RFC 5869 HKDF-SHA-256 over Python's hmac/hashlib, the
+	// obfuscated header with OpenSSL's aes-128-ctr from IV
+	// 0x01 || nonce || 0x0001.
+	const (
+		wantKey = "63d2de308899e24db053b0236df07719"
+		wantObf = "b71ac8326748a561083ea13f"
+	)
+	if got := hex.EncodeToString(privacyKey); got != wantKey {
+		t.Errorf("privacy key = %s, want %s", got, wantKey)
+	}
+	if got := hex.EncodeToString(obf); got != wantObf {
+		t.Errorf("obfuscated header = %s, want %s", got, wantObf)
+	}
+}
+
+func TestDerivePrivacyKeyWith_Routed(t *testing.T) {
+	p := &countingProvider{}
+	encKey := bytes.Repeat([]byte{0x5A}, 16)
+	got, err := DerivePrivacyKeyWith(p, encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.hkdfs != 1 {
+		t.Errorf("%d HKDFs, want 1", p.hkdfs)
+	}
+	want, _ := DerivePrivacyKey(encKey)
+	if !bytes.Equal(got, want) {
+		t.Errorf("DerivePrivacyKeyWith = %x, DerivePrivacyKey = %x", got, want)
+	}
+}
+
+func TestPrivacyEncrypt_RejectsBadNonce(t *testing.T) {
+	if _, err := PrivacyEncrypt(make([]byte, 16), make([]byte, 12), []byte("x")); !errors.Is(err, ErrInvalidNonceSize) {
+		t.Errorf("err = %v, want ErrInvalidNonceSize", err)
+	}
+	if _, err := DerivePrivacyKey(nil); err == nil {
+		t.Error("DerivePrivacyKey accepted an empty key")
+	}
+}
@@ -28,10 +28,16 @@ type SessionKeys struct {
 // before treating the locked test vector in session_keys_test.go as
 // spec-authoritative.
 func DeriveSessionKeysFromKe(ke []byte) (SessionKeys, error) {
+	return DeriveSessionKeysFromKeWith(&DefaultCryptoProvider{}, ke)
+}
+
+// DeriveSessionKeysFromKeWith is DeriveSessionKeysFromKe with the HKDF step
+// run on p.
+func DeriveSessionKeysFromKeWith(p CryptoProvider, ke []byte) (SessionKeys, error) {
 	if len(ke) == 0 {
 		return SessionKeys{}, errors.New("crypto: Ke must be non-empty")
 	}
-	out, err := HKDF(ke, nil, []byte(SessionKeyInfo), 48)
+	out, err := p.HKDF(ke, nil, []byte(SessionKeyInfo), 48)
 	if err != nil {
 		return SessionKeys{}, err
 	}
@@ -16,6 +16,9 @@
 //     nil-deref panics later.
 //   - Confirmation values are compared with crypto/subtle, not bytes.Equal.
 //   - M and N are decompressed once at init() and validated on the curve.
+//   - Point arithmetic goes through spakeGroup, with a constant-time
+//     filippo.io/nistec backend (the default) alongside the original
+//     crypto/elliptic one; see SPAKE2PBackend.
 //
 // Wire output (TT layout, w0/w1 byte widths, KcA/KcB HKDF salt+info,
 // SessionKeys derivation) is preserved byte-for-byte from the upstream so
@@ -27,9 +30,7 @@ package crypto
 import (
 	"bytes"
 	"crypto/elliptic"
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
@@ -37,8 +38,6 @@ import (
 	"fmt"
 	"io"
 	"math/big"
-
-	"golang.org/x/crypto/hkdf"
 )
 
 // ErrInvalidPoint is returned when a peer's pA / pB does not unmarshal as a
@@ -66,7 +65,8 @@ var spakeRandReader io.Reader = rand.Reader
 //
 // crypto/elliptic is deprecated in Go 1.21+ in favour of crypto/ecdh, but
 // ecdh does not expose the arbitrary point arithmetic SPAKE2+ needs
-// (X = x·G + w0·M, etc.), so we stay on crypto/elliptic.
+// (X = x·G + w0·M, etc.). It backs SPAKE2PGeneric and the M/N and L
+// validation; SPAKE2PConstantTime uses filippo.io/nistec instead.
 var spake2pCurve = elliptic.P256()
 
 // M and N are the SPAKE2+ generator points for P-256 from RFC 9383 §4.
@@ -104,33 +104,27 @@ func init() {
 	spakeNBytes = elliptic.Marshal(spake2pCurve, spakeNx, spakeNy)
 }
 
-func spakeSha256Sum(in []byte) []byte {
-	s := sha256.New()
-	s.Write(in)
-	return s.Sum(nil)
-}
-
-func spakeHmacSha256(key, in []byte) []byte {
-	mac := hmac.New(sha256.New, key)
-	mac.Write(in)
-	return mac.Sum(nil)
+// spakeHashes is the part of CryptoProvider that key confirmation runs
+// on: Crypto_Hash, Crypto_HMAC and Crypto_KDF.
+type spakeHashes interface {
+	Hash(data []byte) []byte
+	HMAC(key, data []byte) []byte
+	HKDF(secret, salt, info []byte, length int) ([]byte, error)
 }
 
-func spakeHkdfSha256(secret, salt, info []byte, size int) ([]byte, error) {
-	engine := hkdf.New(sha256.New, secret, salt, info)
-	out := make([]byte, size)
-	if _, err := io.ReadFull(engine, out); err != nil {
-		return nil, fmt.Errorf("crypto/spake2plus: HKDF expand: %w", err)
+// spakeRandomScalar returns 32 bytes from r, or from spakeRandReader when r
+// is nil. Values at or above the group order are reduced inside scalar
+// multiplication, so they are not rejected here; the bias this introduces
+// is below 2^-32.
+func spakeRandomScalar(r io.Reader) ([]byte, error) {
+	if r == nil {
+		r = spakeRandReader
 	}
-	return out, nil
-}
-
-func spakeRandomScalar() (*big.Int, error) {
 	buf := make([]byte, 32)
-	if _, err := io.ReadFull(spakeRandReader, buf); err != nil {
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return nil, fmt.Errorf("crypto/spake2plus: random: %w", err)
 	}
-	return new(big.Int).SetBytes(buf), nil
+	return buf, nil
 }
 
 func spakeSerializeBytes(buf *bytes.Buffer, p []byte) {
@@ -157,18 +151,18 @@ func spakeBuildTT(contextHash, pA, pB, z, v, w0 []byte) []byte {
 
 // spakeFinalize derives Ke and the two confirmation MACs from the protocol
 // transcript. Shared by Prover and Verifier so the two paths cannot drift.
-func spakeFinalize(context, pA, pB, z, v, w0 []byte) (ke, cA, cB []byte, err error) {
-	contextHash := spakeSha256Sum(context)
-	ttHash := spakeSha256Sum(spakeBuildTT(contextHash, pA, pB, z, v, w0))
+func spakeFinalize(h spakeHashes, context, pA, pB, z, v, w0 []byte) (ke, cA, cB []byte, err error) {
+	contextHash := h.Hash(context)
+	ttHash := h.Hash(spakeBuildTT(contextHash, pA, pB, z, v, w0))
 	ka := ttHash[:16]
 	ke = bytes.Clone(ttHash[16:32])
 
-	confirmKeys, err := spakeHkdfSha256(ka, nil, []byte("ConfirmationKeys"), 32)
+	confirmKeys, err := h.HKDF(ka, nil, []byte("ConfirmationKeys"), 32)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, fmt.Errorf("crypto/spake2plus: %w", err)
 	}
-	cA = spakeHmacSha256(confirmKeys[:16], pB)
-	cB = spakeHmacSha256(confirmKeys[16:], pA)
+	cA = h.HMAC(confirmKeys[:16], pB)
+	cB = h.HMAC(confirmKeys[16:], pA)
 	return ke, cA, cB, nil
 }
 
@@ -195,11 +189,15 @@ func unmarshalP256Point(in []byte) (*big.Int, *big.Int, error) {
 // SPAKE2PProver is the A-side (Initiator / Commissioner) of a Matter PASE
 // SPAKE2+ exchange. It holds w0 and w1 derived from the shared passcode.
 type SPAKE2PProver struct {
-	w0      []byte
-	w1      []byte
+	group   spakeGroup
+	hashes  spakeHashes
+	rand    io.Reader // nil: spakeRandReader
+	w0      []byte    // as supplied; hashed into the transcript
+	w0s     []byte    // w0 left-padded to 32 bytes for scalar multiplication
+	w1s     []byte
 	context []byte
 
-	x      *big.Int
+	x      []byte
 	pA     []byte
 	pB     []byte
 	ke     []byte
@@ -210,14 +208,32 @@ type SPAKE2PProver struct {
 
 // NewSPAKE2PProver builds a Prover from (w0, w1) reduced mod p256.N (typically
 // the output of ComputeSPAKE2PVerifierData / Spake2pW0W1FromPasscode) and the
-// raw Matter PASE context bytes (hashed inside Finalize).
+// raw Matter PASE context bytes (hashed inside Finalize). It uses the
+// SPAKE2PConstantTime backend; CryptoProvider.NewSPAKE2PProver selects
+// another.
 func NewSPAKE2PProver(w0, w1, context []byte) (*SPAKE2PProver, error) {
+	return newSPAKE2PProver(nistecGroup{}, &DefaultCryptoProvider{}, nil, w0, w1, context)
+}
+
+func newSPAKE2PProver(group spakeGroup, hashes spakeHashes, rand io.Reader, w0, w1, context []byte) (*SPAKE2PProver, error) {
 	if len(w0) == 0 || len(w1) == 0 {
 		return nil, errors.New("crypto/spake2plus: w0 and w1 must be non-empty")
 	}
+	w0s, err := spakeScalar(w0)
+	if err != nil {
+		return nil, err
+	}
+	w1s, err := spakeScalar(w1)
+	if err != nil {
+		return nil, err
+	}
 	return &SPAKE2PProver{
+		group:   group,
+		hashes:  hashes,
+		rand:    rand,
 		w0:      bytes.Clone(w0),
-		w1:      bytes.Clone(w1),
+		w0s:     w0s,
+		w1s:     w1s,
 		context: bytes.Clone(context),
 	}, nil
 }
@@ -225,16 +241,24 @@ func NewSPAKE2PProver(w0, w1, context []byte) (*SPAKE2PProver, error) {
 // ComputePA generates the ephemeral scalar x and returns
 // pA = x·G + w0·M as a 65-byte uncompressed P-256 point.
 func (p *SPAKE2PProver) ComputePA() ([]byte, error) {
-	x, err := spakeRandomScalar()
+	x, err := spakeRandomScalar(p.rand)
 	if err != nil {
 		return nil, err
 	}
-	p.x = x
 
-	tx, ty := spake2pCurve.ScalarBaseMult(x.Bytes())
-	mx, my := spake2pCurve.ScalarMult(spakeMx, spakeMy, p.w0)
-	pAx, pAy := spake2pCurve.Add(tx, ty, mx, my)
-	p.pA = elliptic.Marshal(spake2pCurve, pAx, pAy)
+	t, err := p.group.scalarBaseMult(x)
+	if err != nil {
+		return nil, err
+	}
+	m, err := p.group.scalarMult(spakeMBytes, p.w0s)
+	if err != nil {
+		return nil, err
+	}
+	pA, err := p.group.add(t, m)
+	if err != nil {
+		return nil, err
+	}
+	p.x, p.pA = x, pA
 	return bytes.Clone(p.pA), nil
 }
 
@@ -247,23 +271,31 @@ func (p *SPAKE2PProver) Finalize(pB []byte) error {
 	if p.x == nil {
 		return errors.New("crypto/spake2plus: ComputePA must be called before Finalize")
 	}
-	pBx, pBy, err := unmarshalP256Point(pB)
-	if err != nil {
+	if err := p.group.checkPoint(pB); err != nil {
 		return err
 	}
-	p.pB = bytes.Clone(pB)
 
 	// Z = x · (pB − w0·N)
 	// V = w1 · (pB − w0·N)
-	wnx, wny := spake2pCurve.ScalarMult(spakeNx, spakeNy, p.w0)
-	negNx, negNy := spakeNegateY(wnx, wny)
-	dx, dy := spake2pCurve.Add(pBx, pBy, negNx, negNy)
-	zx, zy := spake2pCurve.ScalarMult(dx, dy, p.x.Bytes())
-	vx, vy := spake2pCurve.ScalarMult(dx, dy, p.w1)
-	z := elliptic.Marshal(spake2pCurve, zx, zy)
-	v := elliptic.Marshal(spake2pCurve, vx, vy)
-
-	ke, cA, cB, err := spakeFinalize(p.context, p.pA, p.pB, z, v, p.w0)
+	wn, err := p.group.scalarMult(spakeNBytes, p.w0s)
+	if err != nil {
+		return err
+	}
+	d, err := p.group.sub(pB, wn)
+	if err != nil {
+		return err
+	}
+	z, err := p.group.scalarMult(d, p.x)
+	if err != nil {
+		return err
+	}
+	v, err := p.group.scalarMult(d, p.w1s)
+	if err != nil {
+		return err
+	}
+	p.pB = bytes.Clone(pB)
+
+	ke, cA, cB, err := spakeFinalize(p.hashes, p.context, p.pA, p.pB, z, v, p.w0)
 	if err != nil {
 		return err
 	}
@@ -305,12 +337,14 @@ func (p *SPAKE2PProver) SharedKey() ([]byte, error) {
 // SPAKE2+ exchange. It holds (w0, L) — the device's persisted verifier — and
 // never sees the original passcode or w1.
 type SPAKE2PVerifier struct {
-	w0      []byte
-	lBytes  []byte // 65-byte uncompressed L = w1·G
-	lx, ly  *big.Int
+	group   spakeGroup
+	hashes  spakeHashes
+	rand    io.Reader // nil: spakeRandReader
+	w0      []byte    // as supplied; hashed into the transcript
+	w0s     []byte    // w0 left-padded to 32 bytes for scalar multiplication
+	lBytes  []byte    // 65-byte uncompressed L = w1·G
 	context []byte
 
-	y      *big.Int
 	pA     []byte
 	pB     []byte
 	zV, vV []byte // pre-computed Z and V points (uncompressed, 65 bytes each)
@@ -322,55 +356,80 @@ type SPAKE2PVerifier struct {
 
 // NewSPAKE2PVerifier builds a Verifier from (w0, L) (typically produced once
 // at provisioning time by ComputeSPAKE2PVerifierData) and the raw Matter PASE
-// context bytes.
+// context bytes. It uses the SPAKE2PConstantTime backend;
+// CryptoProvider.NewSPAKE2PVerifier selects another.
 func NewSPAKE2PVerifier(w0, l, context []byte) (*SPAKE2PVerifier, error) {
+	return newSPAKE2PVerifier(nistecGroup{}, &DefaultCryptoProvider{}, nil, w0, l, context)
+}
+
+func newSPAKE2PVerifier(group spakeGroup, hashes spakeHashes, rand io.Reader, w0, l, context []byte) (*SPAKE2PVerifier, error) {
 	if len(w0) == 0 {
 		return nil, errors.New("crypto/spake2plus: w0 must be non-empty")
 	}
-	lx, ly, err := unmarshalP256Point(l)
+	w0s, err := spakeScalar(w0)
 	if err != nil {
+		return nil, err
+	}
+	if err := group.checkPoint(l); err != nil {
 		return nil, fmt.Errorf("crypto/spake2plus: L: %w", err)
 	}
 	return &SPAKE2PVerifier{
+		group:   group,
+		hashes:  hashes,
+		rand:    rand,
 		w0:      bytes.Clone(w0),
+		w0s:     w0s,
 		lBytes:  bytes.Clone(l),
-		lx:      lx,
-		ly:      ly,
 		context: bytes.Clone(context),
 	}, nil
 }
 
 // ComputePB ingests the peer's pA, generates the ephemeral scalar y, and
 // returns pB = y·G + w0·N. It also pre-computes Z = y·(pA − w0·M) and
-// V = y·L so Finalize can derive keys without further EC work.
+// V = y·L so Finalize can derive keys without further EC work. The scalar
+// y is not retained.
 func (v *SPAKE2PVerifier) ComputePB(pA []byte) ([]byte, error) {
-	pAx, pAy, err := unmarshalP256Point(pA)
-	if err != nil {
+	if err := v.group.checkPoint(pA); err != nil {
 		return nil, err
 	}
-	v.pA = bytes.Clone(pA)
-
-	y, err := spakeRandomScalar()
+	y, err := spakeRandomScalar(v.rand)
 	if err != nil {
 		return nil, err
 	}
-	v.y = y
 
 	// pB = y·G + w0·N
-	npx, npy := spake2pCurve.ScalarMult(spakeNx, spakeNy, v.w0)
-	tpx, tpy := spake2pCurve.ScalarBaseMult(y.Bytes())
-	pBx, pBy := spake2pCurve.Add(tpx, tpy, npx, npy)
-	v.pB = elliptic.Marshal(spake2pCurve, pBx, pBy)
+	n, err := v.group.scalarMult(spakeNBytes, v.w0s)
+	if err != nil {
+		return nil, err
+	}
+	t, err := v.group.scalarBaseMult(y)
+	if err != nil {
+		return nil, err
+	}
+	pB, err := v.group.add(t, n)
+	if err != nil {
+		return nil, err
+	}
 
 	// Z = y · (pA − w0·M); V = y · L
-	wmx, wmy := spake2pCurve.ScalarMult(spakeMx, spakeMy, v.w0)
-	negMx, negMy := spakeNegateY(wmx, wmy)
-	dx, dy := spake2pCurve.Add(pAx, pAy, negMx, negMy)
-	zx, zy := spake2pCurve.ScalarMult(dx, dy, y.Bytes())
-	vx, vy := spake2pCurve.ScalarMult(v.lx, v.ly, y.Bytes())
-	v.zV = elliptic.Marshal(spake2pCurve, zx, zy)
-	v.vV = elliptic.Marshal(spake2pCurve, vx, vy)
+	wm, err := v.group.scalarMult(spakeMBytes, v.w0s)
+	if err != nil {
+		return nil, err
+	}
+	d, err := v.group.sub(pA, wm)
+	if err != nil {
+		return nil, err
+	}
+	z, err := v.group.scalarMult(d, y)
+	if err != nil {
+		return nil, err
+	}
+	vv, err := v.group.scalarMult(v.lBytes, y)
+	if err != nil {
+		return nil, err
+	}
 
+	v.pA, v.pB, v.zV, v.vV = bytes.Clone(pA), pB, z, vv
 	return bytes.Clone(v.pB), nil
 }
 
@@ -383,7 +442,7 @@ func (v *SPAKE2PVerifier) Finalize() error {
 	if v.zV == nil || v.vV == nil {
 		return errors.New("crypto/spake2plus: ComputePB must be called before Finalize")
 	}
-	ke, cA, cB, err := spakeFinalize(v.context, v.pA, v.pB, v.zV, v.vV, v.w0)
+	ke, cA, cB, err := spakeFinalize(v.hashes, v.context, v.pA, v.pB, v.zV, v.vV, v.w0)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,198 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+
+	"filippo.io/nistec"
+)
+
+// SPAKE2PBackend selects the P-256 arithmetic behind SPAKE2PProver and
+// SPAKE2PVerifier. Both backends produce byte-identical transcripts; they
+// differ only in side-channel properties.
+type SPAKE2PBackend int
+
+const (
+	// SPAKE2PConstantTime runs all point arithmetic on filippo.io/nistec,
+	// whose field and scalar operations are constant time. This is the
+	// default.
+	SPAKE2PConstantTime SPAKE2PBackend = iota
+
+	// SPAKE2PGeneric runs on the deprecated crypto/elliptic big.Int API.
+	// Point coordinates and scalars round-trip through math/big, which is
+	// not constant time. Kept for cross-checking the constant-time backend.
+	SPAKE2PGeneric
+)
+
+func (b SPAKE2PBackend) String() string {
+	switch b {
+	case SPAKE2PConstantTime:
+		return "constant-time"
+	case SPAKE2PGeneric:
+		return "generic"
+	default:
+		return fmt.Sprintf("SPAKE2PBackend(%d)", int(b))
+	}
+}
+
+// spakeGroup is the P-256 arithmetic SPAKE2+ needs. Points cross the
+// interface as 65-byte uncompressed SEC1 encodings and scalars as 32-byte
+// big-endian values, so the transcript code above it is backend-agnostic.
+// Every operation rejects malformed input and refuses to return the point
+// at infinity, which has no uncompressed encoding.
+type spakeGroup interface {
+	checkPoint(p []byte) error
+	scalarBaseMult(k []byte) ([]byte, error)
+	scalarMult(p, k []byte) ([]byte, error)
+	add(p, q []byte) ([]byte, error)
+	sub(p, q []byte) ([]byte, error)
+}
+
+func spakeGroupFor(b SPAKE2PBackend) (spakeGroup, error) {
+	switch b {
+	case SPAKE2PConstantTime:
+		return nistecGroup{}, nil
+	case SPAKE2PGeneric:
+		return ellipticGroup{}, nil
+	default:
+		return nil, fmt.Errorf("crypto/spake2plus: unknown backend %v", b)
+	}
+}
+
+// spakeScalar left-pads a big-endian scalar of at most 32 bytes to exactly
+// 32, the fixed width nistec requires. Fixed-width scalars also keep the
+// generic backend from leaking scalar length through big.Int.Bytes.
+func spakeScalar(k []byte) ([]byte, error) {
+	if len(k) > 32 {
+		return nil, fmt.Errorf("crypto/spake2plus: scalar is %d bytes, want at most 32", len(k))
+	}
+	out := make([]byte, 32)
+	copy(out[32-len(k):], k)
+	return out, nil
+}
+
+// nistecGroup is the constant-time backend.
+type nistecGroup struct{}
+
+func (nistecGroup) point(p []byte) (*nistec.P256Point, error) {
+	// SetBytes also accepts compressed points and the 1-byte infinity
+	// encoding; SPAKE2+ only ever exchanges uncompressed points.
+	if len(p) != 65 || p[0] != 0x04 {
+		return nil, ErrInvalidPoint
+	}
+	pt, err := nistec.NewP256Point().SetBytes(p)
+	if err != nil {
+		return nil, ErrInvalidPoint
+	}
+	return pt, nil
+}
+
+func (nistecGroup) encode(p *nistec.P256Point) ([]byte, error) {
+	if p.IsInfinity() == 1 {
+		return nil, ErrInvalidPoint
+	}
+	return p.Bytes(), nil
+}
+
+func (g nistecGroup) checkPoint(p []byte) error {
+	_, err := g.point(p)
+	return err
+}
+
+func (g nistecGroup) scalarBaseMult(k []byte) ([]byte, error) {
+	r, err := nistec.NewP256Point().ScalarBaseMult(k)
+	if err != nil {
+		return nil, fmt.Errorf("crypto/spake2plus: %w", err)
+	}
+	return g.encode(r)
+}
+
+func (g nistecGroup) scalarMult(p, k []byte) ([]byte, error) {
+	pt, err := g.point(p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := nistec.NewP256Point().ScalarMult(pt, k)
+	if err != nil {
+		return nil, fmt.Errorf("crypto/spake2plus: %w", err)
+	}
+	return g.encode(r)
+}
+
+func (g nistecGroup) add(p, q []byte) ([]byte, error) {
+	a, err := g.point(p)
+	if err != nil {
+		return nil, err
+	}
+	b, err := g.point(q)
+	if err != nil {
+		return nil, err
+	}
+	return g.encode(nistec.NewP256Point().Add(a, b))
+}
+
+func (g nistecGroup) sub(p, q []byte) ([]byte, error) {
+	a, err := g.point(p)
+	if err != nil {
+		return nil, err
+	}
+	b, err := g.point(q)
+	if err != nil {
+		return nil, err
+	}
+	return g.encode(nistec.NewP256Point().Add(a, b.Negate(b)))
+}
+
+// ellipticGroup is the generic crypto/elliptic backend the package
+// originally shipped with.
+type ellipticGroup struct{}
+
+func (ellipticGroup) encode(x, y *big.Int) ([]byte, error) {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, ErrInvalidPoint
+	}
+	return elliptic.Marshal(spake2pCurve, x, y), nil
+}
+
+func (ellipticGroup) checkPoint(p []byte) error {
+	_, _, err := unmarshalP256Point(p)
+	return err
+}
+
+func (g ellipticGroup) scalarBaseMult(k []byte) ([]byte, error) {
+	return g.encode(spake2pCurve.ScalarBaseMult(k))
+}
+
+func (g ellipticGroup) scalarMult(p, k []byte) ([]byte, error) {
+	x, y, err := unmarshalP256Point(p)
+	if err != nil {
+		return nil, err
+	}
+	return g.encode(spake2pCurve.ScalarMult(x, y, k))
+}
+
+func (g ellipticGroup) add(p, q []byte) ([]byte, error) {
+	px, py, err := unmarshalP256Point(p)
+	if err != nil {
+		return nil, err
+	}
+	qx, qy, err := unmarshalP256Point(q)
+	if err != nil {
+		return nil, err
+	}
+	return g.encode(spake2pCurve.Add(px, py, qx, qy))
+}
+
+func (g ellipticGroup) sub(p, q []byte) ([]byte, error) {
+	px, py, err := unmarshalP256Point(p)
+	if err != nil {
+		return nil, err
+	}
+	qx, qy, err := unmarshalP256Point(q)
+	if err != nil {
+		return nil, err
+	}
+	nx, ny := spakeNegateY(qx, qy)
+	return g.encode(spake2pCurve.Add(px, py, nx, ny))
+}
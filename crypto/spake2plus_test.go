@@ -5,6 +5,7 @@ import (
 	"crypto/elliptic"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"io"
 	"testing"
 )
@@ -12,6 +13,13 @@ import (
 const testContextString = "CHIP PAKE V1 Commissioning"
 
 func freshProverVerifier(t *testing.T, passcode uint32, salt []byte, iters int, ctx []byte) (*SPAKE2PProver, *SPAKE2PVerifier) {
+	t.Helper()
+	return freshProverVerifierWith(t, &DefaultCryptoProvider{}, &DefaultCryptoProvider{}, passcode, salt, iters, ctx)
+}
+
+// freshProverVerifierWith builds the Prover on pp and the Verifier on vp, so
+// tests can pin or mix SPAKE2+ backends.
+func freshProverVerifierWith(t *testing.T, pp, vp CryptoProvider, passcode uint32, salt []byte, iters int, ctx []byte) (*SPAKE2PProver, *SPAKE2PVerifier) {
 	t.Helper()
 	w0, w1, err := Spake2pW0W1FromPasscode(passcode, salt, iters)
 	if err != nil {
@@ -24,17 +32,19 @@ func freshProverVerifier(t *testing.T, passcode uint32, salt []byte, iters int,
 	if !bytes.Equal(w0, w0Verifier) {
 		t.Fatalf("w0 mismatch between Prover and Verifier derivation")
 	}
-	prover, err := NewSPAKE2PProver(w0, w1, ctx)
+	prover, err := pp.NewSPAKE2PProver(w0, w1, ctx)
 	if err != nil {
 		t.Fatalf("NewSPAKE2PProver: %v", err)
 	}
-	verifier, err := NewSPAKE2PVerifier(w0, L, ctx)
+	verifier, err := vp.NewSPAKE2PVerifier(w0, L, ctx)
 	if err != nil {
 		t.Fatalf("NewSPAKE2PVerifier: %v", err)
 	}
 	return prover, verifier
 }
 
+var spakeBackends = []SPAKE2PBackend{SPAKE2PConstantTime, SPAKE2PGeneric}
+
 // TestRoundTrip — Prover and Verifier seeded from the same passcode complete
 // the protocol with matching Ke and cross-verifying confirmations.
 func TestRoundTrip(t *testing.T) {
@@ -227,10 +237,18 @@ func withDeterministicRand(t *testing.T, seed uint64) func() {
 
 // TestTranscriptDeterminism — with the secret-scalar source pinned, the
 // protocol's wire output (pA, pB, Ke, cA, cB) is byte-identical across
-// runs. Locks bytes against drift; if SPAKE2+ math/encoding changes
-// unexpectedly, this test fails and must be cross-checked against
-// connectedhomeip before being re-pinned.
+// runs and across SPAKE2+ backends. Locks bytes against drift; if SPAKE2+
+// math/encoding changes unexpectedly, this test fails and must be
+// cross-checked against connectedhomeip before being re-pinned.
 func TestTranscriptDeterminism(t *testing.T) {
+	for _, b := range spakeBackends {
+		t.Run(b.String(), func(t *testing.T) {
+			testTranscriptDeterminism(t, &DefaultCryptoProvider{SPAKE2P: b})
+		})
+	}
+}
+
+func testTranscriptDeterminism(t *testing.T, provider CryptoProvider) {
 	salt := []byte("SPAKE2P Key Salt")
 	ctxStr := []byte(testContextString)
 
@@ -239,7 +257,7 @@ func TestTranscriptDeterminism(t *testing.T) {
 	}
 	run := func() result {
 		defer withDeterministicRand(t, 0x42)()
-		prover, verifier := freshProverVerifier(t, 20202021, salt, 1000, ctxStr)
+		prover, verifier := freshProverVerifierWith(t, provider, provider, 20202021, salt, 1000, ctxStr)
 		pA, err := prover.ComputePA()
 		if err != nil {
 			t.Fatalf("ComputePA: %v", err)
@@ -340,3 +358,74 @@ func TestFinalizeRejectsDoubleCall(t *testing.T) {
 // Smoke test: counterReader returns predictable bytes and the test helper
 // io.Reader interface is wired correctly.
 var _ io.Reader = (*counterReader)(nil)
+
+// TestCrossBackendInterop — a Prover on one backend completes the handshake
+// with a Verifier on the other, in both directions.
+func TestCrossBackendInterop(t *testing.T) {
+	for _, pb := range spakeBackends {
+		for _, vb := range spakeBackends {
+			t.Run(pb.String()+"/"+vb.String(), func(t *testing.T) {
+				prover, verifier := freshProverVerifierWith(t,
+					&DefaultCryptoProvider{SPAKE2P: pb}, &DefaultCryptoProvider{SPAKE2P: vb},
+					20202021, []byte("SPAKE2P Key Salt"), 1000, []byte(testContextString))
+				pA, err := prover.ComputePA()
+				if err != nil {
+					t.Fatalf("ComputePA: %v", err)
+				}
+				pB, err := verifier.ComputePB(pA)
+				if err != nil {
+					t.Fatalf("ComputePB: %v", err)
+				}
+				if err := prover.Finalize(pB); err != nil {
+					t.Fatalf("Prover.Finalize: %v", err)
+				}
+				if err := verifier.Finalize(); err != nil {
+					t.Fatalf("Verifier.Finalize: %v", err)
+				}
+				cA, _ := prover.ConfirmationA()
+				cB, _ := verifier.ConfirmationB()
+				if err := verifier.VerifyConfirmationA(cA); err != nil {
+					t.Errorf("VerifyConfirmationA: %v", err)
+				}
+				if err := prover.VerifyConfirmationB(cB); err != nil {
+					t.Errorf("VerifyConfirmationB: %v", err)
+				}
+			})
+		}
+	}
+}
+
+// TestRejectIdentityIntermediate — a pA equal to w0·M makes pA − w0·M the
+// point at infinity. Both backends must refuse rather than hash a bogus
+// encoding into the transcript.
+func TestRejectIdentityIntermediate(t *testing.T) {
+	w0, _, err := Spake2pW0W1FromPasscode(20202021, []byte("SPAKE2P Key Salt"), 1000)
+	if err != nil {
+		t.Fatalf("derive w0: %v", err)
+	}
+	w0s, _ := spakeScalar(w0)
+	for _, b := range spakeBackends {
+		t.Run(b.String(), func(t *testing.T) {
+			provider := &DefaultCryptoProvider{SPAKE2P: b}
+			_, verifier := freshProverVerifierWith(t, provider, provider,
+				20202021, []byte("SPAKE2P Key Salt"), 1000, []byte(testContextString))
+			group, _ := spakeGroupFor(b)
+			pA, err := group.scalarMult(spakeMBytes, w0s)
+			if err != nil {
+				t.Fatalf("w0·M: %v", err)
+			}
+			if _, err := verifier.ComputePB(pA); !errors.Is(err, ErrInvalidPoint) {
+				t.Errorf("ComputePB(w0·M) = %v, want ErrInvalidPoint", err)
+			}
+		})
+	}
+}
+
+func TestSPAKE2PRejectsOversizedScalar(t *testing.T) {
+	if _, err := NewSPAKE2PProver(make([]byte, 33), []byte{1}, nil); err == nil {
+		t.Error("NewSPAKE2PProver accepted a 33-byte w0")
+	}
+	if _, err := (&DefaultCryptoProvider{SPAKE2P: SPAKE2PBackend(99)}).NewSPAKE2PVerifier([]byte{1}, spakeMBytes, nil); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
@@ -0,0 +1,172 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// PBKDF parameter bounds for PASE (Matter §3.9, §5.1.6.2). Devices must not
+// advertise parameters outside these ranges.
+const (
+	MinPBKDFIterations = 1000
+	MaxPBKDFIterations = 100000
+	MinPBKDFSaltLength = 16
+	MaxPBKDFSaltLength = 32
+)
+
+// SPAKE2PVerifierDataSize is the serialised length of SPAKE2PVerifierData:
+// a fixed-width 32-byte w0 followed by the 65-byte uncompressed L. This is
+// the layout connectedhomeip's spake2p tool and factory-data files use.
+const SPAKE2PVerifierDataSize = 32 + 65
+
+// ErrInvalidVerifier is returned when serialised verifier data is malformed
+// or w0 / L are outside their groups.
+var ErrInvalidVerifier = errors.New("crypto: invalid SPAKE2+ verifier data")
+
+// SPAKE2PVerifierData is the (w0, L) pair a device persists in place of its
+// setup passcode. w0 is held in the minimal big-endian encoding produced by
+// Spake2pW0W1FromPasscode, because that is what the SPAKE2+ transcript
+// hashes; only the serialised form is fixed-width.
+type SPAKE2PVerifierData struct {
+	W0 []byte
+	L  []byte
+}
+
+// GeneratePasscode returns a uniformly random passcode that satisfies
+// ValidatePasscode.
+func GeneratePasscode() (uint32, error) {
+	limit := big.NewInt(int64(MaxPasscode))
+	for {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return 0, fmt.Errorf("crypto: generate passcode: %w", err)
+		}
+		passcode := uint32(n.Int64()) + 1
+		if ValidatePasscode(passcode) == nil {
+			return passcode, nil
+		}
+	}
+}
+
+// GenerateSalt returns a random PBKDF salt of MaxPBKDFSaltLength bytes.
+func GenerateSalt() ([]byte, error) {
+	salt := make([]byte, MaxPBKDFSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("crypto: generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// ValidatePBKDFParams checks salt length and iteration count against the
+// Matter §3.9 bounds.
+func ValidatePBKDFParams(salt []byte, iterations int) error {
+	if len(salt) < MinPBKDFSaltLength || len(salt) > MaxPBKDFSaltLength {
+		return fmt.Errorf("crypto: PBKDF salt length %d outside %d..%d",
+			len(salt), MinPBKDFSaltLength, MaxPBKDFSaltLength)
+	}
+	if iterations < MinPBKDFIterations || iterations > MaxPBKDFIterations {
+		return fmt.Errorf("crypto: PBKDF iterations %d outside %d..%d",
+			iterations, MinPBKDFIterations, MaxPBKDFIterations)
+	}
+	return nil
+}
+
+// NewSPAKE2PVerifierData validates passcode and PBKDF parameters, then
+// derives the verifier a device should store. Unlike
+// ComputeSPAKE2PVerifierData it refuses trivial passcodes and
+// out-of-spec salts, so it is the one provisioning tools should call.
+func NewSPAKE2PVerifierData(passcode uint32, salt []byte, iterations int) (*SPAKE2PVerifierData, error) {
+	if err := ValidatePasscode(passcode); err != nil {
+		return nil, err
+	}
+	if err := ValidatePBKDFParams(salt, iterations); err != nil {
+		return nil, err
+	}
+	w0, L, err := ComputeSPAKE2PVerifierData(passcode, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	return &SPAKE2PVerifierData{W0: w0, L: L}, nil
+}
+
+// Validate checks that w0 is a scalar below the P-256 group order and that
+// L is an uncompressed point on the curve.
+func (d *SPAKE2PVerifierData) Validate() error {
+	if len(d.W0) == 0 || len(d.W0) > 32 {
+		return fmt.Errorf("%w: w0 is %d bytes", ErrInvalidVerifier, len(d.W0))
+	}
+	if new(big.Int).SetBytes(d.W0).Cmp(elliptic.P256().Params().N) >= 0 {
+		return fmt.Errorf("%w: w0 is not reduced mod n", ErrInvalidVerifier)
+	}
+	if _, _, err := unmarshalP256Point(d.L); err != nil {
+		return fmt.Errorf("%w: L: %w", ErrInvalidVerifier, err)
+	}
+	return nil
+}
+
+// Matches reports whether the verifier was derived from passcode under the
+// given PBKDF parameters. Useful for checking factory data against the
+// passcode printed on the label.
+func (d *SPAKE2PVerifierData) Matches(passcode uint32, salt []byte, iterations int) bool {
+	w0, L, err := ComputeSPAKE2PVerifierData(passcode, salt, iterations)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(w0, d.W0)&subtle.ConstantTimeCompare(L, d.L) == 1
+}
+
+// MarshalBinary encodes the verifier as w0 (32 bytes, left-padded) || L.
+func (d *SPAKE2PVerifierData) MarshalBinary() ([]byte, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	out := make([]byte, SPAKE2PVerifierDataSize)
+	new(big.Int).SetBytes(d.W0).FillBytes(out[:32])
+	copy(out[32:], d.L)
+	return out, nil
+}
+
+// UnmarshalBinary decodes and validates the MarshalBinary layout. w0 is
+// normalised back to its minimal encoding so a round-tripped verifier
+// produces the same SPAKE2+ transcript as a freshly derived one.
+func (d *SPAKE2PVerifierData) UnmarshalBinary(b []byte) error {
+	if len(b) != SPAKE2PVerifierDataSize {
+		return fmt.Errorf("%w: %d bytes, want %d", ErrInvalidVerifier, len(b), SPAKE2PVerifierDataSize)
+	}
+	v := SPAKE2PVerifierData{
+		W0: new(big.Int).SetBytes(b[:32]).Bytes(),
+		L:  append([]byte(nil), b[32:]...),
+	}
+	if err := v.Validate(); err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// MarshalText encodes the verifier as standard base64 of MarshalBinary,
+// the form factory-data JSON and connectedhomeip's spake2p tool emit.
+func (d *SPAKE2PVerifierData) MarshalText() ([]byte, error) {
+	b, err := d.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(out, b)
+	return out, nil
+}
+
+// UnmarshalText is the inverse of MarshalText.
+func (d *SPAKE2PVerifierData) UnmarshalText(text []byte) error {
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(b, text)
+	if err != nil {
+		return fmt.Errorf("%w: base64: %w", ErrInvalidVerifier, err)
+	}
+	return d.UnmarshalBinary(b[:n])
+}
@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testVerifierSalt = []byte("SPAKE2P Key Salt")
+
+func TestGeneratePasscode(t *testing.T) {
+	for range 100 {
+		p, err := GeneratePasscode()
+		if err != nil {
+			t.Fatalf("GeneratePasscode: %v", err)
+		}
+		if err := ValidatePasscode(p); err != nil {
+			t.Fatalf("GeneratePasscode returned %d: %v", p, err)
+		}
+	}
+}
+
+func TestValidatePBKDFParams(t *testing.T) {
+	cases := []struct {
+		saltLen, iterations int
+		ok                  bool
+	}{
+		{16, 1000, true},
+		{32, 100000, true},
+		{15, 1000, false},
+		{33, 1000, false},
+		{16, 999, false},
+		{16, 100001, false},
+	}
+	for _, c := range cases {
+		err := ValidatePBKDFParams(make([]byte, c.saltLen), c.iterations)
+		if (err == nil) != c.ok {
+			t.Errorf("ValidatePBKDFParams(salt=%d, iter=%d) = %v, want ok=%v", c.saltLen, c.iterations, err, c.ok)
+		}
+	}
+}
+
+func TestNewSPAKE2PVerifierData_RejectsBadInputs(t *testing.T) {
+	if _, err := NewSPAKE2PVerifierData(12345678, testVerifierSalt, 1000); !errors.Is(err, ErrInvalidPasscode) {
+		t.Errorf("trivial passcode: err = %v, want ErrInvalidPasscode", err)
+	}
+	if _, err := NewSPAKE2PVerifierData(20202021, []byte("short"), 1000); err == nil {
+		t.Error("short salt: expected error")
+	}
+}
+
+func TestSPAKE2PVerifierData_RoundTrip(t *testing.T) {
+	v, err := NewSPAKE2PVerifierData(20202021, testVerifierSalt, 1000)
+	if err != nil {
+		t.Fatalf("NewSPAKE2PVerifierData: %v", err)
+	}
+	if !v.Matches(20202021, testVerifierSalt, 1000) {
+		t.Error("Matches(original passcode) = false")
+	}
+	if v.Matches(20202022, testVerifierSalt, 1000) {
+		t.Error("Matches(other passcode) = true")
+	}
+
+	bin, err := v.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	if len(bin) != SPAKE2PVerifierDataSize {
+		t.Fatalf("MarshalBinary length = %d, want %d", len(bin), SPAKE2PVerifierDataSize)
+	}
+	text, err := v.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+	var got SPAKE2PVerifierData
+	if err := got.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText: %v", err)
+	}
+	if !bytes.Equal(got.W0, v.W0) || !bytes.Equal(got.L, v.L) {
+		t.Fatal("text round trip changed the verifier")
+	}
+}
+
+// A w0 with leading zero bytes must come back in minimal form, otherwise
+// the SPAKE2+ transcript would differ from a freshly derived verifier.
+func TestSPAKE2PVerifierData_NormalisesW0(t *testing.T) {
+	v, err := NewSPAKE2PVerifierData(20202021, testVerifierSalt, 1000)
+	if err != nil {
+		t.Fatalf("NewSPAKE2PVerifierData: %v", err)
+	}
+	short := &SPAKE2PVerifierData{W0: []byte{0x01, 0x02}, L: v.L}
+	bin, err := short.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var got SPAKE2PVerifierData
+	if err := got.UnmarshalBinary(bin); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if !bytes.Equal(got.W0, short.W0) {
+		t.Errorf("W0 = %x, want %x", got.W0, short.W0)
+	}
+}
+
+func TestSPAKE2PVerifierData_UnmarshalRejectsMalformed(t *testing.T) {
+	v, err := NewSPAKE2PVerifierData(20202021, testVerifierSalt, 1000)
+	if err != nil {
+		t.Fatalf("NewSPAKE2PVerifierData: %v", err)
+	}
+	bin, _ := v.MarshalBinary()
+
+	var d SPAKE2PVerifierData
+	if err := d.UnmarshalBinary(bin[:96]); !errors.Is(err, ErrInvalidVerifier) {
+		t.Errorf("short input: err = %v, want ErrInvalidVerifier", err)
+	}
+
+	offCurve := append([]byte(nil), bin...)
+	offCurve[len(offCurve)-1] ^= 0x01
+	if err := d.UnmarshalBinary(offCurve); !errors.Is(err, ErrInvalidVerifier) {
+		t.Errorf("L off curve: err = %v, want ErrInvalidVerifier", err)
+	}
+
+	bigW0 := append([]byte(nil), bin...)
+	for i := range 32 {
+		bigW0[i] = 0xFF
+	}
+	if err := d.UnmarshalBinary(bigW0); !errors.Is(err, ErrInvalidVerifier) {
+		t.Errorf("w0 >= n: err = %v, want ErrInvalidVerifier", err)
+	}
+
+	if err := d.UnmarshalText([]byte("not base64!")); !errors.Is(err, ErrInvalidVerifier) {
+		t.Errorf("bad base64: err = %v, want ErrInvalidVerifier", err)
+	}
+}
@@ -0,0 +1,291 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-matter/message"
+)
+
+// Exchange is one conversation with a peer: a request and its responses,
+// or a handshake. A protocol reads the peer's messages from Inbox and
+// answers with Send; the manager takes care of the session, counters and
+// transport. Safe for concurrent use.
+type Exchange struct {
+	mgr      *ExchangeManager
+	key      exchangeKey
+	peer     net.Addr
+	protocol message.ProtocolID
+	inbox    chan *message.Frame
+
+	// mu guards closing, so a message is never delivered to a closed
+	// inbox, the inbox slots claimed by reserve, and the MRP state below.
+	mu           sync.Mutex
+	closed       bool
+	err          error
+	reserved     int          // inbox slots claimed by messages not yet delivered
+	lastActivity atomic.Int64 // UnixNano
+
+	// MRP; see mrp.go. A closed exchange stays in the manager's table
+	// until unacked is acknowledged or given up on.
+	unacked     *retransmission
+	ackPending  bool // a received reliable message awaits its ack
+	ackCounter  uint32
+	ackDeadline time.Time
+	lastRx      time.Time
+	refused     uint32 // counter of a message dropped unacked for want of inbox space
+	hasRefused  bool
+}
+
+// ID returns the exchange ID carried in every message of the exchange.
+func (ex *Exchange) ID() uint16 { return ex.key.id }
+
+// SessionID returns the local ID of the session the exchange runs on.
+func (ex *Exchange) SessionID() uint16 { return ex.key.sessionID }
+
+// Peer returns the transport address messages are sent to.
+func (ex *Exchange) Peer() net.Addr { return ex.peer }
+
+// Initiator reports whether this node opened the exchange.
+func (ex *Exchange) Initiator() bool { return ex.key.initiator }
+
+// Protocol returns the protocol ID Send stamps on outbound messages.
+func (ex *Exchange) Protocol() message.ProtocolID { return ex.protocol }
+
+// Inbox delivers the peer's messages in arrival order. It is closed when
+// the exchange closes; Err then says why. Receive and Request wrap it with
+// cancellation and StatusReport handling.
+func (ex *Exchange) Inbox() <-chan *message.Frame { return ex.inbox }
+
+// Send sends one message on the exchange. payload is encoded as by
+// message.Builder.Payload. The message is reliable: it asks for an ack,
+// carries the ack for the peer's last message if one is owed, and is
+// retransmitted by Tick until acknowledged. Send fails with ErrAckPending
+// while the previous message is still unacknowledged.
+func (ex *Exchange) Send(opcode message.Opcode, payload any) error {
+	return ex.send(ex.protocol, opcode, payload)
+}
+
+// SendStatusReport sends r as a Secure Channel StatusReport, whatever the
+// exchange's protocol (Matter §4.11.1).
+func (ex *Exchange) SendStatusReport(r message.StatusReport) error {
+	payload, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	return ex.send(message.ProtocolSecureChannel, message.OpcodeStatusReport, payload)
+}
+
+// Receive waits for the peer's next message. It fails with ctx's error if
+// ctx ends first, with Err if the exchange closes, and with a *StatusError,
+// alongside the frame, if the message is a StatusReport reporting failure.
+// A cancelled or expired ctx closes the exchange: the awaited message may
+// still arrive, and would otherwise be taken as the answer to whatever is
+// sent next.
+func (ex *Exchange) Receive(ctx context.Context) (*message.Frame, error) {
+	select {
+	case f, ok := <-ex.inbox:
+		if !ok {
+			return nil, ex.Err()
+		}
+		return f, statusError(f)
+	case <-ctx.Done():
+		ex.close(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// Request sends one message and waits for the reply, as Send then Receive.
+func (ex *Exchange) Request(ctx context.Context, opcode message.Opcode, payload any) (*message.Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := ex.Send(opcode, payload); err != nil {
+		return nil, err
+	}
+	return ex.Receive(ctx)
+}
+
+// StatusError is returned by Receive and Request when the peer answers with
+// a StatusReport whose general code is not success.
+type StatusError struct {
+	Report message.StatusReport
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("exchange: peer reported %v", &e.Report)
+}
+
+// statusError returns the *StatusError f carries, if any. A StatusReport
+// too short to parse is itself an error.
+func statusError(f *message.Frame) error {
+	if !message.IsStatusReport(f) {
+		return nil
+	}
+	var r message.StatusReport
+	if err := r.Unmarshal(f.Payload); err != nil {
+		return fmt.Errorf("exchange: status report: %w", err)
+	}
+	if r.Success() {
+		return nil
+	}
+	return &StatusError{Report: r}
+}
+
+func (ex *Exchange) send(protocol message.ProtocolID, opcode message.Opcode, payload any) error {
+	ex.mu.Lock()
+	if ex.closed {
+		defer ex.mu.Unlock()
+		return ex.err
+	}
+	if ex.unacked != nil {
+		ex.mu.Unlock()
+		return ErrAckPending
+	}
+	b := message.NewBuilder().
+		Protocol(protocol).
+		Opcode(opcode).
+		ExchangeID(ex.key.id).
+		RequestAck().
+		Payload(payload)
+	if ex.key.initiator {
+		b.Initiator()
+	}
+	if ex.ackPending {
+		b.AckCounter(ex.ackCounter)
+	}
+	f, err := b.Build()
+	if err != nil {
+		ex.mu.Unlock()
+		return err
+	}
+	wire, err := ex.mgr.sessions.SealFrame(ex.key.sessionID, f)
+	if err != nil {
+		ex.mu.Unlock()
+		return err
+	}
+	now := ex.mgr.clock.Now()
+	r := &retransmission{
+		wire:    wire,
+		counter: f.Header.MessageCounter,
+		sends:   1,
+		next:    now.Add(backoff(ex.retryInterval(now), 0, ex.mgr.random())),
+	}
+	ex.unacked = r
+	ex.ackPending = false
+	ex.mu.Unlock()
+	ex.mgr.kick()
+
+	// The transport may deliver synchronously and the peer's ack may
+	// re-enter Receive, so it is called without ex.mu.
+	if err := ex.mgr.transport.SendPacket(ex.peer, wire); err != nil {
+		ex.mu.Lock()
+		if ex.unacked == r {
+			ex.unacked = nil
+		}
+		ex.mu.Unlock()
+		return err
+	}
+	ex.touch()
+	return nil
+}
+
+// Close ends the exchange: Inbox is closed, further Sends fail with
+// ErrExchangeClosed, and an ack still owed to the peer is sent at once.
+// A message of ours still unacknowledged keeps being retransmitted by
+// Tick; the exchange leaves the manager's table once it is acknowledged
+// or given up on. Closing twice is a no-op.
+func (ex *Exchange) Close() {
+	ex.close(ErrExchangeClosed)
+}
+
+// Err returns nil while the exchange is open, and afterwards why it
+// closed: ErrExchangeClosed, ErrExchangeTimeout, ErrMessageNotAcknowledged,
+// or the error of the context that cancelled a Receive.
+func (ex *Exchange) Err() error {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return ex.err
+}
+
+// LastActivity returns the time of the last message sent or received.
+func (ex *Exchange) LastActivity() time.Time {
+	return time.Unix(0, ex.lastActivity.Load())
+}
+
+func (ex *Exchange) touch() {
+	ex.lastActivity.Store(ex.mgr.clock.Now().UnixNano())
+}
+
+// reserve claims an inbox slot for the message with counter, before it
+// is acknowledged. When the inbox is full it reports false and records
+// counter, so the message goes unacknowledged and its retransmission is
+// let through as new. A closed exchange claims nothing: its messages are
+// acknowledged and dropped.
+func (ex *Exchange) reserve(counter uint32) bool {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.closed {
+		return true
+	}
+	if len(ex.inbox)+ex.reserved >= cap(ex.inbox) {
+		ex.refused, ex.hasRefused = counter, true
+		return false
+	}
+	ex.reserved++
+	return true
+}
+
+// refusedEarlier reports whether counter is that of a message reserve
+// turned away, which the peer is now retransmitting.
+func (ex *Exchange) refusedEarlier(counter uint32) bool {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if !ex.hasRefused || ex.refused != counter {
+		return false
+	}
+	ex.hasRefused = false
+	return true
+}
+
+// deliver queues f, for which reserve claimed a slot, for the protocol.
+func (ex *Exchange) deliver(f *message.Frame) error {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.closed {
+		return ex.err
+	}
+	ex.reserved--
+	ex.inbox <- f
+	ex.touch()
+	return nil
+}
+
+// close closes ex with reason. Only a plain Close lets an unacknowledged
+// message finish its retransmissions.
+func (ex *Exchange) close(reason error) {
+	ex.mu.Lock()
+	if !ex.closed {
+		ex.closed = true
+		ex.err = reason
+		close(ex.inbox)
+	}
+	ack, ackCounter := ex.ackPending, ex.ackCounter
+	ex.ackPending = false
+	if reason != ErrExchangeClosed {
+		ex.unacked = nil
+	}
+	linger := ex.unacked != nil
+	ex.mu.Unlock()
+
+	if ack {
+		ex.mgr.sendStandaloneAck(ex.key, ex.peer, ackCounter)
+	}
+	if !linger {
+		ex.mgr.remove(ex)
+	}
+}
@@ -0,0 +1,363 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go-matter/crypto"
+	"go-matter/message"
+	"go-matter/session"
+)
+
+const (
+	ctrlSessionID = 10
+	devSessionID  = 20
+
+	opReadRequest   message.Opcode = 0x02
+	opReportData    message.Opcode = 0x05
+	opInvokeRequest message.Opcode = 0x08
+)
+
+// testAddr is a transport address that needs no sockets.
+type testAddr string
+
+func (a testAddr) Network() string { return "test" }
+func (a testAddr) String() string  { return string(a) }
+
+// link delivers every packet synchronously to the peer's Receive, as if
+// sent from addr, unless drop says to lose it. Every packet is recorded,
+// and Receive errors are too rather than returned, as a real transport
+// would drop the datagram.
+type link struct {
+	mu   sync.Mutex
+	peer *ExchangeManager
+	addr net.Addr
+	drop func(b []byte) bool
+	sent [][]byte
+	errs []error
+}
+
+func (l *link) SendPacket(_ net.Addr, b []byte) error {
+	l.mu.Lock()
+	l.sent = append(l.sent, b)
+	lost := l.drop != nil && l.drop(b)
+	l.mu.Unlock()
+	if lost {
+		return nil
+	}
+	if err := l.peer.Receive(b, l.addr); err != nil {
+		l.mu.Lock()
+		l.errs = append(l.errs, err)
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+func (l *link) packets() [][]byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([][]byte(nil), l.sent...)
+}
+
+func (l *link) errors() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errs...)
+}
+
+type testNode struct {
+	em   *ExchangeManager
+	sm   *session.SessionManager
+	addr testAddr
+	out  *link // packets this node sends
+}
+
+// pair returns a controller and device sharing one PASE-style secure
+// session, installed under different local IDs on each side.
+func pair(t *testing.T) (ctrl, dev *testNode) {
+	t.Helper()
+	keys, err := crypto.DeriveSessionKeysFromKe(bytes.Repeat([]byte{0x3C}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl = newNode("ctrl")
+	dev = newNode("dev")
+	ctrl.out.peer, dev.out.peer = dev.em, ctrl.em
+	if _, err := ctrl.sm.CreateSession(session.SessionParams{ID: ctrlSessionID, PeerSessionID: devSessionID, Keys: keys, Role: session.RoleInitiator}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dev.sm.CreateSession(session.SessionParams{ID: devSessionID, PeerSessionID: ctrlSessionID, Keys: keys, Role: session.RoleResponder}); err != nil {
+		t.Fatal(err)
+	}
+	return ctrl, dev
+}
+
+func newNode(name testAddr) *testNode {
+	sm := session.NewSessionManager(nil)
+	out := &link{addr: name}
+	return &testNode{em: NewExchangeManager(sm, out), sm: sm, addr: name, out: out}
+}
+
+func recv(t *testing.T, ex *Exchange) *message.Frame {
+	t.Helper()
+	select {
+	case f, ok := <-ex.Inbox():
+		if !ok {
+			t.Fatalf("inbox closed: %v", ex.Err())
+		}
+		return f
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+		return nil
+	}
+}
+
+// echo answers each request with opReportData carrying the same payload
+// and closes the exchange.
+func echo(ex *Exchange) {
+	defer ex.Close()
+	f, ok := <-ex.Inbox()
+	if ok {
+		ex.Send(opReportData, f.Payload)
+	}
+}
+
+func TestExchange_RequestResponse(t *testing.T) {
+	ctrl, dev := pair(t)
+	dev.em.RegisterUnsolicitedHandler(message.ProtocolInteractionModel, echo)
+
+	ex, err := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ex.Close()
+	if err := ex.Send(opReadRequest, []byte("attr")); err != nil {
+		t.Fatal(err)
+	}
+	f := recv(t, ex)
+	if f.PayloadHeader.Opcode != opReportData || string(f.Payload) != "attr" {
+		t.Errorf("reply = %+v", f)
+	}
+	if f.PayloadHeader.ExchangeID != ex.ID() || f.PayloadHeader.ExchangeFlags.Has(message.ExchangeFlagInitiator) {
+		t.Errorf("reply payload header = %+v, want exchange %d without I flag", f.PayloadHeader, ex.ID())
+	}
+	if f.Header.SessionID != ctrlSessionID {
+		t.Errorf("reply arrived on session %d", f.Header.SessionID)
+	}
+}
+
+func TestExchange_Request(t *testing.T) {
+	ctrl, dev := pair(t)
+	dev.em.RegisterUnsolicitedHandler(message.ProtocolInteractionModel, echo)
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	defer ex.Close()
+	f, err := ex.Request(context.Background(), opReadRequest, []byte("attr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.PayloadHeader.Opcode != opReportData || string(f.Payload) != "attr" {
+		t.Errorf("reply = %+v", f)
+	}
+}
+
+func TestExchange_RequestStatusReport(t *testing.T) {
+	for _, code := range []message.GeneralCode{message.GeneralCodeSuccess, message.GeneralCodeBusy} {
+		t.Run(code.String(), func(t *testing.T) {
+			ctrl, dev := pair(t)
+			dev.em.RegisterUnsolicitedHandler(message.ProtocolInteractionModel, func(ex *Exchange) {
+				defer ex.Close()
+				<-ex.Inbox()
+				ex.SendStatusReport(message.StatusReport{
+					GeneralCode:  code,
+					ProtocolID:   message.ProtocolSecureChannel,
+					ProtocolCode: message.SecureChannelBusy,
+				})
+			})
+			ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+			defer ex.Close()
+			f, err := ex.Request(context.Background(), opInvokeRequest, nil)
+			if f == nil || !message.IsStatusReport(f) {
+				t.Fatalf("reply = %+v, err = %v", f, err)
+			}
+			var se *StatusError
+			switch {
+			case code == message.GeneralCodeSuccess && err != nil:
+				t.Errorf("success report: err = %v", err)
+			case code != message.GeneralCodeSuccess && (!errors.As(err, &se) || se.Report.ProtocolCode != message.SecureChannelBusy):
+				t.Errorf("err = %v, want *StatusError with BUSY", err)
+			}
+		})
+	}
+}
+
+func TestExchange_RequestDeadline(t *testing.T) {
+	ctrl, dev := pair(t)
+	dev.em.RegisterUnsolicitedHandler(message.ProtocolInteractionModel, func(*Exchange) {}) // never answers
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ex.Request(ctx, opReadRequest, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if !errors.Is(ex.Err(), context.DeadlineExceeded) || ctrl.em.Len() != 0 {
+		t.Errorf("after deadline: Err = %v, Len = %d; want exchange closed", ex.Err(), ctrl.em.Len())
+	}
+}
+
+func TestExchange_RequestCancelled(t *testing.T) {
+	ctrl, dev := pair(t)
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ex.Request(ctx, opReadRequest, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want Canceled", err)
+	}
+	if n := len(ctrl.out.packets()); n != 0 {
+		t.Errorf("cancelled request sent %d packets", n)
+	}
+}
+
+func TestExchange_ReceiveAfterClose(t *testing.T) {
+	ctrl, dev := pair(t)
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	ex.close(ErrExchangeTimeout)
+	if _, err := ex.Receive(context.Background()); !errors.Is(err, ErrExchangeTimeout) {
+		t.Errorf("err = %v, want ErrExchangeTimeout", err)
+	}
+}
+
+func TestExchange_UnsolicitedWithoutHandler(t *testing.T) {
+	ctrl, dev := pair(t)
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	ex.Send(opReadRequest, nil)
+	if errs := ctrl.out.errors(); len(errs) != 1 || !errors.Is(errs[0], ErrNoUnsolicitedHandler) {
+		t.Errorf("receive errors = %v", errs)
+	}
+	if dev.em.Len() != 0 {
+		t.Errorf("device opened %d exchanges", dev.em.Len())
+	}
+}
+
+// TestExchange_ResponseWithoutExchange — a non-initiator message for an
+// exchange that is not open never reaches a handler.
+func TestExchange_ResponseWithoutExchange(t *testing.T) {
+	ctrl, dev := pair(t)
+	called := false
+	dev.em.RegisterUnsolicitedHandler(message.ProtocolInteractionModel, func(*Exchange) { called = true })
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	ex.key.initiator = false // forge a response
+	ex.Send(opReportData, nil)
+	if errs := ctrl.out.errors(); len(errs) != 1 || !errors.Is(errs[0], ErrUnknownExchange) {
+		t.Errorf("receive errors = %v", errs)
+	}
+	if called {
+		t.Error("handler ran for a response")
+	}
+}
+
+// TestExchange_SameIDBothDirections opens exchange 7 from each side of one
+// session; each message must reach the exchange its sender meant.
+func TestExchange_SameIDBothDirections(t *testing.T) {
+	ctrl, dev := pair(t)
+	incoming := make(chan *Exchange, 1)
+	ctrl.em.RegisterUnsolicitedHandler(message.ProtocolInteractionModel, func(ex *Exchange) { incoming <- ex })
+	dev.em.RegisterUnsolicitedHandler(message.ProtocolInteractionModel, func(ex *Exchange) { incoming <- ex })
+	ctrl.em.idSeeded, ctrl.em.nextID = true, 7
+	dev.em.idSeeded, dev.em.nextID = true, 7
+
+	ours, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	theirs, _ := dev.em.NewExchange(devSessionID, ctrl.addr, message.ProtocolInteractionModel)
+	if ours.ID() != 7 || theirs.ID() != 7 {
+		t.Fatalf("ids = %d, %d", ours.ID(), theirs.ID())
+	}
+	theirs.Send(opInvokeRequest, []byte("from device"))
+	var onCtrl *Exchange
+	select {
+	case onCtrl = <-incoming:
+	case <-time.After(time.Second):
+		t.Fatal("controller handler not called")
+	}
+	if onCtrl == ours || onCtrl.Initiator() {
+		t.Fatal("device's request was routed to the controller's own exchange")
+	}
+	if f := recv(t, onCtrl); string(f.Payload) != "from device" {
+		t.Errorf("payload = %q", f.Payload)
+	}
+	select {
+	case f := <-ours.Inbox():
+		t.Errorf("controller's exchange received %+v", f)
+	default:
+	}
+}
+
+// TestExchange_UnsecuredPeersSeparated — on session 0 two peers using the
+// same exchange ID are two exchanges.
+func TestExchange_UnsecuredPeersSeparated(t *testing.T) {
+	dev := newNode("dev")
+	opened := make(chan *Exchange, 2)
+	dev.em.RegisterUnsolicitedHandler(message.ProtocolSecureChannel, func(ex *Exchange) { opened <- ex })
+	for _, name := range []testAddr{"ctrl-a", "ctrl-b"} {
+		c := newNode(name)
+		c.out.peer = dev.em
+		c.em.idSeeded, c.em.nextID = true, 1
+		ex, _ := c.em.NewExchange(session.UnsecuredSessionID, dev.addr, message.ProtocolSecureChannel)
+		if err := ex.Send(message.OpcodePBKDFParamRequest, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := <-opened, <-opened
+	if a == b || a.ID() != b.ID() || a.Peer().String() == b.Peer().String() {
+		t.Errorf("exchanges: %v@%v and %v@%v", a.ID(), a.Peer(), b.ID(), b.Peer())
+	}
+	if dev.em.Len() != 2 {
+		t.Errorf("Len = %d, want 2", dev.em.Len())
+	}
+}
+
+func TestExchange_ExpireIdle(t *testing.T) {
+	ctrl, dev := pair(t)
+	clk := mrpNodes(ctrl, dev)
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+
+	clk.Advance(DefaultExchangeTimeout - time.Second)
+	if n := ctrl.em.ExpireIdleExchanges(); n != 0 {
+		t.Fatalf("expired %d before timeout", n)
+	}
+	clk.Advance(time.Second)
+	if n := ctrl.em.ExpireIdleExchanges(); n != 1 {
+		t.Fatalf("expired %d, want 1", n)
+	}
+	if _, ok := <-ex.Inbox(); ok {
+		t.Error("inbox still open")
+	}
+	if err := ex.Send(opReadRequest, nil); !errors.Is(err, ErrExchangeTimeout) {
+		t.Errorf("Send after expiry: err = %v", err)
+	}
+	if ctrl.em.Len() != 0 {
+		t.Errorf("Len = %d", ctrl.em.Len())
+	}
+}
+
+func TestExchange_AllocatesFreeIDs(t *testing.T) {
+	ctrl, dev := pair(t)
+	ctrl.em.idSeeded, ctrl.em.nextID = true, 0xFFFF
+	a, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	ctrl.em.nextID = 0xFFFF
+	b, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	if a.ID() != 0xFFFF || b.ID() != 0 {
+		t.Errorf("ids = %#x, %#x; want 0xffff then a wrap past the one in use", a.ID(), b.ID())
+	}
+	a.Close()
+	a.Close()
+	if ctrl.em.Len() != 1 {
+		t.Errorf("Len = %d after close", ctrl.em.Len())
+	}
+	if _, err := ctrl.em.NewExchange(99, dev.addr, message.ProtocolInteractionModel); !errors.Is(err, session.ErrUnknownSession) {
+		t.Errorf("unknown session: err = %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+// Package exchange routes messages between the session layer and the
+// protocols that converse over it (Matter §4.10). Every message belongs to
+// an exchange, identified by its session, exchange ID and which side
+// initiated it; an ExchangeManager keeps the table of open exchanges, hands
+// unsolicited messages to per-protocol handlers and expires exchanges that
+// go quiet. Protocols see only *Exchange — see
+// docs/Messaging_Architecture.md.
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/message"
+	"go-matter/session"
+	"go-matter/transport"
+)
+
+// DefaultExchangeTimeout is how long an exchange may go without traffic
+// before ExpireIdleExchanges closes it.
+const DefaultExchangeTimeout = 30 * time.Second
+
+// runIdlePoll bounds how long Run sleeps between idle-expiry checks when
+// no MRP work is scheduled.
+const runIdlePoll = time.Second
+
+// inboxSize bounds how many received messages an exchange buffers for its
+// protocol. A conversation is strictly request/response, so more than a
+// few queued messages means the protocol has stalled.
+const inboxSize = 8
+
+var (
+	// ErrUnknownExchange is returned for a message that answers an
+	// exchange this node does not have open.
+	ErrUnknownExchange = errors.New("exchange: no such exchange")
+	// ErrNoUnsolicitedHandler is returned for a message that opens an
+	// exchange for a protocol nobody registered.
+	ErrNoUnsolicitedHandler = errors.New("exchange: no handler for unsolicited message")
+	// ErrExchangeClosed is returned by Send on an exchange closed with
+	// Close.
+	ErrExchangeClosed = errors.New("exchange: exchange closed")
+	// ErrExchangeTimeout is returned by Send on an exchange closed by
+	// ExpireIdleExchanges.
+	ErrExchangeTimeout = errors.New("exchange: exchange timed out")
+
+	errInboxFull = errors.New("exchange: inbox full")
+)
+
+// Transport carries encoded messages to a peer address.
+// *transport.TransportManager satisfies it.
+type Transport interface {
+	SendPacket(addr net.Addr, b []byte) error
+}
+
+// UnsolicitedHandler runs a protocol's side of an exchange a peer opened.
+// It is called on its own goroutine with the opening message already in
+// ex.Inbox, and should Close the exchange when the conversation ends.
+type UnsolicitedHandler func(ex *Exchange)
+
+// Config carries the tunables for NewExchangeManagerWithConfig. The zero
+// value is valid and matches NewExchangeManager.
+type Config struct {
+	// Timeout bounds how long an exchange may go without traffic before
+	// ExpireIdleExchanges closes it. Zero selects DefaultExchangeTimeout;
+	// a negative value disables expiry.
+	Timeout time.Duration
+
+	// Clock times MRP retransmissions, acks and idle expiry. Nil selects
+	// clock.Real.
+	Clock clock.Clock
+}
+
+// exchangeKey identifies an exchange. Exchange IDs are chosen by the
+// initiator, so the same ID may be open twice on one session, once in
+// each direction. All unsecured traffic shares session 0; there the peer
+// address tells peers apart.
+type exchangeKey struct {
+	sessionID uint16
+	peer      string // only for session.UnsecuredSessionID
+	id        uint16
+	initiator bool // this node initiated the exchange
+}
+
+// ExchangeManager is safe for concurrent use. mu guards the exchange
+// table and handler registry; each Exchange guards its own state.
+type ExchangeManager struct {
+	sessions  *session.SessionManager
+	transport Transport
+	timeout   time.Duration
+	clock     clock.Clock
+	random    func() float64 // MRP jitter in [0, 1); swapped by tests
+	wake      chan struct{}  // nudges Run when new MRP work is scheduled
+
+	mu             sync.Mutex
+	exchanges      map[exchangeKey]*Exchange
+	handlers       map[message.ProtocolID]UnsolicitedHandler
+	idSeeded       bool
+	nextID         uint16
+	unsecuredPeers map[string]*counterWindow
+}
+
+func NewExchangeManager(sm *session.SessionManager, tr Transport) *ExchangeManager {
+	return NewExchangeManagerWithConfig(sm, tr, Config{})
+}
+
+func NewExchangeManagerWithConfig(sm *session.SessionManager, tr Transport, cfg Config) *ExchangeManager {
+	em := &ExchangeManager{
+		sessions:  sm,
+		transport: tr,
+		timeout:   cfg.Timeout,
+		clock:     cfg.Clock,
+		random:    rand.Float64,
+		wake:      make(chan struct{}, 1),
+		exchanges: make(map[exchangeKey]*Exchange),
+		handlers:  make(map[message.ProtocolID]UnsolicitedHandler),
+
+		unsecuredPeers: make(map[string]*counterWindow),
+	}
+	if em.timeout == 0 {
+		em.timeout = DefaultExchangeTimeout
+	}
+	if em.clock == nil {
+		em.clock = clock.Real
+	}
+	return em
+}
+
+// RegisterUnsolicitedHandler routes exchanges peers open for protocol to
+// h, replacing any handler already registered.
+func (em *ExchangeManager) RegisterUnsolicitedHandler(protocol message.ProtocolID, h UnsolicitedHandler) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.handlers[protocol] = h
+}
+
+// UnregisterUnsolicitedHandler stops accepting new exchanges for
+// protocol. Exchanges already open are unaffected.
+func (em *ExchangeManager) UnregisterUnsolicitedHandler(protocol message.ProtocolID) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	delete(em.handlers, protocol)
+}
+
+// NewExchange opens an exchange this node initiates, on the session with
+// local ID sessionID (session.UnsecuredSessionID for a handshake) towards
+// peer. Its messages carry protocol.
+func (em *ExchangeManager) NewExchange(sessionID uint16, peer net.Addr, protocol message.ProtocolID) (*Exchange, error) {
+	if sessionID != session.UnsecuredSessionID {
+		if _, ok := em.sessions.Session(sessionID); !ok {
+			return nil, session.ErrUnknownSession
+		}
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	key, err := em.allocateID(em.key(sessionID, peer, 0, true))
+	if err != nil {
+		return nil, err
+	}
+	return em.open(key, peer, protocol), nil
+}
+
+// Receive decrypts one datagram from the transport and routes it: to the
+// open exchange it belongs to or, if it opens a new exchange, to the
+// protocol's unsolicited handler. Acks are consumed here and never reach
+// an Inbox; duplicates are acknowledged again and dropped with
+// session.ErrReplayedMessageCounter. A reliable message that cannot be
+// routed is still acknowledged, so the peer stops retransmitting it. One
+// that finds its exchange's Inbox full is dropped unacknowledged instead,
+// so the peer retransmits it once the protocol has caught up. It has the
+// shape of a transport.PacketHandler.
+func (em *ExchangeManager) Receive(b []byte, from net.Addr) error {
+	f, err := em.sessions.OpenFrame(b)
+	duplicate := errors.Is(err, session.ErrReplayedMessageCounter)
+	if err != nil && !duplicate {
+		return err
+	}
+	now := em.clock.Now()
+	if f.Header.SessionID == session.UnsecuredSessionID {
+		if duplicate = em.unsecuredDuplicate(from, f.Header.MessageCounter, now); duplicate {
+			err = session.ErrReplayedMessageCounter
+		}
+	}
+	ph := f.PayloadHeader
+	fromInitiator := ph.ExchangeFlags.Has(message.ExchangeFlagInitiator)
+	key := em.key(f.Header.SessionID, from, ph.ExchangeID, !fromInitiator)
+	reliable := ph.ExchangeFlags.Has(message.ExchangeFlagReliability)
+	standaloneAck := ph.ProtocolID == message.ProtocolSecureChannel && ph.Opcode == message.OpcodeMRPStandaloneAck
+
+	em.mu.Lock()
+	ex := em.exchanges[key]
+	var handler UnsolicitedHandler
+	if ex == nil && !duplicate && !standaloneAck {
+		if !fromInitiator {
+			err = fmt.Errorf("%w: id %d on session %d", ErrUnknownExchange, ph.ExchangeID, f.Header.SessionID)
+		} else if handler = em.handlers[ph.ProtocolID]; handler == nil {
+			err = fmt.Errorf("%w: protocol %#04x", ErrNoUnsolicitedHandler, uint16(ph.ProtocolID))
+		} else {
+			ex = em.open(key, from, ph.ProtocolID)
+		}
+	}
+	em.mu.Unlock()
+
+	if ex == nil {
+		if reliable {
+			em.sendStandaloneAck(key, from, f.Header.MessageCounter)
+		}
+		return err
+	}
+	if duplicate && ex.refusedEarlier(f.Header.MessageCounter) {
+		duplicate, err = false, nil
+	}
+	if !duplicate && !standaloneAck && !ex.reserve(f.Header.MessageCounter) {
+		return errInboxFull
+	}
+	ackNow, retire := ex.receiveAcks(f, duplicate, now)
+	for _, c := range ackNow {
+		em.sendStandaloneAck(key, from, c)
+	}
+	if retire {
+		em.remove(ex)
+	}
+	if duplicate || standaloneAck {
+		return err
+	}
+	if err := ex.deliver(f); err != nil {
+		return err
+	}
+	if handler != nil {
+		go handler(ex)
+	}
+	return nil
+}
+
+// ExpireIdleExchanges closes every exchange without traffic for longer
+// than the configured timeout and returns how many it closed. Callers run
+// it periodically; like the session layer, the exchange layer owns no
+// timers of its own.
+func (em *ExchangeManager) ExpireIdleExchanges() int {
+	if em.timeout < 0 {
+		return 0
+	}
+	now := em.clock.Now()
+	var idle []*Exchange
+	em.mu.Lock()
+	for _, ex := range em.exchanges {
+		if now.Sub(ex.LastActivity()) >= em.timeout {
+			idle = append(idle, ex)
+		}
+	}
+	em.mu.Unlock()
+	for _, ex := range idle {
+		ex.close(ErrExchangeTimeout)
+	}
+	return len(idle)
+}
+
+// Run calls Tick and ExpireIdleExchanges whenever they have work, sleeping
+// on the manager's clock in between, until ctx ends. It returns ctx's
+// error. Callers that drive the manager from their own event loop call
+// Tick and ExpireIdleExchanges themselves instead.
+func (em *ExchangeManager) Run(ctx context.Context) error {
+	timer := em.clock.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C():
+		case <-em.wake:
+		}
+		next := em.Tick()
+		em.ExpireIdleExchanges()
+		wait := runIdlePoll
+		if !next.IsZero() {
+			wait = min(wait, next.Sub(em.clock.Now()))
+		}
+		timer.Reset(wait)
+	}
+}
+
+// kick wakes Run after an exchange scheduled MRP work, which may fall due
+// before Run's current timer.
+func (em *ExchangeManager) kick() {
+	select {
+	case em.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of open exchanges.
+func (em *ExchangeManager) Len() int {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	return len(em.exchanges)
+}
+
+func (em *ExchangeManager) key(sessionID uint16, peer net.Addr, id uint16, initiator bool) exchangeKey {
+	k := exchangeKey{sessionID: sessionID, id: id, initiator: initiator}
+	if sessionID == session.UnsecuredSessionID && peer != nil {
+		k.peer = peer.String()
+	}
+	return k
+}
+
+// allocateID picks the next exchange ID free for key's session and peer.
+// The sequence starts at a random value (Matter §4.10.2) so a restarted
+// node does not reuse the IDs of its previous run. The caller holds em.mu.
+func (em *ExchangeManager) allocateID(key exchangeKey) (exchangeKey, error) {
+	if !em.idSeeded {
+		var b [2]byte
+		if _, err := io.ReadFull(em.sessions.CryptoProvider().Rand(), b[:]); err != nil {
+			return key, fmt.Errorf("exchange: seed exchange id: %w", err)
+		}
+		em.nextID = uint16(b[0]) | uint16(b[1])<<8
+		em.idSeeded = true
+	}
+	for range 1 << 16 {
+		key.id = em.nextID
+		em.nextID++
+		if _, taken := em.exchanges[key]; !taken {
+			return key, nil
+		}
+	}
+	return key, errors.New("exchange: no free exchange id")
+}
+
+// open adds an exchange to the table. The caller holds em.mu.
+func (em *ExchangeManager) open(key exchangeKey, peer net.Addr, protocol message.ProtocolID) *Exchange {
+	ex := &Exchange{
+		mgr:      em,
+		key:      key,
+		peer:     peer,
+		protocol: protocol,
+		inbox:    make(chan *message.Frame, inboxSize),
+	}
+	ex.touch()
+	em.exchanges[key] = ex
+	return ex
+}
+
+// remove drops ex from the table if it is still there.
+func (em *ExchangeManager) remove(ex *Exchange) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	if em.exchanges[ex.key] == ex {
+		delete(em.exchanges, ex.key)
+	}
+}
+
+var _ Transport = (*transport.TransportManager)(nil)
@@ -0,0 +1,274 @@
+package exchange
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"time"
+
+	"go-matter/message"
+	"go-matter/session"
+)
+
+// Message Reliability Protocol parameters (Matter §4.12, MRP parameter
+// table).
+const (
+	// MRPMaxTransmissions is how many times a reliable message is sent,
+	// the first transmission included, before the exchange gives up.
+	MRPMaxTransmissions = 5
+	// MRPBackoffBase is the exponential factor applied per retransmission
+	// beyond MRPBackoffThreshold.
+	MRPBackoffBase = 1.6
+	// MRPBackoffJitter is the largest random fraction added to each
+	// timeout, so peers that lost the same packet do not retry in step.
+	MRPBackoffJitter = 0.25
+	// MRPBackoffMargin pads the peer's advertised interval.
+	MRPBackoffMargin = 1.1
+	// MRPBackoffThreshold is how many retransmissions use the linear
+	// interval before the backoff turns exponential.
+	MRPBackoffThreshold = 1
+	// MRPStandaloneAckTimeout is how long a received reliable message
+	// waits for an outbound message to piggyback its ack before a
+	// standalone ack is sent.
+	MRPStandaloneAckTimeout = 200 * time.Millisecond
+)
+
+// maxUnsecuredPeers bounds the per-peer duplicate detection state kept for
+// the unsecured session; the least recently heard peer is forgotten.
+const maxUnsecuredPeers = 32
+
+var (
+	// ErrAckPending is returned by Send while the exchange's previous
+	// reliable message is unacknowledged; an exchange has at most one in
+	// flight (Matter §4.12.2).
+	ErrAckPending = errors.New("exchange: previous message not yet acknowledged")
+	// ErrMessageNotAcknowledged closes an exchange whose message went
+	// unacknowledged through MRPMaxTransmissions transmissions.
+	ErrMessageNotAcknowledged = errors.New("exchange: message not acknowledged")
+)
+
+// retransmission is a reliable message awaiting its ack. wire is resent
+// unchanged, counter included.
+type retransmission struct {
+	wire    []byte
+	counter uint32
+	sends   int // transmissions so far
+	next    time.Time
+}
+
+// backoff is the time to wait after the (n+1)th transmission of a message
+// before retransmitting it (Matter §4.12.2.1):
+//
+//	base × MARGIN × BASE^max(0, n−THRESHOLD) × (1 + jitter × JITTER)
+//
+// with jitter drawn from [0, 1).
+func backoff(base time.Duration, n int, jitter float64) time.Duration {
+	t := float64(base) * MRPBackoffMargin
+	if n > MRPBackoffThreshold {
+		t *= math.Pow(MRPBackoffBase, float64(n-MRPBackoffThreshold))
+	}
+	return time.Duration(t * (1 + jitter*MRPBackoffJitter))
+}
+
+// retryInterval is the peer's base retransmission interval: its active
+// interval if it was heard from within its active threshold, else its
+// idle interval. Unsecured exchanges use the defaults.
+func (ex *Exchange) retryInterval(now time.Time) time.Duration {
+	var params session.SessionParameters
+	if ex.key.sessionID != session.UnsecuredSessionID {
+		if s, ok := ex.mgr.sessions.Session(ex.key.sessionID); ok {
+			params = s.PeerParameters
+		}
+	}
+	if !ex.lastRx.IsZero() && now.Sub(ex.lastRx) < params.ActiveThreshold() {
+		return params.ActiveInterval()
+	}
+	return params.IdleInterval()
+}
+
+// Tick performs the MRP work that has fallen due: it retransmits
+// unacknowledged messages, closes exchanges whose message has used up
+// MRPMaxTransmissions with ErrMessageNotAcknowledged, and sends standalone
+// acks for reliable messages nothing piggybacked on. It returns when Tick
+// next has work, or the zero time if none is scheduled. Callers run it
+// from their event loop; the exchange layer owns no timers.
+func (em *ExchangeManager) Tick() time.Time {
+	now := em.clock.Now()
+	em.mu.Lock()
+	exchanges := slices.Collect(maps.Values(em.exchanges))
+	em.mu.Unlock()
+	var next time.Time
+	for _, ex := range exchanges {
+		if t := ex.tick(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// tick does ex's share of Tick and returns its next deadline.
+func (ex *Exchange) tick(now time.Time) time.Time {
+	ex.mu.Lock()
+	var resend []byte
+	var ack, giveUp bool
+	var ackCounter uint32
+	if ex.ackPending && !now.Before(ex.ackDeadline) {
+		ack, ackCounter = true, ex.ackCounter
+		ex.ackPending = false
+	}
+	if r := ex.unacked; r != nil && !now.Before(r.next) {
+		if r.sends >= MRPMaxTransmissions {
+			ex.unacked = nil
+			giveUp = true
+		} else {
+			resend = r.wire
+			r.sends++
+			r.next = now.Add(backoff(ex.retryInterval(now), r.sends-1, ex.mgr.random()))
+		}
+	}
+	var next time.Time
+	if ex.unacked != nil {
+		next = ex.unacked.next
+	}
+	if ex.ackPending && (next.IsZero() || ex.ackDeadline.Before(next)) {
+		next = ex.ackDeadline
+	}
+	ex.mu.Unlock()
+
+	if ack {
+		ex.mgr.sendStandaloneAck(ex.key, ex.peer, ackCounter)
+	}
+	if resend != nil {
+		ex.mgr.transport.SendPacket(ex.peer, resend)
+	}
+	if giveUp {
+		ex.close(ErrMessageNotAcknowledged)
+	}
+	return next
+}
+
+// receiveAcks applies the MRP fields of a message received on ex: an ack
+// for the message in flight releases it, and a reliable message is
+// scheduled for acknowledgement. Any ack that cannot wait — for a
+// duplicate, or one a newer message displaces — is returned for the
+// caller to send once ex.mu is released. retire reports that ex was
+// closed and only stayed open for this ack.
+func (ex *Exchange) receiveAcks(f *message.Frame, duplicate bool, now time.Time) (ackNow []uint32, retire bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ph := f.PayloadHeader
+	if ph.ExchangeFlags.Has(message.ExchangeFlagAcknowledgement) &&
+		ex.unacked != nil && ex.unacked.counter == ph.AckCounter {
+		ex.unacked = nil
+		retire = ex.closed
+	}
+	if !duplicate {
+		ex.lastRx = now
+	}
+	if !ph.ExchangeFlags.Has(message.ExchangeFlagReliability) {
+		return nil, retire
+	}
+	if duplicate {
+		return []uint32{f.Header.MessageCounter}, retire
+	}
+	if ex.ackPending {
+		ackNow = append(ackNow, ex.ackCounter)
+	}
+	if ex.closed {
+		// Nobody will reply on a closed exchange; ack straight away.
+		ex.ackPending = false
+		return append(ackNow, f.Header.MessageCounter), retire
+	}
+	ex.ackPending = true
+	ex.ackCounter = f.Header.MessageCounter
+	ex.ackDeadline = now.Add(MRPStandaloneAckTimeout)
+	ex.mgr.kick()
+	return ackNow, retire
+}
+
+// sendStandaloneAck acknowledges counter on the exchange key names,
+// whether or not it is still open.
+func (em *ExchangeManager) sendStandaloneAck(key exchangeKey, peer net.Addr, counter uint32) error {
+	b := message.NewBuilder().
+		Protocol(message.ProtocolSecureChannel).
+		Opcode(message.OpcodeMRPStandaloneAck).
+		ExchangeID(key.id).
+		AckCounter(counter)
+	if key.initiator {
+		b.Initiator()
+	}
+	f, err := b.Build()
+	if err != nil {
+		return err
+	}
+	wire, err := em.sessions.SealFrame(key.sessionID, f)
+	if err != nil {
+		return err
+	}
+	return em.transport.SendPacket(peer, wire)
+}
+
+// unsecuredDuplicate reports whether counter was already received from
+// peer on the unsecured session, recording it if not. The unsecured
+// session has no replay protection of its own; this only stops MRP
+// retransmissions being processed twice. Global unencrypted counters may
+// wrap and restart, so a counter behind the window starts afresh rather
+// than being dropped.
+func (em *ExchangeManager) unsecuredDuplicate(peer net.Addr, counter uint32, now time.Time) bool {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	key := peer.String()
+	w, ok := em.unsecuredPeers[key]
+	if !ok {
+		if len(em.unsecuredPeers) >= maxUnsecuredPeers {
+			var oldest string
+			for k, v := range em.unsecuredPeers {
+				if oldest == "" || v.lastSeen.Before(em.unsecuredPeers[oldest].lastSeen) {
+					oldest = k
+				}
+			}
+			delete(em.unsecuredPeers, oldest)
+		}
+		w = &counterWindow{}
+		em.unsecuredPeers[key] = w
+	}
+	w.lastSeen = now
+	return w.duplicate(counter)
+}
+
+// counterWindow tracks the last 32 counters from one unsecured peer with
+// mod-2^32 arithmetic.
+type counterWindow struct {
+	seen     bool
+	max      uint32
+	bitmap   uint32 // bit i set ⇒ counter (max - 1 - i) received
+	lastSeen time.Time
+}
+
+func (w *counterWindow) duplicate(c uint32) bool {
+	d := int32(c - w.max)
+	switch {
+	case !w.seen || d < -int32(session.MessageCounterWindowSize):
+		w.seen, w.max, w.bitmap = true, c, 0
+		return false
+	case d > 0:
+		if d >= 32 {
+			w.bitmap = 0
+		} else {
+			w.bitmap = w.bitmap<<d | 1<<(d-1)
+		}
+		w.max = c
+		return false
+	case d == 0:
+		return true
+	default:
+		bit := uint32(1) << (-d - 1)
+		if w.bitmap&bit != 0 {
+			return true
+		}
+		w.bitmap |= bit
+		return false
+	}
+}
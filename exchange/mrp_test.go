@@ -0,0 +1,395 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/message"
+	"go-matter/session"
+)
+
+// mrpNodes puts ctrl and dev on one fake clock with no jitter, so every
+// retransmission lands at a predictable time.
+func mrpNodes(ctrl, dev *testNode) *clock.Fake {
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	for _, n := range []*testNode{ctrl, dev} {
+		n.em.clock = clk
+		n.em.random = func() float64 { return 0 }
+	}
+	return clk
+}
+
+// unsecuredPair links two nodes over session 0, whose packets can be
+// decoded in the clear.
+func unsecuredPair() (ctrl, dev *testNode, clock *clock.Fake) {
+	ctrl, dev = newNode("ctrl"), newNode("dev")
+	ctrl.out.peer, dev.out.peer = dev.em, ctrl.em
+	return ctrl, dev, mrpNodes(ctrl, dev)
+}
+
+// accept registers a handler on n that hands each incoming exchange to the
+// test instead of running a protocol.
+func accept(n *testNode, protocol message.ProtocolID) <-chan *Exchange {
+	ch := make(chan *Exchange, 4)
+	n.em.RegisterUnsolicitedHandler(protocol, func(ex *Exchange) { ch <- ex })
+	return ch
+}
+
+func waitExchange(t *testing.T, ch <-chan *Exchange) *Exchange {
+	t.Helper()
+	select {
+	case ex := <-ch:
+		return ex
+	case <-time.After(time.Second):
+		t.Fatal("no exchange opened")
+		return nil
+	}
+}
+
+func decode(t *testing.T, b []byte) *message.Frame {
+	t.Helper()
+	f, err := message.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func isStandaloneAck(f *message.Frame) bool {
+	return f.PayloadHeader.ProtocolID == message.ProtocolSecureChannel &&
+		f.PayloadHeader.Opcode == message.OpcodeMRPStandaloneAck
+}
+
+func TestBackoff_Schedule(t *testing.T) {
+	base := session.DefaultSessionIdleInterval
+	want := []time.Duration{550, 550, 880, 1408, 2252}
+	for n, w := range want {
+		if got := backoff(base, n, 0).Milliseconds(); got != int64(w) {
+			t.Errorf("backoff(n=%d) = %dms, want %dms", n, got, w)
+		}
+	}
+	if got := backoff(base, 0, 1); got != 687500*time.Microsecond {
+		t.Errorf("full jitter = %v, want 687.5ms", got)
+	}
+}
+
+func TestMRP_RetransmitsUntilAcked(t *testing.T) {
+	ctrl, dev, clock := unsecuredPair()
+	incoming := accept(dev, message.ProtocolSecureChannel)
+	lost := 2
+	ctrl.out.drop = func([]byte) bool { lost--; return lost >= 0 }
+
+	ex, _ := ctrl.em.NewExchange(session.UnsecuredSessionID, dev.addr, message.ProtocolSecureChannel)
+	if err := ex.Send(message.OpcodePBKDFParamRequest, []byte("req")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ex.Send(message.OpcodePBKDFParamRequest, nil); !errors.Is(err, ErrAckPending) {
+		t.Fatalf("second Send: err = %v, want ErrAckPending", err)
+	}
+	for _, wait := range []time.Duration{550 * time.Millisecond, 550 * time.Millisecond} {
+		if next := ctrl.em.Tick(); !next.Equal(clock.Now().Add(wait)) {
+			t.Fatalf("next retransmission at +%v, want +%v", next.Sub(clock.Now()), wait)
+		}
+		clock.Advance(wait)
+		ctrl.em.Tick()
+	}
+	got := ctrl.out.packets()
+	if len(got) != 3 || string(got[0]) != string(got[2]) {
+		t.Fatalf("sent %d packets; retransmissions must repeat the original bytes", len(got))
+	}
+
+	devEx := waitExchange(t, incoming)
+	if f := recv(t, devEx); string(f.Payload) != "req" {
+		t.Errorf("payload = %q", f.Payload)
+	}
+	clock.Advance(MRPStandaloneAckTimeout)
+	dev.em.Tick()
+	if acks := dev.out.packets(); len(acks) != 1 || !isStandaloneAck(decode(t, acks[0])) {
+		t.Fatalf("device sent %d packets, want one standalone ack", len(acks))
+	}
+	if next := ctrl.em.Tick(); !next.IsZero() {
+		t.Errorf("retransmission still scheduled at %v", next)
+	}
+	select {
+	case f := <-ex.Inbox():
+		t.Errorf("standalone ack delivered to inbox: %+v", f)
+	default:
+	}
+}
+
+func TestMRP_GivesUpAfterMaxTransmissions(t *testing.T) {
+	ctrl, dev, clock := unsecuredPair()
+	ctrl.out.drop = func([]byte) bool { return true }
+	ex, _ := ctrl.em.NewExchange(session.UnsecuredSessionID, dev.addr, message.ProtocolSecureChannel)
+	ex.Send(message.OpcodePBKDFParamRequest, nil)
+	for i := 0; ex.Err() == nil; i++ {
+		if i > 2*MRPMaxTransmissions {
+			t.Fatal("exchange never gave up")
+		}
+		clock.Set(ctrl.em.Tick())
+		ctrl.em.Tick()
+	}
+	if n := len(ctrl.out.packets()); n != MRPMaxTransmissions {
+		t.Errorf("transmissions = %d, want %d", n, MRPMaxTransmissions)
+	}
+	if !errors.Is(ex.Err(), ErrMessageNotAcknowledged) {
+		t.Errorf("Err = %v", ex.Err())
+	}
+	if ctrl.em.Len() != 0 {
+		t.Errorf("Len = %d", ctrl.em.Len())
+	}
+}
+
+// TestRun_RetransmitsOverHoursOfFakeTime drives MRP through Run against a
+// peer advertising the longest idle interval: the exchange gives up after
+// about eleven hours of clock time without the test waiting for any of it.
+func TestRun_RetransmitsOverHoursOfFakeTime(t *testing.T) {
+	ctrl, dev := pair(t)
+	clk := mrpNodes(ctrl, dev)
+	s, _ := ctrl.sm.Session(ctrlSessionID)
+	s.PeerParameters = session.SessionParameters{SessionIdleIntervalMs: uint32(session.MaxSessionInterval.Milliseconds())}
+	ctrl.em.timeout = -1
+	ctrl.out.drop = func([]byte) bool { return true }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ctrl.em.Run(ctx) }()
+
+	start := clk.Now()
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+	ex.Send(opReadRequest, nil)
+	for ex.Err() == nil {
+		clk.BlockUntil(1)
+		clk.Advance(time.Minute)
+	}
+	if elapsed := clk.Now().Sub(start); elapsed < 11*time.Hour || elapsed > 12*time.Hour {
+		t.Errorf("gave up after %v of clock time", elapsed)
+	}
+	if n := len(ctrl.out.packets()); n != MRPMaxTransmissions || !errors.Is(ex.Err(), ErrMessageNotAcknowledged) {
+		t.Errorf("transmissions = %d, Err = %v", n, ex.Err())
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v", err)
+	}
+}
+
+func TestMRP_PiggybacksAckOnReply(t *testing.T) {
+	ctrl, dev, clock := unsecuredPair()
+	incoming := accept(dev, message.ProtocolSecureChannel)
+	ex, _ := ctrl.em.NewExchange(session.UnsecuredSessionID, dev.addr, message.ProtocolSecureChannel)
+	ex.Send(message.OpcodePBKDFParamRequest, nil)
+	request := decode(t, ctrl.out.packets()[0])
+
+	devEx := waitExchange(t, incoming)
+	recv(t, devEx)
+	clock.Advance(MRPStandaloneAckTimeout / 2)
+	if err := devEx.Send(message.OpcodePBKDFParamResponse, nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(MRPStandaloneAckTimeout)
+	dev.em.Tick()
+
+	sent := dev.out.packets()
+	if len(sent) != 1 {
+		t.Fatalf("device sent %d packets, want only the reply", len(sent))
+	}
+	ph := decode(t, sent[0]).PayloadHeader
+	if !ph.ExchangeFlags.Has(message.ExchangeFlagAcknowledgement|message.ExchangeFlagReliability) ||
+		ph.AckCounter != request.Header.MessageCounter {
+		t.Errorf("reply payload header = %+v, want ack of %d", ph, request.Header.MessageCounter)
+	}
+	if err := ex.Send(message.OpcodePASEPake1, nil); err != nil {
+		t.Errorf("request not acknowledged by reply: %v", err)
+	}
+}
+
+// TestMRP_DuplicateAckedNotRedelivered loses the device's acks so the
+// controller retransmits; the device must ack the copy at once and not
+// hand it to the protocol twice.
+func TestMRP_DuplicateAckedNotRedelivered(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		pair func() (ctrl, dev *testNode, sessionID uint16, clock *clock.Fake)
+	}{
+		{"unsecured", func() (*testNode, *testNode, uint16, *clock.Fake) {
+			ctrl, dev, clock := unsecuredPair()
+			return ctrl, dev, session.UnsecuredSessionID, clock
+		}},
+		{"secure", func() (*testNode, *testNode, uint16, *clock.Fake) {
+			ctrl, dev := pair(t)
+			return ctrl, dev, ctrlSessionID, mrpNodes(ctrl, dev)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl, dev, sid, clock := tc.pair()
+			incoming := accept(dev, message.ProtocolSecureChannel)
+			dev.out.drop = func([]byte) bool { return true }
+			ex, _ := ctrl.em.NewExchange(sid, dev.addr, message.ProtocolSecureChannel)
+			ex.Send(message.OpcodePBKDFParamRequest, nil)
+			devEx := waitExchange(t, incoming)
+
+			clock.Set(ctrl.em.Tick())
+			ctrl.em.Tick()
+			if errs := ctrl.out.errors(); len(errs) != 1 || !errors.Is(errs[0], session.ErrReplayedMessageCounter) {
+				t.Errorf("device receive errors = %v", errs)
+			}
+			if n := len(dev.out.packets()); n != 1 {
+				t.Errorf("device sent %d acks for the duplicate, want 1", n)
+			}
+			recv(t, devEx)
+			select {
+			case f := <-devEx.Inbox():
+				t.Errorf("duplicate delivered: %+v", f)
+			default:
+			}
+		})
+	}
+}
+
+// TestMRP_FullInboxLeftUnacked — a message that finds the protocol's
+// inbox full is not acknowledged, and its retransmission is delivered
+// once the protocol has read from the inbox.
+func TestMRP_FullInboxLeftUnacked(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		pair func() (ctrl, dev *testNode, sessionID uint16, clock *clock.Fake)
+	}{
+		{"unsecured", func() (*testNode, *testNode, uint16, *clock.Fake) {
+			ctrl, dev, clock := unsecuredPair()
+			return ctrl, dev, session.UnsecuredSessionID, clock
+		}},
+		{"secure", func() (*testNode, *testNode, uint16, *clock.Fake) {
+			ctrl, dev := pair(t)
+			return ctrl, dev, ctrlSessionID, mrpNodes(ctrl, dev)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl, dev, sid, clock := tc.pair()
+			incoming := accept(dev, message.ProtocolSecureChannel)
+			ex, _ := ctrl.em.NewExchange(sid, dev.addr, message.ProtocolSecureChannel)
+			var devEx *Exchange
+			for i := 0; i < inboxSize; i++ {
+				if err := ex.Send(message.OpcodePBKDFParamRequest, []byte{byte(i)}); err != nil {
+					t.Fatalf("Send %d: %v", i, err)
+				}
+				if devEx == nil {
+					devEx = waitExchange(t, incoming)
+				}
+				clock.Advance(MRPStandaloneAckTimeout)
+				dev.em.Tick()
+			}
+
+			ex.Send(message.OpcodePBKDFParamRequest, []byte{inboxSize})
+			clock.Advance(MRPStandaloneAckTimeout)
+			dev.em.Tick()
+			clock.Set(ctrl.em.Tick())
+			ctrl.em.Tick()
+			if n := len(dev.out.packets()); n != inboxSize {
+				t.Fatalf("device sent %d acks, want %d: the overflowing message was acknowledged", n, inboxSize)
+			}
+			if errs := ctrl.out.errors(); len(errs) != 2 || !errors.Is(errs[0], errInboxFull) || !errors.Is(errs[1], errInboxFull) {
+				t.Fatalf("device receive errors = %v", errs)
+			}
+
+			recv(t, devEx)
+			clock.Set(ctrl.em.Tick())
+			ctrl.em.Tick()
+			clock.Advance(MRPStandaloneAckTimeout)
+			dev.em.Tick()
+			if err := ex.Send(message.OpcodePBKDFParamRequest, nil); err != nil {
+				t.Fatalf("retransmission not acknowledged: %v", err)
+			}
+			var last *message.Frame
+			for range inboxSize {
+				last = recv(t, devEx)
+			}
+			if !bytes.Equal(last.Payload, []byte{inboxSize}) {
+				t.Errorf("last delivered payload = %x, want the retransmitted message", last.Payload)
+			}
+		})
+	}
+}
+
+func TestMRP_CloseLingersUntilAcked(t *testing.T) {
+	ctrl, dev, clock := unsecuredPair()
+	incoming := accept(dev, message.ProtocolSecureChannel)
+	first := true
+	ctrl.out.drop = func([]byte) bool { defer func() { first = false }(); return first }
+
+	ex, _ := ctrl.em.NewExchange(session.UnsecuredSessionID, dev.addr, message.ProtocolSecureChannel)
+	ex.Send(message.OpcodePASEPake3, nil)
+	ex.Close()
+	if ctrl.em.Len() != 1 {
+		t.Fatalf("closed exchange dropped its unacked message")
+	}
+	clock.Set(ctrl.em.Tick())
+	ctrl.em.Tick()
+
+	devEx := waitExchange(t, incoming)
+	recv(t, devEx)
+	devEx.Close() // flushes the owed ack at once
+	if ctrl.em.Len() != 0 || dev.em.Len() != 0 {
+		t.Errorf("Len after ack = %d, %d; want 0, 0", ctrl.em.Len(), dev.em.Len())
+	}
+}
+
+func TestMRP_UnknownExchangeStillAcked(t *testing.T) {
+	ctrl, dev, _ := unsecuredPair()
+	ex, _ := ctrl.em.NewExchange(session.UnsecuredSessionID, dev.addr, message.ProtocolSecureChannel)
+	ex.Send(message.OpcodePBKDFParamRequest, nil) // no handler on dev
+	if errs := ctrl.out.errors(); len(errs) != 1 || !errors.Is(errs[0], ErrNoUnsolicitedHandler) {
+		t.Fatalf("errors = %v", errs)
+	}
+	if err := ex.Send(message.OpcodePBKDFParamRequest, nil); err != nil {
+		t.Errorf("unroutable message was not acknowledged: %v", err)
+	}
+}
+
+func TestMRP_ActiveIntervalAfterPeerHeard(t *testing.T) {
+	ctrl, dev := pair(t)
+	clock := mrpNodes(ctrl, dev)
+	s, _ := ctrl.sm.Session(ctrlSessionID)
+	s.PeerParameters = session.SessionParameters{SessionIdleIntervalMs: 5000, SessionActiveIntervalMs: 100}
+	ex, _ := ctrl.em.NewExchange(ctrlSessionID, dev.addr, message.ProtocolInteractionModel)
+
+	if got := ex.retryInterval(clock.Now()); got != 5*time.Second {
+		t.Errorf("before hearing from peer: %v, want idle interval", got)
+	}
+	ex.lastRx = clock.Now()
+	clock.Advance(time.Second)
+	if got := ex.retryInterval(clock.Now()); got != 100*time.Millisecond {
+		t.Errorf("peer heard 1s ago: %v, want active interval", got)
+	}
+	clock.Advance(session.DefaultSessionActiveThreshold)
+	if got := ex.retryInterval(clock.Now()); got != 5*time.Second {
+		t.Errorf("peer quiet past threshold: %v, want idle interval", got)
+	}
+}
+
+func TestCounterWindow_WrapsAndResyncs(t *testing.T) {
+	var w counterWindow
+	steps := []struct {
+		c    uint32
+		dup  bool
+		desc string
+	}{
+		{0xFFFFFFFE, false, "first"},
+		{0xFFFFFFFF, false, "next"},
+		{1, false, "across the wrap"},
+		{0, false, "late, inside window"},
+		{0, true, "repeat"},
+		{0xFFFFFFFF, true, "repeat before wrap"},
+		{0x80000000, false, "far behind: peer restarted"},
+		{0x80000000, true, "repeat after resync"},
+	}
+	for _, s := range steps {
+		if got := w.duplicate(s.c); got != s.dup {
+			t.Errorf("%s (%#x): duplicate = %v, want %v", s.desc, s.c, got, s.dup)
+		}
+	}
+}
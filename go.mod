@@ -3,6 +3,9 @@ module go-matter
 go 1.25.2
 
 require (
+	filippo.io/nistec v0.0.4
 	github.com/pion/dtls/v3 v3.1.2
 	golang.org/x/crypto v0.45.0
 )
+
+require golang.org/x/sys v0.38.0 // indirect
@@ -1,6 +1,11 @@
 package interaction
 
 import (
+	"errors"
+	"sync"
+	"time"
+
+	"go-matter/clock"
 	"go-matter/datamodel"
 	"go-matter/session"
 )
@@ -15,18 +20,71 @@ type AttributeStore interface {
 	WriteAttribute(endpointID datamodel.EndpointID, clusterID datamodel.ClusterID, attributeID datamodel.AttributeID, value interface{}) error
 }
 
+// ErrTimedRequestExpired is returned when the action that follows a Timed
+// Request arrives after the timeout that request set (Matter §8.7.2).
+var ErrTimedRequestExpired = errors.New("interaction: timed request expired")
+
+// Config carries the tunables for NewInteractionModelWithConfig. The zero
+// value is valid and matches NewInteractionModel.
+type Config struct {
+	// Clock times subscription intervals and timed interactions. Nil
+	// selects clock.Real.
+	Clock clock.Clock
+}
+
 // InteractionModel handles the Matter Interaction Model protocol.
 type InteractionModel struct {
 	sessionManager *session.SessionManager
 	store          AttributeStore
+	clock          clock.Clock
+
+	mu             sync.Mutex
+	timedDeadlines map[uint16]time.Time // by session ID; see BeginTimedRequest
 }
 
 // NewInteractionModel creates a new InteractionModel.
 func NewInteractionModel(sm *session.SessionManager, store AttributeStore) *InteractionModel {
-	return &InteractionModel{
+	return NewInteractionModelWithConfig(sm, store, Config{})
+}
+
+// NewInteractionModelWithConfig creates an InteractionModel with the
+// tunables in cfg.
+func NewInteractionModelWithConfig(sm *session.SessionManager, store AttributeStore, cfg Config) *InteractionModel {
+	im := &InteractionModel{
 		sessionManager: sm,
 		store:          store,
+		clock:          cfg.Clock,
+		timedDeadlines: make(map[uint16]time.Time),
+	}
+	if im.clock == nil {
+		im.clock = clock.Real
 	}
+	return im
+}
+
+// BeginTimedRequest records a Timed Request received on sessionID: the
+// Write Request that follows must arrive within timeout, measured on the
+// configured clock (Matter §8.7.2).
+func (im *InteractionModel) BeginTimedRequest(sessionID uint16, timeout time.Duration) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.timedDeadlines[sessionID] = im.clock.Now().Add(timeout)
+}
+
+// endTimedRequest consumes the Timed Request pending on sessionID, if any,
+// and returns ErrTimedRequestExpired if its timeout has passed.
+func (im *InteractionModel) endTimedRequest(sessionID uint16) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	deadline, ok := im.timedDeadlines[sessionID]
+	if !ok {
+		return nil
+	}
+	delete(im.timedDeadlines, sessionID)
+	if im.clock.Now().After(deadline) {
+		return ErrTimedRequestExpired
+	}
+	return nil
 }
 
 // SendReadRequest sends a Read Request to a peer.
@@ -49,8 +107,12 @@ func (im *InteractionModel) SendWriteRequest(sessionID uint16, endpointID datamo
 	return nil
 }
 
-// HandleWriteRequest processes an incoming Write Request.
+// HandleWriteRequest processes an incoming Write Request. A request that
+// follows an expired Timed Request is refused with ErrTimedRequestExpired.
 func (im *InteractionModel) HandleWriteRequest(sessionID uint16, payload []byte) error {
+	if err := im.endTimedRequest(sessionID); err != nil {
+		return err
+	}
 	// TODO: Parse Write Request
 	// TODO: Write data to im.store
 	// TODO: Send Write Response
@@ -0,0 +1,44 @@
+package interaction
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go-matter/clock"
+)
+
+func TestTimedRequest_Timeout(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	im := NewInteractionModelWithConfig(nil, nil, Config{Clock: clk})
+
+	im.BeginTimedRequest(1, 500*time.Millisecond)
+	clk.Advance(500 * time.Millisecond)
+	if err := im.HandleWriteRequest(1, nil); err != nil {
+		t.Fatalf("write at the deadline: %v", err)
+	}
+
+	im.BeginTimedRequest(1, 500*time.Millisecond)
+	clk.Advance(501 * time.Millisecond)
+	if err := im.HandleWriteRequest(1, nil); !errors.Is(err, ErrTimedRequestExpired) {
+		t.Fatalf("late write: err = %v, want ErrTimedRequestExpired", err)
+	}
+	// The expired Timed Request was consumed; an untimed write proceeds.
+	if err := im.HandleWriteRequest(1, nil); err != nil {
+		t.Errorf("untimed write: %v", err)
+	}
+}
+
+func TestTimedRequest_PerSession(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	im := NewInteractionModelWithConfig(nil, nil, Config{Clock: clk})
+
+	im.BeginTimedRequest(1, time.Second)
+	clk.Advance(2 * time.Second)
+	if err := im.HandleWriteRequest(2, nil); err != nil {
+		t.Errorf("write on another session: %v", err)
+	}
+	if err := im.HandleWriteRequest(1, nil); !errors.Is(err, ErrTimedRequestExpired) {
+		t.Errorf("err = %v, want ErrTimedRequestExpired", err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 type Builder struct {
 	frame      Frame
 	hasPayload bool
+	hasOpcode  bool
 	err        error
 }
 
@@ -74,6 +75,21 @@ func (b *Builder) Unsecured() *Builder {
 	return b
 }
 
+// GroupSession marks the frame as a group message (SessionType Group)
+// carried under the given group session ID (Matter §4.17.3.6).
+func (b *Builder) GroupSession(id uint16) *Builder {
+	b.frame.Header.SessionID = id
+	b.frame.Header.SecurityFlags = (b.frame.Header.SecurityFlags &^ SecurityFlagSessionTypeMask) | SessionTypeGroup
+	return b
+}
+
+// Control sets the C flag, placing the message in the control counter
+// space (Matter §4.4.1.2).
+func (b *Builder) Control() *Builder {
+	b.frame.Header.SecurityFlags |= SecurityFlagControl
+	return b
+}
+
 // Protocol sets the protocol ID on the payload header.
 func (b *Builder) Protocol(id ProtocolID) *Builder {
 	b.frame.PayloadHeader.ProtocolID = id
@@ -83,6 +99,7 @@ func (b *Builder) Protocol(id ProtocolID) *Builder {
 // Opcode sets the protocol opcode on the payload header.
 func (b *Builder) Opcode(op Opcode) *Builder {
 	b.frame.PayloadHeader.Opcode = op
+	b.hasOpcode = true
 	return b
 }
 
@@ -148,10 +165,9 @@ func (b *Builder) Build() (*Frame, error) {
 	if b.err != nil {
 		return nil, b.err
 	}
-	if b.frame.PayloadHeader.ProtocolID == 0 && b.frame.PayloadHeader.Opcode == 0 {
-		// Both at their zero value strongly suggests the caller forgot to
-		// set them — Secure Channel + opcode 0 is "MsgCounterSyncReq", which
-		// is not what an empty builder represents.
+	if !b.hasOpcode {
+		// Secure Channel + opcode 0 is MsgCounterSyncReq, so the zero
+		// values alone cannot tell a forgotten opcode from a real one.
 		return nil, errors.New("message: builder missing Protocol/Opcode")
 	}
 	out := b.frame
@@ -97,6 +97,30 @@ func TestBuilder_MissingProtocolAndOpcode(t *testing.T) {
 	}
 }
 
+// TestBuilder_MsgCounterSyncReq — Secure Channel opcode 0x00 is a real
+// message, so explicitly setting it must build.
+func TestBuilder_MsgCounterSyncReq(t *testing.T) {
+	frame, err := NewBuilder().
+		GroupSession(0xB9F7).
+		Control().
+		Protocol(ProtocolSecureChannel).
+		Opcode(OpcodeMsgCounterSyncReq).
+		Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if frame.PayloadHeader.Opcode != OpcodeMsgCounterSyncReq {
+		t.Errorf("Opcode = %#x", frame.PayloadHeader.Opcode)
+	}
+	sf := frame.Header.SecurityFlags
+	if sf.SessionType() != SessionTypeGroup || sf&SecurityFlagControl == 0 {
+		t.Errorf("SecurityFlags = %#x, want group + control", sf)
+	}
+	if _, err := NewBuilder().Protocol(ProtocolSecureChannel).Build(); err == nil {
+		t.Error("expected error when Opcode is unset")
+	}
+}
+
 func TestBuilder_DestNodeIDSetsFlags(t *testing.T) {
 	frame, err := NewBuilder().
 		Protocol(ProtocolInteractionModel).
@@ -16,6 +16,8 @@ const (
 type Opcode uint8
 
 const (
+	OpcodeMsgCounterSyncReq  Opcode = 0x00
+	OpcodeMsgCounterSyncRsp  Opcode = 0x01
 	OpcodeMRPStandaloneAck   Opcode = 0x10
 	OpcodePBKDFParamRequest  Opcode = 0x20
 	OpcodePBKDFParamResponse Opcode = 0x21
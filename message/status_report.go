@@ -0,0 +1,114 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// GeneralCode is the protocol-independent outcome carried by a StatusReport
+// (Matter Core Spec Appendix D.3.1).
+type GeneralCode uint16
+
+const (
+	GeneralCodeSuccess           GeneralCode = 0
+	GeneralCodeFailure           GeneralCode = 1
+	GeneralCodeBadPrecondition   GeneralCode = 2
+	GeneralCodeOutOfRange        GeneralCode = 3
+	GeneralCodeBadRequest        GeneralCode = 4
+	GeneralCodeUnsupported       GeneralCode = 5
+	GeneralCodeUnexpected        GeneralCode = 6
+	GeneralCodeResourceExhausted GeneralCode = 7
+	GeneralCodeBusy              GeneralCode = 8
+	GeneralCodeTimeout           GeneralCode = 9
+	GeneralCodeContinue          GeneralCode = 10
+	GeneralCodeAborted           GeneralCode = 11
+	GeneralCodeInvalidArgument   GeneralCode = 12
+	GeneralCodeNotFound          GeneralCode = 13
+	GeneralCodeAlreadyExists     GeneralCode = 14
+	GeneralCodePermissionDenied  GeneralCode = 15
+	GeneralCodeDataLoss          GeneralCode = 16
+)
+
+var generalCodeNames = [...]string{
+	"SUCCESS", "FAILURE", "BAD_PRECONDITION", "OUT_OF_RANGE", "BAD_REQUEST",
+	"UNSUPPORTED", "UNEXPECTED", "RESOURCE_EXHAUSTED", "BUSY", "TIMEOUT",
+	"CONTINUE", "ABORTED", "INVALID_ARGUMENT", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "DATA_LOSS",
+}
+
+func (c GeneralCode) String() string {
+	if int(c) < len(generalCodeNames) {
+		return generalCodeNames[c]
+	}
+	return fmt.Sprintf("GeneralCode(%d)", uint16(c))
+}
+
+// Secure Channel protocol codes carried in a StatusReport's ProtocolCode
+// (Matter Core Spec §4.11.1.3).
+const (
+	SecureChannelSessionEstablishmentSuccess uint16 = 0x0000
+	SecureChannelNoSharedTrustRoots          uint16 = 0x0001
+	SecureChannelInvalidParameter            uint16 = 0x0002
+	SecureChannelCloseSession                uint16 = 0x0003
+	SecureChannelBusy                        uint16 = 0x0004
+)
+
+// StatusReport is the payload of OpcodeStatusReport (Matter Core Spec
+// Appendix D.2). It is not TLV:
+//
+//	+------------------+----------------+
+//	| General Code     |   2 bytes LE   |
+//	| Protocol ID      |   4 bytes LE   | (vendor ID << 16 | protocol)
+//	| Protocol Code    |   2 bytes LE   |
+//	| Protocol Data    |   variable     | (optional)
+//	+------------------+----------------+
+type StatusReport struct {
+	GeneralCode  GeneralCode
+	VendorID     uint16
+	ProtocolID   ProtocolID
+	ProtocolCode uint16
+	ProtocolData []byte
+}
+
+const statusReportFixedSize = 2 + 4 + 2
+
+var errStatusReportTooShort = errors.New("message: status report too short")
+
+// Marshal encodes the status report to its wire form.
+func (s *StatusReport) Marshal() ([]byte, error) {
+	buf := make([]byte, statusReportFixedSize, statusReportFixedSize+len(s.ProtocolData))
+	binary.LittleEndian.PutUint16(buf[0:2], uint16(s.GeneralCode))
+	binary.LittleEndian.PutUint16(buf[2:4], uint16(s.ProtocolID))
+	binary.LittleEndian.PutUint16(buf[4:6], s.VendorID)
+	binary.LittleEndian.PutUint16(buf[6:8], s.ProtocolCode)
+	return append(buf, s.ProtocolData...), nil
+}
+
+// Unmarshal decodes a status report from b. ProtocolData aliases b.
+func (s *StatusReport) Unmarshal(b []byte) error {
+	if len(b) < statusReportFixedSize {
+		return errStatusReportTooShort
+	}
+	s.GeneralCode = GeneralCode(binary.LittleEndian.Uint16(b[0:2]))
+	s.ProtocolID = ProtocolID(binary.LittleEndian.Uint16(b[2:4]))
+	s.VendorID = binary.LittleEndian.Uint16(b[4:6])
+	s.ProtocolCode = binary.LittleEndian.Uint16(b[6:8])
+	s.ProtocolData = nil
+	if len(b) > statusReportFixedSize {
+		s.ProtocolData = b[statusReportFixedSize:]
+	}
+	return nil
+}
+
+// Success reports whether the general code is GeneralCodeSuccess.
+func (s *StatusReport) Success() bool { return s.GeneralCode == GeneralCodeSuccess }
+
+func (s *StatusReport) String() string {
+	return fmt.Sprintf("%v (protocol %#04x, code %#04x)", s.GeneralCode, uint16(s.ProtocolID), s.ProtocolCode)
+}
+
+// IsStatusReport reports whether f carries a StatusReport.
+func IsStatusReport(f *Frame) bool {
+	return f.PayloadHeader.ProtocolID == ProtocolSecureChannel && f.PayloadHeader.Opcode == OpcodeStatusReport
+}
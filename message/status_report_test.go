@@ -0,0 +1,51 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStatusReport_MarshalKnownVector(t *testing.T) {
+	s := StatusReport{
+		GeneralCode:  GeneralCodeFailure,
+		ProtocolID:   ProtocolSecureChannel,
+		ProtocolCode: SecureChannelInvalidParameter,
+	}
+	got, err := s.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := []byte{
+		0x01, 0x00, // general code: FAILURE
+		0x00, 0x00, 0x00, 0x00, // protocol: SecureChannel, vendor 0
+		0x02, 0x00, // protocol code: INVALID_PARAMETER
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Marshal mismatch:\n got %x\nwant %x", got, want)
+	}
+}
+
+func TestStatusReport_RoundTrip(t *testing.T) {
+	in := StatusReport{
+		GeneralCode:  GeneralCodeBusy,
+		VendorID:     0xFFF1,
+		ProtocolID:   ProtocolInteractionModel,
+		ProtocolCode: 0x0104,
+		ProtocolData: []byte{0xE8, 0x03},
+	}
+	wire, _ := in.Marshal()
+	var out StatusReport
+	if err := out.Unmarshal(wire); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.GeneralCode != in.GeneralCode || out.VendorID != in.VendorID || out.ProtocolID != in.ProtocolID ||
+		out.ProtocolCode != in.ProtocolCode || !bytes.Equal(out.ProtocolData, in.ProtocolData) {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+	if out.Success() {
+		t.Error("BUSY reported as success")
+	}
+	if err := out.Unmarshal(wire[:7]); err == nil {
+		t.Error("truncated report accepted")
+	}
+}
@@ -1,26 +1,28 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net"
 	"time"
 
 	"go-matter/commissioning"
+	"go-matter/exchange"
 	"go-matter/message"
 	"go-matter/session"
+	"go-matter/setup"
 	"go-matter/transport"
 )
 
-type controllerMessenger struct {
-	tm         *transport.TransportManager
-	deviceAddr *net.UDPAddr
-}
-
-func (m *controllerMessenger) SendMessage(frame *message.Frame) error {
-	return m.tm.Send(m.deviceAddr, frame, false)
-}
-
 func main() {
+	code := flag.String("code", "34970112332", "QR payload (MT:...) or manual pairing code printed on the device")
+	flag.Parse()
+	payload, err := setup.Parse(*code)
+	if err != nil {
+		panic(err)
+	}
+
 	const ctrlPort = 5550
 	const devicePort = 5540
 	deviceAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: devicePort}
@@ -32,35 +34,35 @@ func main() {
 	defer tm.Close()
 
 	sm := session.NewSessionManager(nil)
-	commissioner := commissioning.NewCommissioner(&controllerMessenger{tm: tm, deviceAddr: deviceAddr}, sm)
-
+	em := exchange.NewExchangeManager(sm, tm)
+	ctx := context.Background()
+	go em.Run(ctx)
 	go func() {
 		fmt.Printf("Controller listening on %d...\n", ctrlPort)
-		if err := tm.Start(func(frame *message.Frame, from *net.UDPAddr) {
-			fmt.Printf("Controller <- opcode=%#x exchange=%d payload=%d bytes from %s\n",
-				byte(frame.PayloadHeader.Opcode), frame.PayloadHeader.ExchangeID,
-				len(frame.Payload), from)
-			if err := commissioner.HandleMessage(frame); err != nil {
-				fmt.Printf("HandleMessage error: %v\n", err)
-				return
-			}
-			fmt.Printf("Commissioner state=%d salt=%x iterations=%d responderSessionID=%d\n",
-				commissioner.State, commissioner.Salt, commissioner.Iterations,
-				commissioner.ResponderSessionID)
-			if commissioner.State == commissioning.StateComplete {
-				if s, ok := sm.Session(commissioner.SessionID); ok {
-					fmt.Printf("Commissioner installed PASE-secure session id=%d attestationPrefix=%x\n",
-						commissioner.SessionID, s.AttestationChallenge[:4])
-				}
-			}
-		}); err != nil {
+		if err := tm.Serve(em.Receive); err != nil {
 			fmt.Printf("Transport error: %v\n", err)
 		}
 	}()
 
-	time.Sleep(100 * time.Millisecond)
-	if err := commissioner.StartPASE(12345678); err != nil {
-		fmt.Printf("StartPASE: %v\n", err)
+	ex, err := em.NewExchange(session.UnsecuredSessionID, deviceAddr, message.ProtocolSecureChannel)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Controller -> PASE on exchange=%d to %s\n", ex.ID(), deviceAddr)
+
+	commissioner := commissioning.NewCommissioner(sm)
+	pctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := commissioner.EstablishPASEWithPayload(pctx, ex, payload); err != nil {
+		fmt.Printf("PASE: %v\n", err)
+		return
+	}
+	fmt.Printf("Commissioner state=%d salt=%x iterations=%d responderSessionID=%d\n",
+		commissioner.State, commissioner.Salt, commissioner.Iterations,
+		commissioner.ResponderSessionID)
+	if s, ok := sm.Session(commissioner.SessionID); ok {
+		fmt.Printf("Commissioner installed PASE-secure session id=%d attestationPrefix=%x\n",
+			commissioner.SessionID, s.AttestationChallenge[:4])
 	}
 	select {}
 }
@@ -1,39 +1,49 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"net"
 
 	"go-matter/commissioning"
+	"go-matter/exchange"
 	"go-matter/message"
 	"go-matter/session"
+	"go-matter/setup"
 	"go-matter/transport"
 )
 
-// deviceMessenger sends Commissionee replies back to the most recent peer.
-// The transport read loop is single-threaded, so no synchronisation is needed.
-type deviceMessenger struct {
-	tm   *transport.TransportManager
-	peer *net.UDPAddr
-}
-
-func (m *deviceMessenger) SendMessage(frame *message.Frame) error {
-	if m.peer == nil {
-		return errors.New("device messenger: no known peer")
-	}
-	return m.tm.Send(m.peer, frame, false)
+// onboarding mirrors the connectedhomeip development device credentials
+// (VID 0xFFF1, PID 0x8000, discriminator 3840, passcode 20202021).
+var onboarding = setup.Payload{
+	VendorID:              0xFFF1,
+	ProductID:             0x8000,
+	DiscoveryCapabilities: setup.DiscoveryOnNetwork,
+	Discriminator:         3840,
+	Passcode:              20202021,
 }
 
 func main() {
 	const devicePort = 5540
 
+	qr, err := onboarding.QRCode()
+	if err != nil {
+		panic(err)
+	}
+	manual, err := onboarding.ManualCode()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("QR code: %s\nManual pairing code: %s\n", qr, manual)
+
 	sm := session.NewSessionManager(nil)
 	commissionee, err := commissioning.NewCommissionee(
-		12345678, []byte("SPAKE2P Key Salt"), 1000, sm)
+		onboarding.Passcode, []byte("SPAKE2P Key Salt"), 1000, sm)
 	if err != nil {
 		panic(err)
 	}
+	if err := commissionee.OpenBasicCommissioningWindow(commissioning.MaxCommissioningTimeout); err != nil {
+		panic(err)
+	}
 
 	tm, err := transport.NewTransportManager(devicePort, nil)
 	if err != nil {
@@ -41,30 +51,25 @@ func main() {
 	}
 	defer tm.Close()
 
-	messenger := &deviceMessenger{tm: tm}
-	commissionee.Messenger = messenger
-
-	fmt.Printf("Device listening on %d...\n", devicePort)
-	err = tm.Start(func(frame *message.Frame, from *net.UDPAddr) {
-		fmt.Printf("Device <- opcode=%#x exchange=%d payload=%d bytes from %s\n",
-			byte(frame.PayloadHeader.Opcode), frame.PayloadHeader.ExchangeID,
-			len(frame.Payload), from)
-
-		messenger.peer = from
-		if err := commissionee.HandleMessage(frame); err != nil {
-			fmt.Printf("HandleMessage error: %v\n", err)
+	ctx := context.Background()
+	em := exchange.NewExchangeManager(sm, tm)
+	em.RegisterUnsolicitedHandler(message.ProtocolSecureChannel, func(ex *exchange.Exchange) {
+		fmt.Printf("Device <- PASE on exchange=%d from %s\n", ex.ID(), ex.Peer())
+		if err := commissionee.HandleExchange(ctx, ex); err != nil {
+			fmt.Printf("PASE: %v\n", err)
 			return
 		}
 		fmt.Printf("Commissionee state=%d sessionID=%d responderRandom=%x\n",
 			commissionee.State, commissionee.SessionID, commissionee.Random)
-		if commissionee.State == commissioning.StateComplete {
-			if s, ok := sm.Session(commissionee.SessionID); ok {
-				fmt.Printf("Commissionee installed PASE-secure session id=%d attestationPrefix=%x\n",
-					commissionee.SessionID, s.AttestationChallenge[:4])
-			}
+		if s, ok := sm.Session(commissionee.SessionID); ok {
+			fmt.Printf("Commissionee installed PASE-secure session id=%d attestationPrefix=%x\n",
+				commissionee.SessionID, s.AttestationChallenge[:4])
 		}
 	})
-	if err != nil {
+	go em.Run(ctx)
+
+	fmt.Printf("Device listening on %d...\n", devicePort)
+	if err := tm.Serve(em.Receive); err != nil {
 		fmt.Printf("Transport error: %v\n", err)
 	}
 }
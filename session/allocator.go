@@ -0,0 +1,84 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// sessionIDQuarantine is how many recently released session IDs
+// AllocateSessionID refuses to hand out again. A peer may still have
+// frames in flight addressed to a just-closed ID; reusing it immediately
+// would route them to an unrelated session.
+const sessionIDQuarantine = 64
+
+// ErrNoSessionIDs is returned when every non-zero session ID is in use,
+// reserved or quarantined.
+var ErrNoSessionIDs = errors.New("session: no free session ids")
+
+// AllocateSessionID reserves a non-zero local session ID for a handshake
+// about to start (Matter §4.13.1.1). IDs are handed out sequentially from
+// a random starting point, skipping any in the table, already reserved or
+// recently released. The reservation is consumed when CreateSession (or
+// InstallSecureSession) installs the ID; call ReleaseSessionID if the
+// handshake is abandoned.
+func (sm *SessionManager) AllocateSessionID() (uint16, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if !sm.idSeeded {
+		var b [2]byte
+		if _, err := io.ReadFull(sm.provider.Rand(), b[:]); err != nil {
+			return 0, fmt.Errorf("session: seed session id: %w", err)
+		}
+		sm.nextID = binary.LittleEndian.Uint16(b[:])
+		sm.idSeeded = true
+	}
+	for range 1 << 16 {
+		id := sm.nextID
+		sm.nextID++
+		if id == UnsecuredSessionID {
+			continue
+		}
+		if _, ok := sm.sessions[id]; ok {
+			continue
+		}
+		if _, ok := sm.reservedIDs[id]; ok {
+			continue
+		}
+		if _, ok := sm.quarantinedIDs[id]; ok {
+			continue
+		}
+		sm.reservedIDs[id] = struct{}{}
+		return id, nil
+	}
+	return 0, ErrNoSessionIDs
+}
+
+// ReleaseSessionID drops a reservation made by AllocateSessionID without
+// installing a session. The ID is quarantined like a closed session's.
+func (sm *SessionManager) ReleaseSessionID(id uint16) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if _, ok := sm.reservedIDs[id]; !ok {
+		return
+	}
+	delete(sm.reservedIDs, id)
+	sm.quarantine(id)
+}
+
+// quarantine records id as recently released, forgetting the oldest entry
+// once sessionIDQuarantine are held. An ID released again while still
+// quarantined stays so until its latest release is forgotten. The caller
+// holds sm.mu.
+func (sm *SessionManager) quarantine(id uint16) {
+	if len(sm.quarantineRing) == sessionIDQuarantine {
+		oldest := sm.quarantineRing[0]
+		if sm.quarantinedIDs[oldest]--; sm.quarantinedIDs[oldest] == 0 {
+			delete(sm.quarantinedIDs, oldest)
+		}
+		sm.quarantineRing = sm.quarantineRing[1:]
+	}
+	sm.quarantineRing = append(sm.quarantineRing, id)
+	sm.quarantinedIDs[id]++
+}
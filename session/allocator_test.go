@@ -0,0 +1,137 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"go-matter/crypto"
+)
+
+func TestAllocateSessionID_UniqueAndNonZero(t *testing.T) {
+	sm := NewSessionManager(nil)
+	seen := make(map[uint16]bool)
+	for range 1000 {
+		id, err := sm.AllocateSessionID()
+		if err != nil {
+			t.Fatalf("AllocateSessionID: %v", err)
+		}
+		if id == UnsecuredSessionID {
+			t.Fatal("allocated the unsecured session id")
+		}
+		if seen[id] {
+			t.Fatalf("id %d allocated twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestAllocateSessionID_RandomStart(t *testing.T) {
+	first := func(seed string) uint16 {
+		sm := NewSessionManagerWithConfig(nil, Config{
+			CryptoProvider: crypto.NewDeterministicCryptoProvider([]byte(seed)),
+		})
+		id, err := sm.AllocateSessionID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	if first("a") == first("b") && first("c") == first("d") {
+		t.Error("starting id does not depend on the provider's randomness")
+	}
+	if first("a") != first("a") {
+		t.Error("same seed gave different starting ids")
+	}
+}
+
+func TestAllocateSessionID_SkipsInUseAndQuarantined(t *testing.T) {
+	sm := NewSessionManager(nil)
+	sm.idSeeded, sm.nextID = true, 10
+
+	// 10 is installed directly, so allocation must skip it.
+	if _, err := sm.CreateSession(SessionParams{ID: 10, Keys: testKeys(t)}); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := sm.AllocateSessionID()
+	if id != 11 {
+		t.Fatalf("got %d, want 11 (10 is in use)", id)
+	}
+	if _, err := sm.CreateSession(SessionParams{ID: id, Keys: testKeys(t)}); err != nil {
+		t.Fatalf("installing the allocated id: %v", err)
+	}
+
+	// Closing 10 and 11 quarantines them even once the counter wraps
+	// back round.
+	sm.RemoveSession(10)
+	sm.RemoveSession(11)
+	sm.nextID = 10
+	if id, _ := sm.AllocateSessionID(); id != 12 {
+		t.Errorf("got %d, want 12 (10 and 11 quarantined)", id)
+	}
+}
+
+func TestReleaseSessionID(t *testing.T) {
+	sm := NewSessionManager(nil)
+	sm.idSeeded, sm.nextID = true, 500
+	id, _ := sm.AllocateSessionID()
+	sm.ReleaseSessionID(id)
+	sm.nextID = id
+	if again, _ := sm.AllocateSessionID(); again == id {
+		t.Error("released id handed out again while quarantined")
+	}
+}
+
+func TestAllocateSessionID_QuarantineIsBounded(t *testing.T) {
+	sm := NewSessionManager(nil)
+	sm.idSeeded, sm.nextID = true, 1
+	first, _ := sm.AllocateSessionID()
+	sm.ReleaseSessionID(first)
+	for range sessionIDQuarantine {
+		id, _ := sm.AllocateSessionID()
+		sm.ReleaseSessionID(id)
+	}
+	sm.nextID = first
+	if id, _ := sm.AllocateSessionID(); id != first {
+		t.Errorf("got %d, want %d to leave quarantine after %d releases", id, first, sessionIDQuarantine)
+	}
+}
+
+// TestAllocateSessionID_RequarantineRestartsWindow — an ID closed twice
+// within the window stays quarantined until its second release ages out.
+func TestAllocateSessionID_RequarantineRestartsWindow(t *testing.T) {
+	sm := NewSessionManager(nil)
+	sm.idSeeded, sm.nextID = true, 1
+	const twice = 1000
+	openAndClose := func(id uint16) {
+		if _, err := sm.CreateSession(SessionParams{ID: id, Keys: testKeys(t)}); err != nil {
+			t.Fatal(err)
+		}
+		sm.RemoveSession(id)
+	}
+	openAndClose(twice)
+	for range sessionIDQuarantine / 2 {
+		id, _ := sm.AllocateSessionID()
+		sm.ReleaseSessionID(id)
+	}
+	openAndClose(twice)
+	for range sessionIDQuarantine / 2 {
+		id, _ := sm.AllocateSessionID()
+		sm.ReleaseSessionID(id)
+	}
+	sm.nextID = twice
+	if id, _ := sm.AllocateSessionID(); id == twice {
+		t.Errorf("id %d handed out %d releases after it was last closed", twice, sessionIDQuarantine/2)
+	}
+}
+
+func TestAllocateSessionID_Exhausted(t *testing.T) {
+	sm := NewSessionManager(nil)
+	for range 1<<16 - 1 {
+		if _, err := sm.AllocateSessionID(); err != nil {
+			t.Fatalf("AllocateSessionID: %v", err)
+		}
+	}
+	if _, err := sm.AllocateSessionID(); !errors.Is(err, ErrNoSessionIDs) {
+		t.Errorf("err = %v, want ErrNoSessionIDs", err)
+	}
+}
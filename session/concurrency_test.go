@@ -0,0 +1,175 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// These tests are meant for `go test -race`; without it they still check
+// the counter and replay invariants under contention.
+
+// TestConcurrent_EncryptDecrypt — many senders draw counters and encrypt in
+// parallel while many receivers decrypt; every counter is unique and every
+// frame is accepted exactly once.
+func TestConcurrent_EncryptDecrypt(t *testing.T) {
+	initSM, respSM, sid, initNode, _, _ := pairedSessions(t)
+	s, _ := initSM.Session(sid)
+
+	const senders, perSender = 8, 50
+	type sealed struct {
+		counter uint32
+		header  []byte
+		ct      []byte
+	}
+	frames := make(chan sealed, senders*perSender)
+
+	var wg sync.WaitGroup
+	for range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perSender {
+				c, err := s.NextOutboundCounter()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				header := buildHeader(t, sid, c, initNode)
+				ct, err := initSM.EncryptPayload(sid, []byte("payload"), header)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				frames <- sealed{c, header, ct}
+			}
+		}()
+	}
+	wg.Wait()
+	close(frames)
+
+	seen := make(map[uint32]bool)
+	var all []sealed
+	for f := range frames {
+		if seen[f.counter] {
+			t.Fatalf("counter %d handed out twice", f.counter)
+		}
+		seen[f.counter] = true
+		all = append(all, f)
+	}
+
+	// Deliver in counter order from several goroutines so the replay
+	// window advances under contention without falling behind by more
+	// than its width.
+	var accepted atomic.Int32
+	work := make(chan sealed)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range work {
+				if _, err := respSM.DecryptPayload(sid, f.ct, f.header); err == nil {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+	for c := uint32(1); c <= senders*perSender; c++ {
+		for _, f := range all {
+			if f.counter == c {
+				work <- f
+				break
+			}
+		}
+	}
+	close(work)
+	wg.Wait()
+	// With 4 workers a frame can trail the newest accepted counter by at
+	// most a few positions, well inside MessageCounterWindowSize.
+	if got := accepted.Load(); got != senders*perSender {
+		t.Errorf("accepted %d frames, want %d", got, senders*perSender)
+	}
+}
+
+// TestConcurrent_ReplayAcceptedOnce — the same frame delivered from many
+// goroutines at once is accepted exactly once.
+func TestConcurrent_ReplayAcceptedOnce(t *testing.T) {
+	initSM, respSM, sid, initNode, _, _ := pairedSessions(t)
+	header := buildHeader(t, sid, 1, initNode)
+	ct, err := initSM.EncryptPayload(sid, []byte("once"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := respSM.DecryptPayload(sid, ct, header); err == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("frame accepted %d times, want 1", got)
+	}
+}
+
+// TestConcurrent_TableChurn — installs, removals, expiry sweeps and
+// traffic on a stable session run side by side.
+func TestConcurrent_TableChurn(t *testing.T) {
+	var closes atomic.Int32
+	sm := NewSessionManagerWithConfig(nil, Config{
+		MaxSessions:     8,
+		PASEIdleTimeout: time.Nanosecond,
+		OnSessionClosed: func(*Session, CloseReason) { closes.Add(1) },
+	})
+	keys := testKeys(t)
+	if _, err := sm.CreateSession(SessionParams{ID: 1, Type: SessionTypeCASE, FabricIndex: 1, Keys: keys}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				id := uint16(100 + w*1000 + i)
+				sm.CreateSession(SessionParams{ID: id, Type: SessionTypePASE, Keys: keys})
+				sm.RemoveSession(id)
+			}
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			sm.ExpireIdleSessions()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		s, _ := sm.Session(1)
+		for range 100 {
+			c, err := s.NextOutboundCounter()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sm.EncryptPayload(1, []byte("x"), buildHeader(t, 1, c, 1))
+		}
+	}()
+	wg.Wait()
+
+	if closes.Load() < 400 {
+		t.Errorf("saw %d close notifications, want at least 400", closes.Load())
+	}
+}
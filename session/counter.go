@@ -0,0 +1,136 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"go-matter/storage"
+)
+
+// DefaultCounterReserve is how far ahead of the last value handed out a
+// PersistentCounter checkpoints. A restart resumes at the checkpoint, so up
+// to this many values are skipped but none is ever reused (Matter
+// §4.5.1.2). Larger values mean fewer storage writes.
+const DefaultCounterReserve uint32 = 1000
+
+// counterMaxInitial bounds the random starting value of a fresh global
+// counter (Matter §4.5.1.2: a random value in [1, 2^28]) so a new node is
+// far from wrapping.
+const counterMaxInitial = 1 << 28
+
+// Storage keys for the node-wide counters.
+const (
+	unsecuredCounterKey    = "session/global-unencrypted-counter"
+	groupDataCounterKey    = "session/group-data-counter"
+	groupControlCounterKey = "session/group-control-counter"
+)
+
+// PersistentCounter is a 32-bit message counter that survives restarts.
+// It stores a checkpoint reserve values ahead of what it has handed out
+// and only writes again when that reserve is used up, so storage sees one
+// write per reserve values. The counter wraps mod 2^32, which the global
+// counters it backs are allowed to do. Safe for concurrent use.
+type PersistentCounter struct {
+	store   storage.Storage
+	key     string
+	reserve uint32
+
+	mu    sync.Mutex
+	next  uint32 // value the next call to Next returns
+	limit uint32 // checkpoint on storage; next never reaches it unpersisted
+}
+
+// NewPersistentCounter loads the counter stored under key, or starts a new
+// one at a random value in [1, 2^28] drawn from r, and checkpoints it
+// before returning. reserve 0 selects DefaultCounterReserve.
+func NewPersistentCounter(store storage.Storage, key string, reserve uint32, r io.Reader) (*PersistentCounter, error) {
+	if reserve == 0 {
+		reserve = DefaultCounterReserve
+	}
+	c := &PersistentCounter{store: store, key: key, reserve: reserve}
+	v, err := store.Get(key)
+	switch {
+	case err == nil:
+		if len(v) != 4 {
+			return nil, fmt.Errorf("session: counter %s: stored value is %d bytes, want 4", key, len(v))
+		}
+		c.next = binary.LittleEndian.Uint32(v)
+	case errors.Is(err, storage.ErrNotFound):
+		var b [4]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, fmt.Errorf("session: seed counter %s: %w", key, err)
+		}
+		c.next = binary.LittleEndian.Uint32(b[:])%counterMaxInitial + 1
+	default:
+		return nil, fmt.Errorf("session: load counter %s: %w", key, err)
+	}
+	if err := c.checkpoint(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Next returns the next counter value. It fails only if the checkpoint
+// cannot be written, in which case no value is handed out.
+func (c *PersistentCounter) Next() (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next == c.limit {
+		if err := c.checkpoint(); err != nil {
+			return 0, err
+		}
+	}
+	v := c.next
+	c.next++
+	return v, nil
+}
+
+// Last returns the value most recently handed out by Next (or, before
+// the first call, the value just below the first).
+func (c *PersistentCounter) Last() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.next - 1
+}
+
+// checkpoint persists next+reserve as the value a restart resumes from.
+// The caller holds c.mu, or is the constructor.
+func (c *PersistentCounter) checkpoint() error {
+	limit := c.next + c.reserve
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], limit)
+	if err := c.store.Set(c.key, b[:]); err != nil {
+		return fmt.Errorf("session: persist counter %s: %w", c.key, err)
+	}
+	c.limit = limit
+	return nil
+}
+
+// NextUnsecuredCounter returns the next value of the node's global
+// unencrypted message counter, shared by every unsecured exchange
+// (Matter §4.5.1.2).
+func (sm *SessionManager) NextUnsecuredCounter() (uint32, error) {
+	c, err := sm.globalCounter(&sm.unsecuredCounter, unsecuredCounterKey)
+	if err != nil {
+		return 0, err
+	}
+	return c.Next()
+}
+
+// globalCounter loads *slot from storage on first use. Loading is lazy so
+// constructing a SessionManager never touches storage.
+func (sm *SessionManager) globalCounter(slot **PersistentCounter, key string) (*PersistentCounter, error) {
+	sm.counterMu.Lock()
+	defer sm.counterMu.Unlock()
+	if *slot == nil {
+		c, err := NewPersistentCounter(sm.storage, key, sm.counterReserve, sm.provider.Rand())
+		if err != nil {
+			return nil, err
+		}
+		*slot = c
+	}
+	return *slot, nil
+}
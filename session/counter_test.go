@@ -0,0 +1,142 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"go-matter/crypto"
+	"go-matter/storage"
+)
+
+func storedCounter(t *testing.T, s storage.Storage, key string) uint32 {
+	t.Helper()
+	v, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	return binary.LittleEndian.Uint32(v)
+}
+
+func TestPersistentCounter_FreshIsRandomAndCheckpointed(t *testing.T) {
+	store := storage.NewMemory()
+	c, err := NewPersistentCounter(store, "c", 10, crypto.NewDeterministicCryptoProvider([]byte("ctr")).Rand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := c.Next()
+	if first == 0 || first > counterMaxInitial {
+		t.Errorf("first = %d, want in [1, 2^28]", first)
+	}
+	if got := storedCounter(t, store, "c"); got != first+10 {
+		t.Errorf("checkpoint = %d, want %d", got, first+10)
+	}
+}
+
+// TestPersistentCounter_RestartNeverReuses hands out values across
+// several checkpoints and "restarts" at every step; each new instance must
+// start beyond everything its predecessors returned.
+func TestPersistentCounter_RestartNeverReuses(t *testing.T) {
+	store := storage.NewMemory()
+	r := crypto.NewDeterministicCryptoProvider([]byte("restart")).Rand()
+	var highest uint32
+	for run := range 5 {
+		c, err := NewPersistentCounter(store, "c", 4, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range run * 3 {
+			v, err := c.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if run > 0 && v <= highest {
+				t.Fatalf("run %d value %d: %d reuses ≤ %d", run, i, v, highest)
+			}
+			highest = v
+		}
+	}
+}
+
+func TestPersistentCounter_Wraps(t *testing.T) {
+	store := storage.NewMemory()
+	store.Set("c", []byte{0xFE, 0xFF, 0xFF, 0xFF})
+	c, err := NewPersistentCounter(store, "c", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []uint32
+	for range 4 {
+		v, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	want := []uint32{0xFFFFFFFE, 0xFFFFFFFF, 0, 1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("values = %x, want %x", got, want)
+		}
+	}
+}
+
+// failingStore accepts writes until fail is set.
+type failingStore struct {
+	*storage.Memory
+	fail bool
+}
+
+func (f *failingStore) Set(key string, value []byte) error {
+	if f.fail {
+		return errors.New("disk full")
+	}
+	return f.Memory.Set(key, value)
+}
+
+func TestPersistentCounter_CheckpointFailureWithholdsValue(t *testing.T) {
+	store := &failingStore{Memory: storage.NewMemory()}
+	c, err := NewPersistentCounter(store, "c", 2, crypto.NewDeterministicCryptoProvider(nil).Rand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Next()
+	c.Next()
+	store.fail = true
+	if _, err := c.Next(); err == nil {
+		t.Fatal("Next handed out a value past an unpersisted checkpoint")
+	}
+	store.fail = false
+	if _, err := c.Next(); err != nil {
+		t.Errorf("after recovery: %v", err)
+	}
+}
+
+func TestPersistentCounter_RejectsCorruptValue(t *testing.T) {
+	store := storage.NewMemory()
+	store.Set("c", []byte{1, 2})
+	if _, err := NewPersistentCounter(store, "c", 0, nil); err == nil {
+		t.Error("accepted a 2-byte counter")
+	}
+}
+
+func TestSessionManager_GlobalCountersSurviveRestart(t *testing.T) {
+	store := storage.NewMemory()
+	cfg := Config{Storage: store, CounterReserve: 8}
+	before := NewSessionManagerWithConfig(nil, cfg)
+	var lastUnsecured, lastGroup uint32
+	for range 10 {
+		lastUnsecured, _ = before.NextUnsecuredCounter()
+		lastGroup, _ = before.NextGroupCounter(false)
+	}
+
+	after := NewSessionManagerWithConfig(nil, cfg)
+	u, err := after.NextUnsecuredCounter()
+	if err != nil || u <= lastUnsecured {
+		t.Errorf("unsecured after restart = %d (%v), last before = %d", u, err, lastUnsecured)
+	}
+	g, err := after.NextGroupCounter(false)
+	if err != nil || g <= lastGroup {
+		t.Errorf("group data after restart = %d (%v), last before = %d", g, err, lastGroup)
+	}
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"go-matter/message"
+)
+
+func testFrame(t *testing.T) *message.Frame {
+	t.Helper()
+	f, err := message.NewBuilder().
+		Protocol(message.ProtocolInteractionModel).
+		Opcode(0x02).
+		ExchangeID(0x4242).
+		Initiator().
+		Payload([]byte("read")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// TestSealOpenFrame_UsesPeerSessionID installs the two ends of one session
+// under different local IDs, as PASE and CASE do, and checks each side
+// addresses the other's.
+func TestSealOpenFrame_UsesPeerSessionID(t *testing.T) {
+	keys := testKeys(t)
+	ctrl, dev := NewSessionManager(nil), NewSessionManager(nil)
+	if _, err := ctrl.CreateSession(SessionParams{ID: 10, PeerSessionID: 20, Keys: keys, Role: RoleInitiator}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dev.CreateSession(SessionParams{ID: 20, PeerSessionID: 10, Keys: keys, Role: RoleResponder}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := testFrame(t)
+	wire, err := ctrl.SealFrame(10, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.SessionID != 20 || f.Header.MessageCounter == 0 {
+		t.Errorf("sealed header = %+v", f.Header)
+	}
+	if bytes.Contains(wire, []byte("read")) {
+		t.Error("payload sent in the clear")
+	}
+	got, err := dev.OpenFrame(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Header.SessionID != 20 || got.PayloadHeader != f.PayloadHeader || string(got.Payload) != "read" {
+		t.Errorf("opened = %+v", got)
+	}
+	if _, err := dev.OpenFrame(wire); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("replayed frame: err = %v", err)
+	}
+}
+
+func TestSealOpenFrame_Unsecured(t *testing.T) {
+	sm := NewSessionManager(nil)
+	f := testFrame(t)
+	wire, err := sm.SealFrame(UnsecuredSessionID, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.MessageCounter == 0 {
+		t.Fatalf("counter not stamped: %+v", f.Header)
+	}
+	got, err := NewSessionManager(nil).OpenFrame(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Header != f.Header || string(got.Payload) != "read" {
+		t.Errorf("opened = %+v, want %+v", got, f)
+	}
+}
+
+func TestSealFrame_UnknownSession(t *testing.T) {
+	if _, err := NewSessionManager(nil).SealFrame(3, testFrame(t)); !errors.Is(err, ErrUnknownSession) {
+		t.Errorf("err = %v, want ErrUnknownSession", err)
+	}
+}
@@ -0,0 +1,480 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-matter/crypto"
+	"go-matter/message"
+)
+
+// DefaultMaxGroupSessions caps how many (fabric, group, source node)
+// counter records a node keeps. The least recently used record is dropped
+// when a new source appears; under trust-first that source is simply
+// trusted afresh on its next message.
+const DefaultMaxGroupSessions = 64
+
+var (
+	// ErrUnknownGroupKey is returned when no operational group key is
+	// installed for the fabric and group, or when no installed key
+	// authenticates an inbound group message.
+	ErrUnknownGroupKey = errors.New("session: no matching group key")
+
+	// ErrGroupCounterUnsynchronized is returned for a message from a
+	// cache-and-sync source whose counter has not been synchronised yet.
+	// The message authenticated but was not accepted; the receiver must
+	// run message counter synchronisation with the source first.
+	ErrGroupCounterUnsynchronized = errors.New("session: group peer counter not synchronized")
+
+	errNotGroupMessage = errors.New("session: header is not a group message")
+	errGroupViaUnicast = errors.New("session: group messages must be sealed with EncryptGroupPayload")
+)
+
+// GroupCounterPolicy selects how a receiver establishes trust in the first
+// counter it sees from a group source (Matter §4.5.4.2).
+type GroupCounterPolicy int
+
+const (
+	// GroupCounterTrustFirst accepts the first authenticated counter from
+	// a source and tracks from there. Cheap, but a message captured before
+	// the receiver first heard from the source can be replayed once.
+	GroupCounterTrustFirst GroupCounterPolicy = iota
+	// GroupCounterCacheAndSync holds back every message from a source
+	// until its counter has been synchronised over a unicast session.
+	GroupCounterCacheAndSync
+)
+
+func (p GroupCounterPolicy) String() string {
+	switch p {
+	case GroupCounterTrustFirst:
+		return "trust-first"
+	case GroupCounterCacheAndSync:
+		return "cache-and-sync"
+	default:
+		return "unknown"
+	}
+}
+
+// GroupKeyParams installs one operational group key. The key is derived
+// from EpochKey and CompressedFabricID with the manager's crypto provider
+// (Matter §4.17.2); the group session ID follows from the key.
+type GroupKeyParams struct {
+	FabricIndex        uint8
+	GroupID            uint16
+	EpochKey           []byte
+	CompressedFabricID []byte
+	Policy             GroupCounterPolicy
+}
+
+// groupKey is an installed operational group key.
+type groupKey struct {
+	fabricIndex uint8
+	groupID     uint16
+	sessionID   uint16
+	key         []byte
+	policy      GroupCounterPolicy
+}
+
+// groupPeer keys the group session table.
+type groupPeer struct {
+	fabricIndex  uint8
+	groupID      uint16
+	sourceNodeID uint64
+}
+
+// GroupSession tracks the counters one source node uses for one group on
+// one fabric. Group messages carry no per-peer keys, so this holds only
+// replay state. Data and control messages are counted independently
+// (Matter §4.5.1.2).
+type GroupSession struct {
+	FabricIndex  uint8
+	GroupID      uint16
+	SourceNodeID uint64
+	Policy       GroupCounterPolicy
+
+	mu           sync.Mutex
+	data         groupWindow
+	control      groupWindow
+	lastActivity atomic.Int64 // UnixNano
+}
+
+// LastActivity returns the time of the last accepted message.
+func (g *GroupSession) LastActivity() time.Time {
+	return time.Unix(0, g.lastActivity.Load())
+}
+
+func (g *GroupSession) window(control bool) *groupWindow {
+	if control {
+		return &g.control
+	}
+	return &g.data
+}
+
+// AddGroupKey derives and installs an operational group key. Several keys
+// may be installed for the same group during an epoch key rotation;
+// inbound messages are tried against each, and outbound messages use the
+// most recently added.
+func (sm *SessionManager) AddGroupKey(p GroupKeyParams) error {
+	key, err := crypto.DeriveOperationalGroupKeyWith(sm.provider, p.EpochKey, p.CompressedFabricID)
+	if err != nil {
+		return fmt.Errorf("session: derive group key: %w", err)
+	}
+	sessionID, err := crypto.DeriveGroupSessionIDWith(sm.provider, key)
+	if err != nil {
+		return fmt.Errorf("session: derive group session id: %w", err)
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, k := range sm.groupKeys {
+		if k.fabricIndex == p.FabricIndex && k.groupID == p.GroupID && bytes.Equal(k.key, key) {
+			k.policy = p.Policy
+			return nil
+		}
+	}
+	sm.groupKeys = append(sm.groupKeys, &groupKey{
+		fabricIndex: p.FabricIndex,
+		groupID:     p.GroupID,
+		sessionID:   sessionID,
+		key:         key,
+		policy:      p.Policy,
+	})
+	return nil
+}
+
+// RemoveGroupKeys drops every key and group session for the group,
+// reporting whether any key was installed.
+func (sm *SessionManager) RemoveGroupKeys(fabricIndex uint8, groupID uint16) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	kept := sm.groupKeys[:0]
+	removed := false
+	for _, k := range sm.groupKeys {
+		if k.fabricIndex == fabricIndex && k.groupID == groupID {
+			clear(k.key)
+			removed = true
+			continue
+		}
+		kept = append(kept, k)
+	}
+	clear(sm.groupKeys[len(kept):])
+	sm.groupKeys = kept
+	for peer := range sm.groupSessions {
+		if peer.fabricIndex == fabricIndex && peer.groupID == groupID {
+			delete(sm.groupSessions, peer)
+		}
+	}
+	return removed
+}
+
+// GroupSessionID returns the session ID outbound messages to the group
+// must carry in their header.
+func (sm *SessionManager) GroupSessionID(fabricIndex uint8, groupID uint16) (uint16, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	k := sm.currentGroupKey(fabricIndex, groupID)
+	if k == nil {
+		return 0, ErrUnknownGroupKey
+	}
+	return k.sessionID, nil
+}
+
+// GroupSession returns the counter record for a source, if one exists.
+func (sm *SessionManager) GroupSession(fabricIndex uint8, groupID uint16, sourceNodeID uint64) (*GroupSession, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	g, ok := sm.groupSessions[groupPeer{fabricIndex, groupID, sourceNodeID}]
+	return g, ok
+}
+
+// NextGroupCounter advances and returns the node-wide group counter for
+// data or control messages (Matter §4.5.1.2). Unlike unicast counters
+// these wrap, which is why receivers apply the mod-2^32 window rules. Both
+// are persisted through Config.Storage.
+func (sm *SessionManager) NextGroupCounter(control bool) (uint32, error) {
+	c, err := sm.groupCounter(control)
+	if err != nil {
+		return 0, err
+	}
+	return c.Next()
+}
+
+// lastGroupCounter returns the group counter most recently handed out, as
+// reported to peers synchronising with this node.
+func (sm *SessionManager) lastGroupCounter(control bool) (uint32, error) {
+	c, err := sm.groupCounter(control)
+	if err != nil {
+		return 0, err
+	}
+	return c.Last(), nil
+}
+
+func (sm *SessionManager) groupCounter(control bool) (*PersistentCounter, error) {
+	if control {
+		return sm.globalCounter(&sm.groupControlCounter, groupControlCounterKey)
+	}
+	return sm.globalCounter(&sm.groupDataCounter, groupDataCounterKey)
+}
+
+// EncryptGroupPayload seals payload with the group's current operational
+// key. The header must be a group message (session type group, source
+// node ID present, 16-bit destination equal to groupID) already carrying
+// GroupSessionID and a NextGroupCounter value; it is the AEAD's AAD.
+func (sm *SessionManager) EncryptGroupPayload(fabricIndex uint8, groupID uint16, payload, header []byte) ([]byte, error) {
+	h, err := parseGroupHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if h.Flags.DSIZ() != message.MessageFlagDSIZGroup || uint16(h.DestNodeID) != groupID {
+		return nil, fmt.Errorf("session: header does not address group 0x%04X", groupID)
+	}
+	sm.mu.RLock()
+	var k groupKey
+	cur := sm.currentGroupKey(fabricIndex, groupID)
+	if cur != nil {
+		k = *cur
+		k.key = bytes.Clone(cur.key)
+	}
+	sm.mu.RUnlock()
+	if cur == nil {
+		return nil, ErrUnknownGroupKey
+	}
+	return sm.sealGroup(k, payload, header)
+}
+
+// DecryptGroupPayload opens a group message. Every installed key whose
+// session ID and group match the header is tried, since group session IDs
+// are a 16-bit hash and may collide. Once one authenticates, the counter
+// is checked and committed against the (fabric, group, source) record;
+// nothing is committed for a message that fails authentication or the
+// window check. Data messages follow the key's policy, control messages
+// are always trust-first since they carry counter synchronisation itself.
+func (sm *SessionManager) DecryptGroupPayload(ciphertext, header []byte) (*GroupSession, []byte, error) {
+	k, h, plaintext, err := sm.openGroup(ciphertext, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := sm.groupSessionFor(groupPeer{k.fabricIndex, k.groupID, h.SourceNodeID}, k.policy)
+	if err := sm.acceptGroupCounter(g, h); err != nil {
+		return g, nil, err
+	}
+	return g, plaintext, nil
+}
+
+// openGroup authenticates a group message without touching any counter
+// state, returning the key that opened it. Messages addressed to a node
+// rather than a group (message counter synchronisation) match on session
+// ID alone.
+func (sm *SessionManager) openGroup(ciphertext, header []byte) (groupKey, message.Header, []byte, error) {
+	h, err := parseGroupHeader(header)
+	if err != nil {
+		return groupKey{}, h, nil, err
+	}
+	toGroup := h.Flags.DSIZ() == message.MessageFlagDSIZGroup
+	sm.mu.RLock()
+	var candidates []groupKey
+	for _, k := range sm.groupKeys {
+		if k.sessionID == h.SessionID && (!toGroup || k.groupID == uint16(h.DestNodeID)) {
+			c := *k
+			c.key = bytes.Clone(k.key)
+			candidates = append(candidates, c)
+		}
+	}
+	sm.mu.RUnlock()
+
+	nonce := crypto.BuildNonce(byte(h.SecurityFlags), h.MessageCounter, h.SourceNodeID)
+	for _, k := range candidates {
+		if plaintext, err := sm.provider.Decrypt(k.key, nonce, ciphertext, header); err == nil {
+			return k, h, plaintext, nil
+		}
+	}
+	if len(candidates) > 0 {
+		sm.emit(Event{
+			Kind:           EventAuthFailed,
+			SessionID:      h.SessionID,
+			SourceNodeID:   h.SourceNodeID,
+			MessageCounter: h.MessageCounter,
+		})
+	}
+	return groupKey{}, h, nil, ErrUnknownGroupKey
+}
+
+// acceptGroupCounter checks and commits h's counter against g, reporting
+// a replay to the observer.
+func (sm *SessionManager) acceptGroupCounter(g *GroupSession, h message.Header) error {
+	control := h.SecurityFlags&message.SecurityFlagControl != 0
+	policy := g.Policy
+	if control {
+		policy = GroupCounterTrustFirst
+	}
+	g.mu.Lock()
+	commit, err := g.window(control).check(h.MessageCounter, policy)
+	if err == nil {
+		commit()
+		g.lastActivity.Store(sm.clock.Now().UnixNano())
+	}
+	g.mu.Unlock()
+	if errors.Is(err, ErrReplayedMessageCounter) {
+		sm.emit(Event{
+			Kind:           EventReplayDropped,
+			GroupSession:   g,
+			SessionID:      h.SessionID,
+			SourceNodeID:   h.SourceNodeID,
+			MessageCounter: h.MessageCounter,
+		})
+	}
+	return err
+}
+
+// synchronizeGroupCounter trusts c as the latest data counter g's source
+// has sent, as learned from a MsgCounterSyncRsp.
+func (sm *SessionManager) synchronizeGroupCounter(g *GroupSession, c uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.data.synchronize(c)
+	g.lastActivity.Store(sm.clock.Now().UnixNano())
+}
+
+// sealGroup encrypts payload under k. header is the AAD.
+func (sm *SessionManager) sealGroup(k groupKey, payload, header []byte) ([]byte, error) {
+	h, err := parseGroupHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if h.SessionID != k.sessionID {
+		return nil, fmt.Errorf("session: header session id 0x%04X, group key expects 0x%04X", h.SessionID, k.sessionID)
+	}
+	nonce := crypto.BuildNonce(byte(h.SecurityFlags), h.MessageCounter, h.SourceNodeID)
+	return sm.provider.Encrypt(k.key, nonce, payload, header)
+}
+
+// currentGroupKey returns the most recently added key for the group. The
+// caller holds sm.mu.
+func (sm *SessionManager) currentGroupKey(fabricIndex uint8, groupID uint16) *groupKey {
+	for i := len(sm.groupKeys) - 1; i >= 0; i-- {
+		if k := sm.groupKeys[i]; k.fabricIndex == fabricIndex && k.groupID == groupID {
+			return k
+		}
+	}
+	return nil
+}
+
+// groupSessionFor returns the record for peer, creating it with policy and
+// evicting the least recently used record if the table is full.
+func (sm *SessionManager) groupSessionFor(peer groupPeer, policy GroupCounterPolicy) *GroupSession {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if g, ok := sm.groupSessions[peer]; ok {
+		return g
+	}
+	for len(sm.groupSessions) >= sm.maxGroupSessions {
+		var victim groupPeer
+		var oldest *GroupSession
+		for p, g := range sm.groupSessions {
+			if oldest == nil || g.LastActivity().Before(oldest.LastActivity()) {
+				victim, oldest = p, g
+			}
+		}
+		delete(sm.groupSessions, victim)
+	}
+	g := &GroupSession{
+		FabricIndex:  peer.fabricIndex,
+		GroupID:      peer.groupID,
+		SourceNodeID: peer.sourceNodeID,
+		Policy:       policy,
+	}
+	g.lastActivity.Store(sm.clock.Now().UnixNano())
+	sm.groupSessions[peer] = g
+	return g
+}
+
+// parseGroupHeader decodes header and checks it is a well-formed group
+// message: group session type, source node ID present and a destination
+// (Matter §4.4.1). The destination is a group, or for message counter
+// synchronisation a node.
+func parseGroupHeader(header []byte) (message.Header, error) {
+	var h message.Header
+	if _, err := h.Unmarshal(header); err != nil {
+		return h, fmt.Errorf("session: parse group header: %w", err)
+	}
+	if h.SecurityFlags.SessionType() != message.SessionTypeGroup ||
+		!h.Flags.SourcePresent() || h.Flags.DSIZ() == message.MessageFlagDSIZAbsent {
+		return h, errNotGroupMessage
+	}
+	return h, nil
+}
+
+// isGroupHeader reports whether header's security flags mark a group
+// message, without a full parse.
+func isGroupHeader(header []byte) bool {
+	return len(header) > 3 && message.SecurityFlags(header[3]).SessionType() == message.SessionTypeGroup
+}
+
+// groupWindow is the replay window for one group source. Group counters
+// wrap, so positions are compared mod 2^32 (Matter §4.5.4.2): a counter up
+// to 2^31-1 ahead of max is new, anything else is behind. Behind but
+// within MessageCounterWindowSize is accepted once; further behind is a
+// duplicate.
+type groupWindow struct {
+	synced bool
+	max    uint32
+	bitmap uint32 // bit i set ⇒ counter (max - 1 - i) already accepted
+	// maxOpen marks a max learned by synchronisation rather than by
+	// receiving it: the source sent it, but this node has not accepted it.
+	maxOpen bool
+}
+
+// check returns a commit closure when c is acceptable under policy; the
+// caller invokes it while still holding the GroupSession's lock. On
+// rejection the window is left untouched.
+func (w *groupWindow) check(c uint32, policy GroupCounterPolicy) (commit func(), err error) {
+	if !w.synced {
+		if policy == GroupCounterCacheAndSync {
+			return nil, ErrGroupCounterUnsynchronized
+		}
+		return func() { w.synchronize(c); w.maxOpen = false }, nil
+	}
+	ahead := c - w.max
+	switch {
+	case ahead == 0:
+		if !w.maxOpen {
+			return nil, ErrReplayedMessageCounter
+		}
+		return func() { w.maxOpen = false }, nil
+	case ahead < 1<<31:
+		return func() {
+			if ahead >= 32 {
+				w.bitmap = 0
+			} else {
+				w.bitmap <<= ahead
+				if !w.maxOpen {
+					w.bitmap |= 1 << (ahead - 1)
+				}
+			}
+			w.max = c
+			w.maxOpen = false
+		}, nil
+	}
+	behind := w.max - c
+	if behind > MessageCounterWindowSize {
+		return nil, ErrReplayedMessageCounter
+	}
+	bit := uint32(1) << (behind - 1)
+	if w.bitmap&bit != 0 {
+		return nil, ErrReplayedMessageCounter
+	}
+	return func() { w.bitmap |= bit }, nil
+}
+
+// synchronize records c as the latest counter the source has sent. c
+// itself and the window behind it stay acceptable, since messages held
+// while synchronising may carry them.
+func (w *groupWindow) synchronize(c uint32) {
+	w.synced = true
+	w.max = c
+	w.bitmap = 0
+	w.maxOpen = true
+}
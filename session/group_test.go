@@ -0,0 +1,249 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"go-matter/crypto"
+	"go-matter/message"
+)
+
+const (
+	testFabric  uint8  = 1
+	testGroupID uint16 = 0x0101
+	testSource  uint64 = 0x1122334455667788
+)
+
+var testCompressedFabricID = []byte{0x87, 0xe1, 0xb0, 0x04, 0xe2, 0x35, 0xa1, 0x30}
+
+func groupKeyParams(policy GroupCounterPolicy, epochByte byte) GroupKeyParams {
+	return GroupKeyParams{
+		FabricIndex:        testFabric,
+		GroupID:            testGroupID,
+		EpochKey:           bytes.Repeat([]byte{epochByte}, crypto.EpochKeySize),
+		CompressedFabricID: testCompressedFabricID,
+		Policy:             policy,
+	}
+}
+
+// groupPair returns a sender and receiver sharing one group key.
+func groupPair(t *testing.T, policy GroupCounterPolicy) (tx, rx *SessionManager) {
+	t.Helper()
+	tx, rx = NewSessionManager(nil), NewSessionManager(nil)
+	for _, sm := range []*SessionManager{tx, rx} {
+		if err := sm.AddGroupKey(groupKeyParams(policy, 0x5A)); err != nil {
+			t.Fatalf("AddGroupKey: %v", err)
+		}
+	}
+	return tx, rx
+}
+
+func buildGroupHeader(t *testing.T, sessionID uint16, counter uint32, control bool) []byte {
+	t.Helper()
+	h := message.Header{
+		Flags:          message.MessageFlagSourceNodeIDPresent | message.MessageFlagDSIZGroup,
+		SessionID:      sessionID,
+		SecurityFlags:  message.SessionTypeGroup,
+		MessageCounter: counter,
+		SourceNodeID:   testSource,
+		DestNodeID:     uint64(testGroupID),
+	}
+	if control {
+		h.SecurityFlags |= message.SecurityFlagControl
+	}
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// sealGroup encrypts a fixed payload from tx at counter.
+func sealGroup(t *testing.T, tx *SessionManager, counter uint32, control bool) (ct, header []byte) {
+	t.Helper()
+	sid, err := tx.GroupSessionID(testFabric, testGroupID)
+	if err != nil {
+		t.Fatalf("GroupSessionID: %v", err)
+	}
+	header = buildGroupHeader(t, sid, counter, control)
+	ct, err = tx.EncryptGroupPayload(testFabric, testGroupID, []byte("on"), header)
+	if err != nil {
+		t.Fatalf("EncryptGroupPayload: %v", err)
+	}
+	return ct, header
+}
+
+func TestGroup_RoundTripViaDecryptPayload(t *testing.T) {
+	tx, rx := groupPair(t, GroupCounterTrustFirst)
+	ct, header := sealGroup(t, tx, 100, false)
+	sid, _ := tx.GroupSessionID(testFabric, testGroupID)
+	pt, err := rx.DecryptPayload(sid, ct, header)
+	if err != nil {
+		t.Fatalf("DecryptPayload: %v", err)
+	}
+	if string(pt) != "on" {
+		t.Errorf("plaintext = %q", pt)
+	}
+	g, ok := rx.GroupSession(testFabric, testGroupID, testSource)
+	if !ok || g.Policy != GroupCounterTrustFirst {
+		t.Fatalf("GroupSession = %+v, %v", g, ok)
+	}
+	if _, err := tx.EncryptPayload(sid, []byte("on"), header); err == nil {
+		t.Error("EncryptPayload accepted a group header")
+	}
+}
+
+func TestGroup_TrustFirstWindow(t *testing.T) {
+	tx, rx := groupPair(t, GroupCounterTrustFirst)
+	open := func(c uint32) error {
+		ct, h := sealGroup(t, tx, c, false)
+		_, _, err := rx.DecryptGroupPayload(ct, h)
+		return err
+	}
+	if err := open(1000); err != nil {
+		t.Fatalf("first counter: %v", err)
+	}
+	if err := open(1000); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("duplicate: err = %v", err)
+	}
+	if err := open(998); err != nil {
+		t.Errorf("behind within window: %v", err)
+	}
+	if err := open(998); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("duplicate behind: err = %v", err)
+	}
+	if err := open(1000 - MessageCounterWindowSize - 1); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("before window: err = %v", err)
+	}
+}
+
+// TestGroup_WindowWrapsMod2_32 — group counters wrap, so 0xFFFFFFFF → 2
+// is a step forward and 2^31 ahead counts as behind.
+func TestGroup_WindowWrapsMod2_32(t *testing.T) {
+	var w groupWindow
+	accept := func(c uint32) error {
+		commit, err := w.check(c, GroupCounterTrustFirst)
+		if err == nil {
+			commit()
+		}
+		return err
+	}
+	if err := accept(0xFFFFFFFE); err != nil {
+		t.Fatal(err)
+	}
+	if err := accept(2); err != nil {
+		t.Fatalf("wrap forward: %v", err)
+	}
+	if err := accept(0xFFFFFFFF); err != nil {
+		t.Errorf("behind across wrap: %v", err)
+	}
+	if err := accept(0xFFFFFFFE); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("replay across wrap: err = %v", err)
+	}
+	if err := accept(2 + 1<<31); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("2^31 ahead: err = %v, want treated as behind", err)
+	}
+	if err := accept(1 + 1<<31); err != nil {
+		t.Errorf("2^31-1 ahead: %v", err)
+	}
+}
+
+func TestGroup_CacheAndSyncHoldsUntilSynchronized(t *testing.T) {
+	tx, rx := groupPair(t, GroupCounterCacheAndSync)
+	ct, h := sealGroup(t, tx, 7, false)
+	g, _, err := rx.DecryptGroupPayload(ct, h)
+	if !errors.Is(err, ErrGroupCounterUnsynchronized) {
+		t.Fatalf("err = %v, want ErrGroupCounterUnsynchronized", err)
+	}
+	rx.synchronizeGroupCounter(g, 7)
+	if _, _, err := rx.DecryptGroupPayload(ct, h); err != nil {
+		t.Errorf("after sync: %v", err)
+	}
+	if _, _, err := rx.DecryptGroupPayload(ct, h); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("replay after sync: err = %v", err)
+	}
+}
+
+func TestGroup_DataAndControlCountedSeparately(t *testing.T) {
+	tx, rx := groupPair(t, GroupCounterTrustFirst)
+	for _, control := range []bool{false, true} {
+		ct, h := sealGroup(t, tx, 50, control)
+		if _, _, err := rx.DecryptGroupPayload(ct, h); err != nil {
+			t.Errorf("control=%v: %v", control, err)
+		}
+	}
+}
+
+func TestGroup_TamperedDoesNotCreateOrAdvance(t *testing.T) {
+	tx, rx := groupPair(t, GroupCounterTrustFirst)
+	ct, h := sealGroup(t, tx, 9, false)
+	bad := bytes.Clone(ct)
+	bad[0] ^= 1
+	if _, _, err := rx.DecryptGroupPayload(bad, h); !errors.Is(err, ErrUnknownGroupKey) {
+		t.Fatalf("tampered: err = %v", err)
+	}
+	if _, ok := rx.GroupSession(testFabric, testGroupID, testSource); ok {
+		t.Error("tampered frame created a group session")
+	}
+	if _, _, err := rx.DecryptGroupPayload(ct, h); err != nil {
+		t.Errorf("genuine after tamper: %v", err)
+	}
+}
+
+// TestGroup_KeyRotation — during an epoch key rotation the receiver holds
+// both keys and accepts either; the sender uses the newest.
+func TestGroup_KeyRotation(t *testing.T) {
+	tx, rx := groupPair(t, GroupCounterTrustFirst)
+	oldCT, oldH := sealGroup(t, tx, 1, false)
+	for _, sm := range []*SessionManager{tx, rx} {
+		if err := sm.AddGroupKey(groupKeyParams(GroupCounterTrustFirst, 0xA5)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newCT, newH := sealGroup(t, tx, 2, false)
+	if bytes.Equal(oldH[1:3], newH[1:3]) {
+		t.Fatal("rotation did not change the group session id")
+	}
+	for i, f := range [][2][]byte{{newCT, newH}, {oldCT, oldH}} {
+		if _, _, err := rx.DecryptGroupPayload(f[0], f[1]); err != nil {
+			t.Errorf("frame %d: %v", i, err)
+		}
+	}
+	if !rx.RemoveGroupKeys(testFabric, testGroupID) {
+		t.Error("RemoveGroupKeys reported nothing removed")
+	}
+	if _, ok := rx.GroupSession(testFabric, testGroupID, testSource); ok {
+		t.Error("group session survived key removal")
+	}
+	if _, err := rx.GroupSessionID(testFabric, testGroupID); !errors.Is(err, ErrUnknownGroupKey) {
+		t.Errorf("GroupSessionID after removal: err = %v", err)
+	}
+}
+
+func TestGroup_TableEvictsLeastRecentlyUsed(t *testing.T) {
+	sm, clock, _ := newLifecycleSM(t, Config{MaxGroupSessions: 2})
+	for i := range uint64(3) {
+		sm.groupSessionFor(groupPeer{testFabric, testGroupID, i}, GroupCounterTrustFirst)
+		clock.Advance(1)
+	}
+	if _, ok := sm.GroupSession(testFabric, testGroupID, 0); ok {
+		t.Error("oldest source not evicted")
+	}
+	if _, ok := sm.GroupSession(testFabric, testGroupID, 2); !ok {
+		t.Error("newest source missing")
+	}
+}
+
+func TestNextGroupCounter_SeededAndIndependent(t *testing.T) {
+	sm := NewSessionManagerWithConfig(nil, Config{CryptoProvider: crypto.NewDeterministicCryptoProvider([]byte("group"))})
+	d1, _ := sm.NextGroupCounter(false)
+	c1, _ := sm.NextGroupCounter(true)
+	d2, _ := sm.NextGroupCounter(false)
+	if d1 == 0 || d1 > counterMaxInitial || c1 == 0 || c1 > counterMaxInitial {
+		t.Errorf("initial counters %d, %d outside [1, 2^28]", d1, c1)
+	}
+	if d2 != d1+1 {
+		t.Errorf("data counter %d → %d", d1, d2)
+	}
+}
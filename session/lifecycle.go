@@ -0,0 +1,263 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"go-matter/crypto"
+)
+
+// Table and timeout defaults. DefaultMaxSessions matches
+// connectedhomeip's CHIP_CONFIG_SECURE_SESSION_POOL_SIZE. PASE sessions
+// only live for the commissioning window, so they expire quickly; CASE
+// sessions can be resumed cheaply and are kept longer.
+const (
+	DefaultMaxSessions     = 16
+	DefaultPASEIdleTimeout = time.Minute
+	DefaultCASEIdleTimeout = time.Hour
+)
+
+// MinCASESessionsPerFabric is the number of concurrent CASE sessions per
+// fabric a node must support (Matter §4.14, CASE session capacity).
+// Eviction never takes a fabric below this while another is above it.
+const MinCASESessionsPerFabric = 3
+
+// SessionType distinguishes how a secure session was established.
+type SessionType int
+
+const (
+	SessionTypePASE SessionType = iota
+	SessionTypeCASE
+)
+
+func (t SessionType) String() string {
+	switch t {
+	case SessionTypePASE:
+		return "PASE"
+	case SessionTypeCASE:
+		return "CASE"
+	default:
+		return "unknown"
+	}
+}
+
+// CloseReason tells OnSessionClosed why a session left the table.
+type CloseReason int
+
+const (
+	// CloseReasonRemoved: RemoveSession was called.
+	CloseReasonRemoved CloseReason = iota
+	// CloseReasonIdle: ExpireIdleSessions found no traffic within the
+	// type's idle timeout.
+	CloseReasonIdle
+	// CloseReasonEvicted: the table was full and the session lost the
+	// eviction policy to a newer one.
+	CloseReasonEvicted
+	// CloseReasonReplaced: InstallSecureSession reused its ID.
+	CloseReasonReplaced
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonRemoved:
+		return "removed"
+	case CloseReasonIdle:
+		return "idle"
+	case CloseReasonEvicted:
+		return "evicted"
+	case CloseReasonReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// SessionParams describes a session produced by a completed PASE or CASE
+// handshake.
+type SessionParams struct {
+	ID             uint16
+	PeerSessionID  uint16 // zero means the same as ID
+	Type           SessionType
+	LocalNodeID    uint64
+	PeerNodeID     uint64
+	FabricIndex    uint8
+	Keys           crypto.SessionKeys
+	Role           Role
+	PeerParameters SessionParameters // as advertised by the peer
+}
+
+// CreateSession installs a new secure session. When the table is at
+// MaxSessions one existing session is evicted first, chosen in this order:
+//
+//  1. the least recently used CASE session of the fabric holding the most
+//     sessions above MinCASESessionsPerFabric;
+//  2. the least recently used session of the new session's own fabric
+//     (PASE sessions count as one fabric), so a fabric recycles its own
+//     slots before eating into another's minimum;
+//  3. the least recently used session overall.
+//
+// Returns ErrSessionIDInUse if id is taken; IDs are never silently reused.
+func (sm *SessionManager) CreateSession(p SessionParams) (*Session, error) {
+	if p.ID == UnsecuredSessionID {
+		return nil, errors.New("session: session id 0 is reserved for unsecured messages")
+	}
+	sm.mu.Lock()
+	if _, ok := sm.sessions[p.ID]; ok {
+		sm.mu.Unlock()
+		return nil, ErrSessionIDInUse
+	}
+	s, evicted := sm.install(p)
+	sm.mu.Unlock()
+	sm.finish(evicted)
+	sm.emit(Event{Kind: EventSessionInstalled, Session: s, SessionID: s.ID})
+	return s, nil
+}
+
+// RemoveSession closes the session with id, reporting whether it existed.
+func (sm *SessionManager) RemoveSession(id uint16) bool {
+	sm.mu.Lock()
+	s := sm.detach(id)
+	sm.mu.Unlock()
+	if s == nil {
+		return false
+	}
+	sm.finish([]closedSession{{s, CloseReasonRemoved}})
+	return true
+}
+
+// RemoveFabricSessions closes every session on the fabric with index
+// fabricIndex, as when the fabric is removed, and returns how many it
+// closed. Index 0, which PASE sessions carry, matches none.
+func (sm *SessionManager) RemoveFabricSessions(fabricIndex uint8) int {
+	if fabricIndex == 0 {
+		return 0
+	}
+	var removed []closedSession
+	sm.mu.Lock()
+	for id, s := range sm.sessions {
+		if s.FabricIndex == fabricIndex {
+			removed = append(removed, closedSession{sm.detach(id), CloseReasonRemoved})
+		}
+	}
+	sm.mu.Unlock()
+	sm.finish(removed)
+	return len(removed)
+}
+
+// ExpireIdleSessions closes every session whose LastActivity is older than
+// its type's idle timeout and returns how many it closed. Callers run it
+// periodically; the session layer owns no timers of its own.
+func (sm *SessionManager) ExpireIdleSessions() int {
+	now := sm.clock.Now()
+	var expired []closedSession
+	sm.mu.Lock()
+	for id, s := range sm.sessions {
+		timeout := sm.caseIdleTimeout
+		if s.Type == SessionTypePASE {
+			timeout = sm.paseIdleTimeout
+		}
+		if timeout > 0 && now.Sub(s.LastActivity()) >= timeout {
+			expired = append(expired, closedSession{sm.detach(id), CloseReasonIdle})
+		}
+	}
+	sm.mu.Unlock()
+	sm.finish(expired)
+	return len(expired)
+}
+
+// closedSession pairs a detached session with why it was closed.
+type closedSession struct {
+	s      *Session
+	reason CloseReason
+}
+
+// detach removes id from the table and returns it, or nil if absent. The
+// caller holds sm.mu and passes the result to finish once unlocked, so
+// OnSessionClosed may call back into the manager.
+func (sm *SessionManager) detach(id uint16) *Session {
+	s, ok := sm.sessions[id]
+	if !ok {
+		return nil
+	}
+	delete(sm.sessions, id)
+	sm.quarantine(id)
+	return s
+}
+
+// finish notifies OnSessionClosed and the observer for each detached
+// session and then zeroes its keys, so a stale *Session held elsewhere can
+// neither encrypt nor decrypt.
+func (sm *SessionManager) finish(closed []closedSession) {
+	for _, c := range closed {
+		if sm.onClosed != nil {
+			sm.onClosed(c.s, c.reason)
+		}
+		sm.emit(Event{Kind: EventSessionClosed, Session: c.s, SessionID: c.s.ID, Reason: c.reason})
+		c.s.mu.Lock()
+		c.s.closed = true
+		clear(c.s.EncryptKey)
+		clear(c.s.DecryptKey)
+		c.s.mu.Unlock()
+	}
+}
+
+// evictionCandidate applies the CreateSession policy on behalf of
+// incoming. Returns nil only when the table is empty. The caller holds
+// sm.mu.
+func (sm *SessionManager) evictionCandidate(incoming *Session) *Session {
+	type bucket struct {
+		n   int
+		lru *Session
+	}
+	fabrics := make(map[uint8]*bucket)
+	var overall *Session
+	bucketKey := func(s *Session) uint8 {
+		if s.Type == SessionTypePASE {
+			return 0
+		}
+		return s.FabricIndex
+	}
+	for _, s := range sm.sessions {
+		b := fabrics[bucketKey(s)]
+		if b == nil {
+			b = &bucket{}
+			fabrics[bucketKey(s)] = b
+		}
+		b.n++
+		if b.lru == nil || lessRecentlyUsed(s, b.lru) {
+			b.lru = s
+		}
+		if overall == nil || lessRecentlyUsed(s, overall) {
+			overall = s
+		}
+	}
+
+	var fattest *bucket
+	var fattestKey uint8
+	for key, b := range fabrics {
+		if key == 0 || b.n <= MinCASESessionsPerFabric {
+			continue
+		}
+		if fattest == nil || b.n > fattest.n ||
+			(b.n == fattest.n && key == bucketKey(incoming)) ||
+			(b.n == fattest.n && fattestKey != bucketKey(incoming) && key < fattestKey) {
+			fattest, fattestKey = b, key
+		}
+	}
+	if fattest != nil {
+		return fattest.lru
+	}
+	if own := fabrics[bucketKey(incoming)]; own != nil {
+		return own.lru
+	}
+	return overall
+}
+
+// lessRecentlyUsed orders sessions by LastActivity, breaking ties by ID so
+// eviction is deterministic.
+func lessRecentlyUsed(a, b *Session) bool {
+	if at, bt := a.LastActivity(), b.LastActivity(); !at.Equal(bt) {
+		return at.Before(bt)
+	}
+	return a.ID < b.ID
+}
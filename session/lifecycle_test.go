@@ -0,0 +1,233 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"go-matter/clock"
+	"go-matter/crypto"
+)
+
+type closeEvent struct {
+	id     uint16
+	reason CloseReason
+}
+
+func newLifecycleSM(t *testing.T, cfg Config) (*SessionManager, *clock.Fake, *[]closeEvent) {
+	t.Helper()
+	var events []closeEvent
+	cfg.OnSessionClosed = func(s *Session, r CloseReason) {
+		events = append(events, closeEvent{s.ID, r})
+	}
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	cfg.Clock = clk
+	return NewSessionManagerWithConfig(nil, cfg), clk, &events
+}
+
+func testKeys(t *testing.T) crypto.SessionKeys {
+	t.Helper()
+	keys, err := crypto.DeriveSessionKeysFromKe(bytes.Repeat([]byte{0x3C}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func mustCreate(t *testing.T, sm *SessionManager, id uint16, typ SessionType, fabric uint8) *Session {
+	t.Helper()
+	s, err := sm.CreateSession(SessionParams{ID: id, Type: typ, FabricIndex: fabric, Keys: testKeys(t)})
+	if err != nil {
+		t.Fatalf("CreateSession(%d): %v", id, err)
+	}
+	return s
+}
+
+func TestCreateSession_RejectsDuplicateAndZeroID(t *testing.T) {
+	sm, _, _ := newLifecycleSM(t, Config{})
+	mustCreate(t, sm, 1, SessionTypePASE, 0)
+	if _, err := sm.CreateSession(SessionParams{ID: 1, Keys: testKeys(t)}); !errors.Is(err, ErrSessionIDInUse) {
+		t.Errorf("duplicate id: err = %v, want ErrSessionIDInUse", err)
+	}
+	if _, err := sm.CreateSession(SessionParams{ID: UnsecuredSessionID, Keys: testKeys(t)}); err == nil {
+		t.Error("session id 0 accepted")
+	}
+}
+
+func TestRemoveSession_NotifiesAndZeroesKeys(t *testing.T) {
+	sm, _, events := newLifecycleSM(t, Config{})
+	s := mustCreate(t, sm, 9, SessionTypeCASE, 1)
+	if !sm.RemoveSession(9) {
+		t.Fatal("RemoveSession(9) = false")
+	}
+	if sm.RemoveSession(9) {
+		t.Error("second RemoveSession(9) = true")
+	}
+	if _, ok := sm.Session(9); ok {
+		t.Error("session still in table")
+	}
+	if len(*events) != 1 || (*events)[0] != (closeEvent{9, CloseReasonRemoved}) {
+		t.Errorf("events = %v", *events)
+	}
+	if !bytes.Equal(s.EncryptKey, make([]byte, 16)) {
+		t.Error("encrypt key not zeroed after close")
+	}
+	if _, err := sm.EncryptPayload(9, []byte("x"), buildHeader(t, 9, 1, 1)); !errors.Is(err, ErrUnknownSession) {
+		t.Errorf("encrypt on removed session: err = %v", err)
+	}
+}
+
+func TestRemoveFabricSessions(t *testing.T) {
+	sm, _, events := newLifecycleSM(t, Config{})
+	mustCreate(t, sm, 1, SessionTypePASE, 0)
+	mustCreate(t, sm, 2, SessionTypeCASE, 1)
+	mustCreate(t, sm, 3, SessionTypeCASE, 2)
+	mustCreate(t, sm, 4, SessionTypeCASE, 1)
+	if n := sm.RemoveFabricSessions(0); n != 0 {
+		t.Errorf("RemoveFabricSessions(0) = %d, want 0", n)
+	}
+	if n := sm.RemoveFabricSessions(1); n != 2 {
+		t.Errorf("RemoveFabricSessions(1) = %d, want 2", n)
+	}
+	for id, want := range map[uint16]bool{1: true, 2: false, 3: true, 4: false} {
+		if _, ok := sm.Session(id); ok != want {
+			t.Errorf("session %d present = %v, want %v", id, ok, want)
+		}
+	}
+	if len(*events) != 2 || (*events)[0].reason != CloseReasonRemoved || (*events)[1].reason != CloseReasonRemoved {
+		t.Errorf("events = %v", *events)
+	}
+}
+
+func TestExpireIdleSessions_PerTypeTimeouts(t *testing.T) {
+	sm, clock, events := newLifecycleSM(t, Config{
+		PASEIdleTimeout: 30 * time.Second,
+		CASEIdleTimeout: 10 * time.Minute,
+	})
+	mustCreate(t, sm, 1, SessionTypePASE, 0)
+	mustCreate(t, sm, 2, SessionTypeCASE, 1)
+	mustCreate(t, sm, 3, SessionTypeCASE, 1)
+
+	clock.Advance(29 * time.Second)
+	// Traffic on session 3 refreshes its LastActivity.
+	if _, err := sm.EncryptPayload(3, []byte("x"), buildHeader(t, 3, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := sm.ExpireIdleSessions(); n != 0 {
+		t.Fatalf("expired %d sessions before any timeout", n)
+	}
+
+	clock.Advance(time.Second)
+	if n := sm.ExpireIdleSessions(); n != 1 {
+		t.Fatalf("expired %d sessions at PASE timeout, want 1", n)
+	}
+	if _, ok := sm.Session(1); ok {
+		t.Error("PASE session survived its idle timeout")
+	}
+
+	clock.Advance(10*time.Minute - 30*time.Second)
+	if n := sm.ExpireIdleSessions(); n != 1 {
+		t.Fatalf("expired %d sessions at CASE timeout, want 1 (session 2 only)", n)
+	}
+	if _, ok := sm.Session(3); !ok {
+		t.Error("recently used CASE session expired early")
+	}
+	for _, e := range *events {
+		if e.reason != CloseReasonIdle {
+			t.Errorf("close reason = %v, want idle", e.reason)
+		}
+	}
+}
+
+func TestExpireIdleSessions_NegativeDisables(t *testing.T) {
+	sm, clock, _ := newLifecycleSM(t, Config{CASEIdleTimeout: -1})
+	mustCreate(t, sm, 1, SessionTypeCASE, 1)
+	clock.Advance(1000 * time.Hour)
+	if n := sm.ExpireIdleSessions(); n != 0 {
+		t.Errorf("expired %d sessions with expiry disabled", n)
+	}
+}
+
+func TestEviction_LRUWhenFull(t *testing.T) {
+	sm, clock, events := newLifecycleSM(t, Config{MaxSessions: 3})
+	for id := uint16(1); id <= 3; id++ {
+		mustCreate(t, sm, id, SessionTypeCASE, uint8(id))
+		clock.Advance(time.Second)
+	}
+	// Touch session 1 so session 2 becomes the least recently used.
+	if _, err := sm.EncryptPayload(1, []byte("x"), buildHeader(t, 1, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	mustCreate(t, sm, 4, SessionTypePASE, 0)
+	if _, ok := sm.Session(2); ok {
+		t.Error("least recently used session 2 not evicted")
+	}
+	if len(*events) != 1 || (*events)[0] != (closeEvent{2, CloseReasonEvicted}) {
+		t.Errorf("events = %v", *events)
+	}
+}
+
+// TestEviction_FabricQuota — a fabric holding more than
+// MinCASESessionsPerFabric gives up a slot before any fabric at or below
+// its minimum, even if the latter's sessions are older.
+func TestEviction_FabricQuota(t *testing.T) {
+	sm, clock, _ := newLifecycleSM(t, Config{MaxSessions: 7})
+	// Fabric 1: three old sessions (exactly its minimum).
+	for id := uint16(1); id <= 3; id++ {
+		mustCreate(t, sm, id, SessionTypeCASE, 1)
+		clock.Advance(time.Second)
+	}
+	// Fabric 2: four newer sessions (one over its minimum).
+	for id := uint16(10); id <= 13; id++ {
+		mustCreate(t, sm, id, SessionTypeCASE, 2)
+		clock.Advance(time.Second)
+	}
+	mustCreate(t, sm, 20, SessionTypeCASE, 1)
+	if _, ok := sm.Session(10); ok {
+		t.Error("over-quota fabric 2 kept its LRU session")
+	}
+	for id := uint16(1); id <= 3; id++ {
+		if _, ok := sm.Session(id); !ok {
+			t.Errorf("fabric 1 session %d evicted while at its minimum", id)
+		}
+	}
+}
+
+// TestEviction_OwnFabricFirst — with no fabric over quota, a new session
+// recycles its own fabric's LRU slot rather than taking another's.
+func TestEviction_OwnFabricFirst(t *testing.T) {
+	sm, clock, _ := newLifecycleSM(t, Config{MaxSessions: 4})
+	mustCreate(t, sm, 1, SessionTypeCASE, 1) // oldest overall
+	clock.Advance(time.Second)
+	mustCreate(t, sm, 2, SessionTypeCASE, 2)
+	clock.Advance(time.Second)
+	mustCreate(t, sm, 3, SessionTypeCASE, 2)
+	clock.Advance(time.Second)
+	mustCreate(t, sm, 4, SessionTypeCASE, 2)
+	clock.Advance(time.Second)
+
+	mustCreate(t, sm, 5, SessionTypeCASE, 2)
+	if _, ok := sm.Session(1); !ok {
+		t.Error("fabric 1 lost its only session to fabric 2")
+	}
+	if _, ok := sm.Session(2); ok {
+		t.Error("fabric 2 did not recycle its own LRU session")
+	}
+}
+
+func TestInstallSecureSession_ReplacesAndInfersType(t *testing.T) {
+	sm, _, events := newLifecycleSM(t, Config{})
+	keys := testKeys(t)
+	s := sm.InstallSecureSession(5, UnspecifiedNodeID, UnspecifiedNodeID, keys, RoleInitiator)
+	if s.Type != SessionTypePASE {
+		t.Errorf("type = %v, want PASE", s.Type)
+	}
+	s = sm.InstallSecureSession(5, 1, 2, keys, RoleInitiator)
+	if s.Type != SessionTypeCASE {
+		t.Errorf("type = %v, want CASE", s.Type)
+	}
+	if len(*events) != 1 || (*events)[0] != (closeEvent{5, CloseReasonReplaced}) {
+		t.Errorf("events = %v", *events)
+	}
+}
@@ -0,0 +1,301 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go-matter/message"
+)
+
+// Message counter synchronisation (MCSP, Matter §4.8). A receiver that
+// holds group messages from a cache-and-sync source it has no counter for
+// sends MsgCounterSyncReq with a random challenge to that source; the
+// source answers with MsgCounterSyncRsp carrying its current group data
+// counter and the echoed challenge. Both messages are encrypted with the
+// operational group key but addressed to a single node.
+const (
+	MsgCounterSyncChallengeSize = 8
+	msgCounterSyncRspSize       = 4 + MsgCounterSyncChallengeSize
+)
+
+// Synchroniser defaults. Held messages are dropped if no response arrives
+// within the timeout; the next message from the source starts over.
+const (
+	DefaultMsgCounterSyncTimeout = 500 * time.Millisecond
+	DefaultMaxHeldGroupMessages  = 10
+)
+
+var (
+	ErrCounterSyncPayload       = errors.New("session: malformed message counter sync payload")
+	ErrUnexpectedCounterSyncRsp = errors.New("session: message counter sync response matches no request")
+	ErrCounterSyncQueueFull     = errors.New("session: too many group messages held for counter sync")
+)
+
+// CounterSyncConfig wires a CounterSynchronizer to the node.
+type CounterSyncConfig struct {
+	// LocalNodeID returns this node's operational node ID on a fabric; it
+	// is the source of every MCSP message sent. Required.
+	LocalNodeID func(fabricIndex uint8) (uint64, bool)
+
+	// Send transmits an encoded, encrypted frame to a node. Required.
+	Send func(fabricIndex uint8, destNodeID uint64, frame []byte) error
+
+	// Deliver receives every accepted group message, either straight from
+	// Receive or once its source has been synchronised. plaintext is what
+	// DecryptGroupPayload would have returned. Required.
+	Deliver func(g *GroupSession, header message.Header, plaintext []byte)
+
+	// Timeout bounds how long held messages wait for a response. Zero
+	// selects DefaultMsgCounterSyncTimeout.
+	Timeout time.Duration
+
+	// MaxHeldMessages caps the messages held per source. Zero selects
+	// DefaultMaxHeldGroupMessages.
+	MaxHeldMessages int
+}
+
+// CounterSynchronizer runs MCSP on top of a SessionManager: it answers
+// peers' requests, and holds messages from unsynchronised cache-and-sync
+// sources until their counter is known. Like the rest of the session
+// layer it owns no timers; callers run ExpireSyncRequests periodically.
+type CounterSynchronizer struct {
+	sm  *SessionManager
+	cfg CounterSyncConfig
+
+	mu      sync.Mutex
+	pending map[groupPeer]*syncRequest
+}
+
+// syncRequest is an outstanding MsgCounterSyncReq and the messages
+// waiting on it.
+type syncRequest struct {
+	challenge [MsgCounterSyncChallengeSize]byte
+	policy    GroupCounterPolicy
+	deadline  time.Time
+	held      []heldGroupMessage
+}
+
+type heldGroupMessage struct {
+	header    message.Header
+	plaintext []byte
+}
+
+// NewCounterSynchronizer returns a synchronizer for sm.
+func NewCounterSynchronizer(sm *SessionManager, cfg CounterSyncConfig) (*CounterSynchronizer, error) {
+	if sm == nil || cfg.LocalNodeID == nil || cfg.Send == nil || cfg.Deliver == nil {
+		return nil, errors.New("session: counter synchronizer needs a session manager, LocalNodeID, Send and Deliver")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultMsgCounterSyncTimeout
+	}
+	if cfg.MaxHeldMessages <= 0 {
+		cfg.MaxHeldMessages = DefaultMaxHeldGroupMessages
+	}
+	return &CounterSynchronizer{
+		sm:      sm,
+		cfg:     cfg,
+		pending: make(map[groupPeer]*syncRequest),
+	}, nil
+}
+
+// Receive authenticates a group-keyed message and routes it: MCSP
+// requests are answered, responses release held messages, and anything
+// else is delivered, held pending synchronisation, or rejected by the
+// replay window. A held message returns nil; it is delivered later or
+// dropped by ExpireSyncRequests.
+func (cs *CounterSynchronizer) Receive(ciphertext, header []byte) error {
+	k, h, plaintext, err := cs.sm.openGroup(ciphertext, header)
+	if err != nil {
+		return err
+	}
+	var ph message.PayloadHeader
+	n, err := ph.Unmarshal(plaintext)
+	if err != nil {
+		return fmt.Errorf("session: parse group payload header: %w", err)
+	}
+	if ph.ProtocolID == message.ProtocolSecureChannel && h.SecurityFlags&message.SecurityFlagControl != 0 {
+		switch ph.Opcode {
+		case message.OpcodeMsgCounterSyncReq:
+			return cs.handleRequest(k, h, ph, plaintext[n:])
+		case message.OpcodeMsgCounterSyncRsp:
+			return cs.handleResponse(k, h, plaintext[n:])
+		}
+	}
+
+	g := cs.sm.groupSessionFor(groupPeer{k.fabricIndex, k.groupID, h.SourceNodeID}, k.policy)
+	switch err := cs.sm.acceptGroupCounter(g, h); {
+	case err == nil:
+		cs.cfg.Deliver(g, h, plaintext)
+		return nil
+	case errors.Is(err, ErrGroupCounterUnsynchronized):
+		return cs.hold(k, h, plaintext)
+	default:
+		return err
+	}
+}
+
+// ExpireSyncRequests abandons requests whose response is overdue,
+// dropping their held messages, and returns how many it abandoned.
+func (cs *CounterSynchronizer) ExpireSyncRequests() int {
+	now := cs.sm.clock.Now()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	n := 0
+	for peer, req := range cs.pending {
+		if !now.Before(req.deadline) {
+			delete(cs.pending, peer)
+			n++
+		}
+	}
+	return n
+}
+
+// hold queues an authenticated message from an unsynchronised source,
+// sending a request if none is outstanding for it.
+func (cs *CounterSynchronizer) hold(k groupKey, h message.Header, plaintext []byte) error {
+	peer := groupPeer{k.fabricIndex, k.groupID, h.SourceNodeID}
+	cs.mu.Lock()
+	req, inFlight := cs.pending[peer]
+	if !inFlight {
+		req = &syncRequest{policy: k.policy, deadline: cs.sm.clock.Now().Add(cs.cfg.Timeout)}
+		if _, err := io.ReadFull(cs.sm.provider.Rand(), req.challenge[:]); err != nil {
+			cs.mu.Unlock()
+			return fmt.Errorf("session: counter sync challenge: %w", err)
+		}
+		cs.pending[peer] = req
+	}
+	if len(req.held) >= cs.cfg.MaxHeldMessages {
+		cs.mu.Unlock()
+		return ErrCounterSyncQueueFull
+	}
+	req.held = append(req.held, heldGroupMessage{h, plaintext})
+	challenge := req.challenge
+	cs.mu.Unlock()
+	if inFlight {
+		return nil
+	}
+
+	// The entry is in place before sending, so a response arriving
+	// synchronously still finds it.
+	if err := cs.sendRequest(k, h.SourceNodeID, challenge); err != nil {
+		cs.mu.Lock()
+		if cs.pending[peer] == req {
+			delete(cs.pending, peer)
+		}
+		cs.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (cs *CounterSynchronizer) sendRequest(k groupKey, dest uint64, challenge [MsgCounterSyncChallengeSize]byte) error {
+	var xid [2]byte
+	if _, err := io.ReadFull(cs.sm.provider.Rand(), xid[:]); err != nil {
+		return fmt.Errorf("session: counter sync exchange id: %w", err)
+	}
+	return cs.send(k, dest, message.OpcodeMsgCounterSyncReq, binary.LittleEndian.Uint16(xid[:]), true, challenge[:])
+}
+
+// handleRequest answers a peer's MsgCounterSyncReq with this node's
+// current group data counter. Requests are not replay-checked: a replay
+// only provokes another response, which the peer discards.
+func (cs *CounterSynchronizer) handleRequest(k groupKey, h message.Header, ph message.PayloadHeader, payload []byte) error {
+	if len(payload) != MsgCounterSyncChallengeSize || h.Flags.DSIZ() != message.MessageFlagDSIZUnicast {
+		return ErrCounterSyncPayload
+	}
+	if local, ok := cs.cfg.LocalNodeID(k.fabricIndex); !ok || h.DestNodeID != local {
+		return fmt.Errorf("session: counter sync request for node 0x%016X", h.DestNodeID)
+	}
+	counter, err := cs.sm.lastGroupCounter(false)
+	if err != nil {
+		return err
+	}
+	rsp := make([]byte, msgCounterSyncRspSize)
+	binary.LittleEndian.PutUint32(rsp[:4], counter)
+	copy(rsp[4:], payload)
+	return cs.send(k, h.SourceNodeID, message.OpcodeMsgCounterSyncRsp, ph.ExchangeID, false, rsp)
+}
+
+// handleResponse matches a MsgCounterSyncRsp to its request by challenge,
+// synchronises the source's data window and releases the held messages
+// through it.
+func (cs *CounterSynchronizer) handleResponse(k groupKey, h message.Header, payload []byte) error {
+	if len(payload) != msgCounterSyncRspSize {
+		return ErrCounterSyncPayload
+	}
+	counter := binary.LittleEndian.Uint32(payload[:4])
+	challenge := payload[4:]
+
+	cs.mu.Lock()
+	var peer groupPeer
+	var req *syncRequest
+	for p, r := range cs.pending {
+		if p.fabricIndex == k.fabricIndex && p.sourceNodeID == h.SourceNodeID && bytes.Equal(r.challenge[:], challenge) {
+			peer, req = p, r
+			break
+		}
+	}
+	if req != nil {
+		delete(cs.pending, peer)
+	}
+	cs.mu.Unlock()
+	if req == nil {
+		return ErrUnexpectedCounterSyncRsp
+	}
+
+	g := cs.sm.groupSessionFor(peer, req.policy)
+	cs.sm.synchronizeGroupCounter(g, counter)
+	for _, m := range req.held {
+		if cs.sm.acceptGroupCounter(g, m.header) == nil {
+			cs.cfg.Deliver(g, m.header, m.plaintext)
+		}
+	}
+	return nil
+}
+
+// send builds, encrypts and transmits one MCSP message under k.
+func (cs *CounterSynchronizer) send(k groupKey, dest uint64, op message.Opcode, exchangeID uint16, initiator bool, payload []byte) error {
+	local, ok := cs.cfg.LocalNodeID(k.fabricIndex)
+	if !ok {
+		return fmt.Errorf("session: no local node id on fabric %d", k.fabricIndex)
+	}
+	counter, err := cs.sm.NextGroupCounter(true)
+	if err != nil {
+		return err
+	}
+	b := message.NewBuilder().
+		GroupSession(k.sessionID).
+		Control().
+		MessageCounter(counter).
+		SourceNodeID(local).
+		DestNodeID(dest).
+		Protocol(message.ProtocolSecureChannel).
+		Opcode(op).
+		ExchangeID(exchangeID).
+		Payload(payload)
+	if initiator {
+		b = b.Initiator()
+	}
+	f, err := b.Build()
+	if err != nil {
+		return err
+	}
+	hdr, err := f.Header.Marshal()
+	if err != nil {
+		return err
+	}
+	body, err := f.PayloadHeader.Marshal()
+	if err != nil {
+		return err
+	}
+	ct, err := cs.sm.sealGroup(k, append(body, f.Payload...), hdr)
+	if err != nil {
+		return err
+	}
+	return cs.cfg.Send(k.fabricIndex, dest, append(hdr, ct...))
+}
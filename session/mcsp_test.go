@@ -0,0 +1,223 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"go-matter/clock"
+	"go-matter/message"
+)
+
+const (
+	mcspSenderNode   uint64 = testSource
+	mcspReceiverNode uint64 = 0x00000000000000B0
+)
+
+// mcspNode is one end of an in-memory MCSP link.
+type mcspNode struct {
+	sm        *SessionManager
+	cs        *CounterSynchronizer
+	clock     *clock.Fake
+	delivered []uint32 // counters of delivered messages
+	peer      *mcspNode
+	drop      bool // discard outbound frames
+	sent      [][]byte
+}
+
+func newMCSPNode(t *testing.T, nodeID uint64, policy GroupCounterPolicy) *mcspNode {
+	t.Helper()
+	n := &mcspNode{}
+	n.sm, n.clock, _ = newLifecycleSM(t, Config{})
+	if err := n.sm.AddGroupKey(groupKeyParams(policy, 0x5A)); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewCounterSynchronizer(n.sm, CounterSyncConfig{
+		LocalNodeID: func(uint8) (uint64, bool) { return nodeID, true },
+		Send: func(_ uint8, _ uint64, frame []byte) error {
+			n.sent = append(n.sent, frame)
+			if n.drop {
+				return nil
+			}
+			return n.peer.receive(frame)
+		},
+		Deliver: func(_ *GroupSession, h message.Header, _ []byte) {
+			n.delivered = append(n.delivered, h.MessageCounter)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.cs = cs
+	return n
+}
+
+func (n *mcspNode) receive(frame []byte) error {
+	var h message.Header
+	off, err := h.Unmarshal(frame)
+	if err != nil {
+		return err
+	}
+	return n.cs.Receive(frame[off:], frame[:off])
+}
+
+// mcspPair links a trust-first sender to a receiver using policy.
+func mcspPair(t *testing.T, policy GroupCounterPolicy) (tx, rx *mcspNode) {
+	t.Helper()
+	tx = newMCSPNode(t, mcspSenderNode, GroupCounterTrustFirst)
+	rx = newMCSPNode(t, mcspReceiverNode, policy)
+	tx.peer, rx.peer = rx, tx
+	return tx, rx
+}
+
+// groupFrame seals a data message from tx to the test group using its
+// next group counter.
+func (n *mcspNode) groupFrame(t *testing.T) ([]byte, uint32) {
+	t.Helper()
+	c, err := n.sm.NextGroupCounter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, _ := n.sm.GroupSessionID(testFabric, testGroupID)
+	f, err := message.NewBuilder().
+		GroupSession(sid).
+		MessageCounter(c).
+		SourceNodeID(mcspSenderNode).
+		DestGroupID(testGroupID).
+		Protocol(message.ProtocolInteractionModel).
+		Opcode(0x08).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, _ := f.Header.Marshal()
+	body, _ := f.PayloadHeader.Marshal()
+	ct, err := n.sm.EncryptGroupPayload(testFabric, testGroupID, body, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(hdr, ct...), c
+}
+
+func TestMCSP_HeldMessagesReleasedAfterSync(t *testing.T) {
+	tx, rx := mcspPair(t, GroupCounterCacheAndSync)
+	// Frames are carried by hand so both messages are held before the
+	// request goes out.
+	tx.drop, rx.drop = true, true
+
+	first, c1 := tx.groupFrame(t)
+	second, c2 := tx.groupFrame(t)
+	for _, f := range [][]byte{first, second} {
+		if err := rx.receive(f); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+	}
+	if len(rx.delivered) != 0 {
+		t.Fatalf("delivered %v before sync", rx.delivered)
+	}
+	if len(rx.sent) != 1 {
+		t.Fatalf("sent %d sync requests, want 1", len(rx.sent))
+	}
+
+	if err := tx.receive(rx.sent[0]); err != nil {
+		t.Fatalf("sender handling request: %v", err)
+	}
+	if err := rx.receive(tx.sent[0]); err != nil {
+		t.Fatalf("receiver handling response: %v", err)
+	}
+	if len(rx.delivered) != 2 || rx.delivered[0] != c1 || rx.delivered[1] != c2 {
+		t.Errorf("delivered %v, want [%d %d]", rx.delivered, c1, c2)
+	}
+	if err := rx.receive(second); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Errorf("replay after sync: err = %v", err)
+	}
+	third, c3 := tx.groupFrame(t)
+	if err := rx.receive(third); err != nil || rx.delivered[len(rx.delivered)-1] != c3 {
+		t.Errorf("post-sync message: err = %v, delivered %v", err, rx.delivered)
+	}
+	if err := rx.receive(tx.sent[0]); !errors.Is(err, ErrUnexpectedCounterSyncRsp) {
+		t.Errorf("replayed response: err = %v", err)
+	}
+}
+
+func TestMCSP_SynchronousRoundTrip(t *testing.T) {
+	tx, rx := mcspPair(t, GroupCounterCacheAndSync)
+	f, c := tx.groupFrame(t)
+	if err := rx.receive(f); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if len(rx.delivered) != 1 || rx.delivered[0] != c {
+		t.Errorf("delivered %v, want [%d]", rx.delivered, c)
+	}
+}
+
+func TestMCSP_TrustFirstNeedsNoSync(t *testing.T) {
+	tx, rx := mcspPair(t, GroupCounterTrustFirst)
+	f, _ := tx.groupFrame(t)
+	if err := rx.receive(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(rx.sent) != 0 || len(rx.delivered) != 1 {
+		t.Errorf("sent %d, delivered %d; want 0, 1", len(rx.sent), len(rx.delivered))
+	}
+}
+
+func TestMCSP_TimeoutDropsHeldMessages(t *testing.T) {
+	tx, rx := mcspPair(t, GroupCounterCacheAndSync)
+	rx.drop = true
+	f, _ := tx.groupFrame(t)
+	if err := rx.receive(f); err != nil {
+		t.Fatal(err)
+	}
+	if n := rx.cs.ExpireSyncRequests(); n != 0 {
+		t.Fatalf("expired %d before timeout", n)
+	}
+	rx.clock.Advance(DefaultMsgCounterSyncTimeout)
+	if n := rx.cs.ExpireSyncRequests(); n != 1 {
+		t.Fatalf("expired %d, want 1", n)
+	}
+
+	// The next message starts a fresh request; the late response to the
+	// abandoned one is refused.
+	g, _ := tx.groupFrame(t)
+	if err := rx.receive(g); err != nil {
+		t.Fatal(err)
+	}
+	if len(rx.sent) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(rx.sent))
+	}
+	tx.drop = true
+	if err := tx.receive(rx.sent[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := rx.receive(tx.sent[0]); !errors.Is(err, ErrUnexpectedCounterSyncRsp) {
+		t.Errorf("late response: err = %v", err)
+	}
+}
+
+func TestMCSP_QueueLimit(t *testing.T) {
+	tx, rx := mcspPair(t, GroupCounterCacheAndSync)
+	rx.drop = true
+	for i := range DefaultMaxHeldGroupMessages + 1 {
+		f, _ := tx.groupFrame(t)
+		err := rx.receive(f)
+		if i < DefaultMaxHeldGroupMessages && err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if i == DefaultMaxHeldGroupMessages && !errors.Is(err, ErrCounterSyncQueueFull) {
+			t.Errorf("overflow: err = %v, want ErrCounterSyncQueueFull", err)
+		}
+	}
+}
+
+func TestMCSP_RequestForOtherNodeRejected(t *testing.T) {
+	tx, rx := mcspPair(t, GroupCounterCacheAndSync)
+	rx.drop = true
+	f, _ := tx.groupFrame(t)
+	if err := rx.receive(f); err != nil {
+		t.Fatal(err)
+	}
+	third := newMCSPNode(t, 0xC0, GroupCounterTrustFirst)
+	if err := third.receive(rx.sent[0]); err == nil {
+		t.Error("request addressed to another node was answered")
+	}
+}
@@ -0,0 +1,135 @@
+package session
+
+import "sync/atomic"
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+const (
+	// EventSessionInstalled: a secure session entered the table, either
+	// new or rekeyed under an existing ID (the old one is reported first
+	// as EventSessionClosed with CloseReasonReplaced).
+	EventSessionInstalled EventKind = iota
+	// EventSessionClosed: a secure session left the table; Reason says
+	// why.
+	EventSessionClosed
+	// EventReplayDropped: an inbound message was rejected by the replay
+	// window. Reported before the message is authenticated for unicast
+	// sessions, after it for group messages.
+	EventReplayDropped
+	// EventAuthFailed: an inbound message failed AEAD authentication
+	// against every candidate key.
+	EventAuthFailed
+	// EventCounterExhausted: a session's outbound counter ran out and its
+	// keys must be retired (Matter §4.5.1.1). Reported once per session.
+	EventCounterExhausted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventSessionInstalled:
+		return "installed"
+	case EventSessionClosed:
+		return "closed"
+	case EventReplayDropped:
+		return "replay-dropped"
+	case EventAuthFailed:
+		return "auth-failed"
+	case EventCounterExhausted:
+		return "counter-exhausted"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is one notification to an Observer.
+type Event struct {
+	Kind EventKind
+
+	// Session is the unicast session concerned, nil for group messages.
+	// Once a closed session's event returns its keys are zeroed.
+	Session *Session
+
+	// GroupSession is the source record a group message was checked
+	// against. Nil for unicast events and for group messages no key
+	// authenticated, whose sender is unknown.
+	GroupSession *GroupSession
+
+	// Reason is set for EventSessionClosed.
+	Reason CloseReason
+
+	// SessionID, SourceNodeID and MessageCounter are taken from the
+	// offending message's header for EventReplayDropped and
+	// EventAuthFailed. SourceNodeID is 0 when the header carries none.
+	SessionID      uint16
+	SourceNodeID   uint64
+	MessageCounter uint32
+}
+
+// Observer receives session events. Calls are synchronous and made with no
+// session locks held, so an Observer may call back into the manager, but
+// it runs on the traffic path and must not block.
+type Observer interface {
+	SessionEvent(Event)
+}
+
+// ObserverFunc adapts a function to Observer.
+type ObserverFunc func(Event)
+
+func (f ObserverFunc) SessionEvent(e Event) { f(e) }
+
+// Metrics is a snapshot of a SessionManager's event counts since it was
+// created, for export to monitoring. A device sending bad frames shows up
+// as a rising ReplayDrops or AuthFailures.
+type Metrics struct {
+	ActiveSessions     int
+	SessionsInstalled  uint64
+	SessionsClosed     uint64
+	ReplayDrops        uint64
+	AuthFailures       uint64
+	CounterExhaustions uint64
+}
+
+// sessionMetrics holds the live counters behind Metrics.
+type sessionMetrics struct {
+	installed  atomic.Uint64
+	closed     atomic.Uint64
+	replays    atomic.Uint64
+	authFails  atomic.Uint64
+	exhaustion atomic.Uint64
+}
+
+// Metrics returns the current event counts.
+func (sm *SessionManager) Metrics() Metrics {
+	sm.mu.RLock()
+	active := len(sm.sessions)
+	sm.mu.RUnlock()
+	return Metrics{
+		ActiveSessions:     active,
+		SessionsInstalled:  sm.metrics.installed.Load(),
+		SessionsClosed:     sm.metrics.closed.Load(),
+		ReplayDrops:        sm.metrics.replays.Load(),
+		AuthFailures:       sm.metrics.authFails.Load(),
+		CounterExhaustions: sm.metrics.exhaustion.Load(),
+	}
+}
+
+// emit counts e and passes it to the observer. The caller holds no
+// session locks.
+func (sm *SessionManager) emit(e Event) {
+	switch e.Kind {
+	case EventSessionInstalled:
+		sm.metrics.installed.Add(1)
+	case EventSessionClosed:
+		sm.metrics.closed.Add(1)
+	case EventReplayDropped:
+		sm.metrics.replays.Add(1)
+	case EventAuthFailed:
+		sm.metrics.authFails.Add(1)
+	case EventCounterExhausted:
+		sm.metrics.exhaustion.Add(1)
+	}
+	if sm.observer != nil {
+		sm.observer.SessionEvent(e)
+	}
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"go-matter/crypto"
+)
+
+// recordingObserver collects events in order.
+type recordingObserver struct{ events []Event }
+
+func (r *recordingObserver) SessionEvent(e Event) { r.events = append(r.events, e) }
+
+func (r *recordingObserver) kinds() []EventKind {
+	var k []EventKind
+	for _, e := range r.events {
+		k = append(k, e.Kind)
+	}
+	return k
+}
+
+func TestObserver_InstallRekeyAndRemove(t *testing.T) {
+	obs := &recordingObserver{}
+	sm := NewSessionManagerWithConfig(nil, Config{Observer: obs})
+	keys := testKeys(t)
+	sm.InstallSecureSession(5, 1, 2, keys, RoleInitiator)
+	sm.InstallSecureSession(5, 1, 2, keys, RoleInitiator) // rekey
+	sm.RemoveSession(5)
+
+	want := []EventKind{EventSessionInstalled, EventSessionClosed, EventSessionInstalled, EventSessionClosed}
+	if got := obs.kinds(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	if obs.events[1].Reason != CloseReasonReplaced || obs.events[3].Reason != CloseReasonRemoved {
+		t.Errorf("reasons = %v, %v", obs.events[1].Reason, obs.events[3].Reason)
+	}
+	m := sm.Metrics()
+	if m.SessionsInstalled != 2 || m.SessionsClosed != 2 || m.ActiveSessions != 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+func TestObserver_ReplayAndAuthFailure(t *testing.T) {
+	initSM, respSM, sid, initNode, _, _ := pairedSessions(t)
+	obs := &recordingObserver{}
+	respSM.observer = obs
+
+	header := buildHeader(t, sid, 1, initNode)
+	ct, _ := initSM.EncryptPayload(sid, []byte("msg"), header)
+	if _, err := respSM.DecryptPayload(sid, ct, header); err != nil {
+		t.Fatal(err)
+	}
+	respSM.DecryptPayload(sid, ct, header)
+	tampered := buildHeader(t, sid, 2, initNode)
+	bad, _ := initSM.EncryptPayload(sid, []byte("msg"), tampered)
+	bad[0] ^= 1
+	respSM.DecryptPayload(sid, bad, tampered)
+
+	want := []EventKind{EventReplayDropped, EventAuthFailed}
+	if got := obs.kinds(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	if e := obs.events[0]; e.Session == nil || e.SessionID != sid || e.SourceNodeID != initNode || e.MessageCounter != 1 {
+		t.Errorf("replay event = %+v", e)
+	}
+	if m := respSM.Metrics(); m.ReplayDrops != 1 || m.AuthFailures != 1 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+func TestObserver_CounterExhaustedReportedOnce(t *testing.T) {
+	obs := &recordingObserver{}
+	sm := NewSessionManagerWithConfig(nil, Config{Observer: obs})
+	s := sm.InstallSecureSession(7, 1, 2, testKeys(t), RoleInitiator)
+	s.OutCounter = math.MaxUint32
+	for range 3 {
+		if _, err := s.NextOutboundCounter(); !errors.Is(err, crypto.ErrCounterExhausted) {
+			t.Fatalf("err = %v", err)
+		}
+	}
+	if got := obs.kinds(); !slices.Equal(got, []EventKind{EventSessionInstalled, EventCounterExhausted}) {
+		t.Errorf("events = %v", got)
+	}
+	if m := sm.Metrics(); m.CounterExhaustions != 1 {
+		t.Errorf("CounterExhaustions = %d, want 1", m.CounterExhaustions)
+	}
+}
+
+func TestObserver_GroupEvents(t *testing.T) {
+	tx, rx := groupPair(t, GroupCounterTrustFirst)
+	obs := &recordingObserver{}
+	rx.observer = obs
+
+	ct, h := sealGroup(t, tx, 9, false)
+	bad := bytes.Clone(ct)
+	bad[0] ^= 1
+	rx.DecryptGroupPayload(bad, h)
+	if _, _, err := rx.DecryptGroupPayload(ct, h); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rx.DecryptGroupPayload(ct, h); !errors.Is(err, ErrReplayedMessageCounter) {
+		t.Fatalf("replay: err = %v", err)
+	}
+
+	want := []EventKind{EventAuthFailed, EventReplayDropped}
+	if got := obs.kinds(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	if g := obs.events[1].GroupSession; g == nil || g.GroupID != testGroupID || g.SourceNodeID != testSource {
+		t.Errorf("replay event group session = %+v", g)
+	}
+	if obs.events[0].Session != nil || obs.events[0].SourceNodeID != testSource {
+		t.Errorf("auth event = %+v", obs.events[0])
+	}
+}
@@ -0,0 +1,92 @@
+package session
+
+import "time"
+
+// Session parameter defaults (Matter §4.12.8, §2.12.1). A peer that omits
+// an interval is assumed to use these.
+const (
+	DefaultSessionIdleInterval    = 500 * time.Millisecond
+	DefaultSessionActiveInterval  = 300 * time.Millisecond
+	DefaultSessionActiveThreshold = 4000 * time.Millisecond
+)
+
+// Revisions this implementation advertises (Matter 1.3).
+const (
+	DataModelRevision        uint16 = 17
+	InteractionModelRevision uint16 = 11
+	SpecificationVersion     uint32 = 0x01030000
+)
+
+// MaxSessionInterval caps the idle and active intervals a node may
+// advertise: one hour (Matter §4.12.8).
+const MaxSessionInterval = time.Hour
+
+// SessionParameters is the session-parameter-struct each side advertises
+// in PBKDFParamRequest/Response and Sigma1/Sigma2 (Matter §4.12.8). The
+// intervals drive the peer's MRP retransmission timing; the revisions and
+// MaxPathsPerInvoke tell the Interaction Model what the peer accepts.
+//
+// Zero means absent: the intervals then fall back to their defaults via
+// the accessor methods, and a zero revision means a pre-1.3 peer that did
+// not send one.
+type SessionParameters struct {
+	SessionIdleIntervalMs    uint32 `tlv:"1,omitempty"` // SII
+	SessionActiveIntervalMs  uint32 `tlv:"2,omitempty"` // SAI
+	SessionActiveThresholdMs uint16 `tlv:"3,omitempty"` // SAT
+	DataModelRevision        uint16 `tlv:"4,omitempty"`
+	InteractionModelRevision uint16 `tlv:"5,omitempty"`
+	SpecificationVersion     uint32 `tlv:"6,omitempty"`
+	MaxPathsPerInvoke        uint16 `tlv:"7,omitempty"`
+	SupportedTransports      uint16 `tlv:"8,omitempty"`
+	MaxTCPMessageSize        uint32 `tlv:"9,omitempty"`
+}
+
+// DefaultSessionParameters is what a node advertises unless configured
+// otherwise: default intervals, this implementation's revisions, and one
+// path per invoke.
+func DefaultSessionParameters() SessionParameters {
+	return SessionParameters{
+		SessionIdleIntervalMs:    uint32(DefaultSessionIdleInterval / time.Millisecond),
+		SessionActiveIntervalMs:  uint32(DefaultSessionActiveInterval / time.Millisecond),
+		SessionActiveThresholdMs: uint16(DefaultSessionActiveThreshold / time.Millisecond),
+		DataModelRevision:        DataModelRevision,
+		InteractionModelRevision: InteractionModelRevision,
+		SpecificationVersion:     SpecificationVersion,
+		MaxPathsPerInvoke:        1,
+	}
+}
+
+// IdleInterval is how long the node may sleep between polls while idle,
+// which bounds the sender's first retransmission delay (SII).
+func (p SessionParameters) IdleInterval() time.Duration {
+	return interval(p.SessionIdleIntervalMs, DefaultSessionIdleInterval)
+}
+
+// ActiveInterval is the retransmission base while the node is active
+// (SAI).
+func (p SessionParameters) ActiveInterval() time.Duration {
+	return interval(p.SessionActiveIntervalMs, DefaultSessionActiveInterval)
+}
+
+// ActiveThreshold is how long the node stays active after its last
+// message exchange (SAT).
+func (p SessionParameters) ActiveThreshold() time.Duration {
+	return interval(uint32(p.SessionActiveThresholdMs), DefaultSessionActiveThreshold)
+}
+
+// MaxPaths returns MaxPathsPerInvoke, treating absent as 1.
+func (p SessionParameters) MaxPaths() int {
+	if p.MaxPathsPerInvoke == 0 {
+		return 1
+	}
+	return int(p.MaxPathsPerInvoke)
+}
+
+// interval converts an advertised millisecond value, applying def when it
+// was absent and clamping to MaxSessionInterval.
+func interval(ms uint32, def time.Duration) time.Duration {
+	if ms == 0 {
+		return def
+	}
+	return min(time.Duration(ms)*time.Millisecond, MaxSessionInterval)
+}
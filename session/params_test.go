@@ -0,0 +1,69 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"go-matter/tlv"
+)
+
+func TestSessionParameters_AbsentFallsBackToDefaults(t *testing.T) {
+	var p SessionParameters
+	if p.IdleInterval() != DefaultSessionIdleInterval ||
+		p.ActiveInterval() != DefaultSessionActiveInterval ||
+		p.ActiveThreshold() != DefaultSessionActiveThreshold ||
+		p.MaxPaths() != 1 {
+		t.Errorf("zero params: idle=%v active=%v threshold=%v paths=%d",
+			p.IdleInterval(), p.ActiveInterval(), p.ActiveThreshold(), p.MaxPaths())
+	}
+}
+
+func TestSessionParameters_ClampsIntervals(t *testing.T) {
+	p := SessionParameters{SessionIdleIntervalMs: 0xFFFFFFFF, SessionActiveIntervalMs: 250}
+	if got := p.IdleInterval(); got != MaxSessionInterval {
+		t.Errorf("IdleInterval = %v, want %v", got, MaxSessionInterval)
+	}
+	if got := p.ActiveInterval(); got != 250*time.Millisecond {
+		t.Errorf("ActiveInterval = %v, want 250ms", got)
+	}
+}
+
+// TestSessionParameters_TLV locks the context tags: SII is tag 1 and
+// MaxPathsPerInvoke tag 7, and absent fields stay off the wire.
+func TestSessionParameters_TLV(t *testing.T) {
+	enc, err := tlv.Marshal(&SessionParameters{SessionIdleIntervalMs: 0x1388, MaxPathsPerInvoke: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// struct { 1: u16 0x1388, 7: u8 2 }
+	want := []byte{0x15, 0x25, 0x01, 0x88, 0x13, 0x24, 0x07, 0x02, 0x18}
+	if string(enc) != string(want) {
+		t.Errorf("encoding = %x, want %x", enc, want)
+	}
+
+	in := DefaultSessionParameters()
+	in.SupportedTransports = 0x2
+	in.MaxTCPMessageSize = 64000
+	enc, err = tlv.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out SessionParameters
+	if err := tlv.Unmarshal(enc, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestSessionManager_LocalSessionParameters(t *testing.T) {
+	if got := NewSessionManager(nil).LocalSessionParameters(); got != DefaultSessionParameters() {
+		t.Errorf("default = %+v", got)
+	}
+	custom := SessionParameters{SessionIdleIntervalMs: 2000}
+	sm := NewSessionManagerWithConfig(nil, Config{LocalParameters: custom})
+	if got := sm.LocalSessionParameters(); got != custom {
+		t.Errorf("configured = %+v, want %+v", got, custom)
+	}
+}
@@ -0,0 +1,286 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"go-matter/storage"
+	"go-matter/tlv"
+)
+
+// ResumptionIDSize is the length of a CASE resumption ID (Matter
+// §4.14.2.2.1).
+const ResumptionIDSize = 16
+
+// MaxCATs is the most CASE Authenticated Tags a NOC subject may carry
+// (Matter §6.6.2.1.2).
+const MaxCATs = 3
+
+// DefaultMaxResumptionRecords bounds a ResumptionStore when no size is
+// given. One record is kept per peer, so this is roughly how many devices
+// a controller can resume with after a restart.
+const DefaultMaxResumptionRecords = 512
+
+const (
+	resumptionIndexKey  = "session/resumption/index"
+	resumptionKeyPrefix = "session/resumption/"
+)
+
+var (
+	ErrResumptionNotFound = errors.New("session: no resumption record")
+	errInvalidResumption  = errors.New("session: invalid resumption record")
+)
+
+// ResumptionRecord is what a completed CASE handshake leaves behind so the
+// next one with the same peer can use Sigma2Resume instead of a full
+// Sigma exchange (Matter §4.14.2.2): the resumption ID both sides agreed,
+// the shared secret the resumed session keys derive from, and the peer's
+// CASE Authenticated Tags.
+type ResumptionRecord struct {
+	ResumptionID []byte   `tlv:"1"`
+	FabricIndex  uint8    `tlv:"2"`
+	PeerNodeID   uint64   `tlv:"3"`
+	SharedSecret []byte   `tlv:"4"`
+	CATs         []uint32 `tlv:"5,omitempty"`
+}
+
+func (r *ResumptionRecord) validate() error {
+	switch {
+	case len(r.ResumptionID) != ResumptionIDSize:
+		return fmt.Errorf("%w: resumption id is %d bytes, want %d", errInvalidResumption, len(r.ResumptionID), ResumptionIDSize)
+	case len(r.SharedSecret) == 0:
+		return fmt.Errorf("%w: empty shared secret", errInvalidResumption)
+	case len(r.CATs) > MaxCATs:
+		return fmt.Errorf("%w: %d CATs, at most %d", errInvalidResumption, len(r.CATs), MaxCATs)
+	}
+	return nil
+}
+
+func (r ResumptionRecord) clone() ResumptionRecord {
+	r.ResumptionID = bytes.Clone(r.ResumptionID)
+	r.SharedSecret = bytes.Clone(r.SharedSecret)
+	r.CATs = slices.Clone(r.CATs)
+	return r
+}
+
+// resumptionPeer identifies a record's owner; there is at most one record
+// per peer.
+type resumptionPeer struct {
+	FabricIndex uint8  `tlv:"1"`
+	NodeID      uint64 `tlv:"2"`
+}
+
+func (p resumptionPeer) key() string {
+	return fmt.Sprintf("%s%d/%016X", resumptionKeyPrefix, p.FabricIndex, p.NodeID)
+}
+
+// resumptionIndex lists stored peers oldest first, so eviction order
+// survives a restart.
+type resumptionIndex struct {
+	Peers []resumptionPeer `tlv:"1,omitempty"`
+}
+
+// ResumptionStore keeps one ResumptionRecord per peer, looked up either by
+// the resumption ID a peer presents in Sigma1 or by the peer a controller
+// is about to contact. Records are written through to storage and loaded
+// back by NewResumptionStore; when the store is full the least recently
+// saved record is dropped. Safe for concurrent use.
+type ResumptionStore struct {
+	store storage.Storage
+	max   int
+
+	mu     sync.Mutex
+	order  []resumptionPeer // oldest first
+	byPeer map[resumptionPeer]ResumptionRecord
+	byID   map[[ResumptionIDSize]byte]resumptionPeer
+}
+
+// NewResumptionStore loads the records held in store. max 0 selects
+// DefaultMaxResumptionRecords. Records that fail to load are skipped and
+// dropped from the index rather than failing the node's start-up.
+func NewResumptionStore(store storage.Storage, max int) (*ResumptionStore, error) {
+	if store == nil {
+		return nil, errors.New("session: resumption store needs a storage backend")
+	}
+	if max <= 0 {
+		max = DefaultMaxResumptionRecords
+	}
+	rs := &ResumptionStore{
+		store:  store,
+		max:    max,
+		byPeer: make(map[resumptionPeer]ResumptionRecord),
+		byID:   make(map[[ResumptionIDSize]byte]resumptionPeer),
+	}
+	raw, err := store.Get(resumptionIndexKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("session: load resumption index: %w", err)
+	}
+	var idx resumptionIndex
+	if err := tlv.Unmarshal(raw, &idx); err != nil {
+		return nil, fmt.Errorf("session: decode resumption index: %w", err)
+	}
+	for _, p := range idx.Peers {
+		raw, err := store.Get(p.key())
+		if err != nil {
+			continue
+		}
+		var rec ResumptionRecord
+		if tlv.Unmarshal(raw, &rec) != nil || rec.validate() != nil ||
+			rec.FabricIndex != p.FabricIndex || rec.PeerNodeID != p.NodeID {
+			continue
+		}
+		rs.insert(p, rec)
+	}
+	for len(rs.order) > rs.max {
+		rs.remove(rs.order[0])
+	}
+	if len(rs.order) != len(idx.Peers) {
+		if err := rs.writeIndex(); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// Save stores rec, replacing any earlier record for the same peer and
+// evicting the oldest record if the store is full.
+func (rs *ResumptionStore) Save(rec ResumptionRecord) error {
+	if err := rec.validate(); err != nil {
+		return err
+	}
+	rec = rec.clone()
+	raw, err := tlv.Marshal(&rec)
+	if err != nil {
+		return fmt.Errorf("session: encode resumption record: %w", err)
+	}
+	p := resumptionPeer{rec.FabricIndex, rec.PeerNodeID}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err := rs.store.Set(p.key(), raw); err != nil {
+		return fmt.Errorf("session: persist resumption record: %w", err)
+	}
+	rs.insert(p, rec)
+	var evicted []resumptionPeer
+	for len(rs.order) > rs.max {
+		evicted = append(evicted, rs.order[0])
+		rs.remove(rs.order[0])
+	}
+	if err := rs.writeIndex(); err != nil {
+		return err
+	}
+	for _, e := range evicted {
+		rs.store.Delete(e.key())
+	}
+	return nil
+}
+
+// FindByID returns the record a peer's resumption ID refers to.
+func (rs *ResumptionStore) FindByID(id []byte) (ResumptionRecord, error) {
+	if len(id) != ResumptionIDSize {
+		return ResumptionRecord{}, ErrResumptionNotFound
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	p, ok := rs.byID[[ResumptionIDSize]byte(id)]
+	if !ok {
+		return ResumptionRecord{}, ErrResumptionNotFound
+	}
+	return rs.byPeer[p].clone(), nil
+}
+
+// FindByPeer returns the record for a peer on a fabric.
+func (rs *ResumptionStore) FindByPeer(fabricIndex uint8, nodeID uint64) (ResumptionRecord, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rec, ok := rs.byPeer[resumptionPeer{fabricIndex, nodeID}]
+	if !ok {
+		return ResumptionRecord{}, ErrResumptionNotFound
+	}
+	return rec.clone(), nil
+}
+
+// Delete drops the record for a peer, e.g. after a resumption attempt
+// failed and a full handshake replaced it.
+func (rs *ResumptionStore) Delete(fabricIndex uint8, nodeID uint64) error {
+	return rs.deleteWhere(func(p resumptionPeer) bool {
+		return p.FabricIndex == fabricIndex && p.NodeID == nodeID
+	})
+}
+
+// DeleteFabric drops every record on a fabric, for when the fabric is
+// removed.
+func (rs *ResumptionStore) DeleteFabric(fabricIndex uint8) error {
+	return rs.deleteWhere(func(p resumptionPeer) bool { return p.FabricIndex == fabricIndex })
+}
+
+// Len returns the number of records held.
+func (rs *ResumptionStore) Len() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.order)
+}
+
+func (rs *ResumptionStore) deleteWhere(match func(resumptionPeer) bool) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var gone []resumptionPeer
+	for _, p := range rs.order {
+		if match(p) {
+			gone = append(gone, p)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	for _, p := range gone {
+		rs.remove(p)
+	}
+	if err := rs.writeIndex(); err != nil {
+		return err
+	}
+	for _, p := range gone {
+		if err := rs.store.Delete(p.key()); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("session: delete resumption record: %w", err)
+		}
+	}
+	return nil
+}
+
+// insert and remove maintain the in-memory maps. insert replaces any
+// record already held for p. The caller holds rs.mu.
+func (rs *ResumptionStore) insert(p resumptionPeer, rec ResumptionRecord) {
+	rs.remove(p)
+	rs.byPeer[p] = rec
+	rs.byID[[ResumptionIDSize]byte(rec.ResumptionID)] = p
+	rs.order = append(rs.order, p)
+}
+
+func (rs *ResumptionStore) remove(p resumptionPeer) {
+	rec, ok := rs.byPeer[p]
+	if !ok {
+		return
+	}
+	clear(rec.SharedSecret)
+	delete(rs.byPeer, p)
+	delete(rs.byID, [ResumptionIDSize]byte(rec.ResumptionID))
+	rs.order = slices.DeleteFunc(rs.order, func(q resumptionPeer) bool { return q == p })
+}
+
+// writeIndex persists the peer order. The caller holds rs.mu.
+func (rs *ResumptionStore) writeIndex() error {
+	raw, err := tlv.Marshal(&resumptionIndex{Peers: rs.order})
+	if err != nil {
+		return fmt.Errorf("session: encode resumption index: %w", err)
+	}
+	if err := rs.store.Set(resumptionIndexKey, raw); err != nil {
+		return fmt.Errorf("session: persist resumption index: %w", err)
+	}
+	return nil
+}
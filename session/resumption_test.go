@@ -0,0 +1,159 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"go-matter/storage"
+)
+
+func resumptionRecord(fabric uint8, node uint64, idByte byte) ResumptionRecord {
+	return ResumptionRecord{
+		ResumptionID: bytes.Repeat([]byte{idByte}, ResumptionIDSize),
+		FabricIndex:  fabric,
+		PeerNodeID:   node,
+		SharedSecret: bytes.Repeat([]byte{idByte ^ 0xFF}, 32),
+		CATs:         []uint32{0x00010001, 0xFFFF0002},
+	}
+}
+
+func mustResumptionStore(t *testing.T, s storage.Storage, max int) *ResumptionStore {
+	t.Helper()
+	rs, err := NewResumptionStore(s, max)
+	if err != nil {
+		t.Fatalf("NewResumptionStore: %v", err)
+	}
+	return rs
+}
+
+func TestResumptionStore_SaveAndFind(t *testing.T) {
+	rs := mustResumptionStore(t, storage.NewMemory(), 0)
+	rec := resumptionRecord(1, 0x1001, 0xA1)
+	if err := rs.Save(rec); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	byID, err := rs.FindByID(rec.ResumptionID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	byPeer, err := rs.FindByPeer(1, 0x1001)
+	if err != nil {
+		t.Fatalf("FindByPeer: %v", err)
+	}
+	for _, got := range []ResumptionRecord{byID, byPeer} {
+		if !bytes.Equal(got.SharedSecret, rec.SharedSecret) || !slices.Equal(got.CATs, rec.CATs) {
+			t.Errorf("record = %+v, want %+v", got, rec)
+		}
+	}
+	if _, err := rs.FindByPeer(2, 0x1001); !errors.Is(err, ErrResumptionNotFound) {
+		t.Errorf("other fabric: err = %v", err)
+	}
+}
+
+// TestResumptionStore_NewHandshakeReplacesPeer — each successful CASE
+// issues a fresh resumption ID; the old one must stop resolving.
+func TestResumptionStore_NewHandshakeReplacesPeer(t *testing.T) {
+	rs := mustResumptionStore(t, storage.NewMemory(), 0)
+	old := resumptionRecord(1, 0x1001, 0xA1)
+	rs.Save(old)
+	if err := rs.Save(resumptionRecord(1, 0x1001, 0xA2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.FindByID(old.ResumptionID); !errors.Is(err, ErrResumptionNotFound) {
+		t.Errorf("old id still resolves: err = %v", err)
+	}
+	if rs.Len() != 1 {
+		t.Errorf("Len = %d, want 1", rs.Len())
+	}
+}
+
+func TestResumptionStore_SurvivesRestart(t *testing.T) {
+	store := storage.NewMemory()
+	rs := mustResumptionStore(t, store, 0)
+	for i := range 200 {
+		if err := rs.Save(resumptionRecord(1+uint8(i%2), uint64(0x2000+i), byte(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restarted := mustResumptionStore(t, store, 0)
+	if restarted.Len() != 200 {
+		t.Fatalf("Len after restart = %d, want 200", restarted.Len())
+	}
+	rec, err := restarted.FindByID(bytes.Repeat([]byte{150}, ResumptionIDSize))
+	if err != nil || rec.PeerNodeID != 0x2000+150 || rec.FabricIndex != 1 {
+		t.Errorf("FindByID after restart = %+v, %v", rec, err)
+	}
+}
+
+func TestResumptionStore_EvictsOldestAcrossRestart(t *testing.T) {
+	store := storage.NewMemory()
+	rs := mustResumptionStore(t, store, 2)
+	for i := range 3 {
+		rs.Save(resumptionRecord(1, uint64(i), byte(i)))
+	}
+	if _, err := rs.FindByPeer(1, 0); !errors.Is(err, ErrResumptionNotFound) {
+		t.Error("oldest record not evicted")
+	}
+	if _, err := store.Get(resumptionPeer{1, 0}.key()); !errors.Is(err, storage.ErrNotFound) {
+		t.Error("evicted record left in storage")
+	}
+
+	restarted := mustResumptionStore(t, store, 2)
+	restarted.Save(resumptionRecord(1, 3, 3))
+	if _, err := restarted.FindByPeer(1, 1); !errors.Is(err, ErrResumptionNotFound) {
+		t.Error("eviction order lost across restart")
+	}
+	if _, err := restarted.FindByPeer(1, 2); err != nil {
+		t.Errorf("newer record evicted: %v", err)
+	}
+}
+
+func TestResumptionStore_DeleteFabric(t *testing.T) {
+	store := storage.NewMemory()
+	rs := mustResumptionStore(t, store, 0)
+	rs.Save(resumptionRecord(1, 10, 1))
+	rs.Save(resumptionRecord(2, 20, 2))
+	rs.Save(resumptionRecord(1, 11, 3))
+	if err := rs.DeleteFabric(1); err != nil {
+		t.Fatal(err)
+	}
+	if rs.Len() != 1 {
+		t.Errorf("Len = %d, want 1", rs.Len())
+	}
+	if err := rs.Delete(2, 20); err != nil {
+		t.Fatal(err)
+	}
+	if restarted := mustResumptionStore(t, store, 0); restarted.Len() != 0 {
+		t.Errorf("Len after restart = %d, want 0", restarted.Len())
+	}
+}
+
+func TestResumptionStore_RejectsInvalid(t *testing.T) {
+	rs := mustResumptionStore(t, storage.NewMemory(), 0)
+	bad := resumptionRecord(1, 1, 1)
+	bad.ResumptionID = bad.ResumptionID[:8]
+	if err := rs.Save(bad); err == nil {
+		t.Error("short resumption id accepted")
+	}
+	bad = resumptionRecord(1, 1, 1)
+	bad.CATs = []uint32{1, 2, 3, 4}
+	if err := rs.Save(bad); err == nil {
+		t.Error("four CATs accepted")
+	}
+}
+
+func TestResumptionStore_SkipsCorruptRecord(t *testing.T) {
+	store := storage.NewMemory()
+	rs := mustResumptionStore(t, store, 0)
+	rs.Save(resumptionRecord(1, 1, 1))
+	rs.Save(resumptionRecord(1, 2, 2))
+	store.Set(resumptionPeer{1, 1}.key(), []byte{0xFF})
+
+	restarted := mustResumptionStore(t, store, 0)
+	if restarted.Len() != 1 {
+		t.Errorf("Len = %d, want 1", restarted.Len())
+	}
+}
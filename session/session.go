@@ -6,11 +6,17 @@
 package session
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go-matter/clock"
 	"go-matter/crypto"
 	"go-matter/message"
+	"go-matter/storage"
 	"go-matter/transport"
 )
 
@@ -34,6 +40,7 @@ const MessageCounterWindowSize uint32 = 32
 var (
 	ErrUnknownSession         = errors.New("session: unknown session id")
 	ErrReplayedMessageCounter = errors.New("session: replayed message counter")
+	ErrSessionIDInUse         = errors.New("session: session id already in use")
 )
 
 // PayloadHandler is the upcall the session layer uses to deliver
@@ -54,72 +61,306 @@ const (
 // 0x0000, see message/opcodes.go) — that protocol's opcodes ride inside
 // a Session's encrypted payload.
 //
-// Unicast only; group sessions (Matter §4.5.4.2 group rules,
-// MSG_COUNTER_SYNC_REQ) are out of scope until TODO §17-18.
+// Unicast only; multicast traffic is tracked per source by GroupSession
+// (see group.go).
 type Session struct {
-	ID                   uint16
+	ID                   uint16 // local session ID; inbound headers carry it
+	PeerSessionID        uint16 // the peer's session ID; outbound headers carry it
+	Type                 SessionType
 	LocalNodeID          uint64
 	PeerNodeID           uint64
+	FabricIndex          uint8  // 0 for PASE; the fabric a CASE session belongs to
 	EncryptKey           []byte // local → peer
 	DecryptKey           []byte // peer → local
 	AttestationChallenge []byte
-	OutCounter           uint32
-	replay               replayWindow
+	OutCounter           uint32 // guarded by mu once the session is shared
+
+	// PeerParameters is what the peer advertised during the handshake;
+	// MRP times retransmissions to it and IM sizes requests by it.
+	PeerParameters SessionParameters
+
+	// mu serialises counter advancement, the replay window and key use.
+	// It is held across AEAD open so a counter's check and commit are
+	// atomic with respect to other receivers.
+	mu           sync.Mutex
+	replay       replayWindow
+	closed       bool
+	exhausted    bool            // EventCounterExhausted already reported
+	manager      *SessionManager // nil for sessions built outside a manager
+	lastActivity atomic.Int64    // UnixNano
 }
 
 // NextOutboundCounter advances and returns the counter the caller will
 // stamp into the next message header. Returns crypto.ErrCounterExhausted
 // before the counter would wrap; the keys must then be retired
-// (Matter §4.5.1.1).
+// (Matter §4.5.1.1). Safe for concurrent use: every caller gets a
+// distinct counter.
 func (s *Session) NextOutboundCounter() (uint32, error) {
+	s.mu.Lock()
 	if s.OutCounter == ^uint32(0) {
+		report := !s.exhausted && s.manager != nil
+		s.exhausted = true
+		s.mu.Unlock()
+		if report {
+			s.manager.emit(Event{Kind: EventCounterExhausted, Session: s, SessionID: s.ID})
+		}
 		return 0, crypto.ErrCounterExhausted
 	}
 	s.OutCounter++
-	return s.OutCounter, nil
+	c := s.OutCounter
+	s.mu.Unlock()
+	return c, nil
+}
+
+// LastActivity returns the install time or, if later, the time of the
+// last successful encrypt or decrypt.
+func (s *Session) LastActivity() time.Time {
+	return time.Unix(0, s.lastActivity.Load())
 }
 
-// SessionManager is not safe for concurrent use; the exchange manager
-// (TODO §18) will serialise access per session once it lands.
+func (s *Session) touch(now time.Time) {
+	s.lastActivity.Store(now.UnixNano())
+}
+
+// SessionManager is safe for concurrent use. mu guards the session table;
+// each Session's own mutex guards its counters and replay window, so
+// traffic on different sessions never contends beyond the table lookup.
 type SessionManager struct {
+	mu       sync.RWMutex
 	sessions map[uint16]*Session
 	handler  PayloadHandler
 	provider crypto.CryptoProvider
+
+	maxSessions     int
+	paseIdleTimeout time.Duration
+	caseIdleTimeout time.Duration
+	onClosed        func(*Session, CloseReason)
+	observer        Observer
+	metrics         sessionMetrics
+	localParams     SessionParameters
+	clock           clock.Clock
+
+	// Session ID allocation; see allocator.go.
+	idSeeded       bool
+	nextID         uint16
+	reservedIDs    map[uint16]struct{}
+	quarantinedIDs map[uint16]int // entries each ID has in quarantineRing
+	quarantineRing []uint16
+
+	// Group messaging; see group.go.
+	groupKeys        []*groupKey
+	groupSessions    map[groupPeer]*GroupSession
+	maxGroupSessions int
+
+	// Node-wide message counters; see counter.go. counterMu is separate
+	// from mu so a storage write never blocks the session table.
+	storage             storage.Storage
+	counterReserve      uint32
+	counterMu           sync.Mutex
+	unsecuredCounter    *PersistentCounter
+	groupDataCounter    *PersistentCounter
+	groupControlCounter *PersistentCounter
+}
+
+// Config carries the tunables for NewSessionManagerWithConfig. The zero
+// value is valid and matches NewSessionManager.
+type Config struct {
+	// CryptoProvider performs all session cryptography and is handed to
+	// the handshake layers via SessionManager.CryptoProvider. Nil selects
+	// crypto.DefaultCryptoProvider.
+	CryptoProvider crypto.CryptoProvider
+
+	// MaxSessions caps the secure session table; installing past it
+	// evicts per the policy on CreateSession. Zero selects
+	// DefaultMaxSessions.
+	MaxSessions int
+
+	// PASEIdleTimeout and CASEIdleTimeout bound how long a session may go
+	// without traffic before ExpireIdleSessions closes it. Zero selects
+	// the default; a negative value disables expiry for that type.
+	PASEIdleTimeout time.Duration
+	CASEIdleTimeout time.Duration
+
+	// MaxGroupSessions caps the per-source group counter records. Zero
+	// selects DefaultMaxGroupSessions.
+	MaxGroupSessions int
+
+	// LocalParameters is the session-parameter-struct this node
+	// advertises in PASE and CASE. The zero value selects
+	// DefaultSessionParameters.
+	LocalParameters SessionParameters
+
+	// Storage persists the global unencrypted and group message counters
+	// so a restart never reuses a value. Nil keeps them in memory only.
+	Storage storage.Storage
+
+	// CounterReserve is how far ahead the persisted counters checkpoint.
+	// Zero selects DefaultCounterReserve.
+	CounterReserve uint32
+
+	// OnSessionClosed, if set, is called after a session leaves the
+	// table, whatever the reason. Its keys are zeroed once it returns.
+	OnSessionClosed func(s *Session, reason CloseReason)
+
+	// Observer, if set, receives install, close, replay-drop,
+	// auth-failure and counter-exhaustion events. Metrics counts them
+	// either way.
+	Observer Observer
+
+	// Clock times idle expiry and counter synchronisation. Nil selects
+	// clock.Real.
+	Clock clock.Clock
 }
 
 func NewSessionManager(handler PayloadHandler) *SessionManager {
-	return &SessionManager{
-		sessions: make(map[uint16]*Session),
-		handler:  handler,
-		provider: &crypto.DefaultCryptoProvider{},
+	return NewSessionManagerWithConfig(handler, Config{})
+}
+
+func NewSessionManagerWithConfig(handler PayloadHandler, cfg Config) *SessionManager {
+	provider := cfg.CryptoProvider
+	if provider == nil {
+		provider = &crypto.DefaultCryptoProvider{}
+	}
+	sm := &SessionManager{
+		sessions:        make(map[uint16]*Session),
+		handler:         handler,
+		provider:        provider,
+		maxSessions:     cfg.MaxSessions,
+		paseIdleTimeout: cfg.PASEIdleTimeout,
+		caseIdleTimeout: cfg.CASEIdleTimeout,
+		onClosed:        cfg.OnSessionClosed,
+		observer:        cfg.Observer,
+		localParams:     cfg.LocalParameters,
+		clock:           cfg.Clock,
+		reservedIDs:     make(map[uint16]struct{}),
+		quarantinedIDs:  make(map[uint16]int),
+
+		groupSessions:    make(map[groupPeer]*GroupSession),
+		maxGroupSessions: cfg.MaxGroupSessions,
+
+		storage:        cfg.Storage,
+		counterReserve: cfg.CounterReserve,
 	}
+	if sm.clock == nil {
+		sm.clock = clock.Real
+	}
+	if sm.storage == nil {
+		sm.storage = storage.NewMemory()
+	}
+	if sm.maxSessions <= 0 {
+		sm.maxSessions = DefaultMaxSessions
+	}
+	if sm.paseIdleTimeout == 0 {
+		sm.paseIdleTimeout = DefaultPASEIdleTimeout
+	}
+	if sm.caseIdleTimeout == 0 {
+		sm.caseIdleTimeout = DefaultCASEIdleTimeout
+	}
+	if sm.localParams == (SessionParameters{}) {
+		sm.localParams = DefaultSessionParameters()
+	}
+	if sm.maxGroupSessions <= 0 {
+		sm.maxGroupSessions = DefaultMaxGroupSessions
+	}
+	return sm
+}
+
+// CryptoProvider returns the provider this manager encrypts with. PASE and
+// CASE use it too, so a whole node runs on one implementation.
+func (sm *SessionManager) CryptoProvider() crypto.CryptoProvider {
+	return sm.provider
+}
+
+// Clock returns the clock this manager times expiry with. The handshake
+// layers time out on it too, so one fake clock drives a whole test node.
+func (sm *SessionManager) Clock() clock.Clock {
+	return sm.clock
+}
+
+// LocalSessionParameters returns the parameters this node advertises to
+// peers during session establishment.
+func (sm *SessionManager) LocalSessionParameters() SessionParameters {
+	return sm.localParams
 }
 
 // InstallSecureSession registers keys derived from a completed PASE or
-// CASE handshake. The role argument picks which of (I2RKey, R2IKey) is
-// the local encrypt key once, so the hot path never re-branches on it.
+// CASE handshake, replacing any session already holding id. The type is
+// inferred from the node IDs: PASE sessions always use
+// UnspecifiedNodeID on both sides, and the peer is assumed to use id too.
+// Use CreateSession to set the type, fabric and peer session ID
+// explicitly.
 func (sm *SessionManager) InstallSecureSession(
 	id uint16,
 	localNodeID, peerNodeID uint64,
 	keys crypto.SessionKeys,
 	role Role,
 ) *Session {
+	typ := SessionTypeCASE
+	if localNodeID == UnspecifiedNodeID && peerNodeID == UnspecifiedNodeID {
+		typ = SessionTypePASE
+	}
+	sm.mu.Lock()
+	var closed []closedSession
+	if old := sm.detach(id); old != nil {
+		closed = append(closed, closedSession{old, CloseReasonReplaced})
+	}
+	s, evicted := sm.install(SessionParams{
+		ID:          id,
+		Type:        typ,
+		LocalNodeID: localNodeID,
+		PeerNodeID:  peerNodeID,
+		Keys:        keys,
+		Role:        role,
+	})
+	sm.mu.Unlock()
+	sm.finish(append(closed, evicted...))
+	sm.emit(Event{Kind: EventSessionInstalled, Session: s, SessionID: id})
+	return s
+}
+
+// install builds the Session, resolving the role into directional keys
+// once so the hot path never re-branches on it, and makes room for it.
+// The caller holds sm.mu and must pass the evicted sessions to finish
+// after unlocking.
+func (sm *SessionManager) install(p SessionParams) (*Session, []closedSession) {
 	s := &Session{
-		ID:                   id,
-		LocalNodeID:          localNodeID,
-		PeerNodeID:           peerNodeID,
-		AttestationChallenge: keys.AttestationChallenge,
+		ID:                   p.ID,
+		PeerSessionID:        p.PeerSessionID,
+		Type:                 p.Type,
+		LocalNodeID:          p.LocalNodeID,
+		PeerNodeID:           p.PeerNodeID,
+		FabricIndex:          p.FabricIndex,
+		AttestationChallenge: p.Keys.AttestationChallenge,
+		PeerParameters:       p.PeerParameters,
+		manager:              sm,
 	}
-	if role == RoleInitiator {
-		s.EncryptKey, s.DecryptKey = keys.I2RKey, keys.R2IKey
+	if s.PeerSessionID == 0 {
+		s.PeerSessionID = p.ID
+	}
+	s.touch(sm.clock.Now())
+	// Keys are copied: close zeroes them, and the caller's SessionKeys may
+	// be shared (e.g. by both ends of an in-process test).
+	if p.Role == RoleInitiator {
+		s.EncryptKey, s.DecryptKey = bytes.Clone(p.Keys.I2RKey), bytes.Clone(p.Keys.R2IKey)
 	} else {
-		s.EncryptKey, s.DecryptKey = keys.R2IKey, keys.I2RKey
+		s.EncryptKey, s.DecryptKey = bytes.Clone(p.Keys.R2IKey), bytes.Clone(p.Keys.I2RKey)
 	}
-	sm.sessions[id] = s
-	return s
+	var evicted []closedSession
+	for len(sm.sessions) >= sm.maxSessions {
+		victim := sm.evictionCandidate(s)
+		if victim == nil {
+			break
+		}
+		evicted = append(evicted, closedSession{sm.detach(victim.ID), CloseReasonEvicted})
+	}
+	delete(sm.reservedIDs, p.ID)
+	sm.sessions[p.ID] = s
+	return s, evicted
 }
 
 func (sm *SessionManager) Session(id uint16) (*Session, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	s, ok := sm.sessions[id]
 	return s, ok
 }
@@ -127,12 +368,19 @@ func (sm *SessionManager) Session(id uint16) (*Session, bool) {
 // EncryptPayload seals payload with AES-128-CCM. The header bytes must
 // already carry the outbound counter (via Session.NextOutboundCounter)
 // and become the AEAD's AAD: Matter authenticates the cleartext header
-// even though it isn't encrypted (§4.5.3).
+// even though it isn't encrypted (§4.5.3). The nonce takes the sender's
+// node ID from the session, not the header, which usually omits it: the
+// local operational node ID for CASE, UnspecifiedNodeID for PASE. Group
+// messages need the fabric to pick a key and must go through
+// EncryptGroupPayload.
 func (sm *SessionManager) EncryptPayload(sessionID uint16, payload []byte, header []byte) ([]byte, error) {
+	if isGroupHeader(header) {
+		return nil, errGroupViaUnicast
+	}
 	if sessionID == UnsecuredSessionID {
 		return payload, nil
 	}
-	s, ok := sm.sessions[sessionID]
+	s, ok := sm.Session(sessionID)
 	if !ok {
 		return nil, ErrUnknownSession
 	}
@@ -140,19 +388,45 @@ func (sm *SessionManager) EncryptPayload(sessionID uint16, payload []byte, heade
 	if _, err := h.Unmarshal(header); err != nil {
 		return nil, fmt.Errorf("session: parse outbound header: %w", err)
 	}
-	nonce := crypto.BuildNonce(byte(h.SecurityFlags), h.MessageCounter, h.SourceNodeID)
-	return sm.provider.Encrypt(s.EncryptKey, nonce, payload, header)
+	nonce := crypto.BuildNonce(byte(h.SecurityFlags), h.MessageCounter, s.LocalNodeID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrUnknownSession
+	}
+	ciphertext, err := sm.provider.Encrypt(s.EncryptKey, nonce, payload, header)
+	if err != nil {
+		return nil, err
+	}
+	s.touch(sm.clock.Now())
+	return ciphertext, nil
 }
 
 // DecryptPayload opens an AES-128-CCM ciphertext. The replay-window
 // commit is deferred until AEAD auth succeeds — otherwise a tampered
 // frame could advance the window and open a gap an attacker would later
-// fill (§4.5.4.2).
+// fill (§4.5.4.2). Group messages are routed to DecryptGroupPayload.
 func (sm *SessionManager) DecryptPayload(sessionID uint16, ciphertext []byte, header []byte) ([]byte, error) {
+	if isGroupHeader(header) {
+		_, plaintext, err := sm.DecryptGroupPayload(ciphertext, header)
+		return plaintext, err
+	}
+	plaintext, err := sm.decrypt(sessionID, ciphertext, header, false)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// decrypt opens a unicast ciphertext and reports failures to the
+// observer. With duplicates set, a replayed message that still
+// authenticates is returned along with ErrReplayedMessageCounter, so the
+// caller can acknowledge it; the window is not touched either way.
+func (sm *SessionManager) decrypt(sessionID uint16, ciphertext, header []byte, duplicates bool) ([]byte, error) {
 	if sessionID == UnsecuredSessionID {
 		return ciphertext, nil
 	}
-	s, ok := sm.sessions[sessionID]
+	s, ok := sm.Session(sessionID)
 	if !ok {
 		return nil, ErrUnknownSession
 	}
@@ -160,16 +434,46 @@ func (sm *SessionManager) DecryptPayload(sessionID uint16, ciphertext []byte, he
 	if _, err := h.Unmarshal(header); err != nil {
 		return nil, fmt.Errorf("session: parse inbound header: %w", err)
 	}
-	commit, err := s.replay.check(h.MessageCounter)
-	if err != nil {
-		return nil, err
+	plaintext, err := sm.open(s, h, ciphertext, header, duplicates)
+	if err != nil && !errors.Is(err, ErrUnknownSession) {
+		kind := EventAuthFailed
+		if errors.Is(err, ErrReplayedMessageCounter) {
+			kind = EventReplayDropped
+		}
+		sm.emit(Event{
+			Kind:           kind,
+			Session:        s,
+			SessionID:      sessionID,
+			SourceNodeID:   h.SourceNodeID,
+			MessageCounter: h.MessageCounter,
+		})
+	}
+	return plaintext, err
+}
+
+// open checks h's counter against s's replay window, authenticates and
+// decrypts, and commits the counter. decrypt reports failures once s.mu is
+// released.
+func (sm *SessionManager) open(s *Session, h message.Header, ciphertext, header []byte, duplicates bool) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrUnknownSession
 	}
-	nonce := crypto.BuildNonce(byte(h.SecurityFlags), h.MessageCounter, h.SourceNodeID)
+	commit, replayErr := s.replay.check(h.MessageCounter)
+	if replayErr != nil && !duplicates {
+		return nil, replayErr
+	}
+	nonce := crypto.BuildNonce(byte(h.SecurityFlags), h.MessageCounter, s.PeerNodeID)
 	plaintext, err := sm.provider.Decrypt(s.DecryptKey, nonce, ciphertext, header)
 	if err != nil {
 		return nil, err
 	}
+	if replayErr != nil {
+		return plaintext, replayErr
+	}
 	commit()
+	s.touch(sm.clock.Now())
 	return plaintext, nil
 }
 
@@ -138,6 +138,33 @@ func TestDirection_WrongKeyRejects(t *testing.T) {
 	}
 }
 
+// TestNonce_UsesSessionNodeIDs checks that the nonce's source node ID
+// comes from the session, not the header: a header without SourceNodeID
+// still round-trips, and a receiver that holds another peer node ID fails
+// to authenticate.
+func TestNonce_UsesSessionNodeIDs(t *testing.T) {
+	initSM, respSM, sid, _, respNode, keys := pairedSessions(t)
+
+	h := message.Header{SessionID: sid, SecurityFlags: message.SessionTypeUnicast, MessageCounter: 1}
+	header, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := initSM.EncryptPayload(sid, []byte("payload"), header)
+	if err != nil {
+		t.Fatalf("EncryptPayload: %v", err)
+	}
+	if _, err := respSM.DecryptPayload(sid, ct, header); err != nil {
+		t.Fatalf("DecryptPayload: %v", err)
+	}
+
+	wrong := NewSessionManager(nil)
+	wrong.InstallSecureSession(sid, respNode, 0x3333333333333333, keys, RoleResponder)
+	if _, err := wrong.DecryptPayload(sid, ct, header); err == nil {
+		t.Fatal("decrypted with the wrong peer node ID")
+	}
+}
+
 func TestReplayWindow_RejectsDuplicate(t *testing.T) {
 	initSM, respSM, sid, initNode, _, _ := pairedSessions(t)
 	initSess, _ := initSM.Session(sid)
@@ -322,3 +349,38 @@ func TestReplayWindow_LargeJumpClearsBitmap(t *testing.T) {
 		t.Fatalf("counter=80 within window should be accepted: %v", err)
 	}
 }
+
+// countingProvider records Encrypt calls so tests can confirm the
+// configured provider is the one actually used.
+type countingProvider struct {
+	crypto.DefaultCryptoProvider
+	encrypts int
+}
+
+func (p *countingProvider) Encrypt(key, nonce, plaintext, aad []byte) ([]byte, error) {
+	p.encrypts++
+	return p.DefaultCryptoProvider.Encrypt(key, nonce, plaintext, aad)
+}
+
+func TestNewSessionManagerWithConfig_UsesProvider(t *testing.T) {
+	if _, ok := NewSessionManager(nil).CryptoProvider().(*crypto.DefaultCryptoProvider); !ok {
+		t.Error("default provider is not DefaultCryptoProvider")
+	}
+
+	p := &countingProvider{}
+	sm := NewSessionManagerWithConfig(nil, Config{CryptoProvider: p})
+	if sm.CryptoProvider() != p {
+		t.Fatal("CryptoProvider() does not return the configured provider")
+	}
+	keys, err := crypto.DeriveSessionKeysFromKe(bytes.Repeat([]byte{0x5A}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.InstallSecureSession(7, 1, 2, keys, RoleInitiator)
+	if _, err := sm.EncryptPayload(7, []byte("hi"), buildHeader(t, 7, 1, 1)); err != nil {
+		t.Fatalf("EncryptPayload: %v", err)
+	}
+	if p.encrypts != 1 {
+		t.Errorf("provider Encrypt called %d times, want 1", p.encrypts)
+	}
+}
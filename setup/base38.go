@@ -0,0 +1,71 @@
+package setup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// base38Alphabet is the QR payload alphabet (Matter §5.1.3.1): digits,
+// upper-case letters, '-' and '.'. It sits entirely inside the QR
+// alphanumeric mode so the code stays compact.
+const base38Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ-."
+
+// base38CharsPerChunk maps a chunk of 1, 2 or 3 bytes to the number of
+// base-38 characters it expands to.
+var base38CharsPerChunk = [4]int{0, 2, 4, 5}
+
+// base38Encode packs data three bytes at a time as a little-endian integer
+// and emits its base-38 digits least-significant first.
+func base38Encode(data []byte) string {
+	var sb strings.Builder
+	for len(data) > 0 {
+		n := min(len(data), 3)
+		var v uint32
+		for i := n - 1; i >= 0; i-- {
+			v = v<<8 | uint32(data[i])
+		}
+		for range base38CharsPerChunk[n] {
+			sb.WriteByte(base38Alphabet[v%38])
+			v /= 38
+		}
+		data = data[n:]
+	}
+	return sb.String()
+}
+
+// base38Decode reverses base38Encode. A trailing group of 2 or 4
+// characters decodes to 1 or 2 bytes; any other remainder is malformed.
+func base38Decode(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s)*3/5+2)
+	for len(s) > 0 {
+		chars := min(len(s), 5)
+		var n int
+		switch chars {
+		case 5:
+			n = 3
+		case 4:
+			n = 2
+		case 2:
+			n = 1
+		default:
+			return nil, fmt.Errorf("%w: base-38 group of %d characters", ErrInvalidPayload, chars)
+		}
+		var v uint32
+		for i := chars - 1; i >= 0; i-- {
+			d := strings.IndexByte(base38Alphabet, s[i])
+			if d < 0 {
+				return nil, fmt.Errorf("%w: invalid base-38 character %q", ErrInvalidPayload, s[i])
+			}
+			v = v*38 + uint32(d)
+		}
+		if v>>(8*n) != 0 {
+			return nil, fmt.Errorf("%w: base-38 group %q overflows %d bytes", ErrInvalidPayload, s[:chars], n)
+		}
+		for range n {
+			out = append(out, byte(v))
+			v >>= 8
+		}
+		s = s[chars:]
+	}
+	return out, nil
+}
@@ -0,0 +1,34 @@
+package setup
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBase38_RoundTrip(t *testing.T) {
+	for n := 0; n <= 12; n++ {
+		in := make([]byte, n)
+		for i := range in {
+			in[i] = byte(0xFF - i*17)
+		}
+		enc := base38Encode(in)
+		want := n/3*5 + base38CharsPerChunk[n%3]
+		if len(enc) != want {
+			t.Errorf("len(base38Encode(%d bytes)) = %d, want %d", n, len(enc), want)
+		}
+		dec, err := base38Decode(enc)
+		if err != nil {
+			t.Fatalf("base38Decode(%q): %v", enc, err)
+		}
+		if !bytes.Equal(dec, in) && !(n == 0 && len(dec) == 0) {
+			t.Errorf("round trip %x -> %q -> %x", in, enc, dec)
+		}
+	}
+}
+
+func TestBase38_RejectsOverflow(t *testing.T) {
+	// ".." is 37 + 37*38 = 1443, which does not fit one byte.
+	if _, err := base38Decode(".."); err == nil {
+		t.Error("accepted a 2-character group that overflows one byte")
+	}
+}
@@ -0,0 +1,113 @@
+package setup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Manual pairing code lengths including the trailing Verhoeff check digit
+// (Matter §5.1.4.1). The long form appends vendor and product IDs and is
+// used whenever the commissioning flow is not FlowStandard.
+const (
+	ManualCodeShortLength = 11
+	ManualCodeLongLength  = 21
+)
+
+// ManualCode renders the payload as an 11-digit (standard flow) or 21-digit
+// (user-action or custom flow) manual pairing code. Only the upper four
+// bits of the discriminator survive this encoding.
+func (p *Payload) ManualCode() (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	long := p.CommissioningFlow != FlowStandard
+	short := uint32(p.ShortDiscriminatorValue())
+
+	// Chunk 1: VID/PID-present flag at bit 2, discriminator bits 3..2 below it.
+	chunk1 := short >> 2
+	if long {
+		chunk1 |= 1 << 2
+	}
+	// Chunk 2: passcode bits 13..0, discriminator bits 1..0 at bit 14.
+	chunk2 := p.Passcode&0x3FFF | (short&0x3)<<14
+	// Chunk 3: passcode bits 26..14.
+	chunk3 := p.Passcode >> 14
+
+	digits := fmt.Sprintf("%01d%05d%04d", chunk1, chunk2, chunk3)
+	if long {
+		digits += fmt.Sprintf("%05d%05d", p.VendorID, p.ProductID)
+	}
+	return digits + string(verhoeffCheckDigit(digits)), nil
+}
+
+// ParseManualCode decodes and validates an 11- or 21-digit manual pairing
+// code. Dashes and spaces, as printed on packaging, are ignored. The
+// returned Payload has ShortDiscriminator set.
+func ParseManualCode(s string) (*Payload, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(digits) != ManualCodeShortLength && len(digits) != ManualCodeLongLength {
+		return nil, fmt.Errorf("%w: manual code has %d digits, want %d or %d",
+			ErrInvalidPayload, len(digits), ManualCodeShortLength, ManualCodeLongLength)
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return nil, fmt.Errorf("%w: non-digit %q in manual code", ErrInvalidPayload, digits[i])
+		}
+	}
+	body, check := digits[:len(digits)-1], digits[len(digits)-1]
+	if verhoeffCheckDigit(body) != check {
+		return nil, ErrInvalidCheckDigit
+	}
+
+	chunk1 := uint32(body[0] - '0')
+	if chunk1 > 7 {
+		return nil, fmt.Errorf("%w: reserved leading digit %d", ErrInvalidPayload, chunk1)
+	}
+	long := chunk1&(1<<2) != 0
+	if long != (len(digits) == ManualCodeLongLength) {
+		return nil, fmt.Errorf("%w: VID/PID flag disagrees with code length", ErrInvalidPayload)
+	}
+	chunk2 := atoiDigits(body[1:6])
+	chunk3 := atoiDigits(body[6:10])
+
+	short := (chunk1&0x3)<<2 | chunk2>>14
+	p := &Payload{
+		Discriminator:      uint16(short) << 8,
+		ShortDiscriminator: true,
+		Passcode:           chunk2&0x3FFF | chunk3<<14,
+	}
+	if long {
+		vid, pid := atoiDigits(body[10:15]), atoiDigits(body[15:20])
+		if vid > 0xFFFF || pid > 0xFFFF {
+			return nil, fmt.Errorf("%w: vendor/product ID out of range", ErrInvalidPayload)
+		}
+		p.VendorID, p.ProductID = uint16(vid), uint16(pid)
+		p.CommissioningFlow = FlowCustom
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Parse accepts either a QR payload or a manual pairing code, whichever a
+// user scanned or typed.
+func Parse(s string) (*Payload, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, QRCodePrefix) {
+		return ParseQRCode(s)
+	}
+	return ParseManualCode(s)
+}
+
+// atoiDigits converts a string already checked to be all decimal digits.
+func atoiDigits(s string) uint32 {
+	v, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(v)
+}
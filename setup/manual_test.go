@@ -0,0 +1,94 @@
+package setup
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerhoeffCheckDigit(t *testing.T) {
+	// Textbook vector: 236 → 2363.
+	if got := verhoeffCheckDigit("236"); got != '3' {
+		t.Errorf("check digit for 236 = %c, want 3", got)
+	}
+}
+
+// TestManualCode_KnownVector pins the all-clusters-app development device's
+// printed code (discriminator 3840, passcode 20202021).
+func TestManualCode_KnownVector(t *testing.T) {
+	p := Payload{VendorID: 0xFFF1, ProductID: 0x8000, Discriminator: 3840, Passcode: 20202021}
+	got, err := p.ManualCode()
+	if err != nil {
+		t.Fatalf("ManualCode: %v", err)
+	}
+	if got != "34970112332" {
+		t.Errorf("ManualCode = %s, want 34970112332", got)
+	}
+
+	parsed, err := ParseManualCode("3497-011-2332")
+	if err != nil {
+		t.Fatalf("ParseManualCode: %v", err)
+	}
+	if parsed.Passcode != 20202021 || !parsed.ShortDiscriminator ||
+		parsed.ShortDiscriminatorValue() != 0xF {
+		t.Errorf("parsed = %+v", parsed)
+	}
+	if !parsed.MatchesDiscriminator(3840) || !parsed.MatchesDiscriminator(0xF42) {
+		t.Error("short discriminator must match any advertised value with the same upper nibble")
+	}
+	if parsed.MatchesDiscriminator(0xE00) {
+		t.Error("short discriminator matched a different upper nibble")
+	}
+}
+
+func TestManualCode_LongRoundTrip(t *testing.T) {
+	p := Payload{
+		VendorID: 0xFFF1, ProductID: 0x8001,
+		CommissioningFlow: FlowCustom,
+		Discriminator:     0x5A5, Passcode: 34567890,
+	}
+	code, err := p.ManualCode()
+	if err != nil {
+		t.Fatalf("ManualCode: %v", err)
+	}
+	if len(code) != ManualCodeLongLength {
+		t.Fatalf("len = %d, want %d", len(code), ManualCodeLongLength)
+	}
+	got, err := ParseManualCode(code)
+	if err != nil {
+		t.Fatalf("ParseManualCode(%s): %v", code, err)
+	}
+	if got.VendorID != p.VendorID || got.ProductID != p.ProductID ||
+		got.Passcode != p.Passcode || got.ShortDiscriminatorValue() != 0x5 ||
+		got.CommissioningFlow != FlowCustom {
+		t.Errorf("round trip mismatch: %+v", got)
+	}
+}
+
+func TestManualCode_Rejects(t *testing.T) {
+	if _, err := ParseManualCode("34970112333"); !errors.Is(err, ErrInvalidCheckDigit) {
+		t.Errorf("bad check digit: err = %v", err)
+	}
+	// Adjacent transposition must be caught by Verhoeff.
+	if _, err := ParseManualCode("43970112332"); !errors.Is(err, ErrInvalidCheckDigit) {
+		t.Errorf("transposed digits: err = %v", err)
+	}
+	for _, s := range []string{"3497011233", "3497011233a", "349701123320"} {
+		if _, err := ParseManualCode(s); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("ParseManualCode(%q) = %v, want ErrInvalidPayload", s, err)
+		}
+	}
+	if _, err := (&Payload{Passcode: 100000000}).ManualCode(); err == nil {
+		t.Error("ManualCode accepted an out-of-range passcode")
+	}
+}
+
+func TestParse_Dispatch(t *testing.T) {
+	q, err := Parse(" MT:Y.K9042C00KA0648G00 ")
+	if err != nil || q.ShortDiscriminator || q.Discriminator != 3840 {
+		t.Errorf("Parse(QR) = %+v, %v", q, err)
+	}
+	m, err := Parse("34970112332")
+	if err != nil || !m.ShortDiscriminator || m.Passcode != 20202021 {
+		t.Errorf("Parse(manual) = %+v, %v", m, err)
+	}
+}
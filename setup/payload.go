@@ -0,0 +1,117 @@
+// Package setup encodes and decodes the Matter onboarding payload (Matter
+// §5.1): the "MT:" base-38 QR code and the 11/21-digit manual pairing
+// code printed on commissionable devices. A decoded Payload carries the
+// discriminator a commissioner filters mDNS results on and the passcode
+// it feeds into PASE.
+package setup
+
+import (
+	"errors"
+	"fmt"
+
+	"go-matter/crypto"
+)
+
+// CommissioningFlow tells the commissioner what the user must do before the
+// device will accept PASE (Matter §5.1.1).
+type CommissioningFlow uint8
+
+const (
+	FlowStandard           CommissioningFlow = 0
+	FlowUserActionRequired CommissioningFlow = 1
+	FlowCustom             CommissioningFlow = 2
+)
+
+// DiscoveryCapabilities is the QR payload's bitmask of the transports the
+// device can be commissioned over (Matter §5.1.1.5).
+type DiscoveryCapabilities uint8
+
+const (
+	DiscoverySoftAP    DiscoveryCapabilities = 0x01
+	DiscoveryBLE       DiscoveryCapabilities = 0x02
+	DiscoveryOnNetwork DiscoveryCapabilities = 0x04
+)
+
+// MaxDiscriminator is the largest value of the 12-bit long discriminator.
+const MaxDiscriminator uint16 = 0x0FFF
+
+var (
+	ErrInvalidPayload       = errors.New("setup: invalid onboarding payload")
+	ErrInvalidCheckDigit    = errors.New("setup: manual pairing code check digit mismatch")
+	ErrShortDiscriminatorQR = errors.New("setup: QR payload requires a long discriminator")
+)
+
+// Payload is the decoded onboarding payload.
+//
+// Manual pairing codes only carry the upper four bits of the discriminator.
+// When a Payload is parsed from one, ShortDiscriminator is set and the low
+// eight bits of Discriminator are zero; use MatchesDiscriminator rather than
+// comparing Discriminator directly.
+type Payload struct {
+	Version               uint8
+	VendorID              uint16
+	ProductID             uint16
+	CommissioningFlow     CommissioningFlow
+	DiscoveryCapabilities DiscoveryCapabilities
+	Discriminator         uint16
+	ShortDiscriminator    bool
+	Passcode              uint32
+
+	// Optional is the TLV extension appended to a QR payload; nil when
+	// absent. Manual pairing codes never carry it.
+	Optional *OptionalData
+}
+
+// OptionalData holds the Matter-common elements of the QR payload's TLV
+// extension (Matter §5.1.5). Vendor-specific elements (tags 0x80-0xFF) are
+// not modelled and are dropped on decode.
+type OptionalData struct {
+	SerialNumber         string `tlv:"0,omitempty"`
+	PBKDFIterations      uint32 `tlv:"1,omitempty"`
+	PBKDFSalt            []byte `tlv:"2,omitempty"`
+	NumberOfDevices      uint8  `tlv:"3,omitempty"`
+	CommissioningTimeout uint16 `tlv:"4,omitempty"`
+}
+
+// ShortDiscriminatorValue returns the upper four bits of the discriminator,
+// the only part a manual pairing code and a short mDNS subtype carry.
+func (p *Payload) ShortDiscriminatorValue() uint8 {
+	return uint8(p.Discriminator >> 8)
+}
+
+// MatchesDiscriminator reports whether an advertised 12-bit discriminator
+// is consistent with this payload, comparing only the upper four bits when
+// the payload came from a manual pairing code.
+func (p *Payload) MatchesDiscriminator(advertised uint16) bool {
+	if p.ShortDiscriminator {
+		return uint8(advertised>>8) == p.ShortDiscriminatorValue()
+	}
+	return advertised == p.Discriminator
+}
+
+// Validate checks the field ranges shared by both encodings and the
+// passcode rules of crypto.ValidatePasscode.
+func (p *Payload) Validate() error {
+	if p.Version != 0 {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidPayload, p.Version)
+	}
+	if p.CommissioningFlow > FlowCustom {
+		return fmt.Errorf("%w: reserved commissioning flow %d", ErrInvalidPayload, p.CommissioningFlow)
+	}
+	if p.Discriminator > MaxDiscriminator {
+		return fmt.Errorf("%w: discriminator %#x exceeds 12 bits", ErrInvalidPayload, p.Discriminator)
+	}
+	if err := crypto.ValidatePasscode(p.Passcode); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+	return nil
+}
+
+// Verifier derives the SPAKE2+ (w0, L) verifier for this payload's
+// passcode, as a device would persist it at manufacturing time.
+func (p *Payload) Verifier(salt []byte, iterations int) (w0, L []byte, err error) {
+	if err := crypto.ValidatePasscode(p.Passcode); err != nil {
+		return nil, nil, err
+	}
+	return crypto.ComputeSPAKE2PVerifierData(p.Passcode, salt, iterations)
+}
@@ -0,0 +1,134 @@
+package setup
+
+import (
+	"fmt"
+	"strings"
+
+	"go-matter/tlv"
+)
+
+// QRCodePrefix starts every Matter QR payload string.
+const QRCodePrefix = "MT:"
+
+// Bit widths of the fixed QR payload fields (Matter §5.1.3), packed
+// LSB-first in this order into 88 bits / 11 bytes.
+const (
+	qrVersionBits       = 3
+	qrVendorIDBits      = 16
+	qrProductIDBits     = 16
+	qrFlowBits          = 2
+	qrDiscoveryBits     = 8
+	qrDiscriminatorBits = 12
+	qrPasscodeBits      = 27
+	qrPaddingBits       = 4
+
+	qrFixedBytes = (qrVersionBits + qrVendorIDBits + qrProductIDBits + qrFlowBits +
+		qrDiscoveryBits + qrDiscriminatorBits + qrPasscodeBits + qrPaddingBits) / 8
+)
+
+// QRCode renders the payload as an "MT:"-prefixed base-38 string, with the
+// TLV extension appended when Optional is set.
+func (p *Payload) QRCode() (string, error) {
+	if p.ShortDiscriminator {
+		return "", ErrShortDiscriminatorQR
+	}
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	var w bitWriter
+	w.put(uint64(p.Version), qrVersionBits)
+	w.put(uint64(p.VendorID), qrVendorIDBits)
+	w.put(uint64(p.ProductID), qrProductIDBits)
+	w.put(uint64(p.CommissioningFlow), qrFlowBits)
+	w.put(uint64(p.DiscoveryCapabilities), qrDiscoveryBits)
+	w.put(uint64(p.Discriminator), qrDiscriminatorBits)
+	w.put(uint64(p.Passcode), qrPasscodeBits)
+	w.put(0, qrPaddingBits)
+
+	data := w.buf
+	if p.Optional != nil {
+		ext, err := tlv.Marshal(p.Optional)
+		if err != nil {
+			return "", fmt.Errorf("setup: encode QR extension: %w", err)
+		}
+		data = append(data, ext...)
+	}
+	return QRCodePrefix + base38Encode(data), nil
+}
+
+// ParseQRCode decodes and validates a single "MT:" QR payload. Concatenated
+// multi-device payloads ('*'-separated) are not supported.
+func ParseQRCode(s string) (*Payload, error) {
+	body, ok := strings.CutPrefix(s, QRCodePrefix)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %q prefix", ErrInvalidPayload, QRCodePrefix)
+	}
+	if strings.Contains(body, "*") {
+		return nil, fmt.Errorf("%w: concatenated QR payloads are not supported", ErrInvalidPayload)
+	}
+	data, err := base38Decode(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < qrFixedBytes {
+		return nil, fmt.Errorf("%w: QR payload is %d bytes, want at least %d", ErrInvalidPayload, len(data), qrFixedBytes)
+	}
+
+	r := bitReader{buf: data[:qrFixedBytes]}
+	p := &Payload{
+		Version:               uint8(r.get(qrVersionBits)),
+		VendorID:              uint16(r.get(qrVendorIDBits)),
+		ProductID:             uint16(r.get(qrProductIDBits)),
+		CommissioningFlow:     CommissioningFlow(r.get(qrFlowBits)),
+		DiscoveryCapabilities: DiscoveryCapabilities(r.get(qrDiscoveryBits)),
+		Discriminator:         uint16(r.get(qrDiscriminatorBits)),
+		Passcode:              uint32(r.get(qrPasscodeBits)),
+	}
+	if r.get(qrPaddingBits) != 0 {
+		return nil, fmt.Errorf("%w: non-zero padding bits", ErrInvalidPayload)
+	}
+	if ext := data[qrFixedBytes:]; len(ext) > 0 {
+		p.Optional = &OptionalData{}
+		if err := tlv.Unmarshal(ext, p.Optional); err != nil {
+			return nil, fmt.Errorf("%w: QR extension: %w", ErrInvalidPayload, err)
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// bitWriter appends values LSB-first into a growing byte slice.
+type bitWriter struct {
+	buf []byte
+	off int
+}
+
+func (w *bitWriter) put(v uint64, bits int) {
+	for i := range bits {
+		if w.off%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[w.off/8] |= byte((v>>i)&1) << (w.off % 8)
+		w.off++
+	}
+}
+
+// bitReader is the inverse of bitWriter. Callers bound reads by the fixed
+// field layout, so it does not check for overrun.
+type bitReader struct {
+	buf []byte
+	off int
+}
+
+func (r *bitReader) get(bits int) uint64 {
+	var v uint64
+	for i := range bits {
+		bit := (r.buf[r.off/8] >> (r.off % 8)) & 1
+		v |= uint64(bit) << i
+		r.off++
+	}
+	return v
+}
@@ -0,0 +1,139 @@
+package setup
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"go-matter/crypto"
+)
+
+// TestQRCode_KnownVectors pins payloads published by connectedhomeip: the
+// SetupPayload unit-test default and the all-clusters-app development
+// device (VID 0xFFF1, PID 0x8000, discriminator 3840, passcode 20202021).
+func TestQRCode_KnownVectors(t *testing.T) {
+	cases := []struct {
+		name string
+		p    Payload
+		want string
+	}{
+		{
+			name: "chip default payload",
+			p: Payload{
+				VendorID: 12, ProductID: 1,
+				DiscoveryCapabilities: DiscoverySoftAP,
+				Discriminator:         128, Passcode: 2048,
+			},
+			want: "MT:M5L90MP500K64J00000",
+		},
+		{
+			name: "all-clusters-app",
+			p: Payload{
+				VendorID: 0xFFF1, ProductID: 0x8000,
+				DiscoveryCapabilities: DiscoveryBLE,
+				Discriminator:         3840, Passcode: 20202021,
+			},
+			want: "MT:Y.K9042C00KA0648G00",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.p.QRCode()
+			if err != nil {
+				t.Fatalf("QRCode: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("QRCode = %s, want %s", got, tc.want)
+			}
+			parsed, err := ParseQRCode(tc.want)
+			if err != nil {
+				t.Fatalf("ParseQRCode: %v", err)
+			}
+			if *parsed != tc.p {
+				t.Errorf("ParseQRCode = %+v, want %+v", *parsed, tc.p)
+			}
+		})
+	}
+}
+
+func TestQRCode_OptionalDataRoundTrip(t *testing.T) {
+	p := Payload{
+		VendorID: 0xFFF1, ProductID: 0x8001,
+		CommissioningFlow:     FlowUserActionRequired,
+		DiscoveryCapabilities: DiscoveryOnNetwork | DiscoveryBLE,
+		Discriminator:         0xABC, Passcode: 34567890,
+		Optional: &OptionalData{
+			SerialNumber:    "SN-0042",
+			PBKDFIterations: 2000,
+			PBKDFSalt:       []byte("SPAKE2P Key Salt"),
+		},
+	}
+	code, err := p.QRCode()
+	if err != nil {
+		t.Fatalf("QRCode: %v", err)
+	}
+	got, err := ParseQRCode(code)
+	if err != nil {
+		t.Fatalf("ParseQRCode(%s): %v", code, err)
+	}
+	if got.Optional == nil {
+		t.Fatal("optional data lost in round trip")
+	}
+	if got.Optional.SerialNumber != "SN-0042" ||
+		got.Optional.PBKDFIterations != 2000 ||
+		!bytes.Equal(got.Optional.PBKDFSalt, []byte("SPAKE2P Key Salt")) {
+		t.Errorf("optional data mismatch: %+v", got.Optional)
+	}
+	if got.Discriminator != 0xABC || got.Passcode != 34567890 ||
+		got.CommissioningFlow != FlowUserActionRequired {
+		t.Errorf("fixed fields mismatch: %+v", got)
+	}
+}
+
+func TestQRCode_Rejects(t *testing.T) {
+	valid := Payload{VendorID: 1, ProductID: 1, Discriminator: 1, Passcode: 20202021}
+
+	bad := valid
+	bad.Passcode = 0
+	if _, err := bad.QRCode(); !errors.Is(err, crypto.ErrInvalidPasscode) {
+		t.Errorf("zero passcode: err = %v, want ErrInvalidPasscode", err)
+	}
+	bad = valid
+	bad.Discriminator = 0x1000
+	if _, err := bad.QRCode(); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("13-bit discriminator: err = %v, want ErrInvalidPayload", err)
+	}
+	bad = valid
+	bad.ShortDiscriminator = true
+	if _, err := bad.QRCode(); !errors.Is(err, ErrShortDiscriminatorQR) {
+		t.Errorf("short discriminator: err = %v, want ErrShortDiscriminatorQR", err)
+	}
+
+	for _, s := range []string{
+		"M5L90MP500K64J00000",      // missing prefix
+		"MT:M5L90MP500K64J0000",    // truncated group
+		"MT:M5L90MP500K64J0000a",   // lower-case is not base-38
+		"MT:M5L90MP5",              // too short for the fixed fields
+		"MT:M5L90MP500K64J00000*x", // concatenated payloads
+	} {
+		if _, err := ParseQRCode(s); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("ParseQRCode(%q) = %v, want ErrInvalidPayload", s, err)
+		}
+	}
+}
+
+func TestPayload_Verifier(t *testing.T) {
+	p := Payload{Passcode: 20202021}
+	salt := []byte("SPAKE2P Key Salt")
+	w0, L, err := p.Verifier(salt, 1000)
+	if err != nil {
+		t.Fatalf("Verifier: %v", err)
+	}
+	wantW0, wantL, _ := crypto.ComputeSPAKE2PVerifierData(20202021, salt, 1000)
+	if !bytes.Equal(w0, wantW0) || !bytes.Equal(L, wantL) {
+		t.Error("Verifier does not match crypto.ComputeSPAKE2PVerifierData")
+	}
+	if _, _, err := (&Payload{Passcode: crypto.MaxPasscode + 1}).Verifier(salt, 1000); err == nil {
+		t.Error("Verifier accepted an out-of-range passcode")
+	}
+}
@@ -0,0 +1,101 @@
+// Package storage is the node's persistent key-value store: message
+// counters, resumption records and, later, fabric tables all go through
+// Storage so the protocol packages never touch the filesystem directly.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotFound is returned by Get and Delete for a key that was never
+	// set or has been deleted.
+	ErrNotFound = errors.New("storage: key not found")
+
+	errInvalidKey = errors.New("storage: invalid key")
+)
+
+// Storage persists opaque values by key. Keys are slash-separated paths of
+// [A-Za-z0-9._-] segments, e.g. "session/group-data-counter". Set must be
+// durable when it returns: callers rely on it to never reuse a counter
+// after a restart. Implementations are safe for concurrent use.
+type Storage interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+}
+
+// ValidateKey reports whether key is acceptable to every Storage.
+func ValidateKey(key string) error {
+	if key == "" {
+		return errInvalidKey
+	}
+	for seg := range strings.SplitSeq(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("%w: %q", errInvalidKey, key)
+		}
+		for _, r := range seg {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+				r == '.', r == '_', r == '-':
+			default:
+				return fmt.Errorf("%w: %q", errInvalidKey, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Memory is a volatile Storage. It is the default where persistence is
+// optional, and what tests use to simulate a restart: keep the Memory,
+// rebuild everything else.
+type Memory struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{values: make(map[string][]byte)}
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (m *Memory) Set(key string, value []byte) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.values, key)
+	return nil
+}
+
+var _ Storage = (*Memory)(nil)
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// exercise runs the Storage contract against s.
+func exercise(t *testing.T, s Storage) {
+	t.Helper()
+	if _, err := s.Get("a/b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get missing: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Set("a/b", []byte{1, 2, 3}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Set("a/b", []byte{4}); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	v, err := s.Get("a/b")
+	if err != nil || !bytes.Equal(v, []byte{4}) {
+		t.Fatalf("Get = %x, %v; want 04", v, err)
+	}
+	v[0] = 0xFF
+	if again, _ := s.Get("a/b"); again[0] != 4 {
+		t.Error("Get returned an alias of the stored value")
+	}
+	if err := s.Delete("a/b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Delete("a/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete: err = %v, want ErrNotFound", err)
+	}
+	for _, bad := range []string{"", "/a", "a/", "a/../b", "a b", `a\b`} {
+		if err := s.Set(bad, nil); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	exercise(t, NewMemory())
+}
+
+func TestDir(t *testing.T) {
+	d, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exercise(t, d)
+}
+
+func TestDir_SurvivesReopen(t *testing.T) {
+	root := t.TempDir()
+	d, _ := NewDir(root)
+	if err := d.Set("session/counter", []byte("42")); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := reopened.Get("session/counter"); err != nil || string(v) != "42" {
+		t.Errorf("after reopen: %q, %v", v, err)
+	}
+}
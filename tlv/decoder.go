@@ -1,6 +1,7 @@
 package tlv
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,6 +10,24 @@ import (
 	"strings"
 )
 
+// Unmarshal parses one top-level TLV element from data, reading container
+// children recursively, and reflects it into out.
+func Unmarshal(data []byte, out interface{}) error {
+	r := NewReader(bytes.NewReader(data))
+	elem, err := r.ReadElement()
+	if err != nil {
+		return err
+	}
+	if elem.Type == TypeStructure || elem.Type == TypeArray || elem.Type == TypeList {
+		children, err := r.ReadContainerChildren()
+		if err != nil {
+			return err
+		}
+		elem.SubElements = children
+	}
+	return Decode(elem, out)
+}
+
 func Decode(tlv Element, out interface{}) error {
 	rv := reflect.ValueOf(out)
 	if rv.Kind() != reflect.Ptr {
@@ -84,3 +84,26 @@ func TestDecode(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshal_RoundTrip(t *testing.T) {
+	type inner struct {
+		A uint16 `tlv:"1"`
+	}
+	type outer struct {
+		Name  string  `tlv:"0"`
+		Inner *inner  `tlv:"1"`
+		List  []uint8 `tlv:"2"`
+	}
+	want := outer{Name: "x", Inner: &inner{A: 0x1234}, List: []uint8{1, 2, 3}}
+	b, err := Marshal(&want)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got outer
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round-trip mismatch: got %+v want %+v", got, want)
+	}
+}
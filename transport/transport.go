@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"os"
@@ -21,11 +22,15 @@ type MessageSecurity interface {
 // ReadHandler defines the callback for received frames.
 type ReadHandler func(frame *message.Frame, from *net.UDPAddr)
 
+// PacketHandler receives raw datagrams, for layers that decrypt before
+// decoding (exchange.ExchangeManager). It owns b. A returned error is
+// logged and the datagram dropped.
+type PacketHandler func(b []byte, from net.Addr) error
+
 // TransportManager handles sending and receiving messages over UDP.
 type TransportManager struct {
-	conn            *net.UDPConn
-	security        MessageSecurity
-	unackedMessages map[uint32]interface{}
+	conn     *net.UDPConn
+	security MessageSecurity
 }
 
 // NewTransportManager creates a new TransportManager listening on the specified port.
@@ -45,9 +50,9 @@ func NewTransportManager(port int, security MessageSecurity) (*TransportManager,
 }
 
 // Send serialises the frame and writes it to the destination address.
-// MRP behaviour for reliable delivery is not implemented yet (TODO §17).
+// reliable is ignored: MRP runs on exchanges (exchange.ExchangeManager),
+// which send through SendPacket.
 func (tm *TransportManager) Send(addr *net.UDPAddr, frame *message.Frame, reliable bool) error {
-	// TODO: MRP retransmission table when reliable == true.
 	// TODO: Encrypt frame.Payload via tm.security once secure sessions land.
 	wire, err := frame.Encode()
 	if err != nil {
@@ -57,25 +62,42 @@ func (tm *TransportManager) Send(addr *net.UDPAddr, frame *message.Frame, reliab
 	return err
 }
 
+// SendPacket writes already-encoded wire bytes to addr, which must be a
+// *net.UDPAddr.
+func (tm *TransportManager) SendPacket(addr net.Addr, b []byte) error {
+	_, err := tm.conn.WriteTo(b, addr)
+	return err
+}
+
 // Start runs the receive loop, decoding each datagram into a Frame and
 // dispatching it to the handler. Malformed datagrams are logged and dropped.
+//
+// Start does not decrypt; secured traffic needs Serve with an
+// exchange.ExchangeManager instead.
 func (tm *TransportManager) Start(handler ReadHandler) error {
+	return tm.Serve(func(b []byte, from net.Addr) error {
+		frame, err := message.Decode(b)
+		if err != nil {
+			return fmt.Errorf("malformed: %w", err)
+		}
+		if handler != nil {
+			handler(frame, from.(*net.UDPAddr))
+		}
+		return nil
+	})
+}
+
+// Serve runs the receive loop, passing each datagram to handler as raw
+// bytes.
+func (tm *TransportManager) Serve(handler PacketHandler) error {
 	buf := make([]byte, 2048)
 	for {
 		n, addr, err := tm.conn.ReadFromUDP(buf)
 		if err != nil {
 			return err
 		}
-
-		// TODO: Decrypt the post-header portion via tm.security for secured sessions.
-		frame, err := message.Decode(buf[:n])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "transport: drop malformed packet from %s: %v\n", addr, err)
-			continue
-		}
-
-		if handler != nil {
-			handler(frame, addr)
+		if err := handler(bytes.Clone(buf[:n]), addr); err != nil {
+			fmt.Fprintf(os.Stderr, "transport: drop packet from %s: %v\n", addr, err)
 		}
 	}
 }